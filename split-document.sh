@@ -7,6 +7,9 @@ set -e
 # The input file.
 declare input_file
 
+# Keep the text as UTF-8 instead of transliterating it to ASCII.
+declare keep_unicode
+
 # ------------------------------------------------------------------------------
 # Functions
 # ------------------------------------------------------------------------------
@@ -57,6 +60,7 @@ function usage {
     echo "Options:"
     echo "  -h, --help            Print this help message."
     echo "  -o, --output          The output directory. Default: split"
+    echo "  -u, --keep-unicode    Keep the text as UTF-8 instead of converting it to ASCII."
     echo
     exit 1
 }
@@ -85,7 +89,7 @@ function cleanup_pandoc_output {
 # ------------------------------------------------------------------------------
 
 # Parse the command line arguments using getopt.
-eval set -- $(getopt -o h,o: --long help,output: -n 'split-document.sh' -- "$@")
+eval set -- $(getopt -o h,o:,u --long help,output:,keep-unicode -n 'split-document.sh' -- "$@")
 while true; do
     case "$1" in
         -h|--help)
@@ -95,6 +99,10 @@ while true; do
             output_dir=$2
             shift 2
             ;;
+        -u|--keep-unicode)
+            keep_unicode=1
+            shift
+            ;;
         --)
             shift
             break
@@ -148,12 +156,14 @@ start_action "Cleaning up ${tmp_name}..."
 
 # Convert unicode characters to ASCII.
 echo
-start_action "Converting unicode characters to ASCII..." 1
-tmp_name2=$(create_tmp_file)
-iconv -f UTF-8 -t ASCII//TRANSLIT ${tmp_name} > ${tmp_name2}
-status_code=$?
-mv ${tmp_name2} ${tmp_name}
-end_action ${status_code}
+if [ -z "${keep_unicode}" ]; then
+    start_action "Converting unicode characters to ASCII..." 1
+    tmp_name2=$(create_tmp_file)
+    iconv -f UTF-8 -t ASCII//TRANSLIT ${tmp_name} > ${tmp_name2}
+    status_code=$?
+    mv ${tmp_name2} ${tmp_name}
+    end_action ${status_code}
+fi
 
 # Fix pandoc output.
 start_action "Fixing pandoc output..." 1
@@ -12,7 +12,8 @@ def parse_args():
     parser.add_argument('--out-dir', type=str, default="audio-split", help='Directory to save the generated audio files.')
 
     parser.add_argument('--start-idx', type=int, default=0, help='The index to start generating audio files from.')
-    parser.add_argument('--end-idx', type=int, default=-1, help='The index to stop generating audio files at.')
+    parser.add_argument('--end-idx', type=int, default=None, help='The index to stop generating audio files at, exclusive. Default: the end')
+    parser.add_argument('--ids', type=str, default="", help='Comma separated list of the paragraph IDs to generate. Default: all')
     return parser.parse_args()
 
 # Initialize the TTS model
@@ -20,9 +21,10 @@ def init_tts_model(model_name, gpu):
     tts = TTS(model_name=model_name, gpu=gpu)
     return tts
 
-# Generate tts file from text.
+# Generate tts file from text. Single speaker models are given an empty
+# speaker name.
 def generate_tts_file(tts, text, speaker_name, file_path):
-    tts.tts_to_file(text=text, speaker=speaker_name, file_path=file_path)
+    tts.tts_to_file(text=text, speaker=speaker_name or None, file_path=file_path)
 
 
 # Main
@@ -33,6 +35,7 @@ text_dir = args.text_dir
 out_dir = args.out_dir
 start_idx = args.start_idx
 end_idx = args.end_idx
+ids = [int(i) for i in args.ids.split(",") if i != ""]
 
 tts = init_tts_model(model_name, True)
 
@@ -44,6 +47,8 @@ trimmed_filenames = [f.name for f in filenames]
 no_suffix_filenames = [f.with_suffix("") for f in filenames]
 numbers = [int(f.name) for f in no_suffix_filenames]
 numbers.sort()
+if ids:
+    numbers = [n for n in numbers if n in ids]
 numbers = numbers[start_idx:end_idx]
 
 # Create output directory if it doesn't exist
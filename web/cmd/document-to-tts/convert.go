@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+
+	"imitablerabbit/ttsweb"
+)
+
+// runConvert will split and synthesize a document into the output directory.
+// The document is stored in the same layout as in the documents directory of
+// the server, in a directory named after the file. If the conversion was
+// interrupted, running it again only synthesizes the paragraphs that are not
+// finished yet. A conversion of a different file with the same name is only
+// replaced with -restart.
+func runConvert(args []string) error {
+	flags := newFlagSet("convert")
+	outputDir := flags.String("o", ".", "the directory that the document is converted into")
+	name := flags.String("name", "", "the name of the document, the file name if empty")
+	language := flags.String("language", "", "the language code of the document, detected if empty")
+	merge := flags.String("merge", "", "also join the audio of the paragraphs into a single file in the format (wav, mp3)")
+	restart := flags.Bool("restart", false, "discard the paragraphs that were converted before and start again, needed when the file has changed")
+	verbose := flags.Bool("v", false, "print the log of the pipeline instead of a progress bar")
+	pipelineFlags := ttsweb.RegisterPipelineFlags(flags)
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return fmt.Errorf("one file is required")
+	}
+	input := flags.Arg(0)
+
+	pipeline, err := pipelineFlags.Pipeline()
+	if err != nil {
+		return err
+	}
+	pipeline.Jobs = ttsweb.NewJobs()
+	mergeFormat, err := mergeAudioFormat(pipeline, *merge)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(input)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(*outputDir, 0755); err != nil {
+		return err
+	}
+	documentsDir := strings.TrimSuffix(*outputDir, "/") + "/"
+
+	// Pick up the document where it was left. If the file has changed since
+	// it was converted, the earlier conversion is only removed when asked to.
+	id := documentID(input)
+	document, err := ttsweb.LoadDocument(documentsDir, id)
+	resume := err == nil
+	if resume && !*restart && document.Sha1sum != sha1sum(data) {
+		return fmt.Errorf("%s was converted from a different %s, use -restart to replace it", filepath.Join(documentsDir, id), document.Filename)
+	}
+	if resume && *restart {
+		fmt.Fprintln(os.Stderr, "Starting again:", filepath.Join(documentsDir, id))
+		if err := os.RemoveAll(filepath.Join(documentsDir, id)); err != nil {
+			return err
+		}
+		resume = false
+	}
+	if !resume {
+		document = ttsweb.DocumentInfo{
+			ID:       id,
+			Name:     *name,
+			Filename: filepath.Base(input),
+			Language: *language,
+			Size:     int64(len(data)),
+			Sha1sum:  sha1sum(data),
+			Status:   ttsweb.StatusNew,
+		}
+		if document.Name == "" {
+			document.Name = strings.TrimSuffix(document.Filename, filepath.Ext(document.Filename))
+		}
+		if err := document.Save(documentsDir, data); err != nil {
+			return err
+		}
+	}
+
+	// The log of the pipeline would break up the progress bar, so it is only
+	// written when asked for.
+	if *verbose {
+		logger, err := ttsweb.NewLogger(os.Stderr, "text", slog.LevelInfo)
+		if err != nil {
+			return err
+		}
+		slog.SetDefault(logger)
+	} else {
+		slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	}
+
+	// Run the pipeline while the progress is drawn. If it is interrupted, the
+	// partial audio is removed, and running it again resumes the document.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	done := make(chan struct{})
+	drawn := make(chan struct{})
+	go func() {
+		if !*verbose {
+			newProgressBar().follow(pipeline.Jobs, id, done)
+		}
+		close(drawn)
+	}()
+	if resume {
+		err = pipeline.Resume(ctx, documentsDir, &document)
+	} else {
+		err = pipeline.Process(ctx, documentsDir, &document)
+	}
+	close(done)
+	<-drawn
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\t%s\n", filepath.Join(documentsDir, id), document.Status)
+
+	if mergeFormat == nil {
+		return nil
+	}
+	file, err := mergeAudio(documentsDir, document, *mergeFormat)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\tmerged\n", file)
+	return nil
+}
+
+// mergeAudioFormat will return the format of the merged audio, or nil if the
+// audio is not merged. The pipeline must keep the audio in the format.
+func mergeAudioFormat(pipeline *ttsweb.Pipeline, name string) (*ttsweb.AudioFormat, error) {
+	switch name {
+	case "":
+		return nil, nil
+	case ttsweb.FormatWav.Name:
+		if pipeline.DeleteSourceAudio && pipeline.Transcoder != nil {
+			return nil, fmt.Errorf("-merge wav cannot be used with -delete-wav")
+		}
+		return &ttsweb.FormatWav, nil
+	case ttsweb.FormatMP3.Name:
+		for _, format := range pipeline.AudioFormats {
+			if format.Name == ttsweb.FormatMP3.Name {
+				return &ttsweb.FormatMP3, nil
+			}
+		}
+		return nil, fmt.Errorf("-merge mp3 needs -audio-formats to include mp3")
+	}
+	return nil, fmt.Errorf("unsupported merge format: %s", name)
+}
+
+// mergeAudio will join the audio of the paragraphs that are not skipped into a
+// single file next to the directory of the document. The file is written
+// under a temporary name first, so that an interrupted merge is not mistaken
+// for a finished one.
+func mergeAudio(documentsDir string, document ttsweb.DocumentInfo, format ttsweb.AudioFormat) (string, error) {
+	paragraphs, err := ttsweb.LoadParagraphInfos(documentsDir, document.ID)
+	if err != nil {
+		return "", err
+	}
+	document.MarkSkippedParagraphs(paragraphs)
+	paragraphIDs := []string{}
+	for _, paragraph := range paragraphs {
+		if !paragraph.Skip {
+			paragraphIDs = append(paragraphIDs, paragraph.ID)
+		}
+	}
+
+	stream, err := ttsweb.BuildAudioStream(documentsDir, document.ID, paragraphIDs, format)
+	if err != nil {
+		return "", err
+	}
+	reader := stream.Reader()
+	defer reader.Close()
+
+	file := filepath.Join(documentsDir, document.ID+format.Extension)
+	out, err := os.Create(file + ".tmp")
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(out, reader); err != nil {
+		out.Close()
+		os.Remove(file + ".tmp")
+		return "", err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(file + ".tmp")
+		return "", err
+	}
+	return file, os.Rename(file+".tmp", file)
+}
+
+// documentID will name the directory of the document after the file, so that
+// converting the same file again finds the earlier conversion. The ID is the
+// name and the extension of the file followed by a short hash of the file
+// name, e.g. "report-pdf-1a2b3c4d", so that "report.pdf" and "report.docx",
+// or "a b.txt" and "a-b.txt", are converted into different directories.
+func documentID(file string) string {
+	base := filepath.Base(file)
+	ext := filepath.Ext(base)
+	id := idSafe(strings.TrimSuffix(base, ext))
+	if strings.Trim(id, "-") == "" {
+		id = "document"
+	}
+	if ext != "" {
+		id += "-" + idSafe(strings.TrimPrefix(ext, "."))
+	}
+	hash := sha1.Sum([]byte(base))
+	return id + "-" + hex.EncodeToString(hash[:4])
+}
+
+// idSafe will replace the characters that are not allowed in a document ID
+// with "-".
+func idSafe(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '-'
+	}, s)
+}
+
+// sha1sum will return the SHA1 sum of the file, as it is stored with the
+// document.
+func sha1sum(data []byte) string {
+	hash := sha1.Sum(data)
+	return hex.EncodeToString(hash[:])
+}
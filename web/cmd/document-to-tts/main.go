@@ -0,0 +1,81 @@
+// document-to-tts converts documents to audio without running the server. The
+// documents are split and synthesized in-process by the same pipeline that the
+// server uses.
+//
+// Usage:
+//
+//	document-to-tts <command> [flags] [arguments]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// command is a subcommand of document-to-tts.
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(args []string) error
+}
+
+// commands are the subcommands of document-to-tts. They are set in init since
+// the commands print their own usage.
+var commands []command
+
+func init() {
+	commands = []command{
+		{"convert", "convert [-o DIR] [-name NAME] [-language CODE] [-merge wav|mp3] [-restart] [-v] [pipeline flags] FILE", "split and synthesize a document into a directory", runConvert},
+	}
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	for _, cmd := range commands {
+		if cmd.name != flag.Arg(0) {
+			continue
+		}
+		if err := cmd.run(flag.Args()[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, "document-to-tts "+cmd.name+":", err)
+			os.Exit(1)
+		}
+		return
+	}
+	fmt.Fprintln(os.Stderr, "document-to-tts: unknown command:", flag.Arg(0))
+	usage()
+	os.Exit(2)
+}
+
+// usage will print the commands.
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: document-to-tts <command> [flags] [arguments]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.summary)
+		fmt.Fprintf(os.Stderr, "  %-10s   document-to-tts %s\n", "", cmd.usage)
+	}
+}
+
+// newFlagSet will create the flags of a command. The usage of the command is
+// printed if the flags cannot be parsed.
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		for _, cmd := range commands {
+			if cmd.name == name {
+				fmt.Fprintln(os.Stderr, "Usage: document-to-tts "+cmd.usage)
+			}
+		}
+		flags.PrintDefaults()
+	}
+	return flags
+}
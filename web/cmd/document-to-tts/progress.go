@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"imitablerabbit/ttsweb"
+)
+
+// progressInterval is how often the progress bar is redrawn.
+const progressInterval = 250 * time.Millisecond
+
+// progressWidth is the number of characters in the bar.
+const progressWidth = 30
+
+// progressBar draws the progress of the job of a document. On a terminal the
+// bar is redrawn in place, otherwise a line is printed each time it changes.
+type progressBar struct {
+	out      io.Writer
+	terminal bool
+	started  time.Time
+	last     string
+}
+
+// newProgressBar will create a progress bar that is drawn on stderr.
+func newProgressBar() *progressBar {
+	terminal := false
+	if stat, err := os.Stderr.Stat(); err == nil {
+		terminal = stat.Mode()&os.ModeCharDevice != 0
+	}
+	return &progressBar{out: os.Stderr, terminal: terminal, started: time.Now()}
+}
+
+// follow will draw the progress of the latest job of the document until done
+// is closed.
+func (b *progressBar) follow(jobs *ttsweb.Jobs, documentID string, done <-chan struct{}) {
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	for {
+		if list := jobs.List(documentID); len(list) > 0 {
+			b.draw(list[len(list)-1])
+		}
+		select {
+		case <-done:
+			if list := jobs.List(documentID); len(list) > 0 {
+				b.draw(list[len(list)-1])
+			}
+			if b.terminal && b.last != "" {
+				fmt.Fprintln(b.out)
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// draw will show the stage of the job, and a bar of the paragraphs that have
+// been finished if the stage counts them.
+func (b *progressBar) draw(job ttsweb.Job) {
+	line := fmt.Sprintf("%-10s", job.Stage)
+	if job.Total > 0 {
+		filled := job.Done * progressWidth / job.Total
+		line += fmt.Sprintf(" [%s%s] %d/%d %3d%%",
+			strings.Repeat("#", filled), strings.Repeat("-", progressWidth-filled),
+			job.Done, job.Total, job.Done*100/job.Total)
+	}
+
+	// Only the terminal shows the elapsed time, so that a line is not
+	// printed every second when the output is a file.
+	if !b.terminal {
+		if line != b.last {
+			fmt.Fprintln(b.out, line)
+			b.last = line
+		}
+		return
+	}
+	b.last = line
+	elapsed := time.Since(b.started).Truncate(time.Second)
+	fmt.Fprintf(b.out, "\r\033[K%s %s", line, elapsed)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"imitablerabbit/ttsweb"
+)
+
+// client speaks the JSON API of the ttsweb server.
+type client struct {
+	server string
+	http   *http.Client
+}
+
+// newClient will create a client for the server, e.g. http://localhost:8080.
+func newClient(server string) *client {
+	return &client{
+		server: strings.TrimSuffix(server, "/"),
+		http:   &http.Client{Timeout: 10 * time.Minute},
+	}
+}
+
+// do will send the request to the server. An error is returned with the
+// message from the server if the response is not successful.
+func (c *client) do(method, path string, contentType string, body io.Reader) (*http.Response, error) {
+	request, err := http.NewRequest(method, c.server+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+	response, err := c.http.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		defer response.Body.Close()
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 4096))
+		return nil, fmt.Errorf("%s %s: %s: %s", method, path, response.Status, strings.TrimSpace(string(message)))
+	}
+	return response, nil
+}
+
+// getJSON will get the path from the server and decode the JSON response.
+func (c *client) getJSON(path string, v interface{}) error {
+	response, err := c.do(http.MethodGet, path, "", nil)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	return json.NewDecoder(response.Body).Decode(v)
+}
+
+// documents will return the documents on the server.
+func (c *client) documents() ([]ttsweb.DocumentInfo, error) {
+	documents := ttsweb.DocumentsInfo{}
+	if err := c.getJSON("/documents", &documents); err != nil {
+		return nil, err
+	}
+	return documents.Documents, nil
+}
+
+// jobs will return the jobs of the document, or of all of the documents if
+// the document ID is empty.
+func (c *client) jobs(documentID string) ([]ttsweb.Job, error) {
+	path := "/jobs"
+	if documentID != "" {
+		path += "?document=" + documentID
+	}
+	response := struct {
+		Jobs []ttsweb.Job `json:"jobs"`
+	}{}
+	if err := c.getJSON(path, &response); err != nil {
+		return nil, err
+	}
+	return response.Jobs, nil
+}
+
+// uploadFile will upload the file as a new document. If the name is empty,
+// the server names the document after the file.
+func (c *client) uploadFile(file, name, language string) (ttsweb.DocumentInfo, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return ttsweb.DocumentInfo{}, err
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", filepath.Base(file))
+	if err != nil {
+		return ttsweb.DocumentInfo{}, err
+	}
+	part.Write(data)
+	writeUploadFields(form, name, language)
+	if err := form.Close(); err != nil {
+		return ttsweb.DocumentInfo{}, err
+	}
+	return c.postDocument(form.FormDataContentType(), &body)
+}
+
+// uploadURL will ask the server to fetch the web page as a new document.
+func (c *client) uploadURL(url, name, language string) (ttsweb.DocumentInfo, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("url", url)
+	writeUploadFields(form, name, language)
+	if err := form.Close(); err != nil {
+		return ttsweb.DocumentInfo{}, err
+	}
+	return c.postDocument(form.FormDataContentType(), &body)
+}
+
+// writeUploadFields will add the optional fields of an upload to the form.
+func writeUploadFields(form *multipart.Writer, name, language string) {
+	if name != "" {
+		form.WriteField("name", name)
+	}
+	if language != "" {
+		form.WriteField("language", language)
+	}
+}
+
+// postDocument will send the upload form and decode the new document.
+func (c *client) postDocument(contentType string, body io.Reader) (ttsweb.DocumentInfo, error) {
+	document := ttsweb.DocumentInfo{}
+	response, err := c.do(http.MethodPost, "/documents", contentType, body)
+	if err != nil {
+		return document, err
+	}
+	defer response.Body.Close()
+	return document, json.NewDecoder(response.Body).Decode(&document)
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"imitablerabbit/ttsweb"
+)
+
+// pollInterval is how often the server is asked for the progress of the
+// documents and jobs.
+const pollInterval = 2 * time.Second
+
+// -----------------------------------------------------------------------------
+// Upload
+// -----------------------------------------------------------------------------
+
+// runUpload will upload files, the supported files in directories and web
+// pages as new documents.
+func runUpload(c *client, args []string) error {
+	flags := newFlagSet("upload")
+	name := flags.String("name", "", "the name of the document, only when uploading a single file or URL")
+	language := flags.String("language", "", "the language code of the documents, detected if empty")
+	wait := flags.Bool("wait", false, "wait for the documents to be synthesized")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("no files to upload")
+	}
+
+	// Find the files in the directories.
+	sources := []string{}
+	for _, arg := range flags.Args() {
+		if strings.HasPrefix(arg, "http://") || strings.HasPrefix(arg, "https://") {
+			sources = append(sources, arg)
+			continue
+		}
+		files, err := uploadFiles(arg)
+		if err != nil {
+			return err
+		}
+		sources = append(sources, files...)
+	}
+	if *name != "" && len(sources) > 1 {
+		return fmt.Errorf("-name can only be used with a single document")
+	}
+
+	// Upload each of the documents.
+	ids := []string{}
+	for _, source := range sources {
+		var document ttsweb.DocumentInfo
+		var err error
+		if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+			document, err = c.uploadURL(source, *name, *language)
+		} else {
+			document, err = c.uploadFile(source, *name, *language)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", source, err)
+		}
+		fmt.Printf("%s\t%s\t%s\n", document.ID, document.Name, source)
+		ids = append(ids, document.ID)
+	}
+
+	if *wait {
+		return waitForDocuments(c, ids, 0)
+	}
+	return nil
+}
+
+// uploadFiles will return the file, or the supported files in the directory
+// and its subdirectories. Other files are left out when a directory is
+// uploaded.
+func uploadFiles(root string) ([]string, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{root}, nil
+	}
+
+	files := []string{}
+	err = filepath.WalkDir(root, func(file string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			return nil
+		}
+		for _, extension := range ttsweb.DocumentExtensions {
+			if strings.EqualFold(filepath.Ext(file), extension) {
+				files = append(files, file)
+				break
+			}
+		}
+		return nil
+	})
+	return files, err
+}
+
+// -----------------------------------------------------------------------------
+// List
+// -----------------------------------------------------------------------------
+
+// runList will list the documents with their status.
+func runList(c *client, args []string) error {
+	flags := newFlagSet("list")
+	status := flags.String("status", "", "only list the documents with the status, e.g. failed")
+	flags.Parse(args)
+
+	documents, err := c.documents()
+	if err != nil {
+		return err
+	}
+
+	table := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "ID\tSTATUS\tLANGUAGE\tSIZE\tNAME")
+	for _, document := range documents {
+		if *status != "" && document.Status != *status {
+			continue
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%d\t%s\n", document.ID, document.Status, document.Language, document.Size, document.Name)
+	}
+	return table.Flush()
+}
+
+// -----------------------------------------------------------------------------
+// Wait
+// -----------------------------------------------------------------------------
+
+// runWait will wait for the documents to be synthesized.
+func runWait(c *client, args []string) error {
+	flags := newFlagSet("wait")
+	timeout := flags.Duration("timeout", 0, "give up after this long, e.g. 30m, or 0 to wait forever")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("no documents to wait for")
+	}
+	return waitForDocuments(c, flags.Args(), *timeout)
+}
+
+// waitForDocuments will poll the server until each of the documents has been
+// synthesized or has failed. The progress of their jobs is printed while
+// waiting. An error is returned if any of the documents failed.
+func waitForDocuments(c *client, ids []string, timeout time.Duration) error {
+	started := time.Now()
+	pending := map[string]bool{}
+	for _, id := range ids {
+		pending[id] = true
+	}
+	failed := 0
+	printed := map[string]string{}
+	for {
+		documents, err := c.documents()
+		if err != nil {
+			return err
+		}
+		found := map[string]bool{}
+		for _, document := range documents {
+			found[document.ID] = true
+			if !pending[document.ID] {
+				continue
+			}
+			switch document.Status {
+			case ttsweb.StatusSynthesized:
+				fmt.Printf("%s\t%s\n", document.ID, document.Status)
+				delete(pending, document.ID)
+			case ttsweb.StatusFailed:
+				fmt.Printf("%s\t%s\t%s\n", document.ID, document.Status, document.Error)
+				delete(pending, document.ID)
+				failed++
+			}
+		}
+		for id := range pending {
+			if !found[id] {
+				return fmt.Errorf("document not found: %s", id)
+			}
+		}
+		if len(pending) == 0 {
+			break
+		}
+
+		// Print the progress of the documents that are still running.
+		jobs, err := c.jobs("")
+		if err != nil {
+			return err
+		}
+		for _, job := range jobs {
+			if !pending[job.DocumentID] || job.Status != ttsweb.JobRunning {
+				continue
+			}
+			line := formatJob(job)
+			if printed[job.ID] != line {
+				fmt.Println(line)
+				printed[job.ID] = line
+			}
+		}
+
+		if timeout > 0 && time.Since(started) > timeout {
+			return fmt.Errorf("timed out waiting for %d documents", len(pending))
+		}
+		time.Sleep(pollInterval)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d documents failed", failed, len(ids))
+	}
+	return nil
+}
+
+// -----------------------------------------------------------------------------
+// Download
+// -----------------------------------------------------------------------------
+
+// runDownload will download the audio of a document as a single file, or its
+// bookmarks as markdown.
+func runDownload(c *client, args []string) error {
+	flags := newFlagSet("download")
+	format := flags.String("format", "", "the audio format, wav or mp3, chosen by the server if empty")
+	bookmarks := flags.Bool("bookmarks", false, "download the bookmarks as markdown instead of the audio")
+	output := flags.String("o", "", "the file to write, named after the document if empty, or - for stdout")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return fmt.Errorf("one document ID is required")
+	}
+	id := flags.Arg(0)
+
+	path := "/documents/" + url.PathEscape(id) + "/stream"
+	if *format != "" {
+		path += "?format=" + url.QueryEscape(*format)
+	}
+	if *bookmarks {
+		path = "/documents/" + url.PathEscape(id) + "/bookmarks?format=markdown"
+	}
+	response, err := c.do(http.MethodGet, path, "", nil)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	// Name the file after the document.
+	file := *output
+	if file == "" {
+		file, err = downloadFilename(c, id, response.Header.Get("Content-Type"))
+		if err != nil {
+			return err
+		}
+	}
+
+	var out io.Writer = os.Stdout
+	if file != "-" {
+		f, err := os.Create(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	written, err := io.Copy(out, response.Body)
+	if err != nil {
+		return err
+	}
+	if file != "-" {
+		fmt.Printf("%s\t%d bytes\n", file, written)
+	}
+	return nil
+}
+
+// downloadFilename will return a file name from the name of the document and
+// the extension of the content type.
+func downloadFilename(c *client, id, contentType string) (string, error) {
+	documents, err := c.documents()
+	if err != nil {
+		return "", err
+	}
+	name := id
+	for _, document := range documents {
+		if document.ID == id && document.Name != "" {
+			name = document.Name
+		}
+	}
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < ' ' {
+			return '_'
+		}
+		return r
+	}, name)
+
+	extension := ".bin"
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case ttsweb.FormatWav.ContentType:
+		extension = ttsweb.FormatWav.Extension
+	case ttsweb.FormatMP3.ContentType:
+		extension = ttsweb.FormatMP3.Extension
+	case "text/markdown":
+		extension = "-bookmarks.md"
+	}
+	return name + extension, nil
+}
+
+// -----------------------------------------------------------------------------
+// Delete
+// -----------------------------------------------------------------------------
+
+// runDelete will delete the documents.
+func runDelete(c *client, args []string) error {
+	flags := newFlagSet("delete")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("no documents to delete")
+	}
+	for _, id := range flags.Args() {
+		response, err := c.do(http.MethodDelete, "/documents/"+url.PathEscape(id), "", nil)
+		if err != nil {
+			return err
+		}
+		response.Body.Close()
+		fmt.Printf("%s\tdeleted\n", id)
+	}
+	return nil
+}
+
+// -----------------------------------------------------------------------------
+// Jobs
+// -----------------------------------------------------------------------------
+
+// runJobs will show the progress of the jobs. With -follow, the changes to
+// the jobs are printed until none of them are running.
+func runJobs(c *client, args []string) error {
+	flags := newFlagSet("jobs")
+	document := flags.String("document", "", "only show the jobs of the document")
+	follow := flags.Bool("follow", false, "print the progress of the jobs until they have finished")
+	flags.Parse(args)
+
+	printed := map[string]string{}
+	for {
+		jobs, err := c.jobs(*document)
+		if err != nil {
+			return err
+		}
+		running := 0
+		for _, job := range jobs {
+			if job.Status == ttsweb.JobRunning {
+				running++
+			}
+			line := formatJob(job)
+			if printed[job.ID] != line {
+				fmt.Println(line)
+				printed[job.ID] = line
+			}
+		}
+		if !*follow || running == 0 {
+			return nil
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// formatJob will describe the progress of a job on one line.
+func formatJob(job ttsweb.Job) string {
+	line := fmt.Sprintf("%s\t%s\t%s\t%s", job.ID, job.DocumentID, job.Type, job.Status)
+	if job.Stage != "" {
+		line += "\t" + job.Stage
+		if job.Total > 0 {
+			line += fmt.Sprintf(" %d/%d", job.Done, job.Total)
+		}
+	}
+	if job.Error != "" {
+		line += "\t" + job.Error
+	}
+	return line
+}
@@ -0,0 +1,103 @@
+// ttsctl is a command line client for the ttsweb server. It uploads documents,
+// lists them, waits for them to be synthesized, downloads their audio and
+// bookmarks, deletes them and follows the progress of the jobs.
+//
+// Usage:
+//
+//	ttsctl [-server URL] <command> [flags] [arguments]
+//
+// The server can also be set with the TTSWEB_SERVER environment variable.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// command is a subcommand of ttsctl.
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(c *client, args []string) error
+}
+
+// commands are the subcommands of ttsctl. They are set in init since the
+// commands print their own usage.
+var commands []command
+
+func init() {
+	commands = []command{
+		{"upload", "upload [-name NAME] [-language CODE] [-wait] FILE|DIR|URL...", "upload files, the supported files in directories, or web pages", runUpload},
+		{"list", "list [-status STATUS]", "list the documents with their status", runList},
+		{"wait", "wait [-timeout DURATION] ID...", "wait for documents to be synthesized", runWait},
+		{"download", "download [-format wav|mp3] [-bookmarks] [-o FILE] ID", "download the audio or the bookmarks of a document", runDownload},
+		{"delete", "delete ID...", "delete documents and all of their files", runDelete},
+		{"jobs", "jobs [-document ID] [-follow]", "show the progress of the jobs", runJobs},
+	}
+}
+
+var serverFlag = flag.String("server", defaultServer(), "the URL of the ttsweb server")
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	c := newClient(*serverFlag)
+	for _, cmd := range commands {
+		if cmd.name != flag.Arg(0) {
+			continue
+		}
+		if err := cmd.run(c, flag.Args()[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, "ttsctl "+cmd.name+":", err)
+			os.Exit(1)
+		}
+		return
+	}
+	fmt.Fprintln(os.Stderr, "ttsctl: unknown command:", flag.Arg(0))
+	usage()
+	os.Exit(2)
+}
+
+// defaultServer will return the server from the environment, or the local
+// server on the default port.
+func defaultServer() string {
+	if server := os.Getenv("TTSWEB_SERVER"); server != "" {
+		return server
+	}
+	return "http://localhost:8080"
+}
+
+// usage will print the commands and the global flags.
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: ttsctl [-server URL] <command> [flags] [arguments]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.summary)
+		fmt.Fprintf(os.Stderr, "  %-10s   ttsctl %s\n", "", cmd.usage)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Flags:")
+	flag.PrintDefaults()
+}
+
+// newFlagSet will create the flags of a command. The usage of the command is
+// printed if the flags cannot be parsed.
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		for _, cmd := range commands {
+			if cmd.name == name {
+				fmt.Fprintln(os.Stderr, "Usage: ttsctl "+cmd.usage)
+			}
+		}
+		flags.PrintDefaults()
+	}
+	return flags
+}
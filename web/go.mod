@@ -1,7 +1,9 @@
 module main
 
-go 1.20
+go 1.21
 
-replace imitablerabbit/ttsweb  => ./ttsweb
+replace imitablerabbit/ttsweb => ./ttsweb
 
 require imitablerabbit/ttsweb v0.0.0
+
+require golang.org/x/text v0.22.0 // indirect
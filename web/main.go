@@ -1,11 +1,16 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"imitablerabbit/ttsweb"
 )
@@ -15,11 +20,41 @@ var (
 	staticFilesFlag = flag.String("static", "build/static", "the static file directory")
 
 	documentsDirFlag = flag.String("documents-dir", "documents/", "the directory that contains the documents")
+
+	watchDirFlag      = flag.String("watch-dir", "", "a folder that is watched for new documents, which are moved to its archive subfolder once they are uploaded")
+	watchIntervalFlag = flag.Duration("watch-interval", 5*time.Second, "how often the watch folder is checked for new documents")
+
+	logFormatFlag = flag.String("log-format", "text", "the format of the log records written to stderr (text, json)")
+	logLevelFlag  = flag.String("log-level", "info", "the lowest level of the log records that are written (debug, info, warn, error)")
+
+	shutdownTimeoutFlag = flag.Duration("shutdown-timeout", 30*time.Second, "how long the documents that are being processed are given to finish when the server is stopped, before they are interrupted and left to be resumed at the next start")
+
+	selfCheckFlag           = flag.Bool("self-check", true, "check the splitters, voices and audio formats at startup, and refuse the uploads that they cannot process")
+	healthCheckIntervalFlag = flag.Duration("health-check-interval", 10*time.Minute, "how often the splitters, voices and audio formats are checked again while the server is running, 0 to only check them at startup")
+
+	webhooksFlag = flag.String("webhooks", "", "JSON file of webhooks that are sent the events of documents being created, synthesized, failing and deleted")
+
+	pipelineFlags = ttsweb.RegisterPipelineFlags(flag.CommandLine)
 )
 
 func main() {
 	flag.Parse()
 
+	// Write structured logs to stderr.
+	logLevel, err := ttsweb.ParseLogLevel(*logLevelFlag)
+	if err != nil {
+		panic(err)
+	}
+	logger, err := ttsweb.NewLogger(os.Stderr, *logFormatFlag, logLevel)
+	if err != nil {
+		panic(err)
+	}
+	slog.SetDefault(logger)
+
+	// Shut down gracefully when the server is interrupted or terminated.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	listenAddress := fmt.Sprintf(":%d", *portFlag)
 
 	// Verify the required directories.
@@ -37,34 +72,118 @@ func main() {
 	}
 	documents.SetDocumentsDir(*documentsDirFlag)
 
-	// Create the http server.
+	// Configure the pipeline that uploaded documents are processed with.
+	pipeline, err := pipelineFlags.Pipeline()
+	if err != nil {
+		panic(err)
+	}
+	pipeline.SearchIndex = ttsweb.NewSearchIndex()
+	pipeline.Jobs = ttsweb.NewJobs()
+	documents.SetPipeline(pipeline)
+
+	// Check the backends of the pipeline in the background, as the test
+	// synthesis can take a while, and again every interval. The server is
+	// not ready until they have been checked.
+	if *selfCheckFlag {
+		pipeline.Health = ttsweb.NewHealth()
+		go pipeline.Health.Watch(ctx, pipeline, *healthCheckIntervalFlag)
+	}
+
+	// Tell the webhooks about the lifecycle of the documents.
+	var webhooks *ttsweb.Webhooks
+	if *webhooksFlag != "" {
+		hooks, err := ttsweb.LoadWebhooks(*webhooksFlag)
+		if err != nil {
+			panic(err)
+		}
+		webhooks = ttsweb.NewWebhooks(hooks)
+		documents.SetWebhooks(webhooks)
+	}
+
+	// Resume the documents that were interrupted by the last shutdown.
+	if resumed := documents.ResumeDocuments(context.Background()); resumed > 0 {
+		slog.Info("Resuming interrupted documents", "documents", resumed)
+	}
+
+	// Build the search index from the paragraphs of the documents in the
+	// background, so that the server can start while it is built.
+	go func() {
+		if err := documents.RebuildSearchIndex(); err != nil {
+			slog.Error("Unable to build the search index", "error", err)
+			return
+		}
+		indexed, paragraphs := pipeline.SearchIndex.Size()
+		slog.Info("Indexed the documents for search", "documents", indexed, "paragraphs", paragraphs)
+	}()
+
+	// Upload the documents that are dropped into the watch folder.
+	if *watchDirFlag != "" {
+		if err := verifyPath(*watchDirFlag); err != nil {
+			panic(err)
+		}
+		watcher := ttsweb.NewFolderWatcher(*watchDirFlag, documents)
+		watcher.Interval = *watchIntervalFlag
+		go watcher.Watch(ctx.Done())
+		slog.Info("Watching for new documents", "dir", *watchDirFlag, "interval", watchIntervalFlag.String())
+	}
+
+	// Create the http server. Each request is given an ID and logged once it
+	// has been served.
 	server := &http.Server{
 		Addr: listenAddress,
-		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			fmt.Printf("Request: %s %s\n", r.Method, r.URL.Path)
-
+		Handler: ttsweb.LogRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// Check if the request is for a static file.
 			if strings.HasPrefix(r.URL.Path, "/static/") {
 				http.StripPrefix("/static/", http.FileServer(http.Dir(*staticFilesFlag+"/"))).ServeHTTP(w, r)
 				return
 			}
 
-			// Check if the request is for a document.
-			if strings.HasPrefix(r.URL.Path, "/documents") {
+			// Check if the request is for a document, a lexicon, a bookmark,
+			// the statistics, a job, a search, the webhooks, the metrics or
+			// the health of the server.
+			if strings.HasPrefix(r.URL.Path, "/documents") || strings.HasPrefix(r.URL.Path, "/lexicons") || strings.HasPrefix(r.URL.Path, "/bookmarks") ||
+				strings.HasPrefix(r.URL.Path, "/stats") || strings.HasPrefix(r.URL.Path, "/jobs") || strings.HasPrefix(r.URL.Path, "/search") ||
+				strings.HasPrefix(r.URL.Path, "/webhooks") || r.URL.Path == "/metrics" || r.URL.Path == "/healthz" || r.URL.Path == "/healthz/check" || r.URL.Path == "/readyz" {
 				documents.ServeHTTP(w, r)
 				return
 			}
 
 			// Otherwise, serve the index page.
 			http.ServeFile(w, r, *staticFilesFlag+"/html/index.html")
-		}),
+		})),
 	}
 
 	// Start the server.
-	fmt.Printf("Listening on %s for HTTP requests ...\n", listenAddress)
-	if err := server.ListenAndServe(); err != nil {
-		panic(err)
+	go func() {
+		slog.Info("Listening for HTTP requests", "address", listenAddress)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Error("Unable to serve HTTP requests", "error", err)
+			os.Exit(1)
+		}
+	}()
+
+	// Wait for a signal, then stop accepting documents and give the ones that
+	// are being processed time to finish. A second signal stops the server
+	// straight away.
+	<-ctx.Done()
+	stop()
+	slog.Info("Shutting down", "timeout", shutdownTimeoutFlag.String())
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *shutdownTimeoutFlag)
+	defer cancel()
+	if err := documents.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("Interrupted the documents that were being processed, they are resumed at the next start", "error", err)
+	}
+
+	// Let the HTTP requests finish, and deliver the webhooks that are still
+	// being sent, e.g. for the documents that finished during the shutdown.
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("Closed the HTTP requests that had not finished", "error", err)
+		server.Close()
+	}
+	if err := webhooks.Wait(shutdownCtx); err != nil {
+		slog.Warn("Gave up on the webhooks that had not been delivered", "error", err)
 	}
+	slog.Info("Shut down")
 }
 
 // verifyPath will verify that the path is valid. The path must be a directory
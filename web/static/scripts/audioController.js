@@ -8,6 +8,10 @@ finished playing.
 
 When an audio file has finished playing, the AudioController will
 notify the document model that the current paragraph has changed.
+
+The AudioController also records listening sessions. A session starts when
+audio begins to play and ends when it is paused, another document is opened
+or the page is closed.
 */
 export class AudioController {
     constructor(model) {
@@ -16,10 +20,16 @@ export class AudioController {
 
         this.playing = false;
 
+        // The listening session that is being recorded and the position in
+        // the audio that was last counted towards it.
+        this.session = null;
+        this.lastTime = 0;
+
         this.audioElement = document.getElementById('audio-player');
 
         // Subscribe to the document model to be notified when the document has been loaded.
         this.model.addEventListener('documentOpened', (e) => {
+            this.endSession(false);
             this.document = e.detail;
             this.updateView();
             
@@ -43,6 +53,28 @@ export class AudioController {
             }
             console.log('pause');
             this.playing = false;
+            this.endSession(false);
+        });
+
+        // Count the audio that has been played towards the session. Jumps
+        // in the audio, such as seeking, are not counted.
+        this.audioElement.addEventListener('timeupdate', (e) => {
+            let time = this.audioElement.currentTime;
+            if (!this.audioElement.paused && this.document) {
+                if (this.session === null) {
+                    this.startSession();
+                }
+                let delta = time - this.lastTime;
+                if (delta > 0 && delta < 5) {
+                    this.session.duration += delta;
+                }
+            }
+            this.lastTime = time;
+        });
+
+        // Record the session before the page is closed.
+        window.addEventListener('pagehide', (e) => {
+            this.endSession(true);
         });
 
         this.audioElement.addEventListener('ended', (e) => {
@@ -57,6 +89,50 @@ export class AudioController {
         });
     }
 
+    // Start recording a listening session at the current paragraph.
+    startSession() {
+        this.session = {
+            document: this.document,
+            startParagraphId: String(this.document.getCurrentParagraphIndex()),
+            started: new Date(),
+            duration: 0,
+        };
+    }
+
+    // Finish the listening session at the current paragraph and send it to
+    // the server. Sessions of less than a second are not recorded.
+    endSession(closing) {
+        let session = this.session;
+        this.session = null;
+        if (session === null || session.duration < 1) {
+            return;
+        }
+        session.document.recordSession({
+            startParagraphId: session.startParagraphId,
+            endParagraphId: String(session.document.getCurrentParagraphIndex()),
+            started: session.started.toISOString(),
+            ended: new Date().toISOString(),
+            duration: session.duration,
+        }, closing);
+    }
+
+    // Get the number of seconds into the audio of the current paragraph.
+    getCurrentOffset() {
+        return this.audioElement.currentTime || 0;
+    }
+
+    // Move to the number of seconds into the audio of the current paragraph.
+    // If the audio is still loading, this will wait for it to be loaded.
+    seek(offset) {
+        if (this.audioElement.readyState >= HTMLMediaElement.HAVE_METADATA) {
+            this.audioElement.currentTime = offset;
+            return;
+        }
+        this.audioElement.addEventListener('loadedmetadata', () => {
+            this.audioElement.currentTime = offset;
+        }, {once: true});
+    }
+
     // Update the audio view. This will create a new audio element within
     // the audio container and set the source to the current paragraph's
     // audio file. Automatically starts playing the audio if the playing
@@ -76,6 +152,7 @@ export class AudioController {
         let index = this.document.currentParagraphIndex;
         let paragraph = this.document.paragraphs[index];
         this.audioElement.src = paragraph.audioLink;
+        this.lastTime = 0;
 
         // If the audio is playing, start playing the new audio.
         if (this.playing) {
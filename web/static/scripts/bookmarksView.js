@@ -0,0 +1,125 @@
+import * as alert from './alert.js'
+
+/*
+View for the bookmarks of the current document.
+
+The current paragraph can be bookmarked from the sidebar with a color and a
+note, at the point in the audio that is playing. The bookmarks are listed
+below the form, and clicking on a bookmark moves back to it.
+*/
+export class BookmarksView {
+    constructor(model, audioController) {
+        this.model = model;
+        this.audioController = audioController;
+        this.document = model.currentDocument;
+
+        this.bookmarkFormElement = document.getElementById('bookmark-form');
+        this.bookmarkColorElement = document.getElementById('bookmark-color');
+        this.bookmarkNoteElement = document.getElementById('bookmark-note');
+        this.bookmarkExportElement = document.getElementById('bookmark-export');
+        this.bookmarksContentElement = document.getElementById('bookmarks-content');
+
+        this.bookmarkFormElement.addEventListener('submit', (e) => {
+            e.preventDefault();
+            this.addBookmark();
+        });
+
+        // Subscribe to the document model to be notified when the document has been loaded.
+        this.model.addEventListener('documentOpened', (e) => {
+            this.document = e.detail;
+            this.updateColors();
+            this.updateView();
+
+            this.document.addEventListener('bookmarksChanged', (e) => {
+                this.updateView();
+            });
+        });
+    }
+
+    // Bookmark the current paragraph at the point that the audio is at.
+    addBookmark() {
+        if (!this.document) {
+            return;
+        }
+        let paragraphID = this.document.getCurrentParagraphIndex();
+        let offset = this.audioController.getCurrentOffset();
+        let color = this.bookmarkColorElement.value;
+        let note = this.bookmarkNoteElement.value;
+        this.document.addBookmark(paragraphID, offset, color, note).then(() => {
+            this.bookmarkNoteElement.value = '';
+            alert.success('Bookmark added.');
+        }).catch((e) => {
+            alert.error('Error adding bookmark: ' + e);
+        });
+    }
+
+    // Update the colors that bookmarks can have.
+    updateColors() {
+        this.bookmarkColorElement.innerHTML = '';
+        for (let i = 0; i < this.document.bookmarkColors.length; i++) {
+            let color = this.document.bookmarkColors[i];
+            let optionElement = document.createElement('option');
+            optionElement.value = color;
+            optionElement.innerText = color;
+            this.bookmarkColorElement.appendChild(optionElement);
+        }
+        this.bookmarkExportElement.href = '/documents/' + this.document.id + '/bookmarks?format=markdown';
+    }
+
+    // Update the view with the list of bookmarks.
+    updateView() {
+        this.bookmarksContentElement.innerHTML = '';
+        if (!this.document) {
+            return;
+        }
+
+        for (let i = 0; i < this.document.bookmarks.length; i++) {
+            let bookmark = this.document.bookmarks[i];
+
+            let bookmarkElement = document.createElement('div');
+            bookmarkElement.classList.add('bookmark');
+            bookmarkElement.classList.add('bookmark-' + bookmark.color);
+
+            let titleElement = document.createElement('a');
+            titleElement.classList.add('bookmark-title');
+            titleElement.href = bookmark.link;
+            titleElement.innerText = 'Paragraph ' + bookmark.paragraphId;
+            if (bookmark.offset !== undefined) {
+                titleElement.innerText += ' at ' + formatOffset(bookmark.offset);
+            }
+            titleElement.addEventListener('click', (e) => {
+                e.preventDefault();
+                this.document.setCurrentParagraphIndex(parseInt(bookmark.paragraphId));
+                if (bookmark.offset !== undefined) {
+                    this.audioController.seek(bookmark.offset);
+                }
+            });
+            bookmarkElement.appendChild(titleElement);
+
+            let deleteElement = document.createElement('button');
+            deleteElement.classList.add('close');
+            deleteElement.innerText = 'x';
+            deleteElement.addEventListener('click', (e) => {
+                this.document.deleteBookmark(bookmark.id).catch((e) => {
+                    alert.error('Error removing bookmark: ' + e);
+                });
+            });
+            bookmarkElement.appendChild(deleteElement);
+
+            if (bookmark.note) {
+                let noteElement = document.createElement('p');
+                noteElement.classList.add('bookmark-note');
+                noteElement.innerText = bookmark.note;
+                bookmarkElement.appendChild(noteElement);
+            }
+
+            this.bookmarksContentElement.appendChild(bookmarkElement);
+        }
+    }
+}
+
+// Format the number of seconds as minutes and seconds, e.g. 1:05.
+function formatOffset(offset) {
+    let seconds = Math.floor(offset);
+    return Math.floor(seconds / 60) + ':' + String(seconds % 60).padStart(2, '0');
+}
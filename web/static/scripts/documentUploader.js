@@ -13,6 +13,10 @@ export class DocumentUploader {
                 <input type="text" name="document-name" id="document-name">
                 <label for="document-file">Document File:</label>
                 <input type="file" name="document" id="document-file">
+                <label for="document-url">Or Web Page URL:</label>
+                <input type="url" name="document-url" id="document-url">
+                <label for="document-language">Language:</label>
+                <select name="document-language" id="document-language">...</select>
                 <button id="document-upload-submit">Submit</button>
             </form>
         */
@@ -20,6 +24,8 @@ export class DocumentUploader {
         this.form = document.getElementById('document-upload-form');
         this.nameInput = document.getElementById('document-name');
         this.fileInput = document.getElementById('document-file');
+        this.urlInput = document.getElementById('document-url');
+        this.languageInput = document.getElementById('document-language');
         this.submitButton = document.getElementById('document-upload-submit');
 
         this.form.addEventListener('submit', (event) => {
@@ -36,7 +42,9 @@ export class DocumentUploader {
     submit() {
         let name = this.nameInput.value;
         let file = this.fileInput.files[0];
-        this.model.uploadDocument(name, file).then((d) => {
+        let url = this.urlInput.value;
+        let language = this.languageInput.value;
+        this.model.uploadDocument(name, file, url, language).then((d) => {
             alert.success('Document uploaded: ' + d.name);
         }).catch((e) => {
             alert.error('Error uploading document: ' + e);
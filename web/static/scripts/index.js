@@ -4,6 +4,10 @@ import { Model } from './model.js'
 import { MainReaderView } from './mainReaderView.js';
 import { SidebarParagraphView } from './sidebarParagraphView.js';
 import { SidebarLoadDocumentView } from './sidebarLoadDocumentView.js';
+import { SkipClassesView } from './skipClassesView.js';
+import { SearchView } from './searchView.js';
+import { BookmarksView } from './bookmarksView.js';
+import { StatsView } from './statsView.js';
 import { AudioController } from './audioController.js';
 import { SaveDocumentPositionController } from './saveDocumentPosition.js';
 
@@ -22,6 +26,10 @@ var saveDocumentPositionController;
 var mainReaderView;
 var sidebarParagraphView;
 var sidebarLoadDocumentView;
+var skipClassesView;
+var searchView;
+var bookmarksView;
+var statsView;
 
 var documentUploader;
 
@@ -38,6 +46,10 @@ function init() {
     mainReaderView = new MainReaderView(model);
     sidebarParagraphView = new SidebarParagraphView(model);
     sidebarLoadDocumentView = new SidebarLoadDocumentView(model);
+    skipClassesView = new SkipClassesView(model);
+    searchView = new SearchView(model);
+    bookmarksView = new BookmarksView(model, audioController);
+    statsView = new StatsView(model);
     audioController = new AudioController(model);
     saveDocumentPositionController = new SaveDocumentPositionController(model, audioController);
 
@@ -70,4 +82,11 @@ function start() {
         });
     });
     // saveDocumentPositionController.loadLastDocument();
+
+    // Open the document at the paragraph in the link, e.g. a link to a
+    // search result.
+    let params = new URLSearchParams(window.location.search);
+    if (params.get('document')) {
+        model.openDocumentAtParagraph(params.get('document'), params.get('paragraph') || 0);
+    }
 }
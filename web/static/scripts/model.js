@@ -74,12 +74,21 @@ export class Model {
     }
 
     // Upload the document to the server. This will return a promise
-    // that will be resolved when the document has been uploaded.
-    uploadDocument(documentName, documentFile) {
+    // that will be resolved when the document has been uploaded. If a
+    // URL is given, the server will fetch the document from the URL
+    // instead of the file being uploaded.
+    uploadDocument(documentName, documentFile, documentURL, documentLanguage) {
         return new Promise((resolve, reject) => {
             let formData = new FormData();
             formData.append('name', documentName);
-            formData.append('file', documentFile);
+            if (documentLanguage) {
+                formData.append('language', documentLanguage);
+            }
+            if (documentURL) {
+                formData.append('url', documentURL);
+            } else {
+                formData.append('file', documentFile);
+            }
 
             let request = new XMLHttpRequest();
             request.open('POST', '/documents');
@@ -108,6 +117,71 @@ export class Model {
         });
     }
 
+    // Open the document and move to the paragraph once the paragraphs have
+    // been loaded. This is used by search results and links to a paragraph.
+    openDocumentAtParagraph(documentID, paragraphID) {
+        let index = parseInt(paragraphID);
+        if (this.currentDocument !== null && this.currentDocument.id === documentID && this.currentDocument.loaded) {
+            this.currentDocument.setCurrentParagraphIndex(index);
+            return Promise.resolve(this.currentDocument);
+        }
+        return this.openDocument(documentID).then((d) => {
+            d.addEventListener(d.DOCUMENT_LOADED, () => {
+                d.setCurrentParagraphIndex(index);
+            });
+            return d;
+        });
+    }
+
+    // Load the listening statistics of all of the documents for the last
+    // number of days. This will return a promise that will be resolved with
+    // the statistics.
+    loadStats(days) {
+        return new Promise((resolve, reject) => {
+            let request = new XMLHttpRequest();
+            request.open('GET', '/stats?days=' + days);
+            request.responseType = 'json';
+            request.onreadystatechange = () => {
+                if (request.readyState !== XMLHttpRequest.DONE) {
+                    return;
+                }
+                if (request.status !== 200) {
+                    reject(request.response);
+                    return;
+                }
+                resolve(request.response);
+            };
+            request.send();
+        });
+    }
+
+    // Search the paragraphs of the documents. If a document ID is given, only
+    // that document is searched. This will return a promise that will be
+    // resolved with the results.
+    search(query, documentID) {
+        return new Promise((resolve, reject) => {
+            let url = '/search?q=' + encodeURIComponent(query);
+            if (documentID) {
+                url += '&document=' + encodeURIComponent(documentID);
+            }
+
+            let request = new XMLHttpRequest();
+            request.open('GET', url);
+            request.responseType = 'json';
+            request.onreadystatechange = () => {
+                if (request.readyState !== XMLHttpRequest.DONE) {
+                    return;
+                }
+                if (request.status !== 200) {
+                    reject(request.response);
+                    return;
+                }
+                resolve(request.response.results);
+            };
+            request.send();
+        });
+    }
+
     // Register listeners for the model. The listeners will be called
     // when the model changes.
     addEventListener(event, eventHandler) {
@@ -145,6 +219,19 @@ export class DocumentModel {
         this.name = null;
         this.paragraphs = null;
 
+        // Table of contents entries for the document. Each entry contains
+        // the title, level and range of paragraphs of a chapter or section.
+        this.toc = [];
+
+        // Classes of paragraphs, such as footnotes, that are skipped during
+        // playback.
+        this.skipClasses = [];
+
+        // Bookmarks of paragraphs with their colors and notes, and the
+        // colors that bookmarks can have.
+        this.bookmarks = [];
+        this.bookmarkColors = [];
+
         // Has the document model been loaded from the server?
         this.loaded = false;
 
@@ -158,6 +245,9 @@ export class DocumentModel {
         this.DOCUMENT_LOADED = 'documentLoaded';
         this.PARAGRAPH_LOADED = 'paragraphLoaded';
         this.PARAGRAPH_CHANGED = 'paragraphChanged';
+        this.SKIP_CLASSES_CHANGED = 'skipClassesChanged';
+        this.BOOKMARKS_CHANGED = 'bookmarksChanged';
+        this.SESSION_RECORDED = 'sessionRecorded';
     }
 
     // Load the document from the server. This will return a promise
@@ -179,7 +269,21 @@ export class DocumentModel {
                 this.id = request.response.id;
                 this.name = request.response.name;
                 this.paragraphs = request.response.paragraphs; // paragraph info
+                this.skipClasses = request.response.skipClasses || [];
                 this.loaded = false;
+
+                // Load the table of contents before resolving so that the
+                // views can show the chapters along with the paragraphs.
+                try {
+                    await this.loadTableOfContents(documentID);
+                } catch (e) {
+                    console.log('Unable to load table of contents: ' + documentID);
+                }
+                try {
+                    await this.loadBookmarks(documentID);
+                } catch (e) {
+                    console.log('Unable to load bookmarks: ' + documentID);
+                }
                 resolve();
 
                 // Load the paragraphs in the document. Trigger the
@@ -245,14 +349,197 @@ export class DocumentModel {
         });
     }
 
-    // Move to the previous paragraph.
+    // Move to the previous paragraph. Paragraphs that are skipped are
+    // passed over.
     previousParagraph() {
-        this.setCurrentParagraphIndex(this.currentParagraphIndex - 1);
+        let index = this.currentParagraphIndex - 1;
+        while (index > 0 && this.paragraphs[index] && this.paragraphs[index].skip) {
+            index--;
+        }
+        this.setCurrentParagraphIndex(index);
     }
 
-    // Move to the next paragraph.
+    // Move to the next paragraph. Paragraphs that are skipped are passed
+    // over.
     nextParagraph() {
-        this.setCurrentParagraphIndex(this.currentParagraphIndex + 1);
+        let index = this.currentParagraphIndex + 1;
+        while (index < this.paragraphs.length - 1 && this.paragraphs[index] && this.paragraphs[index].skip) {
+            index++;
+        }
+        this.setCurrentParagraphIndex(index);
+    }
+
+    // Change the classes of paragraphs that are skipped. This will return a
+    // promise that will be resolved when the server has saved the classes.
+    // If paragraphs that were skipped now need audio, the server is asked to
+    // synthesize them.
+    setSkipClasses(classes) {
+        return new Promise((resolve, reject) => {
+            let request = new XMLHttpRequest();
+            request.open('PUT', '/documents/' + this.id + '/skip-classes');
+            request.responseType = 'json';
+            request.setRequestHeader('Content-Type', 'application/json');
+            request.onreadystatechange = () => {
+                if (request.readyState !== XMLHttpRequest.DONE) {
+                    return;
+                }
+                if (request.status !== 200) {
+                    reject(request.response);
+                    return;
+                }
+
+                this.skipClasses = request.response.skipClasses;
+                for (let i = 0; i < this.paragraphs.length; i++) {
+                    let paragraph = this.paragraphs[i];
+                    paragraph.skip = this.skipClasses.includes(paragraph.class);
+                }
+                if (request.response.staleParagraphs > 0) {
+                    let resynthesize = new XMLHttpRequest();
+                    resynthesize.open('POST', '/documents/' + this.id + '/resynthesize');
+                    resynthesize.send();
+                }
+
+                let e = new CustomEvent(this.SKIP_CLASSES_CHANGED, {detail: this.skipClasses});
+                this.listeners.forEach((listener) => {
+                    if (listener.event === this.SKIP_CLASSES_CHANGED) {
+                        listener.eventHandler(e);
+                    }
+                });
+                resolve(this.skipClasses);
+            };
+            request.send(JSON.stringify(classes));
+        });
+    }
+
+    // Bookmark a paragraph. The offset is the number of seconds into the
+    // audio of the paragraph, or null for the whole paragraph. This will
+    // return a promise that will be resolved with the new bookmark.
+    addBookmark(paragraphID, offset, color, note) {
+        let bookmark = {paragraphId: String(paragraphID), color: color, note: note};
+        if (offset !== null && offset !== undefined) {
+            bookmark.offset = offset;
+        }
+        return this.sendBookmarkRequest('POST', '/documents/' + this.id + '/bookmarks', bookmark).then((bookmark) => {
+            this.bookmarks.push(bookmark);
+            this.sortBookmarks();
+            this.fireBookmarksChanged();
+            return bookmark;
+        });
+    }
+
+    // Remove a bookmark. This will return a promise that will be resolved
+    // when the server has removed the bookmark.
+    deleteBookmark(bookmarkID) {
+        return this.sendBookmarkRequest('DELETE', '/documents/' + this.id + '/bookmarks/' + bookmarkID, null).then((bookmark) => {
+            this.bookmarks = this.bookmarks.filter((b) => b.id !== bookmarkID);
+            this.fireBookmarksChanged();
+            return bookmark;
+        });
+    }
+
+    // Get the bookmarks of a paragraph.
+    getParagraphBookmarks(paragraphID) {
+        return this.bookmarks.filter((b) => b.paragraphId === String(paragraphID));
+    }
+
+    // Send a request that changes a bookmark and resolve with the bookmark
+    // that the server responds with.
+    sendBookmarkRequest(method, url, body) {
+        return new Promise((resolve, reject) => {
+            let request = new XMLHttpRequest();
+            request.open(method, url);
+            request.responseType = 'json';
+            request.setRequestHeader('Content-Type', 'application/json');
+            request.onreadystatechange = () => {
+                if (request.readyState !== XMLHttpRequest.DONE) {
+                    return;
+                }
+                if (request.status !== 200) {
+                    reject(request.response);
+                    return;
+                }
+                resolve(request.response);
+            };
+            request.send(body === null ? null : JSON.stringify(body));
+        });
+    }
+
+    // Sort the bookmarks by the paragraph and the offset, the same as the
+    // server.
+    sortBookmarks() {
+        this.bookmarks.sort((a, b) => {
+            let paragraphs = parseInt(a.paragraphId) - parseInt(b.paragraphId);
+            if (paragraphs !== 0) {
+                return paragraphs;
+            }
+            let offsetA = a.offset === undefined ? -1 : a.offset;
+            let offsetB = b.offset === undefined ? -1 : b.offset;
+            return offsetA - offsetB;
+        });
+    }
+
+    // Trigger the bookmarks changed event.
+    fireBookmarksChanged() {
+        let e = new CustomEvent(this.BOOKMARKS_CHANGED, {detail: this.bookmarks});
+        this.listeners.forEach((listener) => {
+            if (listener.event === this.BOOKMARKS_CHANGED) {
+                listener.eventHandler(e);
+            }
+        });
+    }
+
+    // Record a listening session of the document. If the page is being
+    // closed, the session is sent with a beacon so that it is not lost and
+    // no event is triggered.
+    recordSession(session, closing) {
+        let url = '/documents/' + this.id + '/sessions';
+        if (closing) {
+            navigator.sendBeacon(url, new Blob([JSON.stringify(session)], {type: 'application/json'}));
+            return;
+        }
+
+        let request = new XMLHttpRequest();
+        request.open('POST', url);
+        request.responseType = 'json';
+        request.setRequestHeader('Content-Type', 'application/json');
+        request.onreadystatechange = () => {
+            if (request.readyState !== XMLHttpRequest.DONE) {
+                return;
+            }
+            if (request.status !== 200) {
+                console.log('Unable to record listening session: ' + this.id);
+                return;
+            }
+
+            let e = new CustomEvent(this.SESSION_RECORDED, {detail: request.response});
+            this.listeners.forEach((listener) => {
+                if (listener.event === this.SESSION_RECORDED) {
+                    listener.eventHandler(e);
+                }
+            });
+        };
+        request.send(JSON.stringify(session));
+    }
+
+    // Load the listening statistics of the document from the server. This
+    // will return a promise that will be resolved with the statistics.
+    loadStats() {
+        return new Promise((resolve, reject) => {
+            let request = new XMLHttpRequest();
+            request.open('GET', '/documents/' + this.id + '/stats');
+            request.responseType = 'json';
+            request.onreadystatechange = () => {
+                if (request.readyState !== XMLHttpRequest.DONE) {
+                    return;
+                }
+                if (request.status !== 200) {
+                    reject(request.response);
+                    return;
+                }
+                resolve(request.response);
+            };
+            request.send();
+        });
     }
 
     // Register listeners for the model. The listeners will be called
@@ -268,6 +555,53 @@ export class DocumentModel {
         this.listeners = [];
     }
 
+    // Load the table of contents from the server. This will return a promise
+    // that will be resolved when the table of contents has been loaded.
+    loadTableOfContents(documentID) {
+        return new Promise((resolve, reject) => {
+            let request = new XMLHttpRequest();
+            request.open('GET', '/documents/' + documentID + '/toc');
+            request.responseType = 'json';
+            request.onreadystatechange = () => {
+                if (request.readyState !== XMLHttpRequest.DONE) {
+                    return;
+                }
+                if (request.status !== 200) {
+                    reject();
+                    return;
+                }
+
+                this.toc = request.response.entries;
+                resolve(this.toc);
+            };
+            request.send();
+        });
+    }
+
+    // Load the bookmarks from the server. This will return a promise that
+    // will be resolved when the bookmarks have been loaded.
+    loadBookmarks(documentID) {
+        return new Promise((resolve, reject) => {
+            let request = new XMLHttpRequest();
+            request.open('GET', '/documents/' + documentID + '/bookmarks');
+            request.responseType = 'json';
+            request.onreadystatechange = () => {
+                if (request.readyState !== XMLHttpRequest.DONE) {
+                    return;
+                }
+                if (request.status !== 200) {
+                    reject();
+                    return;
+                }
+
+                this.bookmarks = request.response.bookmarks;
+                this.bookmarkColors = request.response.colors;
+                resolve(this.bookmarks);
+            };
+            request.send();
+        });
+    }
+
     // Load a batch of paragraphs from the server.
     loadParagraphs(documentID, startID, endID) {
         return new Promise((resolve, reject) => {
@@ -295,6 +629,8 @@ export class DocumentModel {
                     paragraphModel.content = paragraph.content;
                     paragraphModel.link = paragraph.link;
                     paragraphModel.audioLink = paragraph.audioLink;
+                    paragraphModel.class = paragraph.class;
+                    paragraphModel.skip = paragraph.skip;
                     this.paragraphs[paragraph.id] = paragraphModel;
 
                     let e = new CustomEvent(this.PARAGRAPH_LOADED, {detail: paragraphModel});
@@ -330,6 +666,8 @@ export class ParagraphModel {
         this.content = null;
         this.link = null;
         this.audioLink = null;
+        this.class = null;
+        this.skip = false;
     }
     
     // Load the paragraph from the server. This will return a promise
@@ -352,6 +690,8 @@ export class ParagraphModel {
                 this.content = request.response.content;
                 this.link = request.response.link;
                 this.audioLink = request.response.audioLink;
+                this.class = request.response.class;
+                this.skip = request.response.skip;
                 resolve();
             };
             request.send();
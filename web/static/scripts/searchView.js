@@ -0,0 +1,77 @@
+import * as alert from './alert.js'
+
+/*
+View for searching the text of the documents.
+
+The user enters a query in the sidebar, words in double quotes are matched
+as a phrase. The results are listed with a snippet of each paragraph that
+matched, and clicking on a result opens the document at that paragraph.
+*/
+export class SearchView {
+    constructor(model) {
+        this.model = model;
+
+        this.searchFormElement = document.getElementById('search-form');
+        this.searchQueryElement = document.getElementById('search-query');
+        this.searchScopeElement = document.getElementById('search-current-document');
+        this.searchResultsElement = document.getElementById('search-results');
+
+        this.searchFormElement.addEventListener('submit', (e) => {
+            e.preventDefault();
+            this.search();
+        });
+    }
+
+    // Search for the query and show the results.
+    search() {
+        let query = this.searchQueryElement.value.trim();
+        if (!query) {
+            return;
+        }
+        let documentID = null;
+        if (this.searchScopeElement.checked && this.model.currentDocument !== null) {
+            documentID = this.model.currentDocument.id;
+        }
+        this.model.search(query, documentID).then((results) => {
+            this.showResults(results);
+        }).catch((e) => {
+            alert.error('Error searching: ' + e);
+        });
+    }
+
+    // Show the results of a search. The snippets are HTML from the server
+    // with the matched words marked.
+    showResults(results) {
+        this.searchResultsElement.innerHTML = '';
+        if (results.length === 0) {
+            let emptyElement = document.createElement('p');
+            emptyElement.innerText = 'No paragraphs found.';
+            this.searchResultsElement.appendChild(emptyElement);
+            return;
+        }
+
+        for (let i = 0; i < results.length; i++) {
+            let result = results[i];
+
+            let resultElement = document.createElement('a');
+            resultElement.classList.add('search-result');
+            resultElement.href = result.link;
+            resultElement.addEventListener('click', (e) => {
+                e.preventDefault();
+                this.model.openDocumentAtParagraph(result.documentId, result.paragraphId);
+            });
+
+            let titleElement = document.createElement('span');
+            titleElement.classList.add('search-result-title');
+            titleElement.innerText = result.documentName + ' - paragraph ' + result.paragraphId;
+            resultElement.appendChild(titleElement);
+
+            let snippetElement = document.createElement('p');
+            snippetElement.classList.add('search-result-snippet');
+            snippetElement.innerHTML = result.snippet;
+            resultElement.appendChild(snippetElement);
+
+            this.searchResultsElement.appendChild(resultElement);
+        }
+    }
+}
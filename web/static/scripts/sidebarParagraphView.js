@@ -32,9 +32,28 @@ export class SidebarParagraphView {
                 let paragraphContentElement = this.paragraphContentCache[e.detail.id];
                 if (paragraphContentElement) {
                     paragraphContentElement.textContent = e.detail.content;
+                    paragraphContentElement.parentElement.classList.toggle('skipped-paragraph', !!e.detail.skip);
                 }
             });
 
+            // Subscribe to skip class changes. The paragraphs that are
+            // skipped are shown faded out.
+            this.model.currentDocument.addEventListener('skipClassesChanged', (e) => {
+                for (let i = 0; i < this.document.paragraphs.length; i++) {
+                    let paragraph = this.document.paragraphs[i];
+                    let paragraphContentElement = this.paragraphContentCache[paragraph.id];
+                    if (paragraphContentElement) {
+                        paragraphContentElement.parentElement.classList.toggle('skipped-paragraph', !!paragraph.skip);
+                    }
+                }
+            });
+
+            // Subscribe to bookmark changes. The paragraphs that are
+            // bookmarked are shown with a marker.
+            this.model.currentDocument.addEventListener('bookmarksChanged', (e) => {
+                this.updateBookmarkMarkers();
+            });
+
             // Subscribe to paragraph change events. When the current paragraph
             // has changed we will update the view to highlight the current
             // paragraph.
@@ -84,12 +103,43 @@ export class SidebarParagraphView {
         // Clear the paragraphs container element.
         this.paragraphsContainerElement.innerHTML = '';
 
+        // Group the table of contents entries by the paragraph they start
+        // at so that the headings can be added above the paragraphs.
+        let headings = {};
+        let toc = this.document.toc || [];
+        for (let i = 0; i < toc.length; i++) {
+            let entry = toc[i];
+            if (!headings[entry.startParagraphId]) {
+                headings[entry.startParagraphId] = [];
+            }
+            headings[entry.startParagraphId].push(entry);
+        }
+
         // Add the paragraphs to the container element.
         for (let i = 0; i < this.document.paragraphs.length; i++) {
             let paragraph = this.document.paragraphs[i];
+
+            // Add the chapter and section headings that start at this
+            // paragraph. Clicking on a heading will jump to the chapter.
+            let entries = headings[paragraph.id] || [];
+            for (let j = 0; j < entries.length; j++) {
+                let entry = entries[j];
+                let headingElement = document.createElement('h3');
+                headingElement.classList.add('paragraph-heading');
+                headingElement.classList.add('paragraph-heading-' + Math.min(entry.level, 3));
+                headingElement.textContent = entry.title;
+                headingElement.addEventListener('click', (e) => {
+                    this.document.setCurrentParagraphIndex(parseInt(entry.startParagraphId));
+                });
+                this.paragraphsContainerElement.appendChild(headingElement);
+            }
+
             let paragraphElement = document.createElement('div');
             paragraphElement.classList.add('paragraph');
             paragraphElement.dataset.id = paragraph.id;
+            if (paragraph.skip) {
+                paragraphElement.classList.add('skipped-paragraph');
+            }
 
             // Add the paragraph number.
             let paragraphNumberElement = document.createElement('p');
@@ -113,5 +163,29 @@ export class SidebarParagraphView {
             this.paragraphsContainerElement.appendChild(paragraphElement);
             this.paragraphContentCache[paragraph.id] = paragraphContentElement;
         }
+        this.updateBookmarkMarkers();
+    }
+
+    // Mark the paragraphs that have bookmarks with the color of their first
+    // bookmark. The notes of the bookmarks are shown when hovering over the
+    // paragraph number.
+    updateBookmarkMarkers() {
+        for (let i = 0; i < this.document.paragraphs.length; i++) {
+            let paragraph = this.document.paragraphs[i];
+            let paragraphContentElement = this.paragraphContentCache[paragraph.id];
+            if (!paragraphContentElement) {
+                continue;
+            }
+            let paragraphElement = paragraphContentElement.parentElement;
+            let bookmarks = this.document.getParagraphBookmarks(paragraph.id);
+            paragraphElement.classList.toggle('bookmarked-paragraph', bookmarks.length > 0);
+            if (bookmarks.length > 0) {
+                paragraphElement.dataset.bookmarkColor = bookmarks[0].color;
+                paragraphElement.title = bookmarks.map((b) => b.note).filter((note) => note).join('\n');
+            } else {
+                delete paragraphElement.dataset.bookmarkColor;
+                paragraphElement.removeAttribute('title');
+            }
+        }
     }
 }
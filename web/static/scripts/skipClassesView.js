@@ -0,0 +1,67 @@
+import * as alert from './alert.js'
+
+/*
+View for the classes of paragraphs that are skipped in the current document.
+Each class has a checkbox in the sidebar. Changing a checkbox saves the
+classes to the server, which will synthesize any paragraphs that are no
+longer skipped.
+*/
+export class SkipClassesView {
+    constructor(model) {
+        this.model = model;
+        this.document = model.currentDocument;
+
+        this.containerElement = document.getElementById('skip-classes-content');
+
+        // Labels for each of the paragraph classes.
+        this.classes = [
+            {name: 'heading', label: 'Headings'},
+            {name: 'table', label: 'Tables'},
+            {name: 'code', label: 'Code'},
+            {name: 'footnote', label: 'Footnotes'},
+            {name: 'reference', label: 'References'},
+            {name: 'page-number', label: 'Page numbers'},
+        ];
+
+        // Subscribe to the document model to be notified when the document has been loaded.
+        this.model.addEventListener('documentOpened', (e) => {
+            this.document = e.detail;
+            this.updateView();
+        });
+    }
+
+    // Update the view with a checkbox for each class of paragraph.
+    updateView() {
+        this.containerElement.innerHTML = '';
+        if (!this.document) {
+            return;
+        }
+
+        for (let i = 0; i < this.classes.length; i++) {
+            let paragraphClass = this.classes[i];
+
+            let labelElement = document.createElement('label');
+            let checkboxElement = document.createElement('input');
+            checkboxElement.type = 'checkbox';
+            checkboxElement.value = paragraphClass.name;
+            checkboxElement.checked = this.document.skipClasses.includes(paragraphClass.name);
+            checkboxElement.addEventListener('change', (e) => {
+                this.submit();
+            });
+            labelElement.appendChild(checkboxElement);
+            labelElement.appendChild(document.createTextNode(' ' + paragraphClass.label));
+            this.containerElement.appendChild(labelElement);
+        }
+    }
+
+    // Save the checked classes to the server.
+    submit() {
+        let checked = this.containerElement.querySelectorAll('input:checked');
+        let classes = Array.from(checked).map((checkbox) => checkbox.value);
+        this.document.setSkipClasses(classes).then(() => {
+            alert.success('Skipped content updated.');
+        }).catch((e) => {
+            alert.error('Error updating skipped content: ' + e);
+        });
+    }
+}
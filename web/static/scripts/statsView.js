@@ -0,0 +1,59 @@
+/*
+View for the listening statistics of the current document. This shows how
+much of the document has been listened to, the estimated time remaining and
+the time listened today. The statistics are updated whenever a listening
+session is recorded.
+*/
+export class StatsView {
+    constructor(model) {
+        this.model = model;
+        this.document = model.currentDocument;
+
+        this.statsContentElement = document.getElementById('stats-content');
+
+        // Subscribe to the document model to be notified when the document has been loaded.
+        this.model.addEventListener('documentOpened', (e) => {
+            this.document = e.detail;
+            this.updateView();
+
+            this.document.addEventListener('sessionRecorded', (e) => {
+                this.updateView();
+            });
+        });
+    }
+
+    // Load the statistics and update the view.
+    updateView() {
+        if (!this.document) {
+            return;
+        }
+        Promise.all([this.document.loadStats(), this.model.loadStats(1)]).then(([documentStats, stats]) => {
+            this.statsContentElement.innerHTML = '';
+
+            let progressElement = document.createElement('progress');
+            progressElement.max = 100;
+            progressElement.value = documentStats.completion;
+            this.statsContentElement.appendChild(progressElement);
+
+            let completionElement = document.createElement('p');
+            completionElement.innerText = documentStats.completion + '% listened, about ' +
+                formatDuration(documentStats.remaining) + ' remaining';
+            this.statsContentElement.appendChild(completionElement);
+
+            let todayElement = document.createElement('p');
+            todayElement.innerText = 'Listened today: ' + formatDuration(stats.days[0].listened);
+            this.statsContentElement.appendChild(todayElement);
+        }).catch((e) => {
+            console.log('Unable to load listening statistics: ' + e);
+        });
+    }
+}
+
+// Format the number of seconds as hours and minutes, e.g. 1h 5m.
+function formatDuration(seconds) {
+    let minutes = Math.round(seconds / 60);
+    if (minutes < 60) {
+        return minutes + 'm';
+    }
+    return Math.floor(minutes / 60) + 'h ' + (minutes % 60) + 'm';
+}
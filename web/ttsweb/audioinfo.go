@@ -0,0 +1,311 @@
+package ttsweb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// -----------------------------------------------------------------------------
+// Audio Info
+// -----------------------------------------------------------------------------
+
+// audioInfo describes the layout of a paragraph audio file. Only the region
+// between DataOffset and DataOffset+DataSize contains audio, the rest of the
+// file is container headers and tags. Audio files with the same Header can be
+// joined by concatenating their data regions.
+type audioInfo struct {
+	Format AudioFormat
+
+	// Header is the format description of the audio. For wav files this is
+	// the body of the "fmt " chunk. It is empty for mp3 files since each
+	// frame carries its own header.
+	Header []byte
+
+	// DataOffset is the offset of the first byte of audio data in the file.
+	DataOffset int64
+
+	// DataSize is the number of bytes of audio data in the file.
+	DataSize int64
+
+	// Duration is the playback time of the audio data.
+	Duration time.Duration
+
+	modTime time.Time
+}
+
+// audioInfoCache caches the parsed audio info by file path. An entry is only
+// used while the modification time and size of the file are unchanged.
+var audioInfoCache sync.Map
+
+// readAudioInfo will parse the audio file in the specified format.
+func readAudioInfo(path string, format AudioFormat) (audioInfo, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return audioInfo{}, err
+	}
+
+	// Check the cache before parsing the file.
+	if cached, ok := audioInfoCache.Load(path); ok {
+		info := cached.(audioInfo)
+		if info.Format.Name == format.Name && info.modTime.Equal(stat.ModTime()) &&
+			info.DataOffset+info.DataSize <= stat.Size() {
+			observeCache("audio_info", true)
+			return info, nil
+		}
+	}
+	observeCache("audio_info", false)
+
+	var info audioInfo
+	switch format.Name {
+	case FormatWav.Name:
+		info, err = readWavInfo(path)
+	case FormatMP3.Name:
+		info, err = readMP3Info(path)
+	default:
+		err = fmt.Errorf("cannot read audio info for format: %s", format.Name)
+	}
+	if err != nil {
+		return info, err
+	}
+	info.modTime = stat.ModTime()
+	audioInfoCache.Store(path, info)
+
+	return info, nil
+}
+
+// -----------------------------------------------------------------------------
+// WAV
+// -----------------------------------------------------------------------------
+
+// maxWavFmtSize is the largest fmt chunk that is read from a wav file.
+const maxWavFmtSize = 64
+
+// readWavInfo will read the chunks of a RIFF WAVE file to find the format and
+// audio data.
+func readWavInfo(path string) (audioInfo, error) {
+	info := audioInfo{Format: FormatWav}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return info, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return info, err
+	}
+
+	// Check the RIFF header.
+	riff := make([]byte, 12)
+	if _, err := io.ReadFull(file, riff); err != nil {
+		return info, fmt.Errorf("%s: invalid wav header: %v", path, err)
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return info, fmt.Errorf("%s: not a wav file", path)
+	}
+
+	// Loop through the chunks until the data chunk is found. The fmt chunk
+	// always comes before the data chunk.
+	offset := int64(12)
+	chunk := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(file, chunk); err != nil {
+			return info, fmt.Errorf("%s: missing data chunk", path)
+		}
+		id := string(chunk[0:4])
+		size := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+		offset += 8
+
+		if id == "data" {
+			if info.Header == nil {
+				return info, fmt.Errorf("%s: missing fmt chunk", path)
+			}
+
+			// The size may be left unset by writers that stream the
+			// file, in which case the data runs to the end of the file.
+			if size == 0 || offset+size > stat.Size() {
+				size = stat.Size() - offset
+			}
+			info.DataOffset = offset
+			info.DataSize = size
+			break
+		}
+
+		if id == "fmt " {
+			// The largest fmt chunk is the 40 bytes of the extensible
+			// format, anything much larger is a corrupt file.
+			if size > maxWavFmtSize || offset+size > stat.Size() {
+				return info, fmt.Errorf("%s: invalid fmt chunk size: %d", path, size)
+			}
+			info.Header = make([]byte, size)
+			if _, err := io.ReadFull(file, info.Header); err != nil {
+				return info, fmt.Errorf("%s: invalid fmt chunk: %v", path, err)
+			}
+			if size%2 == 1 {
+				file.Seek(1, io.SeekCurrent)
+			}
+		} else {
+			if _, err := file.Seek(size+size%2, io.SeekCurrent); err != nil {
+				return info, err
+			}
+		}
+		offset += size + size%2
+	}
+
+	// Work out the duration from the byte rate in the fmt chunk.
+	if len(info.Header) < 16 {
+		return info, fmt.Errorf("%s: invalid fmt chunk", path)
+	}
+	byteRate := int64(binary.LittleEndian.Uint32(info.Header[8:12]))
+	if byteRate == 0 {
+		return info, fmt.Errorf("%s: invalid byte rate", path)
+	}
+	info.Duration = time.Duration(info.DataSize * int64(time.Second) / byteRate)
+
+	return info, nil
+}
+
+// wavHeader will create the header of a wav file containing dataSize bytes of
+// audio described by the fmt chunk.
+func wavHeader(fmtChunk []byte, dataSize int64) []byte {
+	riffSize := 4 + 8 + int64(len(fmtChunk)) + 8 + dataSize
+	if riffSize > 0xFFFFFFFF {
+		riffSize = 0xFFFFFFFF
+	}
+	if dataSize > 0xFFFFFFFF {
+		dataSize = 0xFFFFFFFF
+	}
+
+	var header bytes.Buffer
+	header.WriteString("RIFF")
+	binary.Write(&header, binary.LittleEndian, uint32(riffSize))
+	header.WriteString("WAVE")
+	header.WriteString("fmt ")
+	binary.Write(&header, binary.LittleEndian, uint32(len(fmtChunk)))
+	header.Write(fmtChunk)
+	header.WriteString("data")
+	binary.Write(&header, binary.LittleEndian, uint32(dataSize))
+	return header.Bytes()
+}
+
+// -----------------------------------------------------------------------------
+// MP3
+// -----------------------------------------------------------------------------
+
+// Bitrates in kbps for MPEG audio layer III, indexed by the bitrate index of
+// the frame header.
+var (
+	mp3BitratesV1 = []int{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320}
+	mp3BitratesV2 = []int{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160}
+)
+
+// Sample rates in Hz for MPEG version 1, indexed by the sample rate index of
+// the frame header. Version 2 is half of these and version 2.5 a quarter.
+var mp3SampleRatesV1 = []int{44100, 48000, 32000}
+
+// readMP3Info will scan the frames of an mp3 file to find the audio data and
+// the duration. Any ID3 tags before or after the frames are excluded from the
+// data region.
+func readMP3Info(path string) (audioInfo, error) {
+	info := audioInfo{Format: FormatMP3}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return info, err
+	}
+
+	// Skip the ID3v2 tag if there is one.
+	pos := 0
+	if len(data) >= 10 && string(data[0:3]) == "ID3" {
+		size := int(data[6]&0x7f)<<21 | int(data[7]&0x7f)<<14 | int(data[8]&0x7f)<<7 | int(data[9]&0x7f)
+		pos = 10 + size
+		if data[5]&0x10 != 0 {
+			pos += 10
+		}
+	}
+
+	// Ignore the ID3v1 tag at the end of the file.
+	end := len(data)
+	if end-pos >= 128 && string(data[end-128:end-125]) == "TAG" {
+		end -= 128
+	}
+
+	// Walk the frames. If a frame header is invalid, move forward a byte at a
+	// time until the next frame is found.
+	start, last := -1, -1
+	samples := 0
+	sampleRate := 0
+	for pos+4 <= end {
+		length, frameSamples, rate, ok := parseMP3FrameHeader(data[pos : pos+4])
+		if !ok || pos+length > end {
+			pos++
+			continue
+		}
+		if start < 0 {
+			start = pos
+		}
+		samples += frameSamples
+		sampleRate = rate
+		pos += length
+		last = pos
+	}
+	if start < 0 || sampleRate == 0 {
+		return info, fmt.Errorf("%s: no mp3 frames found", path)
+	}
+
+	info.DataOffset = int64(start)
+	info.DataSize = int64(last - start)
+	info.Duration = time.Duration(int64(samples) * int64(time.Second) / int64(sampleRate))
+
+	return info, nil
+}
+
+// parseMP3FrameHeader will parse the 4 byte header of an MPEG audio layer III
+// frame. It returns the length of the frame in bytes, the number of samples in
+// the frame and the sample rate.
+func parseMP3FrameHeader(h []byte) (length int, samples int, sampleRate int, ok bool) {
+	// Frame sync is 11 set bits.
+	if h[0] != 0xff || h[1]&0xe0 != 0xe0 {
+		return 0, 0, 0, false
+	}
+
+	version := (h[1] >> 3) & 0x03 // 0: 2.5, 2: 2, 3: 1
+	layer := (h[1] >> 1) & 0x03   // 1: layer III
+	bitrateIndex := int(h[2]>>4) & 0x0f
+	sampleRateIndex := int(h[2]>>2) & 0x03
+	padding := int(h[2]>>1) & 0x01
+	if version == 1 || layer != 1 || bitrateIndex == 0 || bitrateIndex == 15 || sampleRateIndex == 3 {
+		return 0, 0, 0, false
+	}
+
+	sampleRate = mp3SampleRatesV1[sampleRateIndex]
+	var bitrate int
+	switch version {
+	case 3:
+		bitrate = mp3BitratesV1[bitrateIndex] * 1000
+		samples = 1152
+		length = 144*bitrate/sampleRate + padding
+	case 2:
+		sampleRate /= 2
+		bitrate = mp3BitratesV2[bitrateIndex] * 1000
+		samples = 576
+		length = 72*bitrate/sampleRate + padding
+	case 0:
+		sampleRate /= 4
+		bitrate = mp3BitratesV2[bitrateIndex] * 1000
+		samples = 576
+		length = 72*bitrate/sampleRate + padding
+	}
+	if length < 4 {
+		return 0, 0, 0, false
+	}
+
+	return length, samples, sampleRate, true
+}
@@ -0,0 +1,172 @@
+package ttsweb
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// -----------------------------------------------------------------------------
+// Bookmarks
+// -----------------------------------------------------------------------------
+
+// Bookmark marks a paragraph of a document, e.g. to come back to a passage
+// while listening. The bookmarks of a document are stored in
+// documents/{id}/bookmarks.json.
+type Bookmark struct {
+	ID          string `json:"id"`
+	DocumentID  string `json:"documentId"`
+	ParagraphID string `json:"paragraphId"`
+
+	// Offset is the number of seconds into the audio of the paragraph that
+	// the bookmark was made at. It is nil if the bookmark is for the whole
+	// paragraph.
+	Offset *float64 `json:"offset,omitempty"`
+
+	// Color is the color that the paragraph is highlighted with, one of
+	// BookmarkColors.
+	Color string `json:"color"`
+	Note  string `json:"note,omitempty"`
+
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+
+	// Link opens the reader at the paragraph of the bookmark.
+	Link string `json:"link"`
+}
+
+// BookmarkColors are the colors that a bookmark can have. The first color is
+// used if none is given.
+var BookmarkColors = []string{"yellow", "green", "blue", "pink", "purple"}
+
+// bookmarksPath will return the path to the bookmarks of the document.
+func bookmarksPath(documentsDir, documentID string) string {
+	return path.Join(documentsDir, documentID, "bookmarks.json")
+}
+
+// LoadBookmarks will load the bookmarks of the document, sorted by the
+// paragraph and the offset. No bookmarks are returned if the document has
+// none.
+func LoadBookmarks(documentsDir, documentID string) ([]Bookmark, error) {
+	bookmarks := []Bookmark{}
+	data, err := ioutil.ReadFile(bookmarksPath(documentsDir, documentID))
+	if os.IsNotExist(err) {
+		return bookmarks, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &bookmarks); err != nil {
+		return nil, err
+	}
+	sortBookmarks(bookmarks)
+	return bookmarks, nil
+}
+
+// SaveBookmarks will write the bookmarks of the document.
+func SaveBookmarks(documentsDir, documentID string, bookmarks []Bookmark) error {
+	sortBookmarks(bookmarks)
+	data, err := json.Marshal(bookmarks)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(bookmarksPath(documentsDir, documentID), data, 0644)
+}
+
+// sortBookmarks will sort the bookmarks by the paragraph and the offset. The
+// bookmarks of the whole paragraph come first.
+func sortBookmarks(bookmarks []Bookmark) {
+	sort.SliceStable(bookmarks, func(i, j int) bool {
+		a, _ := strconv.Atoi(bookmarks[i].ParagraphID)
+		b, _ := strconv.Atoi(bookmarks[j].ParagraphID)
+		if a != b {
+			return a < b
+		}
+		return bookmarkOffset(bookmarks[i]) < bookmarkOffset(bookmarks[j])
+	})
+}
+
+// bookmarkOffset will return the offset of the bookmark, or -1 if it is for
+// the whole paragraph.
+func bookmarkOffset(bookmark Bookmark) float64 {
+	if bookmark.Offset == nil {
+		return -1
+	}
+	return *bookmark.Offset
+}
+
+// Validate will check the bookmark before it is saved. The color defaults to
+// the first of BookmarkColors and the paragraph must be in the document.
+func (b *Bookmark) Validate(documentsDir string) error {
+	if _, err := strconv.Atoi(b.ParagraphID); err != nil {
+		return fmt.Errorf("invalid paragraph ID: %s", b.ParagraphID)
+	}
+	if _, err := os.Stat(path.Join(documentsDir, b.DocumentID, "paragraphs", b.ParagraphID+".txt")); err != nil {
+		return fmt.Errorf("paragraph not found: %s", b.ParagraphID)
+	}
+	if b.Offset != nil && (*b.Offset < 0 || math.IsNaN(*b.Offset) || math.IsInf(*b.Offset, 0)) {
+		return fmt.Errorf("invalid offset: %v", *b.Offset)
+	}
+	if b.Color == "" {
+		b.Color = BookmarkColors[0]
+	}
+	if !containsString(BookmarkColors, b.Color) {
+		return fmt.Errorf("invalid color: %s", b.Color)
+	}
+	b.Note = strings.TrimSpace(b.Note)
+	b.Link = "/?document=" + b.DocumentID + "&paragraph=" + b.ParagraphID
+	return nil
+}
+
+// BookmarksMarkdown will export the bookmarks of the documents as markdown.
+// Each document is a section with its bookmarks in order, and each bookmark
+// quotes the text of its paragraph followed by the note.
+func BookmarksMarkdown(documentsDir string, documents []DocumentInfo, bookmarks map[string][]Bookmark) string {
+	var b strings.Builder
+	b.WriteString("# Bookmarks\n")
+	for _, document := range documents {
+		if len(bookmarks[document.ID]) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\n## %s\n", escapeMarkdownHeading(document.Name))
+		for _, bookmark := range bookmarks[document.ID] {
+			fmt.Fprintf(&b, "\n### Paragraph %s", bookmark.ParagraphID)
+			if bookmark.Offset != nil {
+				fmt.Fprintf(&b, " at %s", formatBookmarkOffset(*bookmark.Offset))
+			}
+			fmt.Fprintf(&b, " (%s)\n\n", bookmark.Color)
+
+			text, err := ioutil.ReadFile(path.Join(documentsDir, document.ID, "paragraphs", bookmark.ParagraphID+".txt"))
+			if err == nil {
+				for _, line := range strings.Split(strings.TrimSpace(string(text)), "\n") {
+					b.WriteString(strings.TrimRight("> "+line, " ") + "\n")
+				}
+			}
+			if bookmark.Note != "" {
+				b.WriteString("\n" + bookmark.Note + "\n")
+			}
+		}
+	}
+	return b.String()
+}
+
+// formatBookmarkOffset will format the offset in seconds as minutes and
+// seconds, e.g. 1:05.
+func formatBookmarkOffset(offset float64) string {
+	seconds := int(offset)
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+// escapeMarkdownHeading will escape the characters of the text that would be
+// read as markdown in a heading.
+func escapeMarkdownHeading(text string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, "*", `\*`, "_", `\_`, "`", "\\`", "#", `\#`, "[", `\[`, "]", `\]`)
+	return replacer.Replace(text)
+}
@@ -0,0 +1,315 @@
+package ttsweb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// -----------------------------------------------------------------------------
+// Paragraph Classes
+// -----------------------------------------------------------------------------
+
+const (
+	// ClassBody is the class of the paragraphs of running text.
+	ClassBody = "body"
+
+	// ClassHeading is the class of chapter and section titles.
+	ClassHeading = "heading"
+
+	// ClassTable is the class of the rows and cells of tables.
+	ClassTable = "table"
+
+	// ClassCode is the class of source code listings.
+	ClassCode = "code"
+
+	// ClassFootnote is the class of footnotes, e.g. "1 See the appendix."
+	ClassFootnote = "footnote"
+
+	// ClassReference is the class of the entries of reference lists and
+	// bibliographies.
+	ClassReference = "reference"
+
+	// ClassPageNumber is the class of page numbers and running page headers
+	// that were left in the text.
+	ClassPageNumber = "page-number"
+)
+
+// ParagraphClasses are all of the classes that paragraphs are tagged with.
+var ParagraphClasses = []string{
+	ClassBody, ClassHeading, ClassTable, ClassCode, ClassFootnote,
+	ClassReference, ClassPageNumber,
+}
+
+// ParseParagraphClasses will parse a comma separated list of paragraph
+// classes, e.g. "footnote,reference". "none" is an empty list.
+func ParseParagraphClasses(s string) ([]string, error) {
+	classes := []string{}
+	if strings.TrimSpace(s) == "" || strings.TrimSpace(s) == "none" {
+		return classes, nil
+	}
+	for _, class := range strings.Split(s, ",") {
+		class = strings.TrimSpace(class)
+		if !containsString(ParagraphClasses, class) {
+			return nil, fmt.Errorf("unknown paragraph class: %s", class)
+		}
+		classes = append(classes, class)
+	}
+	return classes, nil
+}
+
+// maxRomanPageNumber is the first number that is not written as a roman page
+// number of the front matter.
+const maxRomanPageNumber = 100
+
+var (
+	pageNumberPattern        = regexp.MustCompile(`(?i)^(page\s+)?(\d{1,4}|[ivxlcdm]{1,7})(\s*(of|/)\s*\d{1,4})?$`)
+	footnotePattern          = regexp.MustCompile(`^(?:(\d{1,3})\s+|[*†‡§]+\s*)(\p{Lu}\p{L}*)`)
+	referenceEntryPattern    = regexp.MustCompile(`^(?:\[\d{1,4}\]\s*|\d{1,4}\.\s+)?(?:(?:\p{Lu}\.[\s-]*)+\p{Lu}[\p{L}'’-]+|\p{Lu}[\p{L}'’-]+,?\s+(?:\p{Lu}\.|\p{Lu}{1,3}[,.])|\p{Lu}[\p{L}'’-]+,\s+\p{Lu}\p{Ll}+[,.])`)
+	referenceYearPattern     = regexp.MustCompile(`\((1[5-9]|20)\d\d[a-z]?\)|\b(1[5-9]|20)\d\d[a-z]?[.,;]`)
+	referenceMarkerPattern   = regexp.MustCompile(`(?i)\bdoi:|\bet al\.|\bpp\.\s*\d|\bvol\.\s*\d|\bin proceedings\b|\bisbn\b|https?://`)
+	referencesHeadingPattern = regexp.MustCompile(`(?i)^(\d+\.?\s*)?(references|bibliography|works cited|literature cited|notes)$`)
+)
+
+// ClassifyParagraph will guess the class of a paragraph from its text.
+// Headings must be known from the structure of the document, apart from the
+// titles of reference lists. Paragraphs that are only white space are body
+// text.
+func ClassifyParagraph(text string, heading bool) string {
+	text = collapseSpace(text)
+	switch {
+	case text == "":
+		return ClassBody
+	case heading || referencesHeadingPattern.MatchString(text):
+		return ClassHeading
+	case isPageNumberText(text):
+		return ClassPageNumber
+	case isTableText(text):
+		return ClassTable
+	case isCodeText(text):
+		return ClassCode
+	case isReferenceText(text):
+		return ClassReference
+	case isFootnoteText(text):
+		return ClassFootnote
+	}
+	return ClassBody
+}
+
+// isPageNumberText will check if the text is only a page number, e.g. "12",
+// "Page 3 of 10" or "xiv". Roman page numbers are only used in the front
+// matter, so they must be a valid lower case numeral below 100. Upper case
+// numerals on their own, e.g. "IV", are chapter numbers, and words such as
+// "Mild" or "mix" are not page numbers at all.
+func isPageNumberText(text string) bool {
+	match := pageNumberPattern.FindStringSubmatch(text)
+	if match == nil {
+		return false
+	}
+	number := match[2]
+	if number[0] >= '0' && number[0] <= '9' {
+		return true
+	}
+	if number != strings.ToLower(number) {
+		return false
+	}
+	n, ok := parseRoman(strings.ToUpper(number))
+	return ok && n < maxRomanPageNumber
+}
+
+// isTableText will check if the text looks like the row of a table, either
+// with cell separators or as a run of numbers.
+func isTableText(text string) bool {
+	if strings.Count(text, "|") >= 2 || strings.Count(text, "\t") >= 2 {
+		return true
+	}
+	fields := strings.Fields(text)
+	if len(fields) < 4 {
+		return false
+	}
+	numbers := 0
+	for _, field := range fields {
+		if isNumberField(field) {
+			numbers++
+		}
+	}
+	return float64(numbers)/float64(len(fields)) >= 0.6
+}
+
+// isNumberField will check if the word is a number or a measurement, e.g.
+// "12.5", "3%" or "±0.2".
+func isNumberField(field string) bool {
+	digits := 0
+	for _, r := range field {
+		switch {
+		case unicode.IsDigit(r):
+			digits++
+		case unicode.IsLetter(r):
+			return false
+		}
+	}
+	return digits > 0
+}
+
+// isCodeText will check if the text has the density of symbols of source
+// code.
+func isCodeText(text string) bool {
+	if len(text) < 10 || !strings.ContainsAny(text, ";{}=") {
+		return false
+	}
+	symbols, total := 0, 0
+	for _, r := range text {
+		if unicode.IsSpace(r) {
+			continue
+		}
+		total++
+		if strings.ContainsRune("{}();=<>[]_#$&|\\", r) {
+			symbols++
+		}
+	}
+	return float64(symbols)/float64(total) >= 0.12
+}
+
+// isReferenceText will check if the text looks like the entry of a reference
+// list. The entry must start with the list of authors, optionally after a
+// number such as "[12]" or "12.", e.g. "Smith, J." or "J. Smith", and have a
+// year or a citation marker such as "et al." or a DOI. Citations in running
+// text, e.g. "Smith et al. (2019) showed", do not start with an author list.
+func isReferenceText(text string) bool {
+	if !referenceEntryPattern.MatchString(text) {
+		return false
+	}
+	return referenceYearPattern.MatchString(text) || referenceMarkerPattern.MatchString(text)
+}
+
+// isFootnoteText will check if the text looks like a footnote, a short
+// sentence that starts with a note number or symbol. A number followed by a
+// plural, e.g. "100 Years of Solitude", is a count rather than a note number.
+func isFootnoteText(text string) bool {
+	last, _ := utf8.DecodeLastRuneInString(text)
+	if len(text) >= 500 || !strings.ContainsRune(".)]\"", last) {
+		return false
+	}
+	match := footnotePattern.FindStringSubmatch(text)
+	if match == nil {
+		return false
+	}
+	return match[1] == "" || match[1] == "1" || !isPluralWord(match[2])
+}
+
+// isPluralWord will guess if the English word is a plural, e.g. "Years" but
+// not "This" or "Thus".
+func isPluralWord(word string) bool {
+	word = strings.ToLower(word)
+	if len(word) < 4 || !strings.HasSuffix(word, "s") {
+		return false
+	}
+	return !strings.HasSuffix(word, "ss") && !strings.HasSuffix(word, "us") && !strings.HasSuffix(word, "is")
+}
+
+// ClassifyParagraphs will tag each of the paragraphs of the document with its
+// class. The paragraphs that follow the title of a reference list are
+// references until the next heading.
+func (d *DocumentInfo) ClassifyParagraphs(documentsDir string, headings []Heading) error {
+	paragraphs, err := LoadParagraphInfos(documentsDir, d.ID)
+	if err != nil {
+		return err
+	}
+	meta, err := LoadParagraphMeta(documentsDir, d.ID)
+	if err != nil {
+		return err
+	}
+	headingIDs := map[string]bool{}
+	for _, heading := range headings {
+		headingIDs[heading.ParagraphID] = true
+	}
+
+	references := false
+	for _, paragraph := range paragraphs {
+		content, err := ioutil.ReadFile(path.Join(documentsDir, d.ID, "paragraphs", paragraph.ID+".txt"))
+		if err != nil {
+			return err
+		}
+		text := collapseSpace(string(content))
+		class := ClassifyParagraph(text, headingIDs[paragraph.ID])
+		switch {
+		case class == ClassHeading:
+			references = referencesHeadingPattern.MatchString(text)
+		case references && class != ClassPageNumber:
+			class = ClassReference
+		}
+		m := meta[paragraph.ID]
+		m.Class = class
+		meta[paragraph.ID] = m
+	}
+
+	// Return the result of saving the meta.
+	return SaveParagraphMeta(documentsDir, d.ID, meta)
+}
+
+// Skips will check if the paragraphs of the class are skipped in the
+// document.
+func (d *DocumentInfo) Skips(class string) bool {
+	return containsString(d.SkipClasses, class)
+}
+
+// MarkSkippedParagraphs will set the skip flag of the paragraphs that are in
+// the classes that the document skips.
+func (d *DocumentInfo) MarkSkippedParagraphs(paragraphs []ParagraphInfo) {
+	for i := range paragraphs {
+		paragraphs[i].Skip = d.Skips(paragraphs[i].Class)
+	}
+}
+
+// unskippedParagraphIDs will return the IDs of the paragraphs that are not
+// skipped, out of the paragraphs with the specified IDs or all of the
+// paragraphs if no IDs are specified.
+func (d *DocumentInfo) unskippedParagraphIDs(documentsDir string, paragraphIDs []string) ([]string, error) {
+	paragraphs, err := LoadParagraphInfos(documentsDir, d.ID)
+	if err != nil {
+		return nil, err
+	}
+	unskipped := []string{}
+	for _, paragraph := range filterParagraphs(paragraphs, paragraphIDs) {
+		if !d.Skips(paragraph.Class) {
+			unskipped = append(unskipped, paragraph.ID)
+		}
+	}
+	return unskipped, nil
+}
+
+// MarkUnsynthesizedParagraphs will mark the paragraphs that are not skipped
+// but have no audio in any of the formats as stale, so that they are
+// synthesized when the document is synthesized again. This is needed when a
+// class of paragraphs stops being skipped. The number of paragraphs that
+// were marked is returned.
+func (d *DocumentInfo) MarkUnsynthesizedParagraphs(documentsDir string, formats []AudioFormat) (int, error) {
+	paragraphIDs, err := d.unskippedParagraphIDs(documentsDir, nil)
+	if err != nil {
+		return 0, err
+	}
+	meta, err := LoadParagraphMeta(documentsDir, d.ID)
+	if err != nil {
+		return 0, err
+	}
+	marked := 0
+	for _, paragraphID := range paragraphIDs {
+		if len(availableAudioFormats(documentsDir, d.ID, paragraphID, formats)) > 0 {
+			continue
+		}
+		m := meta[paragraphID]
+		if !m.Stale {
+			m.Stale = true
+			meta[paragraphID] = m
+			marked++
+		}
+	}
+	if marked == 0 {
+		return 0, nil
+	}
+	return marked, SaveParagraphMeta(documentsDir, d.ID, meta)
+}
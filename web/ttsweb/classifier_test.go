@@ -0,0 +1,98 @@
+package ttsweb
+
+import (
+	"flag"
+	"testing"
+)
+
+func TestClassifyParagraph(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		// Paragraphs that are only white space.
+		{"", ClassBody},
+		{"  ", ClassBody},
+		{"\u00a0", ClassBody},
+		{"\f", ClassBody},
+		{" \t\n\u00a0", ClassBody},
+
+		// Footnotes.
+		{"1 See the appendix for the full derivation.", ClassFootnote},
+		{"3 This was later retracted.", ClassFootnote},
+		{"* Not including the preface.", ClassFootnote},
+		{"12See the appendix.", ClassBody},
+		{"100 Years of Solitude is his most famous novel.", ClassBody},
+
+		// References, and citations in running text.
+		{"[1] J. Smith and K. Jones, \"A title,\" in Proceedings of X, 2019, pp. 1-10.", ClassReference},
+		{"12. Smith JA, Jones K. A title. Nature. 2019;12:34-56.", ClassReference},
+		{"Smith, J., & Jones, K. (2019). A title. Journal of Things, 12, 34–56.", ClassReference},
+		{"Smith et al. (2019) showed that the effect is real.", ClassBody},
+		{"As shown by Jones et al., 2020, the effect persists.", ClassBody},
+		{"However, Smith (2019) found the opposite.", ClassBody},
+
+		// Tables and code.
+		{"Year | Sales | Profit", ClassTable},
+		{"2019 12.5 3% 40 ±0.2", ClassTable},
+		{"for (i = 0; i < n; i++) { sum += x[i]; }", ClassCode},
+
+		{"The first paragraph of the chapter.", ClassBody},
+	}
+	for _, test := range tests {
+		if got := ClassifyParagraph(test.text, false); got != test.want {
+			t.Errorf("ClassifyParagraph(%q) = %s, want %s", test.text, got, test.want)
+		}
+	}
+
+	if got := ClassifyParagraph("Chapter One", true); got != ClassHeading {
+		t.Errorf("ClassifyParagraph(heading) = %s, want %s", got, ClassHeading)
+	}
+	if got := ClassifyParagraph("References", false); got != ClassHeading {
+		t.Errorf("ClassifyParagraph(References) = %s, want %s", got, ClassHeading)
+	}
+}
+
+func TestClassifyPageNumber(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"12", ClassPageNumber},
+		{"Page 3 of 10", ClassPageNumber},
+		{"7 / 240", ClassPageNumber},
+		{"xiv", ClassPageNumber},
+		{"xcix", ClassPageNumber},
+
+		// Chapter numbers and words that look like numerals are read.
+		{"I", ClassBody},
+		{"IV", ClassBody},
+		{"XLII", ClassBody},
+		{"Mild", ClassBody},
+		{"mix", ClassBody},
+		{"did", ClassBody},
+		{"Ivi", ClassBody},
+	}
+	for _, test := range tests {
+		if got := ClassifyParagraph(test.text, false); got != test.want {
+			t.Errorf("ClassifyParagraph(%q) = %s, want %s", test.text, got, test.want)
+		}
+	}
+}
+
+func TestDefaultSkipClasses(t *testing.T) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	pipelineFlags := RegisterPipelineFlags(flags)
+	if err := flags.Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+	pipeline, err := pipelineFlags.Pipeline()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []*Pipeline{pipeline, DefaultPipeline} {
+		if len(p.SkipClasses) != 0 {
+			t.Errorf("paragraphs in %v are skipped by default, want none", p.SkipClasses)
+		}
+	}
+}
@@ -1,12 +1,15 @@
 package ttsweb
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"log/slog"
 	"os"
-	"os/exec"
 	"path"
+	"strings"
+	"time"
 )
 
 const (
@@ -25,6 +28,10 @@ const (
 	// StatusSynthesized is the status of a document that has been split into
 	// paragraphs and synthesized.
 	StatusSynthesized = "synthesized"
+
+	// StatusFailed is the status of a document that could not be split or
+	// synthesized. The error is stored with the document.
+	StatusFailed = "failed"
 )
 
 // -----------------------------------------------------------------------------
@@ -58,10 +65,26 @@ type DocumentInfo struct {
 	// paragraphs directory in the document directory.
 	Paragraphs []ParagraphInfo `json:"paragraphs"`
 
+	// Language is the default language of the document. It is either chosen
+	// when the document is uploaded or detected from the paragraphs. It is
+	// used for the paragraphs whose language could not be detected.
+	Language string `json:"language,omitempty"`
+
+	// Lexicons are the names of the pronunciation lexicons that are used
+	// when the document is synthesized.
+	Lexicons []string `json:"lexicons,omitempty"`
+
+	// SkipClasses are the classes of paragraphs, such as footnotes and
+	// references, that are not synthesized and are skipped during playback.
+	SkipClasses []string `json:"skipClasses,omitempty"`
+
 	// Status of the document. This will be used to determine if the document
 	// has been split into paragraphs and synthesized.
 	Status string `json:"status"`
 
+	// Error is the reason that the document failed to be processed.
+	Error string `json:"error,omitempty"`
+
 	Link string `json:"link"`
 }
 
@@ -72,7 +95,7 @@ type DocumentInfo struct {
 // directory. The document will be loaded from the index.json file
 // in the document directory.
 func LoadDocument(documentsDir string, id string) (DocumentInfo, error) {
-	fmt.Println("Loading document: " + id)
+	slog.Debug("Loading document", "document_id", id)
 
 	document := DocumentInfo{}
 
@@ -126,43 +149,76 @@ func (d *DocumentInfo) Save(documentsDir string, file []byte) error {
 
 	d.Status = StatusSaved
 
-	go func() {
-		// Split the document into paragraphs.
-		if err := d.SplitToParagraphs(documentsDir); err != nil {
-			fmt.Println(err)
-			return
-		}
-		fmt.Println("Split document into paragraphs:", d.ID)
-
-		// Synthesize the paragraphs of the document.
-		if err := d.SynthesizeParagraphs(documentsDir); err != nil {
-			fmt.Println(err)
-			return
-		}
-		fmt.Println("Synthesized paragraphs:", d.ID)
-	}()
-
 	// Return no error.
 	return nil
 }
 
-// SplitToParagraphs splits the text into paragraphs by running the text through
-// the paragraph splitter script 'split-document.sh'.
-func (d *DocumentInfo) SplitToParagraphs(documentsDir string) error {
+// WriteIndex will write the document information to the index.json file in the
+// document directory. This is used to store the changes to the document as it
+// goes through the pipeline.
+func (d *DocumentInfo) WriteIndex(documentsDir string) error {
+	indexData, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path.Join(documentsDir, d.ID, "index.json"), indexData, 0644)
+}
+
+// SplitToParagraphs splits the text into paragraphs using the splitter. The
+// text and markup of the paragraphs and the headings is normalized, and the
+// headings are stored as the table of contents of the document. The
+// paragraphs are then tagged with their class.
+func (d *DocumentInfo) SplitToParagraphs(ctx context.Context, documentsDir string, splitter Splitter, normalization TextNormalization) error {
 	outputDir := path.Join(documentsDir, d.ID, "paragraphs")
 	inputFile := path.Join(documentsDir, d.ID, d.Filename)
 
-	// Run the paragraph splitter script.
-	cmd := exec.Command("bash", "../split-document.sh",
-		"--output", outputDir,
-		"--", inputFile)
+	// Split the document.
+	headings, err := splitter.Split(ctx, inputFile, outputDir)
+	if err != nil {
+		return err
+	}
 
-	// Print the output of the script.
-	output, err := cmd.Output()
+	// Normalize the text of the paragraphs.
+	paragraphs, err := LoadParagraphInfos(documentsDir, d.ID)
 	if err != nil {
 		return err
 	}
-	fmt.Println(string(output))
+	paragraphIDs := []string{}
+	for _, paragraph := range paragraphs {
+		paragraphFile := path.Join(outputDir, paragraph.ID+".txt")
+		content, err := ioutil.ReadFile(paragraphFile)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(paragraphFile, []byte(normalization.Normalize(string(content))), 0644); err != nil {
+			return err
+		}
+		markup, err := LoadParagraphMarkup(documentsDir, d.ID, paragraph.ID)
+		if err != nil {
+			return err
+		}
+		if markup != "" {
+			markupFile := path.Join(markupDir(outputDir), paragraph.ID+".txt")
+			if err := ioutil.WriteFile(markupFile, []byte(normalization.Normalize(markup)), 0644); err != nil {
+				return err
+			}
+		}
+		paragraphIDs = append(paragraphIDs, paragraph.ID)
+	}
+
+	// Store the table of contents.
+	for i := range headings {
+		headings[i].Title = normalization.Normalize(headings[i].Title)
+	}
+	toc := BuildTableOfContents(d.ID, headings, paragraphIDs)
+	if err := toc.Save(documentsDir); err != nil {
+		return err
+	}
+
+	// Tag the paragraphs with their class.
+	if err := d.ClassifyParagraphs(documentsDir, headings); err != nil {
+		return err
+	}
 
 	d.Status = StatusSplit
 
@@ -170,27 +226,429 @@ func (d *DocumentInfo) SplitToParagraphs(documentsDir string) error {
 	return nil
 }
 
-// SynthesizeParagraphs synthesizes the paragraphs of the document by running
-// the text through the paragraph synthesizer script 'split-txt-to-tts.py'.
-func (d *DocumentInfo) SynthesizeParagraphs(documentsDir string) error {
+// DetectLanguages detects the language of each of the paragraphs of the
+// document. If the document has no language yet, it is given the language
+// that most of its text is written in. Paragraphs that are too short to
+// detect are given the language of the document.
+func (d *DocumentInfo) DetectLanguages(documentsDir string) error {
+	paragraphs, err := LoadParagraphInfos(documentsDir, d.ID)
+	if err != nil {
+		return err
+	}
+	meta, err := LoadParagraphMeta(documentsDir, d.ID)
+	if err != nil {
+		return err
+	}
+
+	// Detect the language of each paragraph and count the amount of text
+	// in each language.
+	lengths := map[string]int{}
+	for _, paragraph := range paragraphs {
+		content, err := ioutil.ReadFile(path.Join(documentsDir, d.ID, "paragraphs", paragraph.ID+".txt"))
+		if err != nil {
+			return err
+		}
+		language, confidence := DetectLanguage(string(content))
+		if confidence < minLanguageConfidence {
+			language = ""
+		}
+		if language != "" {
+			lengths[language] += len(content)
+		}
+		m := meta[paragraph.ID]
+		m.Language = language
+		meta[paragraph.ID] = m
+	}
+
+	// The document is in the language with the most text.
+	if d.Language == "" {
+		for language, count := range lengths {
+			if d.Language == "" || count > lengths[d.Language] ||
+				(count == lengths[d.Language] && language < d.Language) {
+				d.Language = language
+			}
+		}
+	}
+	for id, m := range meta {
+		if m.Language == "" {
+			m.Language = d.Language
+			meta[id] = m
+		}
+	}
+
+	// Return the result of saving the meta.
+	return SaveParagraphMeta(documentsDir, d.ID, meta)
+}
+
+// SynthesizeParagraphs synthesizes the paragraphs of the document with the
+// specified IDs, or all of the paragraphs if no IDs are specified. The speech
+// function returns the voice and synthesizer that read each paragraph and the
+// text that they read. The spoken text is written to the speech directory
+// first, the paragraph text that is displayed is left as it is. The progress
+// function, if not nil, is called for each paragraph whose audio is written.
+func (d *DocumentInfo) SynthesizeParagraphs(ctx context.Context, documentsDir string, paragraphIDs []string, speechFor func(paragraph ParagraphInfo, text string) Speech, progress func()) error {
 	paragraphsDir := path.Join(documentsDir, d.ID, "paragraphs")
+	speechDir := path.Join(documentsDir, d.ID, "speech")
 	audioDir := path.Join(documentsDir, d.ID, "audio")
 
-	// Run the paragraph synthesizer script.
-	cmd := exec.Command("python3", "../split-txt-to-tts.py",
-		"--speaker", "p241",
-		"--text-dir", paragraphsDir,
-		"--out-dir", audioDir)
-
-	// Print the output of the script.
-	output, err := cmd.Output()
+	// Write the text that each voice will read, and group the paragraphs
+	// by voice so that each model is only loaded once.
+	paragraphs, err := LoadParagraphInfos(documentsDir, d.ID)
 	if err != nil {
 		return err
 	}
-	fmt.Println(string(output))
+	paragraphs = filterParagraphs(paragraphs, paragraphIDs)
+	if err := os.MkdirAll(speechDir, 0755); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(audioDir, 0755); err != nil {
+		return err
+	}
+	voices := []Voice{}
+	synthesizers := map[Voice]Synthesizer{}
+	voiceParagraphs := map[Voice][]string{}
+	for _, paragraph := range paragraphs {
+		content, err := ioutil.ReadFile(path.Join(paragraphsDir, paragraph.ID+".txt"))
+		if err != nil {
+			return err
+		}
+		speech := speechFor(paragraph, string(content))
+		if err := ioutil.WriteFile(path.Join(speechDir, paragraph.ID+".txt"), []byte(speech.Text), 0644); err != nil {
+			return err
+		}
+
+		if _, ok := voiceParagraphs[speech.Voice]; !ok {
+			voices = append(voices, speech.Voice)
+			synthesizers[speech.Voice] = speech.Synthesizer
+		}
+		voiceParagraphs[speech.Voice] = append(voiceParagraphs[speech.Voice], paragraph.ID)
+	}
+
+	// Synthesize the paragraphs of each voice in batches, so that the
+	// progress can be counted as each batch is finished. The paragraphs whose
+	// audio was written are counted as synthesized by the engine, and the
+	// rest as failed.
+	for _, voice := range voices {
+		engine := voice.Engine
+		if engine == "" {
+			engine = EngineCoqui
+		}
+		remaining := voiceParagraphs[voice]
+		for len(remaining) > 0 {
+			batch := remaining[:minInt(len(remaining), synthesisBatchSize)]
+			remaining = remaining[len(batch):]
+			previous := audioModTimes(audioDir, batch)
+			started := time.Now()
+			err := synthesizers[voice].Synthesize(ctx, voice, speechDir, audioDir, batch)
+			synthesisSeconds.add(time.Since(started).Seconds(), engine)
+			if ctx.Err() != nil {
+				removePartialAudio(audioDir, batch, started)
+			}
+			written := 0
+			for _, paragraphID := range batch {
+				stat, err := os.Stat(path.Join(audioDir, paragraphID+".wav"))
+				if err == nil && !stat.ModTime().Equal(previous[paragraphID]) {
+					written++
+					if progress != nil {
+						progress()
+					}
+				}
+			}
+			paragraphsSynthesized.add(float64(written), engine)
+			synthesisFailures.add(float64(len(batch)-written), engine)
+			if err != nil {
+				return err
+			}
+		}
+	}
 
 	d.Status = StatusSynthesized
 
 	// Return no error.
 	return nil
 }
+
+// synthesisBatchSize is the most paragraphs that are given to a synthesizer
+// at once. Larger batches load the model of the voice fewer times, smaller
+// batches report the progress of the synthesis more often.
+const synthesisBatchSize = 50
+
+// audioModTimes will return the modification times of the wav files of the
+// paragraphs that have audio, so that the files that a synthesizer writes can
+// be told apart from the older audio.
+func audioModTimes(audioDir string, paragraphIDs []string) map[string]time.Time {
+	modTimes := map[string]time.Time{}
+	for _, paragraphID := range paragraphIDs {
+		if stat, err := os.Stat(path.Join(audioDir, paragraphID+".wav")); err == nil {
+			modTimes[paragraphID] = stat.ModTime()
+		}
+	}
+	return modTimes
+}
+
+// removePartialAudio will remove the audio that a synthesizer may not have
+// finished writing when it was interrupted, so that the paragraphs are
+// synthesized again when the document is resumed. That is the newest file
+// that was written since the synthesizer started, and any file since then
+// that is not a valid wav file.
+func removePartialAudio(audioDir string, paragraphIDs []string, started time.Time) {
+	newest := ""
+	var newestTime time.Time
+	for _, paragraphID := range paragraphIDs {
+		audioPath := path.Join(audioDir, paragraphID+".wav")
+		stat, err := os.Stat(audioPath)
+		if err != nil || stat.ModTime().Before(started) {
+			continue
+		}
+		if _, err := readWavInfo(audioPath); err != nil {
+			os.Remove(audioPath)
+			continue
+		}
+		if newest == "" || stat.ModTime().After(newestTime) {
+			newest, newestTime = audioPath, stat.ModTime()
+		}
+	}
+	if newest != "" {
+		os.Remove(newest)
+	}
+}
+
+// MarkStaleParagraphs will mark the paragraphs of the document that contain
+// any of the words as stale, so that they can be synthesized again with the
+// new pronunciation of the words. The number of paragraphs that were marked
+// is returned.
+func (d *DocumentInfo) MarkStaleParagraphs(documentsDir string, words []string) (int, error) {
+	if len(words) == 0 {
+		return 0, nil
+	}
+	paragraphs, err := LoadParagraphInfos(documentsDir, d.ID)
+	if err != nil {
+		return 0, err
+	}
+	meta, err := LoadParagraphMeta(documentsDir, d.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	// Find the paragraphs that contain the words.
+	matcher := pronunciationsOf(words)
+	marked := 0
+	for _, paragraph := range paragraphs {
+		content, err := ioutil.ReadFile(path.Join(documentsDir, d.ID, "paragraphs", paragraph.ID+".txt"))
+		if err != nil {
+			return 0, err
+		}
+		if !matcher.contains(string(content)) {
+			continue
+		}
+		m := meta[paragraph.ID]
+		m.Stale = true
+		meta[paragraph.ID] = m
+		marked++
+	}
+	if marked == 0 {
+		return 0, nil
+	}
+
+	// Return the number of paragraphs that were marked.
+	return marked, SaveParagraphMeta(documentsDir, d.ID, meta)
+}
+
+// StaleParagraphIDs will return the IDs of the paragraphs of the document that
+// need to be synthesized again.
+func (d *DocumentInfo) StaleParagraphIDs(documentsDir string) ([]string, error) {
+	paragraphs, err := LoadParagraphInfos(documentsDir, d.ID)
+	if err != nil {
+		return nil, err
+	}
+	paragraphIDs := []string{}
+	for _, paragraph := range paragraphs {
+		if paragraph.Stale {
+			paragraphIDs = append(paragraphIDs, paragraph.ID)
+		}
+	}
+	return paragraphIDs, nil
+}
+
+// clearStaleParagraphs will mark the paragraphs as up to date if their audio
+// has been written since the time that they were synthesized again. The IDs of
+// the paragraphs that were marked are returned.
+func (d *DocumentInfo) clearStaleParagraphs(documentsDir string, paragraphIDs []string, since time.Time) ([]string, error) {
+	meta, err := LoadParagraphMeta(documentsDir, d.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The modification time of some filesystems is only stored to the second.
+	since = since.Truncate(time.Second)
+	cleared := []string{}
+	for _, paragraphID := range paragraphIDs {
+		if !d.audioWrittenSince(documentsDir, paragraphID, since) {
+			continue
+		}
+		m := meta[paragraphID]
+		m.Stale = false
+		meta[paragraphID] = m
+		cleared = append(cleared, paragraphID)
+	}
+	if len(cleared) == 0 {
+		return cleared, nil
+	}
+	return cleared, SaveParagraphMeta(documentsDir, d.ID, meta)
+}
+
+// audioWrittenSince will check if the audio of the paragraph has been written
+// in any format since the time. The wav is removed once it is transcoded, so
+// the transcoded files are checked as well.
+func (d *DocumentInfo) audioWrittenSince(documentsDir, paragraphID string, since time.Time) bool {
+	formats := append([]AudioFormat{FormatWav}, compressedAudioFormats...)
+	for _, format := range formats {
+		info, err := os.Stat(paragraphAudioPath(documentsDir, d.ID, paragraphID, format))
+		if err == nil && !info.ModTime().Before(since) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterParagraphs will return the paragraphs with the specified IDs, or all
+// of the paragraphs if no IDs are specified.
+func filterParagraphs(paragraphs []ParagraphInfo, paragraphIDs []string) []ParagraphInfo {
+	if paragraphIDs == nil {
+		return paragraphs
+	}
+	ids := map[string]bool{}
+	for _, paragraphID := range paragraphIDs {
+		ids[paragraphID] = true
+	}
+	filtered := []ParagraphInfo{}
+	for _, paragraph := range paragraphs {
+		if ids[paragraph.ID] {
+			filtered = append(filtered, paragraph)
+		}
+	}
+	return filtered
+}
+
+// AlignSentences splits the paragraphs of the document with the specified IDs,
+// or all of the paragraphs if no IDs are specified, into sentences and stores
+// the time that each sentence is spoken in the paragraph audio. This must be
+// done before the wav files are transcoded since the pauses between sentences
+// are found in the wav audio. The spoken function returns the text that the
+// voice reads for a sentence of the paragraph, which the timings are estimated
+// from. The progress function, if not nil, is called for each paragraph whose
+// timings are written.
+func (d *DocumentInfo) AlignSentences(ctx context.Context, documentsDir string, paragraphIDs []string, spokenFor func(paragraph ParagraphInfo, sentence string) string, progress func()) error {
+	paragraphs, err := LoadParagraphInfos(documentsDir, d.ID)
+	if err != nil {
+		return err
+	}
+	paragraphs = filterParagraphs(paragraphs, paragraphIDs)
+
+	// Align each paragraph. Keep going if one of the paragraphs fails, the
+	// timings are aligned again when the document is resumed.
+	for _, paragraph := range paragraphs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		content, err := ioutil.ReadFile(path.Join(documentsDir, d.ID, "paragraphs", paragraph.ID+".txt"))
+		if err != nil {
+			slog.WarnContext(ctx, "Unable to read paragraph", "paragraph_id", paragraph.ID, "error", err)
+			continue
+		}
+		spoken := func(sentence string) string {
+			return spokenFor(paragraph, sentence)
+		}
+		sentences, err := alignParagraph(documentsDir, d.ID, paragraph.ID, string(content), spoken)
+		if err != nil {
+			slog.WarnContext(ctx, "Unable to align sentences", "paragraph_id", paragraph.ID, "error", err)
+			continue
+		}
+		timingsPath := sentenceTimingsPath(documentsDir, d.ID, paragraph.ID)
+		if err := writeSentenceTimings(timingsPath, sentences); err != nil {
+			slog.WarnContext(ctx, "Unable to write sentence timings", "paragraph_id", paragraph.ID, "error", err)
+			continue
+		}
+		if progress != nil {
+			progress()
+		}
+	}
+
+	// Return no error.
+	return nil
+}
+
+// TranscodeParagraphs converts the synthesized audio of the paragraphs with the
+// specified IDs, or all of the paragraphs if no IDs are specified, into the
+// specified formats. The compressed audio is stored next to the wav file in the
+// audio directory. If deleteSource is set, the wav file is removed once all of
+// the formats have been written for the paragraph. The progress function, if
+// not nil, is called for each paragraph that is transcoded.
+func (d *DocumentInfo) TranscodeParagraphs(ctx context.Context, documentsDir string, paragraphIDs []string, transcoder Transcoder, formats []AudioFormat, deleteSource bool, progress func()) error {
+	audioDir := path.Join(documentsDir, d.ID, "audio")
+
+	audioFiles, err := os.ReadDir(audioDir)
+	if err != nil {
+		return err
+	}
+
+	// Loop through the wav files in the audio directory. Each file is the
+	// audio of a single paragraph.
+	failed := 0
+	for _, audioFile := range audioFiles {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		name := audioFile.Name()
+		if path.Ext(name) != FormatWav.Extension {
+			continue
+		}
+		paragraphID := strings.TrimSuffix(name, FormatWav.Extension)
+		if paragraphIDs != nil && !containsString(paragraphIDs, paragraphID) {
+			continue
+		}
+		source := path.Join(audioDir, name)
+
+		// Transcode the wav into each of the formats. Keep going if one of
+		// the paragraphs fails so the others can still be compressed.
+		transcoded := true
+		for _, format := range formats {
+			destination := paragraphAudioPath(documentsDir, d.ID, paragraphID, format)
+			if err := transcodeFile(ctx, transcoder, source, destination, format); err != nil {
+				slog.WarnContext(ctx, "Unable to transcode paragraph", "paragraph_id", paragraphID, "format", format.Name, "error", err)
+				transcoded = false
+			}
+		}
+		if !transcoded {
+			failed++
+			continue
+		}
+		if progress != nil {
+			progress()
+		}
+
+		// Remove the source audio if it is no longer needed.
+		if deleteSource && len(formats) > 0 {
+			if err := os.Remove(source); err != nil {
+				slog.WarnContext(ctx, "Unable to remove source audio", "paragraph_id", paragraphID, "error", err)
+			}
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to transcode %d paragraphs of document %s", failed, d.ID)
+	}
+
+	// Return no error.
+	return nil
+}
+
+// containsString will check if the list contains the string.
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -1,10 +1,30 @@
 package ttsweb
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/sha1"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"log/slog"
+	"os"
+	"path"
+	"sync"
+)
+
+var (
+	// ErrDocumentNotFound is returned when there is no document with the ID.
+	ErrDocumentNotFound = errors.New("document not found")
+
+	// ErrDocumentBusy is returned when a document cannot be changed while
+	// it is being processed.
+	ErrDocumentBusy = errors.New("document is being processed")
+
+	// ErrShuttingDown is returned when a document cannot be processed
+	// because the server is shutting down.
+	ErrShuttingDown = errors.New("server is shutting down")
 )
 
 // -----------------------------------------------------------------------------
@@ -19,6 +39,34 @@ type DocumentsInfo struct {
 
 	// The documents directory.
 	documentsDir string
+
+	// The pipeline that uploaded documents are processed with.
+	pipeline *Pipeline
+
+	// The webhooks that are told about the lifecycle of the documents.
+	webhooks *Webhooks
+
+	// mu guards the list of documents. The documents are updated by the
+	// pipeline in the background while the HTTP handlers read them.
+	mu sync.RWMutex
+
+	// bookmarksMu guards the bookmark files of the documents while they are
+	// read and written again.
+	bookmarksMu sync.Mutex
+
+	// sessionsMu guards the listening session files of the documents while
+	// they are read and written again.
+	sessionsMu sync.Mutex
+
+	// jobsCtx is cancelled to interrupt the documents that are being
+	// processed in the background, which are counted by processing.
+	jobsCtx    context.Context
+	cancelJobs context.CancelFunc
+	processing sync.WaitGroup
+
+	// closed is set once the documents are shut down, after which no more
+	// documents are processed. It is guarded by mu.
+	closed bool
 }
 
 // LoadDocuments will load all of the documents from the documents directory.
@@ -27,6 +75,7 @@ func LoadDocuments(documentsDir string) (*DocumentsInfo, error) {
 		Documents: []DocumentInfo{},
 		Link:      "/documents",
 	}
+	documents.jobsCtx, documents.cancelJobs = context.WithCancel(context.Background())
 
 	// Load the documents from the documents directory.
 	documentDirs, err := ioutil.ReadDir(documentsDir)
@@ -56,18 +105,103 @@ func (d *DocumentsInfo) SetDocumentsDir(documentsDir string) {
 	d.documentsDir = documentsDir
 }
 
+// SetPipeline will set the pipeline that uploaded documents are processed
+// with.
+func (d *DocumentsInfo) SetPipeline(pipeline *Pipeline) {
+	d.pipeline = pipeline
+}
+
+// Pipeline will return the pipeline that uploaded documents are processed
+// with. The default pipeline is returned if none has been set.
+func (d *DocumentsInfo) Pipeline() *Pipeline {
+	if d.pipeline == nil {
+		return DefaultPipeline
+	}
+	return d.pipeline
+}
+
+// SetWebhooks will set the webhooks that are told when documents are created,
+// synthesized, fail or are deleted.
+func (d *DocumentsInfo) SetWebhooks(webhooks *Webhooks) {
+	d.webhooks = webhooks
+}
+
+// Webhooks will return the webhooks of the documents, or nil if none have
+// been set.
+func (d *DocumentsInfo) Webhooks() *Webhooks {
+	return d.webhooks
+}
+
+// Document will return the document with the specified ID.
+func (d *DocumentsInfo) Document(id string) (DocumentInfo, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	for _, document := range d.Documents {
+		if document.ID == id {
+			return document, true
+		}
+	}
+	return DocumentInfo{}, false
+}
+
+// UpdateDocument will replace the document with the same ID in the list of
+// documents.
+func (d *DocumentsInfo) UpdateDocument(document DocumentInfo) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for i := range d.Documents {
+		if d.Documents[i].ID == document.ID {
+			d.Documents[i] = document
+			return
+		}
+	}
+}
+
+// DocumentsWithLexicon will return the documents that the lexicon is attached
+// to.
+func (d *DocumentsInfo) DocumentsWithLexicon(name string) []DocumentInfo {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	documents := []DocumentInfo{}
+	for _, document := range d.Documents {
+		if containsString(document.Lexicons, name) {
+			documents = append(documents, document)
+		}
+	}
+	return documents
+}
+
+// RebuildSearchIndex will rebuild the search index of the pipeline from the
+// paragraph files of all of the documents.
+func (d *DocumentsInfo) RebuildSearchIndex() error {
+	index := d.Pipeline().SearchIndex
+	if index == nil {
+		return fmt.Errorf("search is not enabled")
+	}
+	return index.Rebuild(d.documentsDir, func() []string {
+		d.mu.RLock()
+		defer d.mu.RUnlock()
+		documentIDs := []string{}
+		for _, document := range d.Documents {
+			documentIDs = append(documentIDs, document.ID)
+		}
+		return documentIDs
+	})
+}
+
 // GenerateID will generate a unique ID for a document. We will check the
 // documents directory to make sure that the ID is unique.
-func (d DocumentsInfo) GenerateID() string {
+func (d *DocumentsInfo) GenerateID() string {
 	// Generate a random ID.
 	idString := generateID()
 
 	// Check if the ID already exists.
-	for _, document := range d.Documents {
-		if document.ID == idString {
-			// The ID already exists, so generate a new ID.
-			return d.GenerateID()
-		}
+	if _, ok := d.Document(idString); ok {
+		// The ID already exists, so generate a new ID.
+		return d.GenerateID()
 	}
 
 	// Return the ID.
@@ -75,11 +209,24 @@ func (d DocumentsInfo) GenerateID() string {
 }
 
 // CreateDocument will create a new document with the specified name and data.
-func (d *DocumentsInfo) CreateDocument(name string, filename string, data []byte) (DocumentInfo, error) {
+// The language is the default language of the document, if it is empty the
+// language is detected once the document has been split. The document is
+// processed in the background with the log attributes of the context, such as
+// the ID of the request that uploaded it. Documents that the pipeline cannot
+// split or synthesize are refused with ErrCapabilityUnavailable, and all
+// documents are refused with ErrShuttingDown once the server is shutting down.
+func (d *DocumentsInfo) CreateDocument(ctx context.Context, name string, filename string, language string, data []byte) (DocumentInfo, error) {
+	if d.isClosed() {
+		return DocumentInfo{}, ErrShuttingDown
+	}
+	if err := d.Pipeline().CheckUpload(filename); err != nil {
+		return DocumentInfo{}, err
+	}
 	document := DocumentInfo{
 		ID:       d.GenerateID(),
 		Name:     name,
 		Filename: filename,
+		Language: language,
 		Size:     int64(len(data)),
 		Sha1sum:  sha1sum(data),
 		Status:   StatusNew,
@@ -92,10 +239,183 @@ func (d *DocumentsInfo) CreateDocument(name string, filename string, data []byte
 		return document, err
 	}
 
+	// Add the document to the list.
+	d.mu.Lock()
+	d.Documents = append(d.Documents, document)
+	d.mu.Unlock()
+	uploads.add(1)
+	uploadBytes.add(float64(len(data)))
+	d.webhooks.Notify(ctx, EventDocumentCreated, document)
+
+	// Process the document in the background. If the server has started to
+	// shut down in the meantime, the document is processed at the next start.
+	err := d.background(ctx, func(ctx context.Context) {
+		d.process(ctx, document, false)
+	})
+	if err != nil {
+		slog.WarnContext(ctx, "Left document to be processed at the next start", "document_id", document.ID, "error", err)
+	}
+
 	// Return the document.
 	return document, nil
 }
 
+// process will run the document through the pipeline, or resume it from
+// where it was interrupted. The list is updated with the changes that the
+// pipeline made to the document once it has finished. If the processing was
+// interrupted, the webhooks are not told, as the document is resumed at the
+// next start.
+func (d *DocumentsInfo) process(ctx context.Context, document DocumentInfo, resume bool) {
+	pipeline := d.Pipeline()
+	run := pipeline.Process
+	if resume {
+		run = pipeline.Resume
+	}
+	err := run(ctx, d.documentsDir, &document)
+	d.UpdateDocument(document)
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	event := EventDocumentSynthesized
+	if err != nil {
+		event = EventDocumentFailed
+	}
+	d.webhooks.Notify(ctx, event, document)
+}
+
+// ResumeDocuments will process the documents that were interrupted before
+// they were synthesized, e.g. by the server shutting down, in the
+// background. The number of documents that are resumed is returned.
+func (d *DocumentsInfo) ResumeDocuments(ctx context.Context) int {
+	d.mu.RLock()
+	var interrupted []DocumentInfo
+	for _, document := range d.Documents {
+		switch document.Status {
+		case StatusNew, StatusSaved, StatusSplit:
+			interrupted = append(interrupted, document)
+		}
+	}
+	d.mu.RUnlock()
+
+	resumed := 0
+	for _, document := range interrupted {
+		document := document
+		err := d.background(ctx, func(ctx context.Context) {
+			d.process(ctx, document, true)
+		})
+		if err != nil {
+			break
+		}
+		resumed++
+	}
+	return resumed
+}
+
+// background will run the function in a goroutine that is waited for when
+// the documents are shut down. The context of the function keeps the values
+// of ctx, e.g. the request ID, but is only cancelled by the shutdown.
+// ErrShuttingDown is returned if the documents have already been shut down.
+func (d *DocumentsInfo) background(ctx context.Context, run func(ctx context.Context)) error {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return ErrShuttingDown
+	}
+	d.processing.Add(1)
+	d.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(d.jobsContext(), cancel)
+	go func() {
+		defer d.processing.Done()
+		defer cancel()
+		defer stop()
+		run(ctx)
+	}()
+	return nil
+}
+
+// jobsContext will return the context that is cancelled to interrupt the
+// documents that are being processed.
+func (d *DocumentsInfo) jobsContext() context.Context {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.jobsCtx == nil {
+		d.jobsCtx, d.cancelJobs = context.WithCancel(context.Background())
+	}
+	return d.jobsCtx
+}
+
+// isClosed will return true once the documents have been shut down.
+func (d *DocumentsInfo) isClosed() bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.closed
+}
+
+// Shutdown will stop processing new documents and wait for the documents that
+// are being processed to finish. If the context is done first, the processing
+// is cancelled, which interrupts the commands of the pipeline and removes
+// their partial output, and the documents are resumed at the next start. The
+// error of the context is returned if the processing had to be cancelled.
+func (d *DocumentsInfo) Shutdown(ctx context.Context) error {
+	d.jobsContext()
+	d.mu.Lock()
+	d.closed = true
+	cancelJobs := d.cancelJobs
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.processing.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	slog.Warn("Interrupting the documents that are being processed", "error", ctx.Err())
+	cancelJobs()
+	<-done
+	return ctx.Err()
+}
+
+// DeleteDocument will remove the document and all of its files, including the
+// audio, bookmarks and listening sessions. Documents that are being
+// processed cannot be deleted.
+func (d *DocumentsInfo) DeleteDocument(ctx context.Context, id string) (DocumentInfo, error) {
+	document, ok := d.Document(id)
+	if !ok {
+		return document, ErrDocumentNotFound
+	}
+	pipeline := d.Pipeline()
+	if pipeline.Jobs.Running(id) {
+		return document, ErrDocumentBusy
+	}
+
+	// Remove the document from the list before its files, so that it is
+	// no longer served while they are removed.
+	d.mu.Lock()
+	for i := range d.Documents {
+		if d.Documents[i].ID == id {
+			d.Documents = append(d.Documents[:i], d.Documents[i+1:]...)
+			break
+		}
+	}
+	d.mu.Unlock()
+	if pipeline.SearchIndex != nil {
+		pipeline.SearchIndex.RemoveDocument(id)
+	}
+	if err := os.RemoveAll(path.Join(d.documentsDir, id)); err != nil {
+		return document, err
+	}
+	slog.InfoContext(ctx, "Deleted document", "document_id", id)
+	d.webhooks.Notify(ctx, EventDocumentDeleted, document)
+	return document, nil
+}
+
 // generateID will generate a random ID
 func generateID() string {
 	// Create a random ID.
@@ -0,0 +1,364 @@
+package ttsweb
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// -----------------------------------------------------------------------------
+// EPUB Splitter
+// -----------------------------------------------------------------------------
+
+// EPUBSplitter is a Splitter for EPUB books. The chapters are read in the order
+// of the spine of the book and the chapter titles are taken from the table of
+// contents.
+type EPUBSplitter struct{}
+
+// Split will split the chapters of the book into paragraphs.
+func (s EPUBSplitter) Split(ctx context.Context, inputFile, outputDir string) ([]Heading, error) {
+	book, err := zip.OpenReader(inputFile)
+	if err != nil {
+		return nil, err
+	}
+	defer book.Close()
+
+	files := map[string]*zip.File{}
+	for _, file := range book.File {
+		files[file.Name] = file
+	}
+
+	// Find the package document from the container.
+	var container struct {
+		Rootfiles []struct {
+			FullPath string `xml:"full-path,attr"`
+		} `xml:"rootfiles>rootfile"`
+	}
+	if err := decodeZipXML(files, "META-INF/container.xml", &container); err != nil {
+		return nil, err
+	}
+	if len(container.Rootfiles) == 0 {
+		return nil, fmt.Errorf("epub: no rootfile in container")
+	}
+	opfPath := container.Rootfiles[0].FullPath
+
+	// Read the manifest and spine from the package document.
+	var opf struct {
+		Manifest []struct {
+			ID         string `xml:"id,attr"`
+			Href       string `xml:"href,attr"`
+			MediaType  string `xml:"media-type,attr"`
+			Properties string `xml:"properties,attr"`
+		} `xml:"manifest>item"`
+		Spine struct {
+			Toc      string `xml:"toc,attr"`
+			Itemrefs []struct {
+				IDRef  string `xml:"idref,attr"`
+				Linear string `xml:"linear,attr"`
+			} `xml:"itemref"`
+		} `xml:"spine"`
+	}
+	if err := decodeZipXML(files, opfPath, &opf); err != nil {
+		return nil, err
+	}
+	opfDir := path.Dir(opfPath)
+	hrefs := map[string]string{}
+	navPath, ncxPath := "", ""
+	for _, item := range opf.Manifest {
+		hrefs[item.ID] = resolveEPUBHref(opfDir, item.Href)
+		if strings.Contains(" "+item.Properties+" ", " nav ") {
+			navPath = hrefs[item.ID]
+		}
+		if item.ID == opf.Spine.Toc || item.MediaType == "application/x-dtbncx+xml" {
+			ncxPath = hrefs[item.ID]
+		}
+	}
+
+	// Load the chapter titles from the table of contents. The EPUB 3
+	// navigation document is preferred over the EPUB 2 NCX.
+	titles := map[string]string{}
+	if navPath != "" {
+		titles, err = readEPUBNav(files, navPath)
+	} else if ncxPath != "" {
+		titles, err = readEPUBNCX(files, ncxPath)
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "Unable to read the table of contents of the book", "error", err)
+	}
+
+	// Extract the blocks of each chapter in spine order.
+	blocks := []Block{}
+	for _, itemref := range opf.Spine.Itemrefs {
+		if itemref.Linear == "no" {
+			continue
+		}
+		chapterPath, ok := hrefs[itemref.IDRef]
+		if !ok {
+			continue
+		}
+		file, ok := files[chapterPath]
+		if !ok {
+			slog.WarnContext(ctx, "Missing chapter of the book", "chapter", chapterPath)
+			continue
+		}
+		r, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		chapter, err := extractXHTMLBlocks(r)
+		r.Close()
+		if err != nil {
+			return nil, fmt.Errorf("epub: %s: %v", chapterPath, err)
+		}
+		if len(chapter) == 0 {
+			continue
+		}
+
+		// The chapter starts with a level 1 heading. If the chapter text
+		// does not start with a heading, the title from the table of
+		// contents is read out instead.
+		if chapter[0].HeadingLevel > 0 {
+			chapter[0].HeadingLevel = 1
+		} else if title := titles[chapterPath]; title != "" {
+			chapter = append([]Block{{Text: title, HeadingLevel: 1}}, chapter...)
+		}
+		for i := 1; i < len(chapter); i++ {
+			if chapter[i].HeadingLevel == 1 {
+				chapter[i].HeadingLevel = 2
+			}
+		}
+		blocks = append(blocks, chapter...)
+	}
+
+	// Write the paragraphs.
+	return writeBlocks(outputDir, blocks)
+}
+
+// decodeZipXML will decode the XML file with the specified name in the zip.
+func decodeZipXML(files map[string]*zip.File, name string, v interface{}) error {
+	file, ok := files[name]
+	if !ok {
+		return fmt.Errorf("epub: missing %s", name)
+	}
+	r, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	decoder := newHTMLDecoder(r)
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("epub: %s: %v", name, err)
+	}
+	return nil
+}
+
+// resolveEPUBHref will resolve an href relative to the directory of the file
+// it was found in. Any fragment is removed.
+func resolveEPUBHref(dir, href string) string {
+	if i := strings.Index(href, "#"); i >= 0 {
+		href = href[:i]
+	}
+	if unescaped, err := url.PathUnescape(href); err == nil {
+		href = unescaped
+	}
+	return path.Join(dir, href)
+}
+
+// readEPUBNav will read the chapter titles from an EPUB 3 navigation document.
+// The first link to each file is used as the title of the file.
+func readEPUBNav(files map[string]*zip.File, navPath string) (map[string]string, error) {
+	var nav struct {
+		Body struct {
+			Links []struct {
+				Href string `xml:"href,attr"`
+				Text string `xml:",chardata"`
+				Span string `xml:"span"`
+			} `xml:"nav>ol>li>a"`
+			NestedLinks []struct {
+				Href string `xml:"href,attr"`
+				Text string `xml:",chardata"`
+				Span string `xml:"span"`
+			} `xml:"nav>ol>li>ol>li>a"`
+		} `xml:"body"`
+	}
+	if err := decodeZipXML(files, navPath, &nav); err != nil {
+		return nil, err
+	}
+
+	titles := map[string]string{}
+	dir := path.Dir(navPath)
+	for _, link := range append(nav.Body.Links, nav.Body.NestedLinks...) {
+		target := resolveEPUBHref(dir, link.Href)
+		title := collapseSpace(link.Text + " " + link.Span)
+		if _, ok := titles[target]; !ok && title != "" {
+			titles[target] = title
+		}
+	}
+	return titles, nil
+}
+
+// readEPUBNCX will read the chapter titles from an EPUB 2 NCX file.
+func readEPUBNCX(files map[string]*zip.File, ncxPath string) (map[string]string, error) {
+	type navPoint struct {
+		Label   string `xml:"navLabel>text"`
+		Content struct {
+			Src string `xml:"src,attr"`
+		} `xml:"content"`
+		Children []navPoint `xml:"navPoint"`
+	}
+	var ncx struct {
+		NavPoints []navPoint `xml:"navMap>navPoint"`
+	}
+	if err := decodeZipXML(files, ncxPath, &ncx); err != nil {
+		return nil, err
+	}
+
+	titles := map[string]string{}
+	dir := path.Dir(ncxPath)
+	var walk func(points []navPoint)
+	walk = func(points []navPoint) {
+		for _, point := range points {
+			target := resolveEPUBHref(dir, point.Content.Src)
+			if _, ok := titles[target]; !ok && collapseSpace(point.Label) != "" {
+				titles[target] = collapseSpace(point.Label)
+			}
+			walk(point.Children)
+		}
+	}
+	walk(ncx.NavPoints)
+	return titles, nil
+}
+
+// -----------------------------------------------------------------------------
+// XHTML Text
+// -----------------------------------------------------------------------------
+
+// htmlBlockElements are the elements that start a new block of text.
+var htmlBlockElements = map[string]bool{
+	"p": true, "div": true, "li": true, "blockquote": true, "pre": true,
+	"dd": true, "dt": true, "section": true, "article": true, "table": true,
+	"tr": true, "td": true, "th": true, "figcaption": true, "aside": true,
+	"header": true, "footer": true, "ul": true, "ol": true, "dl": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"body": true, "hr": true,
+}
+
+// htmlSkippedElements are the elements whose text is never read.
+var htmlSkippedElements = map[string]bool{
+	"head": true, "script": true, "style": true, "title": true, "noscript": true,
+	"svg": true, "math": true, "template": true,
+}
+
+// newHTMLDecoder will create an XML decoder that accepts the HTML that is
+// commonly found in books and web pages, e.g. unclosed tags and HTML entities.
+func newHTMLDecoder(r io.Reader) *xml.Decoder {
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = false
+	decoder.AutoClose = xml.HTMLAutoClose
+	decoder.Entity = xml.HTMLEntity
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	return decoder
+}
+
+// headingLevel will return the level of a heading element, or 0 if the element
+// is not a heading.
+func headingLevel(name string) int {
+	if len(name) == 2 && name[0] == 'h' && name[1] >= '1' && name[1] <= '6' {
+		return int(name[1] - '0')
+	}
+	return 0
+}
+
+// extractXHTMLBlocks will extract the blocks of text from an XHTML document. A
+// new block is started at every block level element.
+func extractXHTMLBlocks(r io.Reader) ([]Block, error) {
+	decoder := newHTMLDecoder(r)
+
+	blocks := []Block{}
+	var text, markup strings.Builder
+	level := 0
+	skip := 0
+	quotes := 0
+
+	// flush will add the text collected so far as a block.
+	flush := func() {
+		if t := collapseSpace(text.String()); t != "" {
+			blocks = append(blocks, Block{Text: t, HeadingLevel: level, Quote: quotes > 0, Markup: markup.String()})
+		}
+		text.Reset()
+		markup.Reset()
+	}
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			name := strings.ToLower(t.Name.Local)
+			if htmlSkippedElements[name] {
+				skip++
+				continue
+			}
+			if name == "br" {
+				text.WriteString(" ")
+				markup.WriteString(" ")
+				continue
+			}
+			if htmlBlockElements[name] {
+				flush()
+				if l := headingLevel(name); l > 0 {
+					level = l
+				}
+				if name == "blockquote" {
+					quotes++
+				}
+			}
+			if skip == 0 {
+				markup.WriteString(htmlEmphasisMarker(name))
+			}
+		case xml.EndElement:
+			name := strings.ToLower(t.Name.Local)
+			if htmlSkippedElements[name] {
+				if skip > 0 {
+					skip--
+				}
+				continue
+			}
+			if skip == 0 {
+				markup.WriteString(htmlEmphasisMarker(name))
+			}
+			if htmlBlockElements[name] {
+				flush()
+				if headingLevel(name) > 0 {
+					level = 0
+				}
+				if name == "blockquote" && quotes > 0 {
+					quotes--
+				}
+			}
+		case xml.CharData:
+			if skip == 0 {
+				text.Write(t)
+				markup.WriteString(escapeMarkup(string(t)))
+			}
+		}
+	}
+	flush()
+
+	return blocks, nil
+}
@@ -0,0 +1,146 @@
+package ttsweb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// -----------------------------------------------------------------------------
+// Fetch Documents
+// -----------------------------------------------------------------------------
+
+// MaxFetchSize is the largest document that will be downloaded from a URL.
+const MaxFetchSize = 32 << 20
+
+// ErrPrivateAddress is returned when a document URL, or a redirect from it,
+// points at an address on the server's own network.
+var ErrPrivateAddress = errors.New("refusing to fetch from a private address")
+
+// fetchClient is the HTTP client used to download documents from a URL. It
+// refuses to connect to private addresses, so that the server cannot be made
+// to fetch from itself, its network or the metadata endpoint of its cloud.
+var fetchClient = newFetchClient(refusePrivateAddress)
+
+// newFetchClient will create the client that documents are fetched with. The
+// control function is run on every connection, including the ones made for
+// redirects, once the host name has been resolved. Documents are fetched
+// without a proxy, since the address that a proxy connects to cannot be
+// checked.
+func newFetchClient(control func(network, address string, c syscall.RawConn) error) *http.Client {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, Control: control}
+	return &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+}
+
+// refusePrivateAddress will refuse to connect to loopback, private, link-local
+// and unspecified addresses, e.g. 127.0.0.1, 10.0.0.1 or 169.254.169.254.
+func refusePrivateAddress(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("%w: %s", ErrPrivateAddress, host)
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsUnspecified() {
+		return fmt.Errorf("%w: %s", ErrPrivateAddress, host)
+	}
+	return nil
+}
+
+// fetchExtensions maps the content types that can be fetched to the file
+// extension that is used to split the document.
+var fetchExtensions = map[string]string{
+	"text/html":             ".html",
+	"application/xhtml+xml": ".html",
+	"text/plain":            ".txt",
+	"text/markdown":         ".md",
+	"application/pdf":       ".pdf",
+	"application/epub+zip":  ".epub",
+}
+
+// FetchDocument will download the document at the URL. The filename is made up
+// from the URL with an extension that matches the content type of the
+// response, so that the document is split by the right splitter. The name is
+// the title of the page for web pages, or the filename otherwise. The download
+// is stopped if the context is done.
+func FetchDocument(ctx context.Context, rawURL string) (name string, filename string, data []byte, err error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", "", nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", "", nil, fmt.Errorf("unsupported url scheme: %s", u.Scheme)
+	}
+
+	// Download the document.
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", "", nil, err
+	}
+	response, err := fetchClient.Do(request)
+	if err != nil {
+		return "", "", nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", "", nil, fmt.Errorf("fetching %s: %s", u, response.Status)
+	}
+	data, err = ioutil.ReadAll(io.LimitReader(response.Body, MaxFetchSize+1))
+	if err != nil {
+		return "", "", nil, err
+	}
+	if len(data) > MaxFetchSize {
+		return "", "", nil, fmt.Errorf("fetching %s: document is larger than %d bytes", u, MaxFetchSize)
+	}
+
+	// Work out the file type from the content type.
+	contentType, _, err := mime.ParseMediaType(response.Header.Get("Content-Type"))
+	if err != nil {
+		contentType = http.DetectContentType(data)
+		contentType, _, _ = mime.ParseMediaType(contentType)
+	}
+	extension, ok := fetchExtensions[contentType]
+	if !ok {
+		return "", "", nil, fmt.Errorf("fetching %s: unsupported content type: %s", u, contentType)
+	}
+
+	// Make up the filename from the last part of the URL path.
+	base := path.Base(u.Path)
+	if base == "/" || base == "." || base == "" {
+		base = u.Hostname()
+	}
+	base = strings.TrimSuffix(base, path.Ext(base))
+	filename = base + extension
+
+	// Use the title of web pages as the name of the document.
+	name = base
+	if extension == ".html" {
+		if title := htmlTitle(bytes.NewReader(data)); title != "" {
+			name = title
+		}
+	}
+
+	return name, filename, data, nil
+}
@@ -0,0 +1,270 @@
+package ttsweb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// testArticle is a blog post with the navigation, ads and footer around it
+// that the article extraction should drop.
+const testArticle = `<!DOCTYPE html>
+<html>
+<head>
+  <title>A Long Read</title>
+  <script>var tracking = "do not read this";</script>
+  <style>body { color: black; }</style>
+</head>
+<body>
+  <nav class="menu"><a href="/">Home</a> <a href="/about">About</a></nav>
+  <div class="advert">Buy one, get one free, while stocks last, today only.</div>
+  <article class="post">
+    <h1>A Long Read</h1>
+    <p>The first paragraph of the post, which goes on for a while, with a few commas, so that it looks like content.</p>
+    <h2>The Middle</h2>
+    <p>The second paragraph has <em>some emphasis</em> in it, and it is also long enough to count as content.</p>
+    <p>The third paragraph closes the post, thanking the reader, who made it all the way to the end.</p>
+  </article>
+  <div class="sidebar"><p>Related posts that should not be read out loud to anyone, ever.</p></div>
+  <footer>Copyright, all rights reserved, no part of this page may be read.</footer>
+</body>
+</html>`
+
+// fakeSynthesizer is a Synthesizer that writes a second of silence as the
+// audio of each paragraph.
+type fakeSynthesizer struct{}
+
+// Synthesize will write the silent audio of the paragraphs.
+func (fakeSynthesizer) Synthesize(ctx context.Context, voice Voice, textDir, audioDir string, paragraphIDs []string) error {
+	for _, paragraphID := range paragraphIDs {
+		if err := os.WriteFile(filepath.Join(audioDir, paragraphID+".wav"), silentWav(time.Second), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SupportsSSML reports that the fake reads plain text.
+func (fakeSynthesizer) SupportsSSML() bool {
+	return false
+}
+
+// newTestSite will start a web server with the pages that the tests fetch.
+// Documents can be fetched from loopback addresses until the test ends, since
+// the server listens on one.
+func newTestSite(t *testing.T) *httptest.Server {
+	t.Helper()
+	client := fetchClient
+	fetchClient = newFetchClient(nil)
+	t.Cleanup(func() { fetchClient = client })
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/posts/long-read", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(testArticle))
+	})
+	mux.HandleFunc("/notes.txt", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("Some notes."))
+	})
+	mux.HandleFunc("/image.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("\x89PNG"))
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, r.URL.Query().Get("to"), http.StatusFound)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestFetchDocument(t *testing.T) {
+	site := newTestSite(t)
+
+	tests := []struct {
+		path     string
+		name     string
+		filename string
+	}{
+		{"/posts/long-read", "A Long Read", "long-read.html"},
+		{"/notes.txt", "notes", "notes.txt"},
+	}
+	for _, test := range tests {
+		name, filename, data, err := FetchDocument(context.Background(), site.URL+test.path)
+		if err != nil {
+			t.Errorf("FetchDocument(%s) returned error: %v", test.path, err)
+			continue
+		}
+		if name != test.name || filename != test.filename {
+			t.Errorf("FetchDocument(%s) = %q, %q, want %q, %q", test.path, name, filename, test.name, test.filename)
+		}
+		if len(data) == 0 {
+			t.Errorf("FetchDocument(%s) returned no data", test.path)
+		}
+	}
+
+	for _, path := range []string{"/missing", "/image.png"} {
+		if _, _, _, err := FetchDocument(context.Background(), site.URL+path); err == nil {
+			t.Errorf("FetchDocument(%s) did not return an error", path)
+		}
+	}
+	if _, _, _, err := FetchDocument(context.Background(), "file:///etc/passwd"); err == nil {
+		t.Error("FetchDocument(file:///etc/passwd) did not return an error")
+	}
+}
+
+func TestFetchDocumentPrivateAddress(t *testing.T) {
+	site := newTestSite(t)
+	private := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the private server was fetched from")
+	}))
+	defer private.Close()
+
+	// The site is public for the test, and the other server is private, so
+	// that a redirect from one to the other can be followed.
+	_, privatePort, _ := net.SplitHostPort(private.Listener.Addr().String())
+	fetchClient = newFetchClient(func(network, address string, c syscall.RawConn) error {
+		if _, port, _ := net.SplitHostPort(address); port == privatePort {
+			return refusePrivateAddress(network, address, c)
+		}
+		return nil
+	})
+	for _, rawURL := range []string{
+		private.URL + "/notes.txt",
+		site.URL + "/redirect?to=" + url.QueryEscape(private.URL+"/notes.txt"),
+	} {
+		if _, _, _, err := FetchDocument(context.Background(), rawURL); !errors.Is(err, ErrPrivateAddress) {
+			t.Errorf("FetchDocument(%s) returned %v, want %v", rawURL, err, ErrPrivateAddress)
+		}
+	}
+	if _, _, _, err := FetchDocument(context.Background(), site.URL+"/notes.txt"); err != nil {
+		t.Errorf("FetchDocument(public) returned error: %v", err)
+	}
+
+	// A document cannot be fetched once the request has gone away.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, _, err := FetchDocument(ctx, site.URL+"/notes.txt"); !errors.Is(err, context.Canceled) {
+		t.Errorf("FetchDocument() with a cancelled context returned %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestRefusePrivateAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		refused bool
+	}{
+		{"127.0.0.1:80", true},
+		{"[::1]:80", true},
+		{"10.1.2.3:80", true},
+		{"172.16.0.1:443", true},
+		{"192.168.1.1:80", true},
+		{"169.254.169.254:80", true},
+		{"[fe80::1]:80", true},
+		{"[fd00::1]:80", true},
+		{"0.0.0.0:80", true},
+		{"[::ffff:127.0.0.1]:80", true},
+		{"93.184.216.34:443", false},
+		{"[2606:2800:220:1::1]:443", false},
+	}
+	for _, test := range tests {
+		err := refusePrivateAddress("tcp", test.address, nil)
+		if refused := errors.Is(err, ErrPrivateAddress); refused != test.refused {
+			t.Errorf("refusePrivateAddress(%s) = %v, want refused %t", test.address, err, test.refused)
+		}
+	}
+}
+
+func TestExtractHTMLArticle(t *testing.T) {
+	blocks := ExtractHTMLArticle(testArticle)
+
+	want := []Block{
+		{Text: "A Long Read", HeadingLevel: 1},
+		{Text: "The first paragraph of the post, which goes on for a while, with a few commas, so that it looks like content."},
+		{Text: "The Middle", HeadingLevel: 2},
+		{Text: "The second paragraph has some emphasis in it, and it is also long enough to count as content."},
+		{Text: "The third paragraph closes the post, thanking the reader, who made it all the way to the end."},
+	}
+	if len(blocks) != len(want) {
+		t.Fatalf("ExtractHTMLArticle() returned %d blocks, want %d: %+v", len(blocks), len(want), blocks)
+	}
+	for i := range want {
+		if blocks[i].Text != want[i].Text || blocks[i].HeadingLevel != want[i].HeadingLevel {
+			t.Errorf("block %d = %q at level %d, want %q at level %d", i, blocks[i].Text, blocks[i].HeadingLevel, want[i].Text, want[i].HeadingLevel)
+		}
+	}
+	if !strings.Contains(blocks[3].Markup, "some emphasis") || blocks[3].Markup == blocks[3].Text {
+		t.Errorf("block 3 markup = %q, want the emphasis kept", blocks[3].Markup)
+	}
+}
+
+func TestPostDocumentURL(t *testing.T) {
+	site := newTestSite(t)
+	documentsDir := t.TempDir() + "/"
+	documents, err := LoadDocuments(documentsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	documents.SetDocumentsDir(documentsDir)
+	documents.SetPipeline(&Pipeline{
+		Splitters:    DefaultSplitters(),
+		Voice:        DefaultVoice,
+		Synthesizers: map[string]Synthesizer{EngineCoqui: fakeSynthesizer{}},
+		Jobs:         NewJobs(),
+	})
+
+	form := url.Values{"url": {site.URL + "/posts/long-read"}}
+	r := httptest.NewRequest(http.MethodPost, "/documents", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	documents.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /documents returned %d: %s", w.Code, w.Body.String())
+	}
+	var document DocumentInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &document); err != nil {
+		t.Fatal(err)
+	}
+	if document.Name != "A Long Read" || document.Filename != "long-read.html" {
+		t.Errorf("POST /documents created %q from %q, want %q from %q", document.Name, document.Filename, "A Long Read", "long-read.html")
+	}
+
+	// URLs that cannot be fetched are refused.
+	form = url.Values{"url": {site.URL + "/image.png"}}
+	r = httptest.NewRequest(http.MethodPost, "/documents", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	documents.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("POST /documents with an image returned %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	// Wait for the document to be processed.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := documents.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	document, _ = documents.Document(document.ID)
+	if document.Status != StatusSynthesized {
+		t.Fatalf("document status = %s (%s), want %s", document.Status, document.Error, StatusSynthesized)
+	}
+	paragraphs, err := LoadParagraphInfos(documentsDir, document.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paragraphs) != 5 {
+		t.Errorf("document has %d paragraphs, want 5", len(paragraphs))
+	}
+}
@@ -0,0 +1,126 @@
+package ttsweb
+
+import (
+	"flag"
+	"strings"
+)
+
+// -----------------------------------------------------------------------------
+// Pipeline Flags
+// -----------------------------------------------------------------------------
+
+// PipelineFlags are the command line flags that configure the pipeline. They
+// are shared by the server and the command line tools, so that a document is
+// processed the same way by each of them.
+type PipelineFlags struct {
+	TextNormalization      *string
+	VoiceEngine            *string
+	VoiceModel             *string
+	VoiceSpeaker           *string
+	VoiceLanguage          *string
+	LanguageVoices         *string
+	SpeechExpanders        *string
+	SpeechRules            *string
+	TransliterateLanguages *string
+	SkipClasses            *string
+	LexiconsDir            *string
+
+	AudioFormats *string
+	DeleteWav    *bool
+	FFmpeg       *string
+	AudioBitrate *string
+}
+
+// RegisterPipelineFlags will define the flags of the pipeline in the flag set.
+func RegisterPipelineFlags(flags *flag.FlagSet) *PipelineFlags {
+	return &PipelineFlags{
+		TextNormalization:      flags.String("text-normalization", "nfc,quotes,ligatures", "comma separated list of normalizations applied to the text of documents (nfc, quotes, ligatures, none)"),
+		VoiceEngine:            flags.String("voice-engine", EngineCoqui, "the TTS engine of the voice model (coqui, espeak)"),
+		VoiceModel:             flags.String("voice-model", DefaultVoice.Model, "the TTS model used to synthesize documents"),
+		VoiceSpeaker:           flags.String("voice-speaker", DefaultVoice.Speaker, "the speaker of the TTS model"),
+		VoiceLanguage:          flags.String("voice-language", DefaultVoice.Language, "the language code of the TTS model"),
+		LanguageVoices:         flags.String("language-voices", "", "comma separated list of voices for other languages, e.g. de=tts_models/de/thorsten/vits"),
+		SpeechExpanders:        flags.String("speech-expanders", "urls,abbreviations,currencies,dates,roman,numbers", "comma separated list of expanders applied to English text before synthesis, or none"),
+		SpeechRules:            flags.String("speech-rules", "", "JSON file of regular expression speech rules applied to all documents"),
+		TransliterateLanguages: flags.String("transliterate-languages", "", "comma separated list of languages whose voices cannot read accented letters, the text that they read is transliterated to ASCII"),
+		SkipClasses:            flags.String("skip-classes", "", "comma separated list of paragraph classes that new documents skip (body, heading, table, code, footnote, reference, page-number), or none"),
+		LexiconsDir:            flags.String("lexicons-dir", "lexicons/", "the directory that contains the pronunciation lexicons"),
+
+		AudioFormats: flags.String("audio-formats", "", "comma separated list of compressed audio formats to transcode into (opus, mp3)"),
+		DeleteWav:    flags.Bool("delete-wav", false, "delete the synthesized wav files once they have been transcoded"),
+		FFmpeg:       flags.String("ffmpeg", "ffmpeg", "the path to the ffmpeg binary used for transcoding"),
+		AudioBitrate: flags.String("audio-bitrate", "", "the bitrate of the transcoded audio, e.g. 48k"),
+	}
+}
+
+// Pipeline will create the pipeline that the flags describe. The search index
+// and the jobs are left unset.
+func (f *PipelineFlags) Pipeline() (*Pipeline, error) {
+	audioFormats, err := ParseAudioFormats(*f.AudioFormats)
+	if err != nil {
+		return nil, err
+	}
+	textNormalization, err := ParseTextNormalization(*f.TextNormalization)
+	if err != nil {
+		return nil, err
+	}
+	languageVoices, err := ParseLanguageVoices(*f.LanguageVoices)
+	if err != nil {
+		return nil, err
+	}
+	speechExpanders, err := ParseSpeechExpanders(*f.SpeechExpanders)
+	if err != nil {
+		return nil, err
+	}
+	skipClasses, err := ParseParagraphClasses(*f.SkipClasses)
+	if err != nil {
+		return nil, err
+	}
+	speechRules := SpeechRules{}
+	if *f.SpeechRules != "" {
+		if speechRules, err = LoadSpeechRules(*f.SpeechRules); err != nil {
+			return nil, err
+		}
+	}
+
+	pipeline := &Pipeline{
+		Splitters:         DefaultSplitters(),
+		TextNormalization: textNormalization,
+		SpeechRules:       speechRules,
+		SpeechExpanders:   speechExpanders,
+		Voice: Voice{
+			Engine:   *f.VoiceEngine,
+			Model:    *f.VoiceModel,
+			Speaker:  *f.VoiceSpeaker,
+			Language: *f.VoiceLanguage,
+		},
+		LanguageVoices:    languageVoices,
+		SkipClasses:       skipClasses,
+		LexiconsDir:       *f.LexiconsDir,
+		AudioFormats:      audioFormats,
+		DeleteSourceAudio: *f.DeleteWav,
+	}
+
+	// Transliterate the text of the voices of the languages that need it.
+	for _, language := range strings.Split(*f.TransliterateLanguages, ",") {
+		language = strings.TrimSpace(language)
+		if language == "" {
+			continue
+		}
+		if pipeline.Voice.Language == language {
+			pipeline.Voice.Transliterate = true
+		}
+		if voice, ok := pipeline.LanguageVoices[language]; ok {
+			voice.Transliterate = true
+			pipeline.LanguageVoices[language] = voice
+		}
+	}
+
+	if len(audioFormats) > 0 {
+		pipeline.Transcoder = FFmpegTranscoder{
+			Path:    *f.FFmpeg,
+			Bitrate: *f.AudioBitrate,
+		}
+	}
+	return pipeline, nil
+}
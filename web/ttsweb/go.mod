@@ -1,3 +1,5 @@
 module imitablerabbit/ttsweb
 
-go 1.20
+go 1.21
+
+require golang.org/x/text v0.22.0
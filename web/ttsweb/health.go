@@ -0,0 +1,478 @@
+package ttsweb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of the backends of the pipeline that are checked.
+const (
+	CapabilitySplitter    = "splitter"
+	CapabilitySynthesizer = "synthesizer"
+	CapabilityTranscoder  = "transcoder"
+)
+
+// Statuses of the backends of the pipeline, and of the server as a whole.
+const (
+	// CapabilityOK is the status of a backend that passed its check.
+	CapabilityOK = "ok"
+
+	// CapabilityDegraded is the status of a backend that works, but not for
+	// every document, e.g. the PDF splitter without its fallback for scanned
+	// PDFs.
+	CapabilityDegraded = "degraded"
+
+	// CapabilityUnavailable is the status of a backend that failed its check.
+	CapabilityUnavailable = "unavailable"
+
+	// HealthStarting is the status of the server until the backends have
+	// been checked.
+	HealthStarting = "starting"
+
+	// HealthStopping is the status of the server once it has started to
+	// shut down, and no longer accepts documents.
+	HealthStopping = "stopping"
+)
+
+const (
+	// toolCheckTimeout is how long a tool is given to print its version.
+	toolCheckTimeout = 10 * time.Second
+
+	// synthesisCheckTimeout is how long a voice is given to synthesize the
+	// test text. Models may be loaded, or even downloaded, the first time.
+	synthesisCheckTimeout = 5 * time.Minute
+
+	// checkText is the text that the voices synthesize to be checked.
+	checkText = "Hello."
+)
+
+var (
+	// ErrCapabilityUnavailable is returned when a document needs a backend
+	// that failed its check.
+	ErrCapabilityUnavailable = errors.New("not available on this server")
+
+	// ErrCapabilityDegraded is wrapped by the errors of the checks of the
+	// backends that still work for most documents.
+	ErrCapabilityDegraded = errors.New("only partly available")
+)
+
+// Checker is implemented by the splitters, synthesizers and transcoders that
+// depend on tools outside of the server. Check will verify that the tools are
+// installed and return their version.
+type Checker interface {
+	Check(ctx context.Context) (string, error)
+}
+
+// -----------------------------------------------------------------------------
+// Capabilities
+// -----------------------------------------------------------------------------
+
+// Capability is the result of the check of a backend of the pipeline. The
+// name of a splitter is the file extension that it splits, or "*" for the
+// script that splits the other file types, the name of a synthesizer is the
+// voice that it was checked with, and the name of a transcoder is the audio
+// format. The server is only ready if its required backends are available.
+type Capability struct {
+	Kind     string `json:"kind"`
+	Name     string `json:"name"`
+	Language string `json:"language,omitempty"`
+	Required bool   `json:"required"`
+	Status   string `json:"status"`
+	Version  string `json:"version,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Duration int64  `json:"durationMs"`
+}
+
+// CheckCapabilities will check each of the splitters, the synthesizer of each
+// of the voices with a test synthesis, and the transcoder with each of the
+// audio formats. The default voice is required, the other backends only
+// limit the documents that can be processed.
+func (p *Pipeline) CheckCapabilities(ctx context.Context) []Capability {
+	capabilities := []Capability{}
+
+	// Check the splitter of each file type.
+	extensions := make([]string, 0, len(p.Splitters))
+	for extension := range p.Splitters {
+		extensions = append(extensions, extension)
+	}
+	sort.Strings(extensions)
+	for _, extension := range extensions {
+		capabilities = append(capabilities, checkCapability(CapabilitySplitter, extension, func() (string, error) {
+			return checkBackend(ctx, p.Splitters[extension])
+		}))
+	}
+	capabilities = append(capabilities, checkCapability(CapabilitySplitter, "*", func() (string, error) {
+		return checkBackend(ctx, p.splitterFor(""))
+	}))
+
+	// Synthesize the test text with each of the voices.
+	voices := []Voice{p.voice()}
+	languages := make([]string, 0, len(p.LanguageVoices))
+	for language := range p.LanguageVoices {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	for _, language := range languages {
+		voices = append(voices, p.LanguageVoices[language])
+	}
+	for i, voice := range voices {
+		capability := checkCapability(CapabilitySynthesizer, voiceName(voice), func() (string, error) {
+			return p.checkVoice(ctx, voice)
+		})
+		capability.Required = i == 0
+		if i > 0 {
+			capability.Language = voice.Language
+		}
+		capabilities = append(capabilities, capability)
+	}
+
+	// Transcode silence into each of the audio formats.
+	if p.Transcoder != nil {
+		for _, format := range p.AudioFormats {
+			capabilities = append(capabilities, checkCapability(CapabilityTranscoder, format.Name, func() (string, error) {
+				return p.checkTranscoder(ctx, format)
+			}))
+		}
+	}
+
+	return capabilities
+}
+
+// checkCapability will run the check of a backend and record how long it
+// took.
+func checkCapability(kind, name string, check func() (string, error)) Capability {
+	started := time.Now()
+	version, err := check()
+	capability := Capability{
+		Kind:     kind,
+		Name:     name,
+		Status:   CapabilityOK,
+		Version:  version,
+		Duration: time.Since(started).Milliseconds(),
+	}
+	if err != nil {
+		capability.Status = CapabilityUnavailable
+		if errors.Is(err, ErrCapabilityDegraded) {
+			capability.Status = CapabilityDegraded
+		}
+		capability.Error = err.Error()
+	}
+	return capability
+}
+
+// checkBackend will check the backend if it depends on any tools. Backends
+// that are built into the server always pass.
+func checkBackend(ctx context.Context, backend interface{}) (string, error) {
+	checker, ok := backend.(Checker)
+	if !ok {
+		return "", nil
+	}
+	return checker.Check(ctx)
+}
+
+// checkVoice will check the synthesizer of the voice and synthesize the test
+// text with it.
+func (p *Pipeline) checkVoice(ctx context.Context, voice Voice) (string, error) {
+	synthesizer, err := p.synthesizerFor(voice)
+	if err != nil {
+		return "", err
+	}
+	version, err := checkBackend(ctx, synthesizer)
+	if err != nil {
+		return version, err
+	}
+
+	dir, err := os.MkdirTemp("", "ttsweb-check-")
+	if err != nil {
+		return version, err
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "0.txt"), []byte(voice.SpeechText(checkText)), 0644); err != nil {
+		return version, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, synthesisCheckTimeout)
+	defer cancel()
+	if err := synthesizer.Synthesize(ctx, voice, dir, dir, []string{"0"}); err != nil {
+		return version, err
+	}
+	info, err := readWavInfo(filepath.Join(dir, "0.wav"))
+	if err != nil {
+		return version, err
+	}
+	if info.Duration <= 0 {
+		return version, fmt.Errorf("the test synthesis has no audio")
+	}
+	return version, nil
+}
+
+// checkTranscoder will check the transcoder and transcode a short silence
+// into the format.
+func (p *Pipeline) checkTranscoder(ctx context.Context, format AudioFormat) (string, error) {
+	version, err := checkBackend(ctx, p.Transcoder)
+	if err != nil {
+		return version, err
+	}
+
+	dir, err := os.MkdirTemp("", "ttsweb-check-")
+	if err != nil {
+		return version, err
+	}
+	defer os.RemoveAll(dir)
+	source := filepath.Join(dir, "silence.wav")
+	if err := ioutil.WriteFile(source, silentWav(100*time.Millisecond), 0644); err != nil {
+		return version, err
+	}
+	destination := filepath.Join(dir, "silence"+format.Extension)
+	if err := transcodeFile(ctx, p.Transcoder, source, destination, format); err != nil {
+		return version, err
+	}
+	if stat, err := os.Stat(destination); err != nil || stat.Size() == 0 {
+		return version, fmt.Errorf("the test transcode has no audio")
+	}
+	return version, nil
+}
+
+// silentWav will create a 16 bit mono wav file of silence.
+func silentWav(duration time.Duration) []byte {
+	const sampleRate = 22050
+	var fmtChunk bytes.Buffer
+	binary.Write(&fmtChunk, binary.LittleEndian, []uint16{1, 1})
+	binary.Write(&fmtChunk, binary.LittleEndian, []uint32{sampleRate, sampleRate * 2})
+	binary.Write(&fmtChunk, binary.LittleEndian, []uint16{2, 16})
+	data := make([]byte, int(duration.Seconds()*sampleRate)*2)
+	return append(wavHeader(fmtChunk.Bytes(), int64(len(data))), data...)
+}
+
+// voiceName will return the name of the voice in the form that it is
+// configured in, e.g. "tts_models/en/vctk/vits:p241" or "espeak/de".
+func voiceName(voice Voice) string {
+	name := voice.Model
+	if voice.Engine != "" && voice.Engine != EngineCoqui {
+		name = voice.Engine + "/" + name
+	}
+	if voice.Speaker != "" {
+		name += ":" + voice.Speaker
+	}
+	return name
+}
+
+// toolVersion will check that the tool is installed and return the first line
+// that it prints when it is run with the arguments, e.g. --version. If the
+// tool fails, the error has the last line that it printed.
+func toolVersion(ctx context.Context, binary string, args ...string) (string, error) {
+	path, err := exec.LookPath(binary)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(ctx, toolCheckTimeout)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, path, args...).CombinedOutput()
+	lines := []string{}
+	for _, line := range strings.Split(string(output), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if err != nil {
+		if len(lines) > 0 {
+			return "", fmt.Errorf("%s: %v: %s", binary, err, lines[len(lines)-1])
+		}
+		return "", fmt.Errorf("%s: %v", binary, err)
+	}
+	if len(lines) == 0 {
+		return "", nil
+	}
+	return lines[0], nil
+}
+
+// checkScript will check that the script that is run by a backend exists.
+func checkScript(script string) error {
+	if _, err := os.Stat(script); err != nil {
+		return fmt.Errorf("script not found: %s", script)
+	}
+	return nil
+}
+
+// -----------------------------------------------------------------------------
+// Health
+// -----------------------------------------------------------------------------
+
+// Health keeps the results of the self-check of the pipeline. Uploads that
+// need a backend that is unavailable are refused, and the server is only
+// ready once the backends have been checked and the default voice works.
+type Health struct {
+	started time.Time
+
+	// checkMu makes sure that only one check of the backends runs at a time.
+	checkMu sync.Mutex
+
+	mu           sync.RWMutex
+	checked      *time.Time
+	capabilities []Capability
+}
+
+// HealthReport is the status of the server and of each of the backends of
+// the pipeline.
+type HealthReport struct {
+	Status       string       `json:"status"`
+	Ready        bool         `json:"ready"`
+	Started      time.Time    `json:"started"`
+	Checked      *time.Time   `json:"checked,omitempty"`
+	Capabilities []Capability `json:"capabilities"`
+}
+
+// NewHealth will create the health of a server that is starting.
+func NewHealth() *Health {
+	return &Health{started: time.Now().UTC()}
+}
+
+// Watch will check the backends of the pipeline straight away, and again
+// every interval until the context is done, so that a tool that breaks or is
+// installed while the server is running is noticed. The backends are only
+// checked once if the interval is 0.
+func (h *Health) Watch(ctx context.Context, p *Pipeline, interval time.Duration) {
+	h.Check(ctx, p)
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		h.Check(ctx, p)
+	}
+}
+
+// Check will check the backends of the pipeline and keep the results. Each
+// backend whose status has changed since the last check is logged, along
+// with what it means for the documents. The results are not kept if the
+// context is done before the check has finished, since the backends that
+// were interrupted would look unavailable.
+func (h *Health) Check(ctx context.Context, p *Pipeline) []Capability {
+	h.checkMu.Lock()
+	defer h.checkMu.Unlock()
+
+	capabilities := p.CheckCapabilities(ctx)
+	if ctx.Err() != nil {
+		slog.WarnContext(ctx, "Stopped checking the backends", "error", ctx.Err())
+		return capabilities
+	}
+	for _, capability := range capabilities {
+		if previous, ok := h.capability(capability.Kind, capability.Name); ok && previous.Status == capability.Status {
+			continue
+		}
+		attrs := []interface{}{"kind", capability.Kind, "name", capability.Name, "status", capability.Status}
+		if capability.Version != "" {
+			attrs = append(attrs, "version", capability.Version)
+		}
+		switch {
+		case capability.Status == CapabilityOK:
+			slog.InfoContext(ctx, "Checked backend", attrs...)
+		case capability.Required:
+			slog.ErrorContext(ctx, "Backend is unavailable, no documents can be synthesized", append(attrs, "error", capability.Error)...)
+		case capability.Kind == CapabilitySynthesizer:
+			slog.WarnContext(ctx, "Backend is unavailable, the default voice will read the language", append(attrs, "language", capability.Language, "error", capability.Error)...)
+		case capability.Status == CapabilityDegraded:
+			slog.WarnContext(ctx, "Backend is only partly available", append(attrs, "error", capability.Error)...)
+		default:
+			slog.WarnContext(ctx, "Backend is unavailable, the documents that need it will be refused", append(attrs, "error", capability.Error)...)
+		}
+	}
+
+	checked := time.Now().UTC()
+	h.mu.Lock()
+	h.checked = &checked
+	h.capabilities = capabilities
+	h.mu.Unlock()
+	return capabilities
+}
+
+// Report will return the status of the server. The status is starting until
+// the backends have been checked, unavailable if a required backend failed,
+// degraded if any other backend did not pass, and ok otherwise. If the health
+// is nil, the backends are not checked and the server is always ready.
+func (h *Health) Report() HealthReport {
+	if h == nil {
+		return HealthReport{Status: CapabilityOK, Ready: true, Capabilities: []Capability{}}
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	report := HealthReport{
+		Status:       HealthStarting,
+		Started:      h.started,
+		Checked:      h.checked,
+		Capabilities: append([]Capability{}, h.capabilities...),
+	}
+	if h.checked == nil {
+		return report
+	}
+	report.Status = CapabilityOK
+	report.Ready = true
+	for _, capability := range h.capabilities {
+		if capability.Status == CapabilityOK {
+			continue
+		}
+		if capability.Required && capability.Status == CapabilityUnavailable {
+			report.Status = CapabilityUnavailable
+			report.Ready = false
+			break
+		}
+		report.Status = CapabilityDegraded
+	}
+	return report
+}
+
+// capability will return the result of the check of the backend. Nothing is
+// found if the health is nil or the backends have not been checked yet.
+func (h *Health) capability(kind, name string) (Capability, bool) {
+	if h == nil {
+		return Capability{}, false
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, capability := range h.capabilities {
+		if capability.Kind == kind && capability.Name == name {
+			return capability, true
+		}
+	}
+	return Capability{}, false
+}
+
+// unavailable will return an error if the backend failed its check.
+func (h *Health) unavailable(kind, name string) error {
+	capability, ok := h.capability(kind, name)
+	if !ok || capability.Status != CapabilityUnavailable {
+		return nil
+	}
+	return fmt.Errorf("%s %s is %w: %s", kind, name, ErrCapabilityUnavailable, capability.Error)
+}
+
+// CheckUpload will return an error wrapping ErrCapabilityUnavailable if the
+// document cannot be split or no documents can be synthesized. Documents are
+// accepted until the backends have been checked.
+func (p *Pipeline) CheckUpload(filename string) error {
+	extension := strings.ToLower(filepath.Ext(filename))
+	if _, ok := p.Splitters[extension]; !ok {
+		extension = "*"
+	}
+	if err := p.Health.unavailable(CapabilitySplitter, extension); err != nil {
+		return err
+	}
+	return p.Health.unavailable(CapabilitySynthesizer, voiceName(p.voice()))
+}
@@ -0,0 +1,116 @@
+package ttsweb
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// -----------------------------------------------------------------------------
+// HLS Playlist
+// -----------------------------------------------------------------------------
+
+// PlaylistContentType is the MIME type of an HLS playlist.
+const PlaylistContentType = "application/vnd.apple.mpegurl"
+
+// playlistFormats are the audio formats that can be used for playlist segments
+// in order of preference. HLS players only accept packed mp3 audio, so the
+// documents must be transcoded to mp3 to be played from a playlist.
+var playlistFormats = []AudioFormat{FormatMP3}
+
+// Playlist is an HLS video on demand playlist for the audio of a document.
+type Playlist struct {
+	// TargetDuration is the longest segment duration rounded up to the
+	// nearest second.
+	TargetDuration int
+
+	Segments []PlaylistSegment
+}
+
+// PlaylistSegment is a single segment of an HLS playlist.
+type PlaylistSegment struct {
+	// Title of the segment. The title is the range of paragraph IDs that the
+	// segment contains.
+	Title string
+
+	Duration time.Duration
+	URI      string
+
+	// Discontinuity is set if the segment starts a new part of the document
+	// and players should reset their decoder.
+	Discontinuity bool
+}
+
+// NewPlaylist will create the playlist for the document from the stream of its
+// audio. Each segment contains the audio of up to group paragraphs, and links
+// to the range of the document stream that covers the paragraphs. The segments
+// are stamped with the time that they start at, as packed audio segments
+// carry no timestamps of their own.
+//
+// A segment never crosses the start of a chapter. Each chapter after the first
+// starts with a discontinuity so that players can treat it as a new part.
+func NewPlaylist(documentID string, stream *AudioStream, group int, chapterStarts map[string]bool) Playlist {
+	playlist := Playlist{}
+	if group < 1 {
+		group = 1
+	}
+
+	for i := 0; i < len(stream.Timeline); {
+		// End the segment early if the next chapter starts within it.
+		end := i + 1
+		for end < len(stream.Timeline) && end-i < group && !chapterStarts[stream.Timeline[end].ParagraphID] {
+			end++
+		}
+		first := stream.Timeline[i]
+		last := stream.Timeline[end-1]
+
+		segment := PlaylistSegment{
+			Title:         first.ParagraphID,
+			Duration:      time.Duration(last.EndMs-first.StartMs) * time.Millisecond,
+			Discontinuity: i > 0 && chapterStarts[first.ParagraphID],
+		}
+		if end-i > 1 {
+			segment.Title += "-" + last.ParagraphID
+		}
+		query := url.Values{}
+		query.Set("from", first.ParagraphID)
+		query.Set("to", last.ParagraphID)
+		query.Set("format", stream.Format.Name)
+		query.Set("timestamp", strconv.FormatInt(first.StartMs, 10))
+		segment.URI = "/documents/" + documentID + "/stream?" + query.Encode()
+		playlist.Segments = append(playlist.Segments, segment)
+
+		// The target duration must not be less than any segment duration.
+		seconds := int(math.Ceil(segment.Duration.Seconds()))
+		if seconds > playlist.TargetDuration {
+			playlist.TargetDuration = seconds
+		}
+		i = end
+	}
+
+	// Return the playlist.
+	return playlist
+}
+
+// String will write the playlist in the m3u8 format.
+func (p Playlist) String() string {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-VERSION:3\n")
+	b.WriteString(fmt.Sprintf("#EXT-X-TARGETDURATION:%d\n", p.TargetDuration))
+	b.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n")
+	b.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n")
+	for _, segment := range p.Segments {
+		if segment.Discontinuity {
+			b.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		b.WriteString(fmt.Sprintf("#EXTINF:%.3f,%s\n", segment.Duration.Seconds(), segment.Title))
+		b.WriteString(segment.URI)
+		b.WriteString("\n")
+	}
+	b.WriteString("#EXT-X-ENDLIST\n")
+	return b.String()
+}
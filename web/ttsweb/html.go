@@ -0,0 +1,404 @@
+package ttsweb
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+// -----------------------------------------------------------------------------
+// HTML Splitter
+// -----------------------------------------------------------------------------
+
+// HTMLSplitter is a Splitter for web pages. The main content of the page is
+// found by scoring the elements of the page on the amount of text that they
+// contain, in the same way as reader views in web browsers. Navigation, ads,
+// footers and scripts are dropped.
+type HTMLSplitter struct{}
+
+// Split will split the main content of the web page into paragraphs.
+func (s HTMLSplitter) Split(ctx context.Context, inputFile, outputDir string) ([]Heading, error) {
+	data, err := ioutil.ReadFile(inputFile)
+	if err != nil {
+		return nil, err
+	}
+	return writeBlocks(outputDir, ExtractHTMLArticle(string(data)))
+}
+
+// htmlNode is an element or text in the tree of an HTML document.
+type htmlNode struct {
+	Name     string
+	Attrs    map[string]string
+	Text     string
+	Parent   *htmlNode
+	Children []*htmlNode
+}
+
+var (
+	// htmlRawText matches the elements whose content is not HTML and would
+	// confuse the parser, along with comments.
+	htmlRawText = regexp.MustCompile(`(?is)<script\b.*?</script\s*>|<style\b.*?</style\s*>|<!--.*?-->`)
+
+	// htmlUnlikely matches the class and id of elements that are not part of
+	// the main content of a page.
+	htmlUnlikely = regexp.MustCompile(`(?i)nav|menu|footer|masthead|sidebar|comment|share|social|promo|advert|\bads?\b|banner|cookie|subscribe|newsletter|related|breadcrumb|popup|modal|sponsor|widget`)
+
+	// htmlLikely matches the class and id of elements that are likely to be
+	// the main content of a page.
+	htmlLikely = regexp.MustCompile(`(?i)article|content|main|post|entry|body|story|text`)
+)
+
+// htmlDroppedElements are the elements that are never part of the content.
+var htmlDroppedElements = map[string]bool{
+	"script": true, "style": true, "nav": true, "header": true, "footer": true,
+	"aside": true, "form": true, "noscript": true, "iframe": true, "button": true,
+	"svg": true, "select": true, "input": true, "textarea": true, "template": true,
+	"menu": true, "dialog": true, "canvas": true, "video": true, "audio": true,
+}
+
+// parseHTML will parse the HTML into a tree. Parsing stops at the first error
+// that cannot be recovered from, the tree that has been built up to that point
+// is returned.
+func parseHTML(html string) *htmlNode {
+	html = htmlRawText.ReplaceAllString(html, "")
+	decoder := newHTMLDecoder(strings.NewReader(html))
+
+	root := &htmlNode{Name: "#document"}
+	current := root
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			node := &htmlNode{
+				Name:   strings.ToLower(t.Name.Local),
+				Attrs:  map[string]string{},
+				Parent: current,
+			}
+			for _, attr := range t.Attr {
+				node.Attrs[strings.ToLower(attr.Name.Local)] = attr.Value
+			}
+			current.Children = append(current.Children, node)
+			current = node
+		case xml.EndElement:
+			// Close up to the matching element. Unmatched end tags are
+			// ignored.
+			name := strings.ToLower(t.Name.Local)
+			for n := current; n != root; n = n.Parent {
+				if n.Name == name {
+					current = n.Parent
+					break
+				}
+			}
+		case xml.CharData:
+			current.Children = append(current.Children, &htmlNode{
+				Text:   string(t),
+				Parent: current,
+			})
+		}
+	}
+	return root
+}
+
+// find will return the first element with the specified name.
+func (n *htmlNode) find(name string) *htmlNode {
+	if n.Name == name {
+		return n
+	}
+	for _, child := range n.Children {
+		if found := child.find(name); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// findAll will return all of the elements with the specified name.
+func (n *htmlNode) findAll(name string, found []*htmlNode) []*htmlNode {
+	if n.Name == name {
+		found = append(found, n)
+	}
+	for _, child := range n.Children {
+		found = child.findAll(name, found)
+	}
+	return found
+}
+
+// text will return all of the text within the node.
+func (n *htmlNode) text() string {
+	var b strings.Builder
+	n.writeText(&b)
+	return b.String()
+}
+
+// writeText will write all of the text within the node to the builder.
+func (n *htmlNode) writeText(b *strings.Builder) {
+	if n.Name == "" {
+		b.WriteString(n.Text)
+		return
+	}
+	if n.Name == "br" {
+		b.WriteString(" ")
+	}
+	for _, child := range n.Children {
+		child.writeText(b)
+	}
+}
+
+// markup will return the text within the node with its emphasis kept as
+// markup.
+func (n *htmlNode) markup() string {
+	var b strings.Builder
+	n.writeMarkup(&b)
+	return b.String()
+}
+
+// writeMarkup will write the text within the node to the builder with its
+// emphasis kept as markup.
+func (n *htmlNode) writeMarkup(b *strings.Builder) {
+	if n.Name == "" {
+		b.WriteString(escapeMarkup(n.Text))
+		return
+	}
+	if n.Name == "br" {
+		b.WriteString(" ")
+	}
+	marker := htmlEmphasisMarker(n.Name)
+	b.WriteString(marker)
+	for _, child := range n.Children {
+		child.writeMarkup(b)
+	}
+	b.WriteString(marker)
+}
+
+// htmlEmphasisMarker will return the markup marker of an emphasis element, or
+// an empty string if the element is not emphasis.
+func htmlEmphasisMarker(name string) string {
+	switch name {
+	case "em", "i", "cite":
+		return "*"
+	case "strong", "b":
+		return "**"
+	}
+	return ""
+}
+
+// inside will check if the node is, or is within, an element with the
+// specified name.
+func (n *htmlNode) inside(name string) bool {
+	for ; n != nil; n = n.Parent {
+		if n.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// linkDensity is the share of the text within the node that is link text.
+func (n *htmlNode) linkDensity() float64 {
+	length := len(collapseSpace(n.text()))
+	if length == 0 {
+		return 0
+	}
+	links := 0
+	for _, a := range n.findAll("a", nil) {
+		links += len(collapseSpace(a.text()))
+	}
+	return float64(links) / float64(length)
+}
+
+// clean will remove the elements that are not part of the content from the
+// tree.
+func (n *htmlNode) clean() {
+	children := n.Children[:0]
+	for _, child := range n.Children {
+		if child.Name != "" {
+			if htmlDroppedElements[child.Name] {
+				continue
+			}
+			// Drop elements that look like page furniture, unless they
+			// also look like the content.
+			hint := child.Attrs["class"] + " " + child.Attrs["id"] + " " + child.Attrs["role"]
+			if child.Name != "body" && child.Name != "html" && child.Name != "article" && child.Name != "main" &&
+				htmlUnlikely.MatchString(hint) && !htmlLikely.MatchString(hint) {
+				continue
+			}
+			child.clean()
+		}
+		children = append(children, child)
+	}
+	n.Children = children
+}
+
+// ExtractHTMLArticle will find the main content of the web page and return it
+// as blocks of text. The title of the page is added as a heading if the
+// content does not start with one.
+func ExtractHTMLArticle(html string) []Block {
+	root := parseHTML(html)
+
+	// Find the title of the page before the head is cleaned away.
+	title := ""
+	if node := root.find("title"); node != nil {
+		title = collapseSpace(node.text())
+	}
+
+	// Remove everything that is not part of the content.
+	if head := root.find("head"); head != nil && head.Parent != nil {
+		head.Children = nil
+	}
+	root.clean()
+
+	// Extract the blocks from the element that is most likely to be the
+	// content of the page.
+	blocks := []Block{}
+	extractHTMLBlocks(findHTMLContent(root), &blocks)
+
+	// Start with the title of the page.
+	if title != "" && (len(blocks) == 0 || blocks[0].HeadingLevel == 0) {
+		blocks = append([]Block{{Text: title, HeadingLevel: 1}}, blocks...)
+	}
+
+	// The shallowest heading is the chapter level.
+	minLevel := 0
+	for _, block := range blocks {
+		if block.HeadingLevel > 0 && (minLevel == 0 || block.HeadingLevel < minLevel) {
+			minLevel = block.HeadingLevel
+		}
+	}
+	for i := range blocks {
+		if blocks[i].HeadingLevel > 0 {
+			blocks[i].HeadingLevel -= minLevel - 1
+		}
+	}
+
+	return blocks
+}
+
+// findHTMLContent will find the element that contains the main content of the
+// page. Every paragraph adds to the score of its parent, and half as much to
+// its grandparent. The element with the highest score, reduced by the share
+// of its text that is links, is the content.
+func findHTMLContent(root *htmlNode) *htmlNode {
+	scores := map[*htmlNode]float64{}
+	for _, name := range []string{"p", "pre", "blockquote", "li", "td"} {
+		for _, p := range root.findAll(name, nil) {
+			text := collapseSpace(p.text())
+			if len(text) < 25 {
+				continue
+			}
+			score := 1 + float64(strings.Count(text, ",")) + minFloat(float64(len(text))/100, 3)
+			if p.Parent != nil {
+				scores[p.Parent] += score
+				if p.Parent.Parent != nil {
+					scores[p.Parent.Parent] += score / 2
+				}
+			}
+		}
+	}
+
+	var best *htmlNode
+	bestScore := 0.0
+	for node, score := range scores {
+		hint := node.Attrs["class"] + " " + node.Attrs["id"]
+		if node.Name == "article" || node.Name == "main" || htmlLikely.MatchString(hint) {
+			score *= 1.25
+		}
+		score *= 1 - node.linkDensity()
+		if best == nil || score > bestScore {
+			best = node
+			bestScore = score
+		}
+	}
+	if best == nil {
+		if body := root.find("body"); body != nil {
+			return body
+		}
+		return root
+	}
+	return best
+}
+
+// minFloat will return the smaller of two numbers.
+func minFloat(a, b float64) float64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// htmlTextBlocks are the elements that are read as a block of their own.
+var htmlTextBlocks = map[string]bool{
+	"p": true, "li": true, "blockquote": true, "pre": true, "dd": true,
+	"dt": true, "td": true, "th": true, "figcaption": true, "caption": true,
+}
+
+// extractHTMLBlocks will add the blocks of text within the node to the list of
+// blocks. Text that is not inside of a block element, such as the text
+// directly within a div, is added as a block of its own.
+func extractHTMLBlocks(n *htmlNode, blocks *[]Block) {
+	var loose, looseMarkup strings.Builder
+	quote := n.inside("blockquote")
+	flush := func() {
+		if text := collapseSpace(loose.String()); text != "" {
+			*blocks = append(*blocks, Block{Text: text, Quote: quote, Markup: looseMarkup.String()})
+		}
+		loose.Reset()
+		looseMarkup.Reset()
+	}
+
+	for _, child := range n.Children {
+		switch {
+		case child.Name == "":
+			loose.WriteString(child.Text)
+			looseMarkup.WriteString(escapeMarkup(child.Text))
+		case headingLevel(child.Name) > 0:
+			flush()
+			*blocks = append(*blocks, Block{Text: child.text(), HeadingLevel: headingLevel(child.Name), Markup: child.markup()})
+		case htmlTextBlocks[child.Name] && !containsHTMLBlocks(child):
+			flush()
+			*blocks = append(*blocks, Block{Text: child.text(), Quote: child.inside("blockquote"), Markup: child.markup()})
+		case child.Name == "a" || child.Name == "span" || child.Name == "em" || child.Name == "strong" ||
+			child.Name == "i" || child.Name == "b" || child.Name == "code" || child.Name == "br" ||
+			child.Name == "sup" || child.Name == "sub" || child.Name == "small" || child.Name == "abbr":
+			child.writeText(&loose)
+			child.writeMarkup(&looseMarkup)
+		default:
+			flush()
+			extractHTMLBlocks(child, blocks)
+		}
+	}
+	flush()
+}
+
+// containsHTMLBlocks will check if there are block elements within the node,
+// e.g. a list item that contains paragraphs.
+func containsHTMLBlocks(n *htmlNode) bool {
+	for _, child := range n.Children {
+		if child.Name == "" {
+			continue
+		}
+		if htmlTextBlocks[child.Name] || headingLevel(child.Name) > 0 || child.Name == "div" ||
+			child.Name == "ul" || child.Name == "ol" || containsHTMLBlocks(child) {
+			return true
+		}
+	}
+	return false
+}
+
+// htmlTitle will return the title of the web page.
+func htmlTitle(r io.Reader) string {
+	data, err := ioutil.ReadAll(io.LimitReader(r, 1<<20))
+	if err != nil {
+		return ""
+	}
+	if node := parseHTML(string(data)).find("title"); node != nil {
+		return collapseSpace(node.text())
+	}
+	return ""
+}
@@ -1,13 +1,18 @@
 package ttsweb
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"log/slog"
 	"net/http"
+	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // ServeHTTP will handle the HTTP requests for the documents.
@@ -15,7 +20,8 @@ import (
 // The following HTTP requests are supported:
 //
 // - POST /documents
-//   - Uploads a document to the server.
+//   - Uploads a document to the server, or fetches the document from the
+//     URL in the url field.
 //
 // - GET /documents
 //   - Returns the documents that have been uploaded by the user.
@@ -26,18 +32,183 @@ import (
 // - GET /documents/{id}
 //   - Returns the document info with the specified ID.
 //
+// - GET /documents/{id}/stream?from={paragraph_id}&to={paragraph_id}&timestamp={ms}
+//   - Returns the audio of the paragraphs joined into one continuous audio
+//     file. Byte ranges of the stream are supported. An mp3 stream with a
+//     timestamp starts with the ID3 tag of an HLS segment.
+//
+// - GET /documents/{id}/stream/timeline?from={paragraph_id}&to={paragraph_id}
+//   - Returns the byte and time offsets of each paragraph in the stream.
+//
+// - GET /documents/{id}/playlist.m3u8?group={n}&format={format}
+//   - Returns an HLS playlist with the audio of the document. Each segment
+//     is the audio of n paragraphs, one by default.
+//
+// - GET /documents/{id}/toc
+//   - Returns the table of contents of the document.
+//
+// - GET /documents/{id}/toc/{entry_id}/paragraphs
+//   - Returns the paragraphs of the chapter or section with the specified
+//     entry ID.
+//
 // - GET /documents/{id}/paragraphs/{paragraph_id}
 //   - Returns the paragraph with the specified ID.
 //
 // - GET /documents/{id}/paragraphs/{paragraph_id}/audio
-//   - Returns the audio for the paragraph with the specified ID.
+//   - Returns the audio for the paragraph with the specified ID. The audio
+//     format is negotiated using the Accept header, or can be chosen with the
+//     format query parameter.
+//
+// - GET /documents/{id}/paragraphs/{paragraph_id}/spoken
+//   - Returns the text of the paragraph exactly as it will be spoken, with
+//     the voice that will speak it.
+//
+// - GET /documents/{id}/speech-rules
+//   - Returns the speech rules of the document.
+//
+// - PUT /documents/{id}/speech-rules
+//   - Replaces the speech rules of the document. The body is a JSON list of
+//     rules with a regular expression pattern and a replacement.
+//
+// - GET /documents/{id}/lexicons
+//   - Returns the names of the lexicons that are attached to the document.
+//
+// - PUT /documents/{id}/lexicons
+//   - Replaces the lexicons that are attached to the document. The body is a
+//     JSON list of lexicon names. The paragraphs that contain the words of
+//     the lexicons that were attached or removed are marked as stale.
+//
+// - GET /documents/{id}/skip-classes
+//   - Returns the classes of paragraphs that the document skips.
+//
+// - PUT /documents/{id}/skip-classes
+//   - Replaces the classes of paragraphs that the document skips. The body
+//     is a JSON list of classes, e.g. ["footnote", "reference"]. Paragraphs
+//     that are no longer skipped and have no audio are marked as stale.
+//
+// - POST /documents/{id}/resynthesize
+//   - Synthesizes the stale paragraphs of the document again in the
+//     background.
+//
+// - GET /documents/{id}/bookmarks
+//   - Returns the bookmarks of the document. The bookmarks are exported as
+//     markdown if the format parameter is markdown.
+//
+// - POST /documents/{id}/bookmarks
+//   - Adds a bookmark to a paragraph of the document. The body is a JSON
+//     object with the paragraph ID, an optional offset in seconds into the
+//     audio of the paragraph, a color and a note.
+//
+// - PUT /documents/{id}/bookmarks/{bookmark_id}
+//   - Changes the offset, color and note of the bookmark.
+//
+// - DELETE /documents/{id}/bookmarks/{bookmark_id}
+//   - Removes the bookmark.
+//
+// - GET /bookmarks
+//   - Returns the bookmarks of all of the documents. The bookmarks are
+//     exported as markdown if the format parameter is markdown.
+//
+// - DELETE /documents/{id}
+//   - Removes the document and all of its files. Documents that are being
+//     processed cannot be removed.
+//
+// - GET /jobs?document={id}
+//   - Returns the jobs that are running and that finished recently, or
+//     only the jobs of the document with the specified ID. Each job has the
+//     stage of the pipeline that it is at and the number of paragraphs of
+//     the stage that are done.
+//
+// - GET /jobs/{job_id}
+//   - Returns the job with the specified ID.
+//
+// - GET /documents/{id}/sessions
+//   - Returns the listening sessions of the document.
+//
+// - POST /documents/{id}/sessions
+//   - Records a listening session. The body is a JSON object with the start
+//     and end paragraph IDs, the start and end times and the number of
+//     seconds of audio that were listened to.
+//
+// - GET /documents/{id}/stats
+//   - Returns how much of the document was listened to and the estimated
+//     time remaining.
+//
+// - GET /stats?days={n}
+//   - Returns the time listened on each of the last n days, 30 by default,
+//     and the statistics of each of the documents.
+//
+// - GET /lexicons
+//   - Returns all of the pronunciation lexicons.
+//
+// - GET /lexicons/{name}
+//   - Returns the lexicon with the specified name.
+//
+// - PUT /lexicons/{name}
+//   - Creates or replaces the lexicon. The paragraphs of the documents that
+//     use the lexicon and contain the words that changed are marked as stale.
+//
+// - GET /search?q={query}&document={id}&limit={n}
+//   - Searches the paragraphs of all of the documents, or of the document
+//     with the specified ID. Words in double quotes are matched as a phrase.
+//     Each result has a snippet of the paragraph with the matches marked and
+//     a link that opens the reader at the paragraph.
+//
+// - POST /search/rebuild
+//   - Rebuilds the search index from the paragraph files of the documents.
+//
+// - GET /webhooks
+//   - Returns the webhooks, without their secrets, and the events that they
+//     can be sent for.
+//
+// - GET /webhooks/deliveries?document={id}
+//   - Returns the log of the attempts to send events to the webhooks, most
+//     recent first, optionally only for the document with the specified ID.
+//
+// - GET /metrics
+//   - Returns the metrics of the server in the Prometheus text format.
+//
+// - GET /healthz
+//   - Returns 200 while the server is running.
+//
+// - POST /healthz/check
+//   - Checks the splitters, synthesizers and transcoder again, and returns
+//     the status of the server in the same way as GET /readyz.
+//
+// - GET /readyz
+//   - Returns the status of the server and the results of the self-check of
+//     the splitters, synthesizers and transcoder. The status is 503 until
+//     the self-check has finished, or if the default voice cannot
+//     synthesize, and 200 otherwise, even if other backends are degraded.
 func (d *DocumentsInfo) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
-	case http.MethodGet:
-		fallthrough
-	case http.MethodPost:
+	case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete:
 		if strings.HasPrefix(r.URL.Path, "/documents") {
 			d.httpDocumentsRouter(w, r)
+		} else if strings.HasPrefix(r.URL.Path, "/bookmarks") {
+			d.httpBookmarksRouter(w, r)
+		} else if strings.HasPrefix(r.URL.Path, "/stats") {
+			d.httpStatsRouter(w, r)
+		} else if strings.HasPrefix(r.URL.Path, "/jobs") {
+			d.httpJobsRouter(w, r)
+		} else if strings.HasPrefix(r.URL.Path, "/lexicons") {
+			d.httpLexiconsRouter(w, r)
+		} else if strings.HasPrefix(r.URL.Path, "/search") {
+			d.httpSearchRouter(w, r)
+		} else if strings.HasPrefix(r.URL.Path, "/webhooks") {
+			d.httpWebhooksRouter(w, r)
+		} else if r.URL.Path == "/metrics" && r.Method == http.MethodGet {
+			logRoute(r, "httpGetMetrics")
+			d.httpGetMetrics(w, r)
+		} else if r.URL.Path == "/healthz" && r.Method == http.MethodGet {
+			logRoute(r, "httpGetHealthz")
+			d.httpGetHealthz(w, r)
+		} else if r.URL.Path == "/healthz/check" && r.Method == http.MethodPost {
+			logRoute(r, "httpPostHealthCheck")
+			d.httpPostHealthCheck(w, r)
+		} else if r.URL.Path == "/readyz" && r.Method == http.MethodGet {
+			logRoute(r, "httpGetReadyz")
+			d.httpGetReadyz(w, r)
 		}
 	default:
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -51,32 +222,200 @@ func (d *DocumentsInfo) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 // httpDocumentsRouter is the top level router for the documents endpoints.
 // This will parse out the request and call the appropriate handler.
 func (d *DocumentsInfo) httpDocumentsRouter(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("httpDocumentsRouter")
+
+	// Split the path and determine which handler to call.
+	path := strings.Split(r.URL.Path, "/")
+
+	// Check if we are changing the speech rules of a document.
+	// /documents/{id}/speech-rules
+	if len(path) == 4 && path[1] == "documents" && path[3] == "speech-rules" {
+		switch r.Method {
+		case http.MethodGet:
+			logRoute(r, "httpGetSpeechRules")
+			d.httpGetSpeechRules(w, r)
+		case http.MethodPut:
+			logRoute(r, "httpPutSpeechRules")
+			d.httpPutSpeechRules(w, r)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	// Check if we are changing the lexicons of a document.
+	// /documents/{id}/lexicons
+	if len(path) == 4 && path[1] == "documents" && path[3] == "lexicons" {
+		switch r.Method {
+		case http.MethodGet:
+			logRoute(r, "httpGetDocumentLexicons")
+			d.httpGetDocumentLexicons(w, r)
+		case http.MethodPut:
+			logRoute(r, "httpPutDocumentLexicons")
+			d.httpPutDocumentLexicons(w, r)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	// Check if we are changing the classes of paragraphs that a document
+	// skips.
+	// /documents/{id}/skip-classes
+	if len(path) == 4 && path[1] == "documents" && path[3] == "skip-classes" {
+		switch r.Method {
+		case http.MethodGet:
+			logRoute(r, "httpGetSkipClasses")
+			d.httpGetSkipClasses(w, r)
+		case http.MethodPut:
+			logRoute(r, "httpPutSkipClasses")
+			d.httpPutSkipClasses(w, r)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	// Check if we are changing the bookmarks of a document.
+	// /documents/{id}/bookmarks
+	if len(path) == 4 && path[1] == "documents" && path[3] == "bookmarks" {
+		switch r.Method {
+		case http.MethodGet:
+			logRoute(r, "httpGetDocumentBookmarks")
+			d.httpGetDocumentBookmarks(w, r)
+		case http.MethodPost:
+			logRoute(r, "httpPostBookmark")
+			d.httpPostBookmark(w, r)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	// Check if we are changing a bookmark.
+	// /documents/{id}/bookmarks/{bookmark_id}
+	if len(path) == 5 && path[1] == "documents" && path[3] == "bookmarks" {
+		switch r.Method {
+		case http.MethodPut:
+			logRoute(r, "httpPutBookmark")
+			d.httpPutBookmark(w, r)
+		case http.MethodDelete:
+			logRoute(r, "httpDeleteBookmark")
+			d.httpDeleteBookmark(w, r)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+	// Check if we are recording the listening sessions of a document.
+	// /documents/{id}/sessions
+	if len(path) == 4 && path[1] == "documents" && path[3] == "sessions" {
+		switch r.Method {
+		case http.MethodGet:
+			logRoute(r, "httpGetSessions")
+			d.httpGetSessions(w, r)
+		case http.MethodPost:
+			logRoute(r, "httpPostSession")
+			d.httpPostSession(w, r)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+	// Check if we are deleting a document.
+	// /documents/{id}
+	if r.Method == http.MethodDelete && len(path) == 3 && path[1] == "documents" && path[2] != "" {
+		logRoute(r, "httpDeleteDocument")
+		d.httpDeleteDocument(w, r)
+		return
+	}
+	if r.Method == http.MethodPut || r.Method == http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Check if we are synthesizing the stale paragraphs of a document.
+	// /documents/{id}/resynthesize
+	if r.Method == http.MethodPost && len(path) == 4 && path[3] == "resynthesize" {
+		logRoute(r, "httpPostResynthesize")
+		d.httpPostResynthesize(w, r)
+		return
+	}
 
 	// Check if we are uploading a document.
+	// /documents
 	if r.Method == http.MethodPost {
-		fmt.Println("\t|-httpPostDocuments")
+		if len(path) != 2 && !(len(path) == 3 && path[2] == "") {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		logRoute(r, "httpPostDocuments")
 		d.httpPostDocuments(w, r)
 		return
 	}
 
-	// Split the path and determine which handler to call.
-	path := strings.Split(r.URL.Path, "/")
 	if len(path) >= 2 && path[1] == "documents" {
 
 		// Check if we are accessing a paragraph. We will hand
 		// off to the paragraphs router here.
 		// /documents/{id}/paragraphs/*
 		if len(path) >= 4 && path[3] == "paragraphs" {
-			fmt.Println("\t|-httpParagraphsRouter")
+			logRoute(r, "httpParagraphsRouter")
 			d.httpParagraphsRouter(w, r)
 			return
 		}
 
+		// Check if we are streaming the audio of the document.
+		// /documents/{id}/stream
+		if len(path) == 4 && path[3] == "stream" {
+			logRoute(r, "httpGetDocumentStream")
+			d.httpGetDocumentStream(w, r)
+			return
+		}
+
+		// Check if we are getting the timeline of the stream.
+		// /documents/{id}/stream/timeline
+		if len(path) == 5 && path[3] == "stream" && path[4] == "timeline" {
+			logRoute(r, "httpGetDocumentStreamTimeline")
+			d.httpGetDocumentStreamTimeline(w, r)
+			return
+		}
+
+		// Check if we are getting the HLS playlist of the document.
+		// /documents/{id}/playlist.m3u8
+		if len(path) == 4 && path[3] == "playlist.m3u8" {
+			logRoute(r, "httpGetDocumentPlaylist")
+			d.httpGetDocumentPlaylist(w, r)
+			return
+		}
+
+		// Check if we are getting the listening statistics of the document.
+		// /documents/{id}/stats
+		if len(path) == 4 && path[3] == "stats" {
+			logRoute(r, "httpGetDocumentStats")
+			d.httpGetDocumentStats(w, r)
+			return
+		}
+
+		// Check if we are getting the table of contents.
+		// /documents/{id}/toc
+		if len(path) == 4 && path[3] == "toc" {
+			logRoute(r, "httpGetDocumentTOC")
+			d.httpGetDocumentTOC(w, r)
+			return
+		}
+
+		// Check if we are getting the paragraphs of a chapter.
+		// /documents/{id}/toc/{entry_id}/paragraphs
+		if len(path) == 6 && path[3] == "toc" && path[5] == "paragraphs" {
+			logRoute(r, "httpGetTOCEntryParagraphs")
+			d.httpGetTOCEntryParagraphs(w, r)
+			return
+		}
+
 		// Check if we are getting the list of documents.
 		// /documents
 		if len(path) == 2 {
-			fmt.Println("\t|-httpGetDocuments")
+			logRoute(r, "httpGetDocuments")
 			d.httpGetDocuments(w, r)
 			return
 		}
@@ -84,7 +423,7 @@ func (d *DocumentsInfo) httpDocumentsRouter(w http.ResponseWriter, r *http.Reque
 		// Check if we are getting a specific document.
 		// /documents/{id}
 		if len(path) == 3 && path[2] != "" {
-			fmt.Println("\t|-httpGetDocument")
+			logRoute(r, "httpGetDocument")
 			d.httpGetDocument(w, r)
 			return
 		}
@@ -97,10 +436,12 @@ func (d *DocumentsInfo) httpDocumentsRouter(w http.ResponseWriter, r *http.Reque
 // httpGetDocuments will return the documents that have been uploaded by the
 // user. Each document will be returned as a JSON object and will contain a link
 // to the document for more specific information.
-func (d DocumentsInfo) httpGetDocuments(w http.ResponseWriter, r *http.Request) {
+func (d *DocumentsInfo) httpGetDocuments(w http.ResponseWriter, r *http.Request) {
 
 	// Marshal the documents.
+	d.mu.RLock()
 	data, err := json.Marshal(d)
+	d.mu.RUnlock()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -112,7 +453,7 @@ func (d DocumentsInfo) httpGetDocuments(w http.ResponseWriter, r *http.Request)
 }
 
 // httpGetDocument will return the document info with the specified ID.
-func (d DocumentsInfo) httpGetDocument(w http.ResponseWriter, r *http.Request) {
+func (d *DocumentsInfo) httpGetDocument(w http.ResponseWriter, r *http.Request) {
 	// Get the ID from the URL.
 	id := strings.TrimPrefix(r.URL.Path, "/documents/")
 	if id == "" {
@@ -121,16 +462,8 @@ func (d DocumentsInfo) httpGetDocument(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Find the document.
-	var document DocumentInfo
-	for _, d := range d.Documents {
-		if d.ID == id {
-			document = d
-			break
-		}
-	}
-
-	// Check if the document was found.
-	if document.ID == "" {
+	document, ok := d.Document(id)
+	if !ok {
 		http.Error(w, "document not found", http.StatusNotFound)
 		return
 	}
@@ -142,6 +475,7 @@ func (d DocumentsInfo) httpGetDocument(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	document.MarkSkippedParagraphs(paragraphs)
 	document.Paragraphs = paragraphs
 
 	// Marshal the document.
@@ -156,37 +490,351 @@ func (d DocumentsInfo) httpGetDocument(w http.ResponseWriter, r *http.Request) {
 	w.Write(data)
 }
 
+// httpDeleteDocument will remove the document and all of its files.
+func (d *DocumentsInfo) httpDeleteDocument(w http.ResponseWriter, r *http.Request) {
+	path := strings.Split(r.URL.Path, "/")
+	document, err := d.DeleteDocument(r.Context(), path[2])
+	switch {
+	case errors.Is(err, ErrDocumentNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, ErrDocumentBusy):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Marshal the document that was removed.
+	data, err := json.Marshal(document)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Write the document.
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// -----------------------------------------------------------------------------
+// Table of Contents Handlers
+// -----------------------------------------------------------------------------
+
+// httpGetDocumentTOC will return the table of contents of the document.
+func (d *DocumentsInfo) httpGetDocumentTOC(w http.ResponseWriter, r *http.Request) {
+	// Get the document ID.
+	path := strings.Split(r.URL.Path, "/")
+	documentID := path[2]
+	if _, ok := d.Document(documentID); !ok {
+		http.Error(w, "document not found", http.StatusNotFound)
+		return
+	}
+
+	// Load the table of contents.
+	toc, err := LoadTableOfContents(d.documentsDir, documentID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Marshal the table of contents.
+	data, err := json.Marshal(toc)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Write the table of contents.
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// httpGetTOCEntryParagraphs will return the paragraphs of a chapter or section
+// of the document. This is the same as a paragraph batch request for the
+// range of paragraphs in the entry.
+func (d *DocumentsInfo) httpGetTOCEntryParagraphs(w http.ResponseWriter, r *http.Request) {
+	// Get the document ID and entry ID.
+	path := strings.Split(r.URL.Path, "/")
+	documentID := path[2]
+	entryID := path[4]
+
+	// Find the entry in the table of contents.
+	toc, err := LoadTableOfContents(d.documentsDir, documentID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	entry, ok := toc.Entry(entryID)
+	if !ok {
+		http.Error(w, "table of contents entry not found", http.StatusNotFound)
+		return
+	}
+
+	// Load the paragraphs in the range of the entry.
+	paragraphIDs, err := parseParagraphIDBatch(entry.StartParagraphID + "-" + entry.EndParagraphID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	paragraphs, err := LoadParagraphBatch(d.documentsDir, documentID, paragraphIDs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Marshal the paragraphs.
+	data, err := json.Marshal(paragraphs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Write the paragraphs.
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// -----------------------------------------------------------------------------
+// Stream Handlers
+// -----------------------------------------------------------------------------
+
+// buildRequestStream will build the audio stream for the document in the
+// request. The range of paragraphs is read from the from and to query
+// parameters and the audio format is negotiated with the Accept header. If
+// the stream cannot be built, an error is written to the response and nil is
+// returned.
+func (d *DocumentsInfo) buildRequestStream(w http.ResponseWriter, r *http.Request) *AudioStream {
+	// Get the document ID.
+	path := strings.Split(r.URL.Path, "/")
+	documentID := path[2]
+
+	// Load the paragraphs for the document.
+	paragraphs, err := LoadParagraphInfos(d.documentsDir, documentID)
+	if err != nil {
+		http.Error(w, "document not found", http.StatusNotFound)
+		return nil
+	}
+	query := r.URL.Query()
+	paragraphIDs, err := paragraphRange(paragraphs, query.Get("from"), query.Get("to"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+	paragraphIDs = d.unskippedParagraphIDs(documentID, paragraphs, paragraphIDs)
+	if len(paragraphIDs) == 0 {
+		http.Error(w, "no paragraphs to stream", http.StatusNotFound)
+		return nil
+	}
+
+	// Find the formats that can be streamed. The first paragraph of the
+	// range is used to check which formats have been stored.
+	candidates := []AudioFormat{}
+	for _, format := range d.Pipeline().audioFormats() {
+		for _, streamFormat := range streamFormats {
+			if format.Name == streamFormat.Name {
+				candidates = append(candidates, format)
+			}
+		}
+	}
+	available := availableAudioFormats(d.documentsDir, documentID, paragraphIDs[0], candidates)
+	if len(available) == 0 {
+		available = candidates
+	}
+	available = selectAudioFormat(available, query.Get("format"))
+	format, err := negotiateAudioFormat(r.Header.Get("Accept"), available)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotAcceptable)
+		return nil
+	}
+
+	// Join the audio of the paragraphs.
+	stream, err := BuildAudioStream(d.documentsDir, documentID, paragraphIDs, format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return nil
+	}
+
+	// Stamp the stream with its start time when it is a playlist segment.
+	if query.Get("timestamp") != "" {
+		ms, err := strconv.ParseInt(query.Get("timestamp"), 10, 64)
+		if err != nil || ms < 0 {
+			http.Error(w, "invalid timestamp: "+query.Get("timestamp"), http.StatusBadRequest)
+			return nil
+		}
+		if err := stream.SetTimestamp(time.Duration(ms) * time.Millisecond); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return nil
+		}
+	}
+	return stream
+}
+
+// httpGetDocumentStream will return the audio of the paragraphs joined into a
+// single continuous audio file. Range requests are served by reading the
+// regions of the paragraph audio files that cover the requested bytes. The
+// response links to the timeline of the stream, and the response to a range
+// request tells which paragraph the range starts in and where that paragraph
+// starts and ends, so that the client can ask for the rest of it.
+func (d *DocumentsInfo) httpGetDocumentStream(w http.ResponseWriter, r *http.Request) {
+	stream := d.buildRequestStream(w, r)
+	if stream == nil {
+		return
+	}
+	reader := stream.Reader()
+	defer reader.Close()
+
+	// Advertise the paragraph boundaries. The range itself is served as it
+	// was asked for, as media players refuse a range that starts elsewhere.
+	timelineLink := strings.TrimSuffix(r.URL.Path, "/") + "/timeline"
+	if r.URL.RawQuery != "" {
+		timelineLink += "?" + r.URL.RawQuery
+	}
+	w.Header().Set("Link", "<"+timelineLink+`>; rel="describedby"; type="application/json"`)
+	if start, ok := rangeStart(r.Header.Get("Range"), stream.Size); ok {
+		if entry, ok := stream.ParagraphAt(start); ok {
+			w.Header().Set("X-Paragraph-ID", entry.ParagraphID)
+			w.Header().Set("X-Paragraph-Range", fmt.Sprintf("bytes %d-%d/%d", entry.ByteStart, entry.ByteEnd-1, stream.Size))
+		}
+	}
+
+	// Serve the stream. ServeContent handles the range requests.
+	w.Header().Set("Content-Type", stream.Format.ContentType)
+	w.Header().Add("Vary", "Accept")
+	http.ServeContent(w, r, "stream"+stream.Format.Extension, stream.ModTime, reader)
+}
+
+// httpGetDocumentStreamTimeline will return the timeline of the stream. This
+// maps the byte and time offsets of the stream to the paragraph IDs so that
+// the client can follow along with the paragraph being played.
+func (d *DocumentsInfo) httpGetDocumentStreamTimeline(w http.ResponseWriter, r *http.Request) {
+	stream := d.buildRequestStream(w, r)
+	if stream == nil {
+		return
+	}
+
+	// Link back to the stream with the same range of paragraphs.
+	documentID := strings.Split(r.URL.Path, "/")[2]
+	link := "/documents/" + documentID + "/stream"
+	if r.URL.RawQuery != "" {
+		link += "?" + r.URL.RawQuery
+	}
+	timeline := StreamTimeline{
+		DocumentID:  documentID,
+		Format:      stream.Format.Name,
+		ContentType: stream.Format.ContentType,
+		Size:        stream.Size,
+		DurationMs:  stream.Duration.Milliseconds(),
+		Link:        link,
+		Paragraphs:  stream.Timeline,
+	}
+
+	// Marshal the timeline.
+	data, err := json.Marshal(timeline)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Write the timeline.
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Add("Vary", "Accept")
+	w.Write(data)
+}
+
+// httpGetDocumentPlaylist will return an HLS playlist for the mp3 audio of the
+// document. The segments of the playlist link to the ranges of the document
+// stream that cover their paragraphs.
+func (d *DocumentsInfo) httpGetDocumentPlaylist(w http.ResponseWriter, r *http.Request) {
+	// Get the document ID.
+	path := strings.Split(r.URL.Path, "/")
+	documentID := path[2]
+
+	// Parse the number of paragraphs in each segment.
+	query := r.URL.Query()
+	group := 1
+	if query.Get("group") != "" {
+		var err error
+		group, err = strconv.Atoi(query.Get("group"))
+		if err != nil || group < 1 {
+			http.Error(w, "invalid group: "+query.Get("group"), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Load the paragraphs for the document.
+	paragraphs, err := LoadParagraphInfos(d.documentsDir, documentID)
+	if err != nil {
+		http.Error(w, "document not found", http.StatusNotFound)
+		return
+	}
+	paragraphIDs := d.unskippedParagraphIDs(documentID, paragraphs, nil)
+
+	// Load the table of contents so that the chapters can be split into
+	// separate parts of the playlist.
+	toc, err := LoadTableOfContents(d.documentsDir, documentID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Build the playlist from the first format that has audio.
+	formats := selectAudioFormat(playlistFormats, query.Get("format"))
+	for _, format := range formats {
+		stream, err := BuildAudioStream(d.documentsDir, documentID, paragraphIDs, format)
+		if err != nil {
+			continue
+		}
+		playlist := NewPlaylist(documentID, stream, group, toc.ChapterStarts())
+
+		// Write the playlist.
+		w.Header().Set("Content-Type", PlaylistContentType)
+		w.Write([]byte(playlist.String()))
+		return
+	}
+
+	http.Error(w, "no audio found for document "+documentID, http.StatusNotFound)
+}
+
 // -----------------------------------------------------------------------------
 // Paragraph Handlers
 // -----------------------------------------------------------------------------
 
 // httpParagraphsRouter is the top level router for the paragraphs endpoints.
 // This will parse out the request and call the appropriate handler.
-func (d DocumentsInfo) httpParagraphsRouter(w http.ResponseWriter, r *http.Request) {
+func (d *DocumentsInfo) httpParagraphsRouter(w http.ResponseWriter, r *http.Request) {
 	path := strings.Split(r.URL.Path, "/")
 	if len(path) == 5 && path[4] != "" {
 		if strings.Contains(path[4], ",") || strings.Contains(path[4], "-") {
 			// /documents/{id}/paragraphs/{paragraph_id1}-{paragraph_id2},{paragraph_id3}
-			fmt.Println("\t\t|-httpGetParagraphBatch")
+			logRoute(r, "httpGetParagraphBatch")
 			d.httpGetParagraphBatch(w, r)
 			return
 		}
 		// /documents/{id}/paragraphs/{paragraph_id}
-		fmt.Println("\t\t|-httpGetParagraph")
+		logRoute(r, "httpGetParagraph")
 		d.httpGetParagraph(w, r)
 		return
 	}
 
 	if len(path) == 6 && path[5] == "audio" {
 		// /documents/{id}/paragraphs/{paragraph_id}/audio
-		fmt.Println("\t\t|-httpGetParagraphAudio")
+		logRoute(r, "httpGetParagraphAudio")
 		d.httpGetParagraphAudio(w, r)
 		return
 	}
+
+	if len(path) == 6 && path[5] == "spoken" {
+		// /documents/{id}/paragraphs/{paragraph_id}/spoken
+		logRoute(r, "httpGetSpokenParagraph")
+		d.httpGetSpokenParagraph(w, r)
+		return
+	}
 }
 
 // httpGetParagraph will return the paragraph with the specified ID.
-func (d DocumentsInfo) httpGetParagraph(w http.ResponseWriter, r *http.Request) {
+func (d *DocumentsInfo) httpGetParagraph(w http.ResponseWriter, r *http.Request) {
 	// Get the document ID and paragraph ID.
 	path := strings.Split(r.URL.Path, "/")
 	documentID := path[2]
@@ -198,6 +846,9 @@ func (d DocumentsInfo) httpGetParagraph(w http.ResponseWriter, r *http.Request)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if document, ok := d.Document(documentID); ok {
+		paragraph.Skip = document.Skips(paragraph.Class)
+	}
 
 	// Marshal the paragraph.
 	data, err := json.Marshal(paragraph)
@@ -214,7 +865,7 @@ func (d DocumentsInfo) httpGetParagraph(w http.ResponseWriter, r *http.Request)
 // httpGetParagraphBatch will return the paragraphs with the specified IDs.
 // The IDs can be specified as a range (e.g. 1-5) or a list (e.g. 1,2,3,4,5).
 // A combination of both is also supported (e.g. 1-3,5,7-10).
-func (d DocumentsInfo) httpGetParagraphBatch(w http.ResponseWriter, r *http.Request) {
+func (d *DocumentsInfo) httpGetParagraphBatch(w http.ResponseWriter, r *http.Request) {
 	// Get the document ID and paragraph ID.
 	path := strings.Split(r.URL.Path, "/")
 	documentID := path[2]
@@ -233,6 +884,11 @@ func (d DocumentsInfo) httpGetParagraphBatch(w http.ResponseWriter, r *http.Requ
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if document, ok := d.Document(documentID); ok {
+		for i := range paragraphs {
+			paragraphs[i].Skip = document.Skips(paragraphs[i].Class)
+		}
+	}
 
 	// Marshal the paragraph.
 	data, err := json.Marshal(paragraphs)
@@ -284,63 +940,1312 @@ func parseParagraphIDBatch(s string) ([]string, error) {
 	return paragraphIDs, nil
 }
 
-// httpGetParagraphAudio will return the audio for the specified paragraph.
-func (d DocumentsInfo) httpGetParagraphAudio(w http.ResponseWriter, r *http.Request) {
+// httpGetParagraphAudio will return the audio for the specified paragraph. If
+// the audio has been transcoded, the format is chosen from the Accept header
+// of the request. The compressed formats are preferred when the client will
+// accept any audio.
+func (d *DocumentsInfo) httpGetParagraphAudio(w http.ResponseWriter, r *http.Request) {
 	// Get the document ID and paragraph ID.
 	path := strings.Split(r.URL.Path, "/")
 	documentID := path[2]
 	paragraphID := path[4]
 
+	// Pick the audio format using the Accept header. Only the formats that
+	// are stored on disk for the paragraph are considered.
+	formats := availableAudioFormats(d.documentsDir, documentID, paragraphID, d.Pipeline().audioFormats())
+	formats = selectAudioFormat(formats, r.URL.Query().Get("format"))
+	if len(formats) == 0 {
+		http.Error(w, "audio not found", http.StatusNotFound)
+		return
+	}
+	format, err := negotiateAudioFormat(r.Header.Get("Accept"), formats)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotAcceptable)
+		return
+	}
+
 	// Serve the audio file.
-	http.ServeFile(w, r, filepath.Join(d.documentsDir, documentID, "audio", paragraphID+".wav"))
+	w.Header().Set("Content-Type", format.ContentType)
+	w.Header().Add("Vary", "Accept")
+	http.ServeFile(w, r, paragraphAudioPath(d.documentsDir, documentID, paragraphID, format))
+}
+
+// httpGetSpokenParagraph will return the text of the paragraph as it will be
+// spoken, so that the speech rules can be checked without synthesizing the
+// paragraph again.
+func (d *DocumentsInfo) httpGetSpokenParagraph(w http.ResponseWriter, r *http.Request) {
+	// Get the document ID and paragraph ID.
+	path := strings.Split(r.URL.Path, "/")
+	documentID := path[2]
+	paragraphID := path[4]
+
+	// Find the document.
+	document, ok := d.Document(documentID)
+	if !ok {
+		http.Error(w, "document not found", http.StatusNotFound)
+		return
+	}
+
+	// Prepare the text of the paragraph.
+	spoken, err := d.Pipeline().SpokenParagraph(d.documentsDir, document, paragraphID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Marshal the spoken paragraph.
+	data, err := json.Marshal(spoken)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Write the spoken paragraph.
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
 }
 
 // -----------------------------------------------------------------------------
-// Upload Handlers
+// Speech Rule Handlers
 // -----------------------------------------------------------------------------
 
-// httpPostDocuments will upload a document to the server. The document will be
-// sent as a multipart form. The form will contain the following fields:
-//   - name: The name of the document as it was uploaded by the user.
-//   - file: The document file.
-func (d *DocumentsInfo) httpPostDocuments(w http.ResponseWriter, r *http.Request) {
-	// Parse the multipart form.
-	if err := r.ParseMultipartForm(32 << 20); err != nil {
+// httpGetSpeechRules will return the speech rules of the document.
+func (d *DocumentsInfo) httpGetSpeechRules(w http.ResponseWriter, r *http.Request) {
+	// Get the document ID.
+	path := strings.Split(r.URL.Path, "/")
+	documentID := path[2]
+	if _, ok := d.Document(documentID); !ok {
+		http.Error(w, "document not found", http.StatusNotFound)
+		return
+	}
+
+	// Load the speech rules.
+	rules, err := LoadSpeechRules(documentSpeechRulesPath(d.documentsDir, documentID))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Marshal the speech rules.
+	data, err := json.Marshal(rules)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Write the speech rules.
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// httpPutSpeechRules will replace the speech rules of the document. The rules
+// are used the next time that the document is synthesized.
+func (d *DocumentsInfo) httpPutSpeechRules(w http.ResponseWriter, r *http.Request) {
+	// Get the document ID.
+	path := strings.Split(r.URL.Path, "/")
+	documentID := path[2]
+	if _, ok := d.Document(documentID); !ok {
+		http.Error(w, "document not found", http.StatusNotFound)
+		return
+	}
+
+	// Parse the speech rules. Rules with invalid patterns are rejected.
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rules, err := ParseSpeechRules(body)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Get the file.
-	file, handler, err := r.FormFile("file")
+	// Save the speech rules.
+	data, err := json.Marshal(rules)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := ioutil.WriteFile(documentSpeechRulesPath(d.documentsDir, documentID), data, 0644); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Write the speech rules.
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// unskippedParagraphIDs will return the IDs of the paragraphs that the
+// document does not skip, out of the paragraphs with the specified IDs or all
+// of the paragraphs if no IDs are specified.
+func (d *DocumentsInfo) unskippedParagraphIDs(documentID string, paragraphs []ParagraphInfo, paragraphIDs []string) []string {
+	document, _ := d.Document(documentID)
+	unskipped := []string{}
+	for _, paragraph := range filterParagraphs(paragraphs, paragraphIDs) {
+		if !document.Skips(paragraph.Class) {
+			unskipped = append(unskipped, paragraph.ID)
+		}
+	}
+	return unskipped
+}
+
+// -----------------------------------------------------------------------------
+// Skip Class Handlers
+// -----------------------------------------------------------------------------
+
+// httpGetSkipClasses will return the classes of paragraphs that the document
+// skips.
+func (d *DocumentsInfo) httpGetSkipClasses(w http.ResponseWriter, r *http.Request) {
+	// Find the document.
+	path := strings.Split(r.URL.Path, "/")
+	document, ok := d.Document(path[2])
+	if !ok {
+		http.Error(w, "document not found", http.StatusNotFound)
+		return
+	}
+
+	// Marshal the classes.
+	classes := document.SkipClasses
+	if classes == nil {
+		classes = []string{}
+	}
+	data, err := json.Marshal(classes)
 	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Write the classes.
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// httpPutSkipClasses will replace the classes of paragraphs that the document
+// skips. Once the document has been synthesized, the paragraphs that are no
+// longer skipped and have no audio are marked as stale so that they are
+// synthesized by resynthesizing the document.
+func (d *DocumentsInfo) httpPutSkipClasses(w http.ResponseWriter, r *http.Request) {
+	// Find the document.
+	path := strings.Split(r.URL.Path, "/")
+	document, ok := d.Document(path[2])
+	if !ok {
+		http.Error(w, "document not found", http.StatusNotFound)
+		return
+	}
+
+	// Parse the classes. Each class must be known.
+	classes := []string{}
+	if err := json.NewDecoder(r.Body).Decode(&classes); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	defer file.Close()
+	for _, class := range classes {
+		if !containsString(ParagraphClasses, class) {
+			http.Error(w, "unknown paragraph class: "+class, http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Save the document and mark the paragraphs that need audio.
+	document.SkipClasses = classes
+	if err := document.WriteIndex(d.documentsDir); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	d.UpdateDocument(document)
 
-	// Get the name of the document. If no name is specified, use the name of the
-	// file without the extension.
-	name := r.FormValue("name")
-	if name == "" {
-		name = strings.TrimSuffix(handler.Filename, filepath.Ext(handler.Filename))
+	// Documents that are still being processed will synthesize the
+	// paragraphs anyway.
+	marked := 0
+	if document.Status == StatusSynthesized {
+		var err error
+		marked, err = document.MarkUnsynthesizedParagraphs(d.documentsDir, d.Pipeline().audioFormats())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 	}
 
-	// Read the file.
-	fileData, err := ioutil.ReadAll(file)
+	// Marshal the result.
+	data, err := json.Marshal(map[string]interface{}{
+		"skipClasses":     classes,
+		"staleParagraphs": marked,
+	})
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Create the document.
-	document, err := d.CreateDocument(name, handler.Filename, fileData)
+	// Write the result.
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// -----------------------------------------------------------------------------
+// Lexicon Handlers
+// -----------------------------------------------------------------------------
+
+// httpLexiconsRouter is the top level router for the lexicons endpoints.
+func (d *DocumentsInfo) httpLexiconsRouter(w http.ResponseWriter, r *http.Request) {
+
+	path := strings.Split(r.URL.Path, "/")
+	switch {
+	case len(path) == 2 && r.Method == http.MethodGet:
+		// /lexicons
+		logRoute(r, "httpGetLexicons")
+		d.httpGetLexicons(w, r)
+	case len(path) == 3 && path[2] != "" && r.Method == http.MethodGet:
+		// /lexicons/{name}
+		logRoute(r, "httpGetLexicon")
+		d.httpGetLexicon(w, r)
+	case len(path) == 3 && path[2] != "" && r.Method == http.MethodPut:
+		// /lexicons/{name}
+		logRoute(r, "httpPutLexicon")
+		d.httpPutLexicon(w, r)
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+// httpGetLexicons will return all of the lexicons.
+func (d *DocumentsInfo) httpGetLexicons(w http.ResponseWriter, r *http.Request) {
+	// Load the lexicons.
+	lexicons, err := LoadLexicons(d.Pipeline().LexiconsDir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Marshal the lexicons.
+	data, err := json.Marshal(lexicons)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Write the lexicons.
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// httpGetLexicon will return the lexicon with the specified name.
+func (d *DocumentsInfo) httpGetLexicon(w http.ResponseWriter, r *http.Request) {
+	// Get the name of the lexicon.
+	path := strings.Split(r.URL.Path, "/")
+	name := path[2]
+
+	// Load the lexicon.
+	lexicon, err := LoadLexicon(d.Pipeline().LexiconsDir, name)
+	if os.IsNotExist(err) {
+		http.Error(w, "lexicon not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Marshal the lexicon.
+	data, err := json.Marshal(lexicon)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Write the lexicon.
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// httpPutLexicon will create or replace the lexicon with the specified name.
+// The paragraphs of the documents that use the lexicon are marked as stale if
+// they contain a word whose pronunciation changed.
+func (d *DocumentsInfo) httpPutLexicon(w http.ResponseWriter, r *http.Request) {
+	// Get the name of the lexicon.
+	path := strings.Split(r.URL.Path, "/")
+	name := path[2]
+	lexiconsDir := d.Pipeline().LexiconsDir
+
+	// Parse the lexicon.
+	lexicon := Lexicon{}
+	if err := json.NewDecoder(r.Body).Decode(&lexicon); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	lexicon.Name = name
+
+	// Find the words that changed since the lexicon was last saved.
+	old, err := LoadLexicon(lexiconsDir, name)
+	if err != nil && !os.IsNotExist(err) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	changed := old.changedWords(lexicon)
+
+	// Save the lexicon.
+	if err := lexicon.Save(lexiconsDir); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Mark the paragraphs that use the changed words.
+	update := LexiconUpdate{Lexicon: lexicon, StaleParagraphs: map[string]int{}}
+	for _, document := range d.DocumentsWithLexicon(name) {
+		marked, err := document.MarkStaleParagraphs(d.documentsDir, changed)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if marked > 0 {
+			update.StaleParagraphs[document.ID] = marked
+		}
+	}
+
+	// Marshal the update.
+	data, err := json.Marshal(update)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Write the update.
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// httpGetDocumentLexicons will return the names of the lexicons that are
+// attached to the document.
+func (d *DocumentsInfo) httpGetDocumentLexicons(w http.ResponseWriter, r *http.Request) {
+	// Find the document.
+	path := strings.Split(r.URL.Path, "/")
+	document, ok := d.Document(path[2])
+	if !ok {
+		http.Error(w, "document not found", http.StatusNotFound)
+		return
+	}
+
+	// Marshal the lexicon names.
+	lexicons := document.Lexicons
+	if lexicons == nil {
+		lexicons = []string{}
+	}
+	data, err := json.Marshal(lexicons)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Add the document to the list.
-	d.Documents = append(d.Documents, document)
+	// Write the lexicon names.
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// httpPutDocumentLexicons will replace the lexicons that are attached to the
+// document. The paragraphs that contain the words of the lexicons that were
+// attached or removed are marked as stale.
+func (d *DocumentsInfo) httpPutDocumentLexicons(w http.ResponseWriter, r *http.Request) {
+	// Find the document.
+	path := strings.Split(r.URL.Path, "/")
+	document, ok := d.Document(path[2])
+	if !ok {
+		http.Error(w, "document not found", http.StatusNotFound)
+		return
+	}
+	lexiconsDir := d.Pipeline().LexiconsDir
+
+	// Parse the lexicon names. Each lexicon must exist.
+	names := []string{}
+	if err := json.NewDecoder(r.Body).Decode(&names); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, name := range names {
+		if _, err := LoadLexicon(lexiconsDir, name); err != nil {
+			http.Error(w, "lexicon not found: "+name, http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Find the words of the lexicons that were attached or removed.
+	changed := []string{}
+	for _, name := range append(append([]string{}, names...), document.Lexicons...) {
+		if containsString(names, name) && containsString(document.Lexicons, name) {
+			continue
+		}
+		if lexicon, err := LoadLexicon(lexiconsDir, name); err == nil {
+			changed = append(changed, lexicon.words()...)
+		}
+	}
+
+	// Save the document and mark the paragraphs that use the words.
+	document.Lexicons = names
+	if err := document.WriteIndex(d.documentsDir); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	d.UpdateDocument(document)
+	marked, err := document.MarkStaleParagraphs(d.documentsDir, changed)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Marshal the result.
+	data, err := json.Marshal(map[string]interface{}{
+		"lexicons":        names,
+		"staleParagraphs": marked,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Write the result.
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// httpPostResynthesize will synthesize the stale paragraphs of the document
+// again in the background. The IDs of the stale paragraphs are returned.
+func (d *DocumentsInfo) httpPostResynthesize(w http.ResponseWriter, r *http.Request) {
+	// Find the document.
+	path := strings.Split(r.URL.Path, "/")
+	document, ok := d.Document(path[2])
+	if !ok {
+		http.Error(w, "document not found", http.StatusNotFound)
+		return
+	}
+
+	// Find the stale paragraphs.
+	paragraphIDs, err := document.StaleParagraphIDs(d.documentsDir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Synthesize the paragraphs in the background.
+	if len(paragraphIDs) > 0 {
+		pipeline := d.Pipeline()
+		err := d.background(r.Context(), func(ctx context.Context) {
+			if _, err := pipeline.Resynthesize(ctx, d.documentsDir, &document); err != nil {
+				slog.ErrorContext(ctx, "Unable to resynthesize document", "document_id", document.ID, "error", err)
+				return
+			}
+			d.UpdateDocument(document)
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	// Marshal the paragraph IDs.
+	data, err := json.Marshal(map[string]interface{}{
+		"paragraphs": paragraphIDs,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Write the paragraph IDs.
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(data)
+}
+
+// -----------------------------------------------------------------------------
+// Bookmark Handlers
+// -----------------------------------------------------------------------------
+
+// bookmarksResponse is the list of bookmarks of one or all of the documents.
+type bookmarksResponse struct {
+	Bookmarks []Bookmark `json:"bookmarks"`
+	Colors    []string   `json:"colors"`
+}
+
+// httpBookmarksRouter is the router for the bookmarks of all of the
+// documents.
+func (d *DocumentsInfo) httpBookmarksRouter(w http.ResponseWriter, r *http.Request) {
+
+	path := strings.Split(r.URL.Path, "/")
+	switch {
+	case len(path) == 2 && r.Method == http.MethodGet:
+		// /bookmarks
+		logRoute(r, "httpGetBookmarks")
+		d.httpGetBookmarks(w, r)
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+// httpGetBookmarks will return the bookmarks of all of the documents, or
+// export them as markdown.
+func (d *DocumentsInfo) httpGetBookmarks(w http.ResponseWriter, r *http.Request) {
+	d.mu.RLock()
+	documents := append([]DocumentInfo{}, d.Documents...)
+	d.mu.RUnlock()
+
+	// Load the bookmarks of each of the documents.
+	bookmarks := map[string][]Bookmark{}
+	all := []Bookmark{}
+	for _, document := range documents {
+		documentBookmarks, err := LoadBookmarks(d.documentsDir, document.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		bookmarks[document.ID] = documentBookmarks
+		all = append(all, documentBookmarks...)
+	}
+	d.writeBookmarks(w, r, documents, bookmarks, all)
+}
+
+// httpGetDocumentBookmarks will return the bookmarks of the document, or
+// export them as markdown.
+func (d *DocumentsInfo) httpGetDocumentBookmarks(w http.ResponseWriter, r *http.Request) {
+	// Get the document ID.
+	path := strings.Split(r.URL.Path, "/")
+	document, ok := d.Document(path[2])
+	if !ok {
+		http.Error(w, "document not found", http.StatusNotFound)
+		return
+	}
+
+	// Load the bookmarks.
+	bookmarks, err := LoadBookmarks(d.documentsDir, document.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	d.writeBookmarks(w, r, []DocumentInfo{document}, map[string][]Bookmark{document.ID: bookmarks}, bookmarks)
+}
+
+// writeBookmarks will write the bookmarks as JSON, or as markdown if the
+// format parameter of the request is markdown.
+func (d *DocumentsInfo) writeBookmarks(w http.ResponseWriter, r *http.Request, documents []DocumentInfo, bookmarks map[string][]Bookmark, all []Bookmark) {
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+	case "markdown":
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		w.Header().Set("Content-Disposition", "attachment; filename=bookmarks.md")
+		w.Write([]byte(BookmarksMarkdown(d.documentsDir, documents, bookmarks)))
+		return
+	default:
+		http.Error(w, "invalid format: "+r.URL.Query().Get("format"), http.StatusBadRequest)
+		return
+	}
+
+	// Marshal the bookmarks.
+	data, err := json.Marshal(bookmarksResponse{Bookmarks: all, Colors: BookmarkColors})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Write the bookmarks.
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// httpPostBookmark will add a bookmark to a paragraph of the document.
+func (d *DocumentsInfo) httpPostBookmark(w http.ResponseWriter, r *http.Request) {
+	// Get the document ID.
+	path := strings.Split(r.URL.Path, "/")
+	documentID := path[2]
+	if _, ok := d.Document(documentID); !ok {
+		http.Error(w, "document not found", http.StatusNotFound)
+		return
+	}
+
+	// Parse the bookmark.
+	bookmark := Bookmark{}
+	if err := json.NewDecoder(r.Body).Decode(&bookmark); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	bookmark.ID = generateID()
+	bookmark.DocumentID = documentID
+	bookmark.Created = time.Now().UTC()
+	bookmark.Updated = bookmark.Created
+	if err := bookmark.Validate(d.documentsDir); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Add the bookmark to the bookmarks of the document.
+	d.bookmarksMu.Lock()
+	bookmarks, err := LoadBookmarks(d.documentsDir, documentID)
+	if err == nil {
+		err = SaveBookmarks(d.documentsDir, documentID, append(bookmarks, bookmark))
+	}
+	d.bookmarksMu.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeBookmark(w, bookmark)
+}
+
+// httpPutBookmark will change the offset, color and note of a bookmark. The
+// paragraph of a bookmark cannot be changed.
+func (d *DocumentsInfo) httpPutBookmark(w http.ResponseWriter, r *http.Request) {
+	// Get the document and bookmark IDs.
+	path := strings.Split(r.URL.Path, "/")
+	documentID, bookmarkID := path[2], path[4]
+	if _, ok := d.Document(documentID); !ok {
+		http.Error(w, "document not found", http.StatusNotFound)
+		return
+	}
+
+	// Parse the changes.
+	changes := Bookmark{}
+	if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	d.bookmarksMu.Lock()
+	defer d.bookmarksMu.Unlock()
+	bookmarks, err := LoadBookmarks(d.documentsDir, documentID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for i := range bookmarks {
+		if bookmarks[i].ID != bookmarkID {
+			continue
+		}
+
+		// Update the bookmark.
+		bookmark := bookmarks[i]
+		bookmark.Offset = changes.Offset
+		bookmark.Color = changes.Color
+		bookmark.Note = changes.Note
+		bookmark.Updated = time.Now().UTC()
+		if err := bookmark.Validate(d.documentsDir); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		bookmarks[i] = bookmark
+		if err := SaveBookmarks(d.documentsDir, documentID, bookmarks); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeBookmark(w, bookmark)
+		return
+	}
+	http.Error(w, "bookmark not found", http.StatusNotFound)
+}
+
+// httpDeleteBookmark will remove a bookmark from the document.
+func (d *DocumentsInfo) httpDeleteBookmark(w http.ResponseWriter, r *http.Request) {
+	// Get the document and bookmark IDs.
+	path := strings.Split(r.URL.Path, "/")
+	documentID, bookmarkID := path[2], path[4]
+	if _, ok := d.Document(documentID); !ok {
+		http.Error(w, "document not found", http.StatusNotFound)
+		return
+	}
+
+	d.bookmarksMu.Lock()
+	defer d.bookmarksMu.Unlock()
+	bookmarks, err := LoadBookmarks(d.documentsDir, documentID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for i := range bookmarks {
+		if bookmarks[i].ID != bookmarkID {
+			continue
+		}
+		bookmark := bookmarks[i]
+		if err := SaveBookmarks(d.documentsDir, documentID, append(bookmarks[:i], bookmarks[i+1:]...)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeBookmark(w, bookmark)
+		return
+	}
+	http.Error(w, "bookmark not found", http.StatusNotFound)
+}
+
+// writeBookmark will write the bookmark as JSON.
+func writeBookmark(w http.ResponseWriter, bookmark Bookmark) {
+	// Marshal the bookmark.
+	data, err := json.Marshal(bookmark)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Write the bookmark.
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// -----------------------------------------------------------------------------
+// Listening Session Handlers
+// -----------------------------------------------------------------------------
+
+// sessionsResponse is the list of listening sessions of a document.
+type sessionsResponse struct {
+	Sessions []ListeningSession `json:"sessions"`
+}
+
+// httpStatsRouter is the router for the statistics of all of the documents.
+func (d *DocumentsInfo) httpStatsRouter(w http.ResponseWriter, r *http.Request) {
+
+	path := strings.Split(r.URL.Path, "/")
+	switch {
+	case len(path) == 2 && r.Method == http.MethodGet:
+		// /stats
+		logRoute(r, "httpGetStats")
+		d.httpGetStats(w, r)
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+// httpGetSessions will return the listening sessions of the document.
+func (d *DocumentsInfo) httpGetSessions(w http.ResponseWriter, r *http.Request) {
+	// Get the document ID.
+	path := strings.Split(r.URL.Path, "/")
+	documentID := path[2]
+	if _, ok := d.Document(documentID); !ok {
+		http.Error(w, "document not found", http.StatusNotFound)
+		return
+	}
+
+	// Load the sessions.
+	sessions, err := LoadListeningSessions(d.documentsDir, documentID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Marshal the sessions.
+	data, err := json.Marshal(sessionsResponse{Sessions: sessions})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Write the sessions.
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// httpPostSession will record a listening session of the document.
+func (d *DocumentsInfo) httpPostSession(w http.ResponseWriter, r *http.Request) {
+	// Get the document ID.
+	path := strings.Split(r.URL.Path, "/")
+	documentID := path[2]
+	if _, ok := d.Document(documentID); !ok {
+		http.Error(w, "document not found", http.StatusNotFound)
+		return
+	}
+
+	// Parse the session.
+	session := ListeningSession{}
+	if err := json.NewDecoder(r.Body).Decode(&session); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	session.ID = generateID()
+	session.DocumentID = documentID
+	if err := session.Validate(d.documentsDir); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Add the session to the sessions of the document.
+	d.sessionsMu.Lock()
+	sessions, err := LoadListeningSessions(d.documentsDir, documentID)
+	if err == nil {
+		err = SaveListeningSessions(d.documentsDir, documentID, append(sessions, session))
+	}
+	d.sessionsMu.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Marshal the session.
+	data, err := json.Marshal(session)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Write the session.
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// httpGetDocumentStats will return how much of the document was listened to.
+func (d *DocumentsInfo) httpGetDocumentStats(w http.ResponseWriter, r *http.Request) {
+	// Get the document ID.
+	path := strings.Split(r.URL.Path, "/")
+	document, ok := d.Document(path[2])
+	if !ok {
+		http.Error(w, "document not found", http.StatusNotFound)
+		return
+	}
+
+	// Work out the statistics from the sessions.
+	sessions, err := LoadListeningSessions(d.documentsDir, document.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	stats, err := ComputeDocumentStats(d.documentsDir, document, sessions)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Marshal the statistics.
+	data, err := json.Marshal(stats)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Write the statistics.
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// httpGetStats will return the time listened on each of the last days and
+// the statistics of each of the documents. The days parameter is the number
+// of days, 30 by default.
+func (d *DocumentsInfo) httpGetStats(w http.ResponseWriter, r *http.Request) {
+	days := 30
+	if r.URL.Query().Get("days") != "" {
+		n, err := strconv.Atoi(r.URL.Query().Get("days"))
+		if err != nil || n < 1 || n > 3660 {
+			http.Error(w, "invalid days: "+r.URL.Query().Get("days"), http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+
+	d.mu.RLock()
+	documents := append([]DocumentInfo{}, d.Documents...)
+	d.mu.RUnlock()
+
+	// Work out the statistics of each of the documents.
+	stats := ListeningStats{Documents: []DocumentStats{}}
+	all := []ListeningSession{}
+	for _, document := range documents {
+		sessions, err := LoadListeningSessions(d.documentsDir, document.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		documentStats, err := ComputeDocumentStats(d.documentsDir, document, sessions)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		stats.Documents = append(stats.Documents, documentStats)
+		stats.Listened += documentStats.Listened
+		all = append(all, sessions...)
+	}
+	sortDocumentStats(stats.Documents)
+	stats.Days = DailyListeningTotals(all, days, time.Now())
+
+	// Marshal the statistics.
+	data, err := json.Marshal(stats)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Write the statistics.
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// -----------------------------------------------------------------------------
+// Job Handlers
+// -----------------------------------------------------------------------------
+
+// jobsResponse is the list of jobs.
+type jobsResponse struct {
+	Jobs []Job `json:"jobs"`
+}
+
+// httpJobsRouter is the router for the jobs endpoints.
+func (d *DocumentsInfo) httpJobsRouter(w http.ResponseWriter, r *http.Request) {
+
+	if d.Pipeline().Jobs == nil {
+		http.Error(w, "jobs are not tracked", http.StatusNotFound)
+		return
+	}
+
+	path := strings.Split(r.URL.Path, "/")
+	switch {
+	case len(path) == 2 && r.Method == http.MethodGet:
+		// /jobs
+		logRoute(r, "httpGetJobs")
+		d.httpGetJobs(w, r)
+	case len(path) == 3 && path[2] != "" && r.Method == http.MethodGet:
+		// /jobs/{job_id}
+		logRoute(r, "httpGetJob")
+		d.httpGetJob(w, r)
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+// httpGetJobs will return the jobs that are running and that finished
+// recently. The document parameter limits the jobs to one document.
+func (d *DocumentsInfo) httpGetJobs(w http.ResponseWriter, r *http.Request) {
+	jobs := d.Pipeline().Jobs.List(r.URL.Query().Get("document"))
+
+	// Marshal the jobs.
+	data, err := json.Marshal(jobsResponse{Jobs: jobs})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Write the jobs.
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// httpGetJob will return the job with the ID.
+func (d *DocumentsInfo) httpGetJob(w http.ResponseWriter, r *http.Request) {
+	path := strings.Split(r.URL.Path, "/")
+	job, ok := d.Pipeline().Jobs.Get(path[2])
+	if !ok {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+
+	// Marshal the job.
+	data, err := json.Marshal(job)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Write the job.
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// -----------------------------------------------------------------------------
+// Search Handlers
+// -----------------------------------------------------------------------------
+
+// searchResponse is the result of a search.
+type searchResponse struct {
+	Query   string         `json:"query"`
+	Results []SearchResult `json:"results"`
+}
+
+// searchIndexResponse is the size of the search index once it was rebuilt.
+type searchIndexResponse struct {
+	Documents  int `json:"documents"`
+	Paragraphs int `json:"paragraphs"`
+}
+
+// httpSearchRouter is the router for the search endpoints.
+func (d *DocumentsInfo) httpSearchRouter(w http.ResponseWriter, r *http.Request) {
+
+	if d.Pipeline().SearchIndex == nil {
+		http.Error(w, "search is not enabled", http.StatusNotFound)
+		return
+	}
+
+	path := strings.Split(r.URL.Path, "/")
+	switch {
+	case len(path) == 2 && r.Method == http.MethodGet:
+		// /search
+		logRoute(r, "httpGetSearch")
+		d.httpGetSearch(w, r)
+	case len(path) == 3 && path[2] == "rebuild" && r.Method == http.MethodPost:
+		// /search/rebuild
+		logRoute(r, "httpPostSearchRebuild")
+		d.httpPostSearchRebuild(w, r)
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+// httpGetSearch will search the paragraphs of the documents. The query is
+// read from the q parameter, words in double quotes are matched as a phrase.
+// The document parameter limits the search to one document and the limit
+// parameter is the largest number of results, 20 by default.
+func (d *DocumentsInfo) httpGetSearch(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if strings.TrimSpace(query.Get("q")) == "" {
+		http.Error(w, "q is required", http.StatusBadRequest)
+		return
+	}
+	search := ParseSearchQuery(query.Get("q"))
+	search.Limit = 20
+	if query.Get("limit") != "" {
+		limit, err := strconv.Atoi(query.Get("limit"))
+		if err != nil || limit < 1 {
+			http.Error(w, "invalid limit: "+query.Get("limit"), http.StatusBadRequest)
+			return
+		}
+		search.Limit = limit
+	}
+	if documentID := query.Get("document"); documentID != "" {
+		if _, ok := d.Document(documentID); !ok {
+			http.Error(w, "document not found", http.StatusNotFound)
+			return
+		}
+		search.DocumentID = documentID
+	}
+
+	// Search the index and add the names of the documents to the results.
+	results := d.Pipeline().SearchIndex.Search(d.documentsDir, search)
+	for i := range results {
+		if document, ok := d.Document(results[i].DocumentID); ok {
+			results[i].DocumentName = document.Name
+		}
+	}
+
+	// Marshal the results.
+	data, err := json.Marshal(searchResponse{Query: query.Get("q"), Results: results})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Write the results.
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// httpPostSearchRebuild will rebuild the search index from the paragraph
+// files of all of the documents.
+func (d *DocumentsInfo) httpPostSearchRebuild(w http.ResponseWriter, r *http.Request) {
+	if err := d.RebuildSearchIndex(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Marshal the size of the index.
+	documents, paragraphs := d.Pipeline().SearchIndex.Size()
+	data, err := json.Marshal(searchIndexResponse{Documents: documents, Paragraphs: paragraphs})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Write the size of the index.
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// -----------------------------------------------------------------------------
+// Webhook Handlers
+// -----------------------------------------------------------------------------
+
+// webhooksResponse is the list of webhooks.
+type webhooksResponse struct {
+	Webhooks []Webhook `json:"webhooks"`
+	Events   []string  `json:"events"`
+}
+
+// webhookDeliveriesResponse is the delivery log of the webhooks.
+type webhookDeliveriesResponse struct {
+	Deliveries []WebhookDelivery `json:"deliveries"`
+}
+
+// httpWebhooksRouter is the router for the webhooks endpoints.
+func (d *DocumentsInfo) httpWebhooksRouter(w http.ResponseWriter, r *http.Request) {
+
+	path := strings.Split(r.URL.Path, "/")
+	switch {
+	case len(path) == 2 && r.Method == http.MethodGet:
+		// /webhooks
+		logRoute(r, "httpGetWebhooks")
+		d.httpGetWebhooks(w, r)
+	case len(path) == 3 && path[2] == "deliveries" && r.Method == http.MethodGet:
+		// /webhooks/deliveries
+		logRoute(r, "httpGetWebhookDeliveries")
+		d.httpGetWebhookDeliveries(w, r)
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+// httpGetWebhooks will return the webhooks and the events that they can be
+// sent for.
+func (d *DocumentsInfo) httpGetWebhooks(w http.ResponseWriter, r *http.Request) {
+	// Marshal the webhooks.
+	data, err := json.Marshal(webhooksResponse{Webhooks: d.Webhooks().Hooks(), Events: WebhookEvents})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Write the webhooks.
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// httpGetWebhookDeliveries will return the delivery log of the webhooks. The
+// document parameter limits the log to one document.
+func (d *DocumentsInfo) httpGetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	deliveries := d.Webhooks().Deliveries(r.URL.Query().Get("document"))
+
+	// Marshal the deliveries.
+	data, err := json.Marshal(webhookDeliveriesResponse{Deliveries: deliveries})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Write the deliveries.
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// -----------------------------------------------------------------------------
+// Metrics Handlers
+// -----------------------------------------------------------------------------
+
+// httpGetMetrics will write the metrics in the Prometheus text format.
+func (d *DocumentsInfo) httpGetMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := d.WriteMetrics(w); err != nil {
+		slog.ErrorContext(r.Context(), "Unable to write the metrics", "error", err)
+	}
+}
+
+// -----------------------------------------------------------------------------
+// Health Handlers
+// -----------------------------------------------------------------------------
+
+// httpGetHealthz will report that the server is running.
+func (d *DocumentsInfo) httpGetHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"status":"ok"}`))
+}
+
+// httpGetReadyz will return the status of the server and of the backends of
+// the pipeline. The status code is 503 if the server is not ready.
+func (d *DocumentsInfo) httpGetReadyz(w http.ResponseWriter, r *http.Request) {
+	report := d.Pipeline().Health.Report()
+	if d.isClosed() {
+		report.Status = HealthStopping
+		report.Ready = false
+	}
+
+	// Marshal the report.
+	data, err := json.Marshal(report)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Write the report.
+	w.Header().Set("Content-Type", "application/json")
+	if !report.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write(data)
+}
+
+// httpPostHealthCheck will check the backends of the pipeline again, e.g.
+// once a missing tool has been installed, and return the status of the
+// server.
+func (d *DocumentsInfo) httpPostHealthCheck(w http.ResponseWriter, r *http.Request) {
+	pipeline := d.Pipeline()
+	if pipeline.Health == nil {
+		http.Error(w, "the self-check is disabled", http.StatusNotFound)
+		return
+	}
+	if d.isClosed() {
+		http.Error(w, ErrShuttingDown.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	// The check carries on if the client goes away, since an interrupted
+	// check is not kept.
+	pipeline.Health.Check(context.WithoutCancel(r.Context()), pipeline)
+	d.httpGetReadyz(w, r)
+}
+
+// -----------------------------------------------------------------------------
+// Upload Handlers
+// -----------------------------------------------------------------------------
+
+// httpPostDocuments will upload a document to the server. The document will be
+// sent as a multipart form. The form will contain the following fields:
+//   - name: The name of the document as it was uploaded by the user.
+//   - file: The document file.
+//   - url: The URL of a document or web page to fetch instead of uploading a
+//     file. The form can be URL encoded when the url field is used.
+func (d *DocumentsInfo) httpPostDocuments(w http.ResponseWriter, r *http.Request) {
+	// Parse the form. A URL can be sent without a multipart form.
+	if err := r.ParseMultipartForm(32 << 20); err != nil && err != http.ErrNotMultipart {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Get the document, either from the uploaded file or by fetching it from
+	// the URL.
+	var filename string
+	var fileData []byte
+	name := r.FormValue("name")
+	if documentURL := r.FormValue("url"); documentURL != "" {
+		fetchedName, fetchedFilename, data, err := FetchDocument(r.Context(), documentURL)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if name == "" {
+			name = fetchedName
+		}
+		filename = fetchedFilename
+		fileData = data
+	} else {
+		// Get the file.
+		file, handler, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+
+		// Get the name of the document. If no name is specified, use the
+		// name of the file without the extension.
+		if name == "" {
+			name = strings.TrimSuffix(handler.Filename, filepath.Ext(handler.Filename))
+		}
+
+		// Read the file.
+		filename = handler.Filename
+		fileData, err = ioutil.ReadAll(file)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Create the document.
+	document, err := d.CreateDocument(r.Context(), name, filename, r.FormValue("language"), fileData)
+	if errors.Is(err, ErrCapabilityUnavailable) || errors.Is(err, ErrShuttingDown) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	// Marshal the document.
 	data, err := json.Marshal(document)
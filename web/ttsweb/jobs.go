@@ -0,0 +1,242 @@
+package ttsweb
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	// JobProcess is the type of the job that splits and synthesizes a new
+	// document.
+	JobProcess = "process"
+
+	// JobResynthesize is the type of the job that synthesizes the stale
+	// paragraphs of a document again.
+	JobResynthesize = "resynthesize"
+
+	// JobRunning is the status of a job that has not finished.
+	JobRunning = "running"
+
+	// JobDone is the status of a job that finished without an error.
+	JobDone = "done"
+
+	// JobFailed is the status of a job that stopped with an error.
+	JobFailed = "failed"
+
+	// JobCancelled is the status of a job that was stopped before it
+	// finished, e.g. because the server was shut down.
+	JobCancelled = "cancelled"
+)
+
+// Stages of the pipeline that are reported by jobs.
+const (
+	StageSplit      = "split"
+	StageIndex      = "index"
+	StageLanguages  = "languages"
+	StageSynthesize = "synthesize"
+	StageAlign      = "align"
+	StageTranscode  = "transcode"
+)
+
+// maxFinishedJobs is the number of finished jobs that are kept so that their
+// results can still be read.
+const maxFinishedJobs = 100
+
+// -----------------------------------------------------------------------------
+// Jobs
+// -----------------------------------------------------------------------------
+
+// Job is a run of the pipeline over a document. The stage is the step of the
+// pipeline that the job is at, and Done and Total count the paragraphs of the
+// stage that have been finished.
+type Job struct {
+	ID         string `json:"id"`
+	DocumentID string `json:"documentId"`
+	Type       string `json:"type"`
+	Status     string `json:"status"`
+	Stage      string `json:"stage"`
+	Done       int    `json:"done"`
+	Total      int    `json:"total"`
+	Error      string `json:"error,omitempty"`
+
+	// RequestID is the ID of the request that started the job, so that the
+	// job can be found in the logs of the request.
+	RequestID string `json:"requestId,omitempty"`
+
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+
+	Link string `json:"link"`
+
+	jobs *Jobs
+}
+
+// Jobs keeps track of the jobs that are running and the jobs that finished
+// most recently. The jobs are only kept in memory.
+type Jobs struct {
+	jobs []*Job
+	mu   sync.RWMutex
+}
+
+// NewJobs will create an empty list of jobs.
+func NewJobs() *Jobs {
+	return &Jobs{}
+}
+
+// Start will add a running job of the type for the document, started by the
+// request of the context. Nothing is tracked if the list of jobs is nil.
+func (j *Jobs) Start(ctx context.Context, documentID, jobType string) *Job {
+	if j == nil {
+		return nil
+	}
+	job := &Job{
+		ID:         generateID(),
+		DocumentID: documentID,
+		Type:       jobType,
+		Status:     JobRunning,
+		RequestID:  RequestID(ctx),
+		Started:    time.Now().UTC(),
+		jobs:       j,
+	}
+	job.Link = "/jobs/" + job.ID
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.jobs = append(j.jobs, job)
+
+	// Drop the oldest finished jobs.
+	finished := 0
+	for _, job := range j.jobs {
+		if job.Status != JobRunning {
+			finished++
+		}
+	}
+	kept := j.jobs[:0]
+	for _, job := range j.jobs {
+		if job.Status != JobRunning && finished > maxFinishedJobs {
+			finished--
+			continue
+		}
+		kept = append(kept, job)
+	}
+	j.jobs = kept
+	return job
+}
+
+// logContext will return a copy of the context whose log records have the IDs
+// of the document and of the job, if it is tracked.
+func (job *Job) logContext(ctx context.Context, documentID string) context.Context {
+	if job == nil {
+		return WithLogAttrs(ctx, slog.String("document_id", documentID))
+	}
+	return WithLogAttrs(ctx, slog.String("document_id", documentID), slog.String("job_id", job.ID))
+}
+
+// setStage will move the job on to the stage of the pipeline. The total is
+// the number of paragraphs of the stage, it is 0 if the progress of the stage
+// is not counted.
+func (job *Job) setStage(stage string, total int) {
+	if job == nil {
+		return
+	}
+	job.jobs.mu.Lock()
+	defer job.jobs.mu.Unlock()
+	job.Stage = stage
+	job.Done = 0
+	job.Total = total
+}
+
+// paragraphDone will count another paragraph of the stage as finished.
+func (job *Job) paragraphDone() {
+	if job == nil {
+		return
+	}
+	job.jobs.mu.Lock()
+	defer job.jobs.mu.Unlock()
+	if job.Done < job.Total {
+		job.Done++
+	}
+}
+
+// finish will mark the job as done, as cancelled if its context was
+// cancelled, or as failed if there was any other error.
+func (job *Job) finish(err error) {
+	if job == nil {
+		return
+	}
+	job.jobs.mu.Lock()
+	defer job.jobs.mu.Unlock()
+	finished := time.Now().UTC()
+	job.Finished = &finished
+	job.Status = JobDone
+	job.Done = job.Total
+	if err != nil {
+		job.Status = JobFailed
+		if errors.Is(err, context.Canceled) {
+			job.Status = JobCancelled
+		}
+		job.Error = err.Error()
+	}
+}
+
+// snapshot will copy the job so that it can be read without the lock.
+func (job *Job) snapshot() Job {
+	job.jobs.mu.RLock()
+	defer job.jobs.mu.RUnlock()
+	copied := *job
+	copied.jobs = nil
+	return copied
+}
+
+// Get will return the job with the ID.
+func (j *Jobs) Get(id string) (Job, bool) {
+	j.mu.RLock()
+	var found *Job
+	for _, job := range j.jobs {
+		if job.ID == id {
+			found = job
+		}
+	}
+	j.mu.RUnlock()
+	if found == nil {
+		return Job{}, false
+	}
+	return found.snapshot(), true
+}
+
+// List will return the jobs of the document, or of all of the documents if
+// the document ID is empty, in the order that they were started.
+func (j *Jobs) List(documentID string) []Job {
+	j.mu.RLock()
+	matched := []*Job{}
+	for _, job := range j.jobs {
+		if documentID == "" || job.DocumentID == documentID {
+			matched = append(matched, job)
+		}
+	}
+	j.mu.RUnlock()
+
+	jobs := []Job{}
+	for _, job := range matched {
+		jobs = append(jobs, job.snapshot())
+	}
+	return jobs
+}
+
+// Running will check if a job is running for the document.
+func (j *Jobs) Running(documentID string) bool {
+	if j == nil {
+		return false
+	}
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	for _, job := range j.jobs {
+		if job.DocumentID == documentID && job.Status == JobRunning {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package ttsweb
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// progressSynthesizer is a fakeSynthesizer that records the progress of the
+// job at the start of each batch of paragraphs.
+type progressSynthesizer struct {
+	fakeSynthesizer
+	jobs     *Jobs
+	jobID    string
+	progress []int
+}
+
+// Synthesize will record the progress of the job and write the silent audio
+// of the paragraphs.
+func (s *progressSynthesizer) Synthesize(ctx context.Context, voice Voice, textDir, audioDir string, paragraphIDs []string) error {
+	job, _ := s.jobs.Get(s.jobID)
+	s.progress = append(s.progress, job.Done)
+	return s.fakeSynthesizer.Synthesize(ctx, voice, textDir, audioDir, paragraphIDs)
+}
+
+func TestJobProgress(t *testing.T) {
+	documentsDir := t.TempDir() + "/"
+	document := DocumentInfo{ID: "doc"}
+	paragraphsDir := filepath.Join(documentsDir, document.ID, "paragraphs")
+	if err := os.MkdirAll(paragraphsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	paragraphIDs := []string{}
+	for i := 0; i < synthesisBatchSize+2; i++ {
+		paragraphID := fmt.Sprint(i)
+		if err := os.WriteFile(filepath.Join(paragraphsDir, paragraphID+".txt"), []byte("A paragraph."), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paragraphIDs = append(paragraphIDs, paragraphID)
+	}
+
+	jobs := NewJobs()
+	job := jobs.Start(context.Background(), document.ID, JobProcess)
+	synthesizer := &progressSynthesizer{jobs: jobs, jobID: job.ID}
+	speechFor := func(paragraph ParagraphInfo, text string) Speech {
+		return Speech{Voice: DefaultVoice, Synthesizer: synthesizer, Text: text}
+	}
+
+	// The paragraphs of each batch are counted once the batch is written.
+	job.setStage(StageSynthesize, len(paragraphIDs))
+	if err := document.SynthesizeParagraphs(context.Background(), documentsDir, paragraphIDs, speechFor, job.paragraphDone); err != nil {
+		t.Fatal(err)
+	}
+	if len(synthesizer.progress) != 2 || synthesizer.progress[0] != 0 || synthesizer.progress[1] != synthesisBatchSize {
+		t.Errorf("progress at the start of each batch = %v, want [0 %d]", synthesizer.progress, synthesisBatchSize)
+	}
+	if got, _ := jobs.Get(job.ID); got.Done != len(paragraphIDs) || got.Total != len(paragraphIDs) {
+		t.Errorf("synthesize progress = %d/%d, want %d/%d", got.Done, got.Total, len(paragraphIDs), len(paragraphIDs))
+	}
+
+	// Only the paragraphs that are transcoded are counted.
+	job.setStage(StageTranscode, 3)
+	err := document.TranscodeParagraphs(context.Background(), documentsDir, []string{"0", "1", "2"}, &fakeTranscoder{}, []AudioFormat{FormatMP3}, true, job.paragraphDone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := jobs.Get(job.ID); got.Stage != StageTranscode || got.Done != 3 || got.Total != 3 {
+		t.Errorf("transcode progress = %s %d/%d, want %s 3/3", got.Stage, got.Done, got.Total, StageTranscode)
+	}
+
+	// Jobs that are not tracked ignore their progress.
+	var untracked *Job
+	untracked.setStage(StageAlign, 1)
+	untracked.paragraphDone()
+}
@@ -0,0 +1,151 @@
+package ttsweb
+
+import (
+	"embed"
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// -----------------------------------------------------------------------------
+// Language Detection
+// -----------------------------------------------------------------------------
+
+// languageSamples are the sample texts that the language profiles are built
+// from. Each file is named after the language code, e.g. "en.txt".
+//
+//go:embed languages/*.txt
+var languageSamples embed.FS
+
+const (
+	// languageProfileSize is the number of trigrams kept in each profile.
+	languageProfileSize = 300
+
+	// minDetectLetters is the least number of letters that a language can be
+	// detected from. Shorter text is given the language of the document.
+	minDetectLetters = 20
+
+	// minLanguageConfidence is the least confidence that a detected language
+	// is used at. Paragraphs below it are given the language of the document.
+	minLanguageConfidence = 0.05
+)
+
+// languageProfile is the rank of the most common trigrams of a language.
+type languageProfile map[string]int
+
+// languageProfiles are the profiles of the languages that can be detected,
+// keyed by language code.
+var languageProfiles = func() map[string]languageProfile {
+	profiles := map[string]languageProfile{}
+	files, err := languageSamples.ReadDir("languages")
+	if err != nil {
+		panic(err)
+	}
+	for _, file := range files {
+		data, err := languageSamples.ReadFile(path.Join("languages", file.Name()))
+		if err != nil {
+			panic(err)
+		}
+		language := strings.TrimSuffix(file.Name(), path.Ext(file.Name()))
+		profiles[language] = newLanguageProfile(string(data))
+	}
+	return profiles
+}()
+
+// Languages will return the codes of the languages that can be detected.
+func Languages() []string {
+	languages := []string{}
+	for language := range languageProfiles {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+// newLanguageProfile will rank the most common trigrams in the text. Each word
+// is padded with a space on either side, so that the start and end of words
+// are part of the profile.
+func newLanguageProfile(text string) languageProfile {
+	counts := map[string]int{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) }) {
+		runes := []rune(" " + word + " ")
+		for i := 0; i+3 <= len(runes); i++ {
+			counts[string(runes[i:i+3])]++
+		}
+	}
+
+	// Rank the trigrams from the most common. Ties are ranked alphabetically
+	// so that the profile is the same every time.
+	trigrams := make([]string, 0, len(counts))
+	for trigram := range counts {
+		trigrams = append(trigrams, trigram)
+	}
+	sort.Slice(trigrams, func(i, j int) bool {
+		if counts[trigrams[i]] != counts[trigrams[j]] {
+			return counts[trigrams[i]] > counts[trigrams[j]]
+		}
+		return trigrams[i] < trigrams[j]
+	})
+	if len(trigrams) > languageProfileSize {
+		trigrams = trigrams[:languageProfileSize]
+	}
+
+	profile := languageProfile{}
+	for rank, trigram := range trigrams {
+		profile[trigram] = rank
+	}
+	return profile
+}
+
+// distance is the out of place distance between two profiles. Trigrams that
+// are missing from the other profile count as the furthest out of place.
+func (p languageProfile) distance(other languageProfile) int {
+	distance := 0
+	for trigram, rank := range p {
+		otherRank, ok := other[trigram]
+		if !ok {
+			distance += languageProfileSize
+			continue
+		}
+		if rank > otherRank {
+			distance += rank - otherRank
+		} else {
+			distance += otherRank - rank
+		}
+	}
+	return distance
+}
+
+// DetectLanguage will detect the language of the text by comparing its
+// trigrams to the profile of each language. The confidence is between 0 and 1,
+// and is the margin between the closest and the second closest language. An
+// empty language is returned if the text is too short or does not look like
+// any of the languages.
+func DetectLanguage(text string) (language string, confidence float64) {
+	letters := 0
+	for _, r := range text {
+		if unicode.IsLetter(r) {
+			letters++
+		}
+	}
+	if letters < minDetectLetters {
+		return "", 0
+	}
+
+	profile := newLanguageProfile(text)
+	worst := len(profile) * languageProfileSize
+	best, second := worst, worst
+	for _, code := range Languages() {
+		distance := profile.distance(languageProfiles[code])
+		if distance < best {
+			language, best, second = code, distance, best
+		} else if distance < second {
+			second = distance
+		}
+	}
+	if best == worst {
+		return "", 0
+	}
+	return language, float64(second-best) / float64(second)
+}
@@ -0,0 +1,274 @@
+package ttsweb
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// -----------------------------------------------------------------------------
+// Lexicons
+// -----------------------------------------------------------------------------
+
+// Lexicon is a named list of pronunciations, e.g. the names of the characters
+// of a fantasy series. Lexicons are stored as {name}.json in the lexicons
+// directory and are attached to documents by name.
+type Lexicon struct {
+	Name    string         `json:"name"`
+	Entries []LexiconEntry `json:"entries"`
+
+	Link string `json:"link"`
+}
+
+// LexiconUpdate is the result of saving a lexicon. StaleParagraphs is the
+// number of paragraphs of each document that were marked to be synthesized
+// again, keyed by document ID.
+type LexiconUpdate struct {
+	Lexicon         Lexicon        `json:"lexicon"`
+	StaleParagraphs map[string]int `json:"staleParagraphs"`
+}
+
+// LexiconEntry is the pronunciation of a word. At least one of the respelling
+// and the IPA should be set.
+type LexiconEntry struct {
+	Word string `json:"word"`
+
+	// Respelling is the word written the way that it sounds, e.g. "her MY
+	// nee". It is read instead of the word by synthesizers without SSML, and
+	// by SSML synthesizers if there is no IPA.
+	Respelling string `json:"respelling,omitempty"`
+
+	// IPA is the pronunciation in the International Phonetic Alphabet. It
+	// is only used by synthesizers that read SSML.
+	IPA string `json:"ipa,omitempty"`
+}
+
+// lexiconNamePattern matches the names that lexicons can be saved with.
+var lexiconNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// lexiconPath will return the path to the file of the lexicon.
+func lexiconPath(lexiconsDir, name string) string {
+	return path.Join(lexiconsDir, name+".json")
+}
+
+// LoadLexicon will load the lexicon with the name from the lexicons directory.
+func LoadLexicon(lexiconsDir, name string) (Lexicon, error) {
+	if !lexiconNamePattern.MatchString(name) {
+		return Lexicon{}, fmt.Errorf("invalid lexicon name: %s", name)
+	}
+	data, err := ioutil.ReadFile(lexiconPath(lexiconsDir, name))
+	if err != nil {
+		return Lexicon{}, err
+	}
+	lexicon := Lexicon{}
+	if err := json.Unmarshal(data, &lexicon); err != nil {
+		return Lexicon{}, err
+	}
+	lexicon.Name = name
+	lexicon.Link = "/lexicons/" + name
+	return lexicon, nil
+}
+
+// LoadLexicons will load all of the lexicons in the lexicons directory. No
+// lexicons are returned if the directory does not exist.
+func LoadLexicons(lexiconsDir string) ([]Lexicon, error) {
+	lexicons := []Lexicon{}
+	files, err := os.ReadDir(lexiconsDir)
+	if os.IsNotExist(err) {
+		return lexicons, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if file.IsDir() || path.Ext(file.Name()) != ".json" {
+			continue
+		}
+		lexicon, err := LoadLexicon(lexiconsDir, strings.TrimSuffix(file.Name(), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		lexicons = append(lexicons, lexicon)
+	}
+	return lexicons, nil
+}
+
+// Save will write the lexicon to the lexicons directory.
+func (l *Lexicon) Save(lexiconsDir string) error {
+	if !lexiconNamePattern.MatchString(l.Name) {
+		return fmt.Errorf("invalid lexicon name: %s", l.Name)
+	}
+	for _, entry := range l.Entries {
+		if strings.TrimSpace(entry.Word) == "" {
+			return fmt.Errorf("lexicon entry without a word")
+		}
+	}
+	l.Link = "/lexicons/" + l.Name
+	if err := os.MkdirAll(lexiconsDir, 0755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(lexiconPath(lexiconsDir, l.Name), data, 0644)
+}
+
+// changedWords will return the words whose pronunciation is different in the
+// other lexicon, including the words that are only in one of them.
+func (l Lexicon) changedWords(other Lexicon) []string {
+	entries := map[string]LexiconEntry{}
+	for _, entry := range l.Entries {
+		entries[strings.ToLower(entry.Word)] = entry
+	}
+	changed := []string{}
+	for _, entry := range other.Entries {
+		word := strings.ToLower(entry.Word)
+		if old, ok := entries[word]; !ok || old.Respelling != entry.Respelling || old.IPA != entry.IPA {
+			changed = append(changed, entry.Word)
+		}
+		delete(entries, word)
+	}
+	for _, entry := range entries {
+		changed = append(changed, entry.Word)
+	}
+	return changed
+}
+
+// words will return the words of the lexicon.
+func (l Lexicon) words() []string {
+	words := []string{}
+	for _, entry := range l.Entries {
+		words = append(words, entry.Word)
+	}
+	return words
+}
+
+// -----------------------------------------------------------------------------
+// Pronunciations
+// -----------------------------------------------------------------------------
+
+// pronunciations finds the words of a set of lexicons in text. Words are
+// matched whole and without regard to case.
+type pronunciations struct {
+	entries map[string]LexiconEntry
+	re      *regexp.Regexp
+}
+
+// newPronunciations will combine the entries of the lexicons. Entries in later
+// lexicons replace the entries for the same word in earlier ones.
+func newPronunciations(lexicons []Lexicon) pronunciations {
+	p := pronunciations{entries: map[string]LexiconEntry{}}
+	for _, lexicon := range lexicons {
+		for _, entry := range lexicon.Entries {
+			p.entries[strings.ToLower(entry.Word)] = entry
+		}
+	}
+	if len(p.entries) == 0 {
+		return p
+	}
+
+	// Match the longest words first, so that "Mary Anne" is matched before
+	// "Mary".
+	words := []string{}
+	for word := range p.entries {
+		words = append(words, regexp.QuoteMeta(word))
+	}
+	sort.Slice(words, func(i, j int) bool {
+		if len(words[i]) != len(words[j]) {
+			return len(words[i]) > len(words[j])
+		}
+		return words[i] < words[j]
+	})
+	p.re = regexp.MustCompile(`(?i)` + strings.Join(words, "|"))
+	return p
+}
+
+// pronunciationsOf will find the words without any pronunciation, so that the
+// text that contains the words can be found.
+func pronunciationsOf(words []string) pronunciations {
+	entries := []LexiconEntry{}
+	for _, word := range words {
+		entries = append(entries, LexiconEntry{Word: word})
+	}
+	return newPronunciations([]Lexicon{{Entries: entries}})
+}
+
+// replace will call the function for each of the words of the lexicons in the
+// text, and replace the word with the result. The text between the words is
+// passed through the between function.
+func (p pronunciations) replace(text string, word func(entry LexiconEntry, match string) string, between func(string) string) string {
+	var b strings.Builder
+	last := 0
+	if p.re != nil {
+		for _, match := range p.re.FindAllStringIndex(text, -1) {
+			// Only match whole words.
+			before, _ := utf8.DecodeLastRuneInString(text[:match[0]])
+			after, _ := utf8.DecodeRuneInString(text[match[1]:])
+			if isWordRune(before) || isWordRune(after) {
+				continue
+			}
+			b.WriteString(between(text[last:match[0]]))
+			b.WriteString(word(p.entries[strings.ToLower(text[match[0]:match[1]])], text[match[0]:match[1]]))
+			last = match[1]
+		}
+	}
+	b.WriteString(between(text[last:]))
+	return b.String()
+}
+
+// isWordRune will check if the rune is part of a word.
+func isWordRune(r rune) bool {
+	return r != utf8.RuneError && (unicode.IsLetter(r) || unicode.IsDigit(r))
+}
+
+// contains will check if any of the words are in the text.
+func (p pronunciations) contains(text string) bool {
+	found := false
+	p.replace(text, func(entry LexiconEntry, match string) string {
+		found = true
+		return match
+	}, func(s string) string { return s })
+	return found
+}
+
+// Respell will replace the words with their respellings, for synthesizers
+// that read plain text.
+func (p pronunciations) Respell(text string) string {
+	return p.replace(text, func(entry LexiconEntry, match string) string {
+		if entry.Respelling == "" {
+			return match
+		}
+		return entry.Respelling
+	}, func(s string) string { return s })
+}
+
+// SSML will write the text as an SSML document for synthesizers that read
+// SSML. Words with an IPA pronunciation are wrapped in a phoneme element, and
+// words with only a respelling are wrapped in a sub element.
+func (p pronunciations) SSML(text string) string {
+	return `<speak>` + p.ssmlBody(text) + `</speak>`
+}
+
+// ssmlBody will write the text as SSML without the speak element, so that it
+// can be placed within other elements.
+func (p pronunciations) ssmlBody(text string) string {
+	return p.replace(text, func(entry LexiconEntry, match string) string {
+		switch {
+		case entry.IPA != "":
+			return `<phoneme alphabet="ipa" ph="` + html.EscapeString(entry.IPA) + `">` + html.EscapeString(match) + `</phoneme>`
+		case entry.Respelling != "":
+			return `<sub alias="` + html.EscapeString(entry.Respelling) + `">` + html.EscapeString(match) + `</sub>`
+		default:
+			return html.EscapeString(match)
+		}
+	}, html.EscapeString)
+}
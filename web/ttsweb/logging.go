@@ -0,0 +1,304 @@
+package ttsweb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// -----------------------------------------------------------------------------
+// Loggers
+// -----------------------------------------------------------------------------
+
+// NewLogger will create a logger that writes text or JSON records at the level
+// and above. The attributes that have been added to the context of a record,
+// such as the request ID, are added to the record.
+func NewLogger(w io.Writer, format string, level slog.Level) (*slog.Logger, error) {
+	options := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch format {
+	case "text":
+		handler = slog.NewTextHandler(w, options)
+	case "json":
+		handler = slog.NewJSONHandler(w, options)
+	default:
+		return nil, fmt.Errorf("unsupported log format: %s", format)
+	}
+	return slog.New(contextHandler{handler}), nil
+}
+
+// ParseLogLevel will parse the name of a log level, e.g. "debug" or "warn".
+func ParseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return level, fmt.Errorf("unsupported log level: %s", s)
+	}
+	return level, nil
+}
+
+// logAttrsKey is the context key of the attributes that are added to the log
+// records of the context.
+type logAttrsKey struct{}
+
+// requestIDKey is the context key of the ID of the request.
+type requestIDKey struct{}
+
+// WithLogAttrs will return a copy of the context whose log records have the
+// attributes along with the attributes of the parent context.
+func WithLogAttrs(ctx context.Context, attrs ...slog.Attr) context.Context {
+	parent, _ := ctx.Value(logAttrsKey{}).([]slog.Attr)
+	return context.WithValue(ctx, logAttrsKey{}, append(parent[:len(parent):len(parent)], attrs...))
+}
+
+// WithRequestID will return a copy of the context for the request with the ID.
+// The ID is added to the log records of the context.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	ctx = context.WithValue(ctx, requestIDKey{}, id)
+	return WithLogAttrs(ctx, slog.String("request_id", id))
+}
+
+// RequestID will return the ID of the request that the context belongs to, or
+// an empty string if there is none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// contextHandler adds the attributes of the context to each record.
+type contextHandler struct {
+	slog.Handler
+}
+
+// Handle will add the attributes of the context to the record.
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if attrs, ok := ctx.Value(logAttrsKey{}).([]slog.Attr); ok {
+		r.AddAttrs(attrs...)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+// WithAttrs will keep adding the attributes of the context to the handler
+// with the attributes.
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup will keep adding the attributes of the context to the handler
+// with the group.
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// -----------------------------------------------------------------------------
+// Access Logs
+// -----------------------------------------------------------------------------
+
+// validRequestID matches the request IDs that are accepted from clients in the
+// X-Request-ID header.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// LogRequests will give each request an ID and log it once it has been served,
+// with the status, the size of the response and how long it took. The ID is
+// taken from the X-Request-ID header if the client sent one, and is returned
+// in the same header. The request is also counted in the metrics of the route
+// that handled it.
+func LogRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID.MatchString(id) {
+			id = generateID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		ctx, route := withRoute(WithRequestID(r.Context(), id))
+
+		access := &accessLogWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(access, r.WithContext(ctx))
+		duration := time.Since(started)
+		observeRequest(*route, r.Method, access.status, duration)
+
+		level := slog.LevelInfo
+		if access.status >= 500 {
+			level = slog.LevelError
+		}
+		slog.Log(ctx, level, "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"query", r.URL.RawQuery,
+			"status", access.status,
+			"bytes", access.bytes,
+			"duration_ms", duration.Milliseconds(),
+			"remote", r.RemoteAddr)
+	})
+}
+
+// accessLogWriter records the status and the size of a response.
+type accessLogWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+// WriteHeader will record the status of the response.
+func (w *accessLogWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write will record the size of the response.
+func (w *accessLogWriter) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush will send the buffered response to the client, so that streams are
+// not held back by the access log.
+func (w *accessLogWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap will return the response writer that is being recorded, for
+// http.ResponseController.
+func (w *accessLogWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// logRoute will log the handler that a request is routed to, and record it as
+// the route of the request in the metrics.
+func logRoute(r *http.Request, handler string) {
+	setRoute(r.Context(), handler)
+	slog.DebugContext(r.Context(), "route", "handler", handler)
+}
+
+// -----------------------------------------------------------------------------
+// Child Processes
+// -----------------------------------------------------------------------------
+
+const (
+	// commandErrorLines is the number of lines of the error output of a
+	// command that are kept for the error when it fails.
+	commandErrorLines = 5
+
+	// commandWaitDelay is how long a command is given to exit once it has
+	// been interrupted, before it is killed.
+	commandWaitDelay = 10 * time.Second
+)
+
+// runCommand will run the command and log each line of its output at the debug
+// level, with the attributes of the context. The standard output is only
+// logged if the caller has not captured it. If the command fails, the error
+// has the last lines of its error output. Commands that were created with
+// exec.CommandContext are interrupted when the context is cancelled, and the
+// error wraps the error of the context.
+func runCommand(ctx context.Context, cmd *exec.Cmd) error {
+	name := filepath.Base(cmd.Path)
+	stderr := &commandLogWriter{ctx: ctx, command: name, stream: "stderr"}
+	cmd.Stderr = stderr
+	var stdout *commandLogWriter
+	if cmd.Stdout == nil {
+		stdout = &commandLogWriter{ctx: ctx, command: name, stream: "stdout"}
+		cmd.Stdout = stdout
+	}
+
+	// Let the command clean up after itself before it is killed.
+	if cmd.Cancel != nil {
+		cmd.Cancel = func() error {
+			return cmd.Process.Signal(os.Interrupt)
+		}
+		cmd.WaitDelay = commandWaitDelay
+	}
+
+	started := time.Now()
+	slog.DebugContext(ctx, "running command", "command", name, "args", cmd.Args[1:])
+	err := cmd.Run()
+	stderr.flush()
+	if stdout != nil {
+		stdout.flush()
+	}
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("%s: %w", name, ctx.Err())
+	}
+	if err != nil {
+		if last := stderr.lastLines(); last != "" {
+			return fmt.Errorf("%s: %v: %s", name, err, last)
+		}
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	slog.DebugContext(ctx, "command finished", "command", name, "duration_ms", time.Since(started).Milliseconds())
+	return nil
+}
+
+// commandLogWriter logs each line that a command writes to one of its
+// outputs.
+type commandLogWriter struct {
+	ctx     context.Context
+	command string
+	stream  string
+
+	mu      sync.Mutex
+	partial []byte
+	last    []string
+}
+
+// Write will log the complete lines that have been written, and keep the rest
+// until the line is finished.
+func (w *commandLogWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexAny(w.partial, "\r\n")
+		if i < 0 {
+			break
+		}
+		w.log(string(w.partial[:i]))
+		w.partial = w.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// flush will log the last line if it was not finished.
+func (w *commandLogWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.log(string(w.partial))
+	w.partial = nil
+}
+
+// log will log the line and keep it as one of the last lines.
+func (w *commandLogWriter) log(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+	slog.DebugContext(w.ctx, line, "command", w.command, "stream", w.stream)
+	w.last = append(w.last, line)
+	if len(w.last) > commandErrorLines {
+		w.last = w.last[1:]
+	}
+}
+
+// lastLines will return the last lines that were written, joined by "; ".
+func (w *commandLogWriter) lastLines() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return strings.Join(w.last, "; ")
+}
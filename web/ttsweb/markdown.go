@@ -0,0 +1,233 @@
+package ttsweb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// -----------------------------------------------------------------------------
+// Markdown Splitter
+// -----------------------------------------------------------------------------
+
+// MarkdownSplitter is a Splitter for markdown documents. Paragraphs are
+// separated by blank lines, and ATX ("# Title") and setext ("Title" underlined
+// with "=" or "-") headings are kept as the structure of the document.
+type MarkdownSplitter struct{}
+
+// Split will split the markdown document into paragraphs.
+func (s MarkdownSplitter) Split(ctx context.Context, inputFile, outputDir string) ([]Heading, error) {
+	file, err := os.Open(inputFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	lines := []string{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return writeBlocks(outputDir, parseMarkdownBlocks(lines))
+}
+
+// PandocSplitter is a Splitter for the documents that pandoc can convert to
+// markdown, such as docx. The markdown is split in the same way as by the
+// MarkdownSplitter, so the headings, emphasis and quotes are kept.
+type PandocSplitter struct {
+	// Path is the path to the pandoc binary. If empty, pandoc is expected on
+	// the PATH.
+	Path string
+}
+
+// Split will convert the document to markdown and split it into paragraphs.
+func (s PandocSplitter) Split(ctx context.Context, inputFile, outputDir string) ([]Heading, error) {
+	binary := s.Path
+	if binary == "" {
+		binary = "pandoc"
+	}
+
+	// Convert the document to markdown. Smart quotes are left as they are
+	// so that the text is not changed.
+	cmd := exec.CommandContext(ctx, binary, inputFile,
+		"--to", "markdown-smart",
+		"--wrap", "none")
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	if err := runCommand(ctx, cmd); err != nil {
+		return nil, err
+	}
+
+	return writeBlocks(outputDir, parseMarkdownBlocks(strings.Split(output.String(), "\n")))
+}
+
+// Check will check that pandoc is installed and return its version.
+func (s PandocSplitter) Check(ctx context.Context) (string, error) {
+	binary := s.Path
+	if binary == "" {
+		binary = "pandoc"
+	}
+	return toolVersion(ctx, binary, "--version")
+}
+
+var (
+	markdownATXHeading    = regexp.MustCompile(`^ {0,3}(#{1,6})\s+(.*?)\s*#*\s*$`)
+	markdownSetextHeading = regexp.MustCompile(`^ {0,3}(=+|-+)\s*$`)
+	markdownListItem      = regexp.MustCompile(`^\s*([-*+]|\d+[.)])\s+`)
+	markdownFence         = regexp.MustCompile("^\\s*(```|~~~)")
+	markdownRule          = regexp.MustCompile(`^ {0,3}([-*_])(\s*([-*_]))(\s*([-*_]))+\s*$`)
+
+	markdownImage    = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+	markdownLink     = regexp.MustCompile(`\[([^\]]*)\]\([^)]*\)`)
+	markdownRefLink  = regexp.MustCompile(`\[([^\]]*)\]\[[^\]]*\]`)
+	markdownCode     = regexp.MustCompile("`+([^`]*)`+")
+	markdownEmphasis = regexp.MustCompile(`(\*{1,3}|_{1,3})(\S(?:.*?\S)?)(\*{1,3}|_{1,3})`)
+	markdownHTMLTag  = regexp.MustCompile(`</?[a-zA-Z][^>]*>`)
+	markdownEscape   = regexp.MustCompile("\\\\([\\\\`*_{}\\[\\]()#+\\-.!|>~\"'])")
+)
+
+// parseMarkdownBlocks will parse the lines of a markdown document into blocks.
+func parseMarkdownBlocks(lines []string) []Block {
+	blocks := []Block{}
+	paragraph := []string{}
+	fenced := false
+	quoted := false
+
+	// flush will add the lines collected so far as a block.
+	flush := func() {
+		if len(paragraph) > 0 {
+			text := strings.Join(paragraph, " ")
+			blocks = append(blocks, Block{
+				Text:   stripMarkdownInline(text),
+				Quote:  quoted,
+				Markup: markdownMarkup(text),
+			})
+		}
+		paragraph = paragraph[:0]
+		quoted = false
+	}
+
+	for _, line := range lines {
+		// Code blocks are kept as a single block.
+		if markdownFence.MatchString(line) {
+			flush()
+			fenced = !fenced
+			continue
+		}
+		if fenced {
+			paragraph = append(paragraph, line)
+			continue
+		}
+
+		trimmed := strings.TrimSpace(line)
+
+		// Blank lines and rules end the current paragraph.
+		if trimmed == "" || markdownRule.MatchString(line) && len(paragraph) == 0 {
+			flush()
+			continue
+		}
+
+		// A setext underline turns the current paragraph into a heading.
+		if len(paragraph) > 0 && markdownSetextHeading.MatchString(line) {
+			level := 1
+			if strings.HasPrefix(trimmed, "-") {
+				level = 2
+			}
+			blocks = append(blocks, Block{
+				Text:         stripMarkdownInline(strings.Join(paragraph, " ")),
+				HeadingLevel: level,
+				Markup:       markdownMarkup(strings.Join(paragraph, " ")),
+			})
+			paragraph = paragraph[:0]
+			quoted = false
+			continue
+		}
+
+		// ATX headings are a block of their own.
+		if m := markdownATXHeading.FindStringSubmatch(line); m != nil {
+			flush()
+			blocks = append(blocks, Block{
+				Text:         stripMarkdownInline(m[2]),
+				HeadingLevel: len(m[1]),
+				Markup:       markdownMarkup(m[2]),
+			})
+			continue
+		}
+
+		// Each list item is a paragraph.
+		if markdownListItem.MatchString(line) {
+			flush()
+			line = markdownListItem.ReplaceAllString(line, "")
+		}
+
+		// Remove the quote markers from block quotes.
+		for strings.HasPrefix(strings.TrimLeft(line, " "), ">") {
+			line = strings.TrimPrefix(strings.TrimLeft(line, " "), ">")
+			quoted = true
+		}
+
+		paragraph = append(paragraph, strings.TrimSpace(line))
+	}
+	flush()
+
+	return blocks
+}
+
+// markdownMarkup will convert the inline markdown of the text into markup.
+// Emphasis is kept, the rest of the inline markdown is removed in the same way
+// as by stripMarkdownInline.
+func markdownMarkup(text string) string {
+	text = markdownImage.ReplaceAllString(text, "$1")
+	text = markdownLink.ReplaceAllString(text, "$1")
+	text = markdownRefLink.ReplaceAllString(text, "$1")
+	text = markdownCode.ReplaceAllString(text, "$1")
+	text = markdownHTMLTag.ReplaceAllString(text, "")
+
+	// Mark the emphasis with control characters, so that it is not escaped
+	// with the rest of the text.
+	for i := 0; i < 3; i++ {
+		marked := markdownEmphasis.ReplaceAllStringFunc(text, func(match string) string {
+			m := markdownEmphasis.FindStringSubmatch(match)
+			marker := "\x01"
+			if len(m[1]) > 1 && len(m[3]) > 1 {
+				marker = "\x02"
+			}
+			return marker + m[2] + marker
+		})
+		if marked == text {
+			break
+		}
+		text = marked
+	}
+	text = markdownEscape.ReplaceAllString(text, "$1")
+	text = escapeMarkup(collapseSpace(text))
+	return strings.NewReplacer("\x01", "*", "\x02", "**").Replace(text)
+}
+
+// stripMarkdownInline will remove the inline markdown from the text so that only
+// the text that should be read is left.
+func stripMarkdownInline(text string) string {
+	text = markdownImage.ReplaceAllString(text, "$1")
+	text = markdownLink.ReplaceAllString(text, "$1")
+	text = markdownRefLink.ReplaceAllString(text, "$1")
+	text = markdownCode.ReplaceAllString(text, "$1")
+	text = markdownHTMLTag.ReplaceAllString(text, "")
+	for i := 0; i < 3; i++ {
+		stripped := markdownEmphasis.ReplaceAllString(text, "$2")
+		if stripped == text {
+			break
+		}
+		text = stripped
+	}
+	text = markdownEscape.ReplaceAllString(text, "$1")
+	return collapseSpace(text)
+}
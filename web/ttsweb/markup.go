@@ -0,0 +1,182 @@
+package ttsweb
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// -----------------------------------------------------------------------------
+// Markup
+// -----------------------------------------------------------------------------
+
+// The Go splitters keep the structure of a paragraph as lightweight markup,
+// so that it can be read with SSML by the synthesizers that support it. The
+// markup of a paragraph is a single line:
+//
+//   - "# ", "## " and so on start a heading of that level.
+//   - "> " starts a block quote.
+//   - "*text*" is emphasized and "**text**" is strongly emphasized.
+//   - "\" escapes the character that follows it.
+//
+// The markup is stored in markup/{id}.txt in the document directory. Only the
+// paragraphs with markup have a file, the others are read as plain text.
+
+// markupDir will return the directory that the markup of the paragraphs that
+// are split into the paragraphs directory is written to.
+func markupDir(paragraphsDir string) string {
+	return filepath.Join(filepath.Dir(filepath.Clean(paragraphsDir)), "markup")
+}
+
+// LoadParagraphMarkup will load the markup of the paragraph. No markup is
+// returned if the paragraph has none.
+func LoadParagraphMarkup(documentsDir, documentID, paragraphID string) (string, error) {
+	data, err := ioutil.ReadFile(path.Join(documentsDir, documentID, "markup", paragraphID+".txt"))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// markupEscaper escapes the characters of plain text that have a meaning in
+// markup.
+var markupEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`)
+
+// escapeMarkup will escape the plain text so that it is read as it is.
+func escapeMarkup(text string) string {
+	text = markupEscaper.Replace(text)
+	if strings.HasPrefix(text, "#") || strings.HasPrefix(text, ">") {
+		text = `\` + text
+	}
+	return text
+}
+
+// blockMarkup will return the markup of the block, including the heading or
+// quote marker.
+func blockMarkup(block Block) string {
+	markup := collapseSpace(block.Markup)
+	if markup == "" {
+		markup = escapeMarkup(collapseSpace(block.Text))
+	}
+	switch {
+	case block.HeadingLevel > 0:
+		return strings.Repeat("#", block.HeadingLevel) + " " + markup
+	case block.Quote:
+		return "> " + markup
+	}
+	return markup
+}
+
+// markupParagraph is a paragraph that has been parsed from its markup.
+type markupParagraph struct {
+	HeadingLevel int
+	Quote        bool
+	Spans        []markupSpan
+}
+
+// markupSpan is a run of text with the same emphasis. Emphasis is 0 for
+// plain text, 1 for emphasized text and 2 for strongly emphasized text.
+type markupSpan struct {
+	Text     string
+	Emphasis int
+}
+
+// parseMarkup will parse the markup of a paragraph. Emphasis that is not
+// closed lasts until the end of the paragraph.
+func parseMarkup(markup string) markupParagraph {
+	p := markupParagraph{}
+
+	// Read the heading or quote marker.
+	if level := len(markup) - len(strings.TrimLeft(markup, "#")); level > 0 && strings.HasPrefix(markup[level:], " ") {
+		p.HeadingLevel = level
+		markup = markup[level+1:]
+	} else if strings.HasPrefix(markup, "> ") {
+		p.Quote = true
+		markup = markup[2:]
+	}
+
+	// Split the text into spans at the emphasis markers.
+	var text strings.Builder
+	emphasis, strong := false, false
+	flush := func() {
+		if text.Len() == 0 {
+			return
+		}
+		span := markupSpan{Text: text.String()}
+		switch {
+		case strong:
+			span.Emphasis = 2
+		case emphasis:
+			span.Emphasis = 1
+		}
+		p.Spans = append(p.Spans, span)
+		text.Reset()
+	}
+	for i := 0; i < len(markup); i++ {
+		switch {
+		case markup[i] == '\\' && i+1 < len(markup):
+			i++
+			text.WriteByte(markup[i])
+		case strings.HasPrefix(markup[i:], "**"):
+			flush()
+			strong = !strong
+			i++
+		case markup[i] == '*':
+			flush()
+			emphasis = !emphasis
+		default:
+			text.WriteByte(markup[i])
+		}
+	}
+	flush()
+
+	return p
+}
+
+// SSML will write the paragraph as an SSML document. The speak function
+// turns the text of each span into the SSML that is spoken, it must escape
+// the text. Headings are followed by a pause, emphasized text is read with
+// emphasis and block quotes are read a little slower and lower.
+func (p markupParagraph) SSML(speak func(text string) string) string {
+	var b strings.Builder
+	b.WriteString("<speak>")
+	if p.Quote {
+		b.WriteString(`<prosody rate="95%" pitch="-10%">`)
+	}
+	for _, span := range p.Spans {
+		switch span.Emphasis {
+		case 2:
+			b.WriteString(`<emphasis level="strong">` + speak(span.Text) + `</emphasis>`)
+		case 1:
+			b.WriteString(`<emphasis level="moderate">` + speak(span.Text) + `</emphasis>`)
+		default:
+			b.WriteString(speak(span.Text))
+		}
+	}
+	if p.Quote {
+		b.WriteString("</prosody>")
+	}
+	if p.HeadingLevel > 0 {
+		b.WriteString(`<break time="` + headingPause(p.HeadingLevel) + `"/>`)
+	}
+	b.WriteString("</speak>")
+	return b.String()
+}
+
+// headingPause will return the length of the pause after a heading. There is
+// a longer pause after a chapter title than after a section title.
+func headingPause(level int) string {
+	switch level {
+	case 1:
+		return "1500ms"
+	case 2:
+		return "1000ms"
+	default:
+		return "750ms"
+	}
+}
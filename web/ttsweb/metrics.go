@@ -0,0 +1,381 @@
+package ttsweb
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// diskUsageInterval is how long the disk usage of the documents directory is
+// kept before the directory is walked again, so that frequent scrapes do not
+// keep walking large directories.
+const diskUsageInterval = time.Minute
+
+var (
+	// httpBuckets are the upper bounds in seconds of the buckets of the HTTP
+	// request latencies.
+	httpBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+	// stageBuckets are the upper bounds in seconds of the buckets of the
+	// durations of the stages of the pipeline.
+	stageBuckets = []float64{0.1, 0.5, 1, 5, 15, 30, 60, 120, 300, 600, 1800, 3600}
+)
+
+// The metrics that are recorded while the server runs. They are written in the
+// Prometheus text format by the /metrics endpoint.
+var (
+	httpRequests = newCounterVec("ttsweb_http_requests_total",
+		"HTTP requests that have been served, by route, method and status code.", "route", "method", "code")
+	httpRequestDuration = newHistogramVec("ttsweb_http_request_duration_seconds",
+		"Time taken to serve HTTP requests, by route.", httpBuckets, "route")
+	uploads = newCounterVec("ttsweb_uploads_total",
+		"Documents that have been uploaded, including the watch folder.")
+	uploadBytes = newCounterVec("ttsweb_upload_bytes_total",
+		"Size of the documents that have been uploaded.")
+	stageDuration = newHistogramVec("ttsweb_stage_duration_seconds",
+		"Time taken by the stages of the pipeline, by stage.", stageBuckets, "stage")
+	paragraphsSynthesized = newCounterVec("ttsweb_paragraphs_synthesized_total",
+		"Paragraphs that have been synthesized, by TTS engine.", "engine")
+	synthesisSeconds = newCounterVec("ttsweb_synthesis_seconds_total",
+		"Time spent running the TTS engines, by TTS engine.", "engine")
+	synthesisFailures = newCounterVec("ttsweb_synthesis_failures_total",
+		"Paragraphs that the TTS engines failed to synthesize, by TTS engine.", "engine")
+	cacheLookups = newCounterVec("ttsweb_cache_lookups_total",
+		"Lookups of the audio info and sentence timing caches, by cache and hit or miss.", "cache", "result")
+)
+
+// observeStage will record the time taken by the stage of the pipeline that
+// was started at the time.
+func observeStage(stage string, started time.Time) {
+	stageDuration.observe(time.Since(started).Seconds(), stage)
+}
+
+// observeCache will record a hit or a miss of the cache.
+func observeCache(cache string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	cacheLookups.add(1, cache, result)
+}
+
+// -----------------------------------------------------------------------------
+// Request Routes
+// -----------------------------------------------------------------------------
+
+// routeKey is the context key of the route that a request is handled by.
+type routeKey struct{}
+
+// withRoute will return a copy of the context that the route of the request
+// can be recorded in by logRoute.
+func withRoute(ctx context.Context) (context.Context, *string) {
+	route := new(string)
+	return context.WithValue(ctx, routeKey{}, route), route
+}
+
+// setRoute will record the handler as the route of the request, if the
+// request is being measured.
+func setRoute(ctx context.Context, handler string) {
+	if route, ok := ctx.Value(routeKey{}).(*string); ok {
+		*route = handler
+	}
+}
+
+// observeRequest will record the request that was served by the route. The
+// method is only used as a label if it is one that the server handles.
+func observeRequest(route, method string, status int, duration time.Duration) {
+	if route == "" {
+		route = "other"
+	}
+	switch method {
+	case "GET", "HEAD", "POST", "PUT", "DELETE":
+	default:
+		method = "other"
+	}
+	httpRequests.add(1, route, method, strconv.Itoa(status))
+	httpRequestDuration.observe(duration.Seconds(), route)
+}
+
+// -----------------------------------------------------------------------------
+// Metric Types
+// -----------------------------------------------------------------------------
+
+// counterVec is a counter with a value for each combination of label values.
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// newCounterVec will create a counter with the labels.
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: map[string]float64{}}
+}
+
+// add will add the value to the counter of the label values.
+func (c *counterVec) add(value float64, labelValues ...string) {
+	key := formatLabels(c.labels, labelValues)
+	c.mu.Lock()
+	c.values[key] += value
+	c.mu.Unlock()
+}
+
+// write will write the counter in the Prometheus text format.
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	if len(c.labels) == 0 {
+		fmt.Fprintf(w, "%s %s\n", c.name, formatValue(c.values[""]))
+		return
+	}
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s{%s} %s\n", c.name, key, formatValue(c.values[key]))
+	}
+}
+
+// histogramVec is a histogram with a series for each combination of label
+// values.
+type histogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogram
+}
+
+// histogram counts the observations below each of the bucket bounds.
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// newHistogramVec will create a histogram with the bucket bounds and the
+// labels.
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, buckets: buckets, series: map[string]*histogram{}}
+}
+
+// observe will add the value to the histogram of the label values.
+func (h *histogramVec) observe(value float64, labelValues ...string) {
+	key := formatLabels(h.labels, labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	series, ok := h.series[key]
+	if !ok {
+		series = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = series
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			series.counts[i]++
+		}
+	}
+	series.count++
+	series.sum += value
+}
+
+// write will write the histogram in the Prometheus text format.
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		series := h.series[key]
+		prefix := key
+		if prefix != "" {
+			prefix += ","
+		}
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket{%sle=\"%s\"} %d\n", h.name, prefix, formatValue(bound), series.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", h.name, prefix, series.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, braces(key), formatValue(series.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, braces(key), series.count)
+	}
+}
+
+// gaugeSample is a value of a gauge with its label values.
+type gaugeSample struct {
+	labelValues []string
+	value       float64
+}
+
+// writeGauge will write the samples of a gauge, that are measured when the
+// metrics are read, in the Prometheus text format.
+func writeGauge(w io.Writer, name, help string, labels []string, samples []gaugeSample) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	for _, sample := range samples {
+		fmt.Fprintf(w, "%s%s %s\n", name, braces(formatLabels(labels, sample.labelValues)), formatValue(sample.value))
+	}
+}
+
+// formatLabels will join the labels and their values into the form that is
+// written between the braces of a sample, e.g. route="x",code="200".
+func formatLabels(labels, values []string) string {
+	pairs := make([]string, len(labels))
+	for i, label := range labels {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs[i] = label + `="` + escapeLabelValue(value) + `"`
+	}
+	return strings.Join(pairs, ",")
+}
+
+// escapeLabelValue will escape the backslashes, quotes and new lines of a
+// label value.
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// braces will wrap the labels in braces, or return nothing if there are no
+// labels.
+func braces(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+// formatValue will format the value of a sample.
+func formatValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// sortedKeys will return the keys of the values in order.
+func sortedKeys(values map[string]float64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// -----------------------------------------------------------------------------
+// Metrics
+// -----------------------------------------------------------------------------
+
+// diskUsage is the size of the documents directory when it was last walked.
+type diskUsage struct {
+	mu       sync.Mutex
+	dir      string
+	bytes    int64
+	files    int64
+	measured time.Time
+}
+
+// documentsDiskUsage is the disk usage of the documents directory.
+var documentsDiskUsage diskUsage
+
+// measure will return the size and the number of files of the directory,
+// walking it again if it was last walked over the disk usage interval ago.
+func (u *diskUsage) measure(dir string) (int64, int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.dir == dir && time.Since(u.measured) < diskUsageInterval {
+		return u.bytes, u.files
+	}
+	var bytes, files int64
+	filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return nil
+		}
+		if info, err := entry.Info(); err == nil {
+			bytes += info.Size()
+			files++
+		}
+		return nil
+	})
+	u.dir, u.bytes, u.files, u.measured = dir, bytes, files, time.Now()
+	return bytes, files
+}
+
+// WriteMetrics will write the metrics of the documents and the pipeline in the
+// Prometheus text format. The gauges are measured as they are written.
+func (d *DocumentsInfo) WriteMetrics(w io.Writer) error {
+	buffered := bufio.NewWriter(w)
+
+	httpRequests.write(buffered)
+	httpRequestDuration.write(buffered)
+	uploads.write(buffered)
+	uploadBytes.write(buffered)
+	stageDuration.write(buffered)
+	paragraphsSynthesized.write(buffered)
+	synthesisSeconds.write(buffered)
+	synthesisFailures.write(buffered)
+	cacheLookups.write(buffered)
+
+	// Documents start processing as soon as they are uploaded, so the jobs
+	// that are running and the paragraphs that they have left are the
+	// backlog of the server.
+	running := map[string]float64{JobProcess: 0, JobResynthesize: 0}
+	remaining := 0.0
+	if jobs := d.Pipeline().Jobs; jobs != nil {
+		for _, job := range jobs.List("") {
+			if job.Status != JobRunning {
+				continue
+			}
+			running[job.Type]++
+			if job.Stage == StageSynthesize {
+				remaining += float64(job.Total - job.Done)
+			}
+		}
+	}
+	writeGauge(buffered, "ttsweb_jobs_running", "Jobs that are running, by type.", []string{"type"}, []gaugeSample{
+		{labelValues: []string{JobProcess}, value: running[JobProcess]},
+		{labelValues: []string{JobResynthesize}, value: running[JobResynthesize]},
+	})
+	writeGauge(buffered, "ttsweb_jobs_paragraphs_remaining", "Paragraphs that the running jobs have left to synthesize.", nil, []gaugeSample{
+		{value: remaining},
+	})
+
+	// Count the documents by status.
+	statuses := map[string]float64{}
+	d.mu.RLock()
+	for _, document := range d.Documents {
+		statuses[document.Status]++
+	}
+	d.mu.RUnlock()
+	samples := []gaugeSample{}
+	for _, status := range sortedKeys(statuses) {
+		samples = append(samples, gaugeSample{labelValues: []string{status}, value: statuses[status]})
+	}
+	writeGauge(buffered, "ttsweb_documents", "Documents, by status.", []string{"status"}, samples)
+
+	// Measure the disk usage of the documents directory.
+	bytes, files := documentsDiskUsage.measure(d.documentsDir)
+	writeGauge(buffered, "ttsweb_documents_disk_bytes", "Size of the files in the documents directory.", nil, []gaugeSample{
+		{value: float64(bytes)},
+	})
+	writeGauge(buffered, "ttsweb_documents_disk_files", "Files in the documents directory.", nil, []gaugeSample{
+		{value: float64(files)},
+	})
+
+	return buffered.Flush()
+}
@@ -0,0 +1,195 @@
+package ttsweb
+
+import (
+	"strconv"
+	"strings"
+)
+
+// -----------------------------------------------------------------------------
+// Number Words
+// -----------------------------------------------------------------------------
+
+var (
+	smallNumberWords = []string{
+		"zero", "one", "two", "three", "four", "five", "six", "seven", "eight",
+		"nine", "ten", "eleven", "twelve", "thirteen", "fourteen", "fifteen",
+		"sixteen", "seventeen", "eighteen", "nineteen",
+	}
+	tensWords = []string{
+		"", "", "twenty", "thirty", "forty", "fifty", "sixty", "seventy",
+		"eighty", "ninety",
+	}
+	scaleWords = []string{"", "thousand", "million", "billion", "trillion"}
+
+	// irregularOrdinals are the number words whose ordinal is not made by
+	// adding "th".
+	irregularOrdinals = map[string]string{
+		"one": "first", "two": "second", "three": "third", "five": "fifth",
+		"eight": "eighth", "nine": "ninth", "twelve": "twelfth",
+	}
+)
+
+// maxNumberWords is the largest number that is read as words. Larger numbers
+// are read digit by digit.
+const maxNumberWords = 999999999999999
+
+// numberWords will write the number in English words, e.g. 123 is "one hundred
+// twenty-three".
+func numberWords(n int64) string {
+	if n < 0 {
+		return "minus " + numberWords(-n)
+	}
+	if n < 20 {
+		return smallNumberWords[n]
+	}
+	if n < 100 {
+		if n%10 == 0 {
+			return tensWords[n/10]
+		}
+		return tensWords[n/10] + "-" + smallNumberWords[n%10]
+	}
+	if n < 1000 {
+		words := smallNumberWords[n/100] + " hundred"
+		if n%100 != 0 {
+			words += " " + numberWords(n%100)
+		}
+		return words
+	}
+
+	// Write each group of three digits followed by its scale.
+	groups := []string{}
+	for scale := 0; n > 0; scale++ {
+		if group := n % 1000; group != 0 {
+			words := numberWords(group)
+			if scaleWords[scale] != "" {
+				words += " " + scaleWords[scale]
+			}
+			groups = append([]string{words}, groups...)
+		}
+		n /= 1000
+	}
+	return strings.Join(groups, " ")
+}
+
+// ordinalWords will write the ordinal of the number in English words, e.g. 21
+// is "twenty-first".
+func ordinalWords(n int64) string {
+	return toOrdinal(numberWords(n))
+}
+
+// toOrdinal will change the last word of the number words into its ordinal.
+func toOrdinal(words string) string {
+	cut := strings.LastIndexAny(words, " -") + 1
+	last := words[cut:]
+	switch {
+	case irregularOrdinals[last] != "":
+		last = irregularOrdinals[last]
+	case strings.HasSuffix(last, "y"):
+		last = strings.TrimSuffix(last, "y") + "ieth"
+	default:
+		last += "th"
+	}
+	return words[:cut] + last
+}
+
+// toPlural will change the last word of the number words into its plural,
+// e.g. "nineteen eighties".
+func toPlural(words string) string {
+	if strings.HasSuffix(words, "y") {
+		return strings.TrimSuffix(words, "y") + "ies"
+	}
+	if strings.HasSuffix(words, "x") {
+		return words + "es"
+	}
+	return words + "s"
+}
+
+// yearWords will write the year in the way that it is spoken, e.g. 1984 is
+// "nineteen eighty-four" and 2005 is "two thousand five".
+func yearWords(n int64) string {
+	if n < 1000 || n > 2099 || (n >= 2000 && n < 2010) || n%1000 == 0 {
+		return numberWords(n)
+	}
+	century, rest := n/100, n%100
+	switch {
+	case rest == 0:
+		return numberWords(century) + " hundred"
+	case rest < 10:
+		return numberWords(century) + " oh " + numberWords(rest)
+	default:
+		return numberWords(century) + " " + numberWords(rest)
+	}
+}
+
+// digitWords will read each digit of the number, e.g. "14" is "one four".
+func digitWords(digits string) string {
+	words := []string{}
+	for _, digit := range digits {
+		if digit >= '0' && digit <= '9' {
+			words = append(words, smallNumberWords[digit-'0'])
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// integerWords will read a string of digits as a number, or digit by digit if
+// it is too large to be read as words.
+func integerWords(digits string) string {
+	n, err := strconv.ParseInt(strings.ReplaceAll(digits, ",", ""), 10, 64)
+	if err != nil || n > maxNumberWords {
+		return digitWords(digits)
+	}
+	return numberWords(n)
+}
+
+// -----------------------------------------------------------------------------
+// Roman Numerals
+// -----------------------------------------------------------------------------
+
+// romanValues are the values of the roman numeral digits.
+var romanValues = map[byte]int{'I': 1, 'V': 5, 'X': 10, 'L': 50, 'C': 100, 'D': 500, 'M': 1000}
+
+// parseRoman will parse an upper case roman numeral. Only numerals written in
+// the standard form are accepted, so that words such as "DID" or "CIVIC" are
+// not mistaken for numbers. Words that are also numerals, e.g. "MIX", must be
+// ruled out by the caller.
+func parseRoman(s string) (int, bool) {
+	if s == "" {
+		return 0, false
+	}
+	n := 0
+	for i := 0; i < len(s); i++ {
+		value, ok := romanValues[s[i]]
+		if !ok {
+			return 0, false
+		}
+		if i+1 < len(s) && romanValues[s[i+1]] > value {
+			n -= value
+		} else {
+			n += value
+		}
+	}
+	if n <= 0 || n >= 4000 || formatRoman(n) != s {
+		return 0, false
+	}
+	return n, true
+}
+
+// formatRoman will write the number as a roman numeral.
+func formatRoman(n int) string {
+	numerals := []struct {
+		value   int
+		numeral string
+	}{
+		{1000, "M"}, {900, "CM"}, {500, "D"}, {400, "CD"}, {100, "C"}, {90, "XC"},
+		{50, "L"}, {40, "XL"}, {10, "X"}, {9, "IX"}, {5, "V"}, {4, "IV"}, {1, "I"},
+	}
+	var b strings.Builder
+	for _, numeral := range numerals {
+		for n >= numeral.value {
+			b.WriteString(numeral.numeral)
+			n -= numeral.value
+		}
+	}
+	return b.String()
+}
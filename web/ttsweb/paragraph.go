@@ -1,9 +1,11 @@
 package ttsweb
 
 import (
-	"fmt"
+	"encoding/json"
 	"io/ioutil"
+	"log/slog"
 	"os"
+	"path"
 	"sort"
 	"strconv"
 	"strings"
@@ -42,6 +44,21 @@ type ParagraphInfo struct {
 
 	Link      string `json:"link"`
 	AudioLink string `json:"audioLink"`
+
+	// Language of the paragraph. Paragraphs are synthesized by the voice for
+	// their language.
+	Language string `json:"language,omitempty"`
+
+	// Stale is set when the pronunciation of a word in the paragraph has
+	// changed since it was synthesized.
+	Stale bool `json:"stale,omitempty"`
+
+	// Class of the paragraph, e.g. body, heading or footnote.
+	Class string `json:"class,omitempty"`
+
+	// Skip is set when the document skips the class of the paragraph. The
+	// paragraph is not synthesized and is skipped during playback.
+	Skip bool `json:"skip,omitempty"`
 }
 
 // LoadParagraphs will load all of the paragraphs from the paragraphs directory.
@@ -67,6 +84,17 @@ func LoadParagraphInfos(documentsDir, documentID string) ([]ParagraphInfo, error
 		paragraphs = append(paragraphs, paragraph)
 	}
 
+	// Add the information that was found while processing the document.
+	meta, err := LoadParagraphMeta(documentsDir, documentID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range paragraphs {
+		paragraphs[i].Language = meta[paragraphs[i].ID].Language
+		paragraphs[i].Stale = meta[paragraphs[i].ID].Stale
+		paragraphs[i].Class = meta[paragraphs[i].ID].Class
+	}
+
 	pil := ParagraphInfoList(paragraphs)
 	sort.Sort(pil)
 
@@ -101,6 +129,52 @@ func LoadParagraphInfo(documentsDir, documentID string, paragraphID string) (Par
 	return paragraph, nil
 }
 
+// -----------------------------------------------------------------------------
+// Paragraph Meta
+// -----------------------------------------------------------------------------
+
+// ParagraphMeta is the information about a paragraph that is found while the
+// document is processed. The meta of all of the paragraphs of a document is
+// stored in the paragraphs.json file in the document directory.
+type ParagraphMeta struct {
+	Language string `json:"language,omitempty"`
+	Stale    bool   `json:"stale,omitempty"`
+	Class    string `json:"class,omitempty"`
+}
+
+// paragraphMetaPath will return the path to the paragraphs.json file of the
+// document.
+func paragraphMetaPath(documentsDir, documentID string) string {
+	return path.Join(documentsDir, documentID, "paragraphs.json")
+}
+
+// LoadParagraphMeta will load the meta of the paragraphs of the document,
+// keyed by paragraph ID. An empty map is returned if the document has not
+// been processed yet.
+func LoadParagraphMeta(documentsDir, documentID string) (map[string]ParagraphMeta, error) {
+	meta := map[string]ParagraphMeta{}
+	data, err := ioutil.ReadFile(paragraphMetaPath(documentsDir, documentID))
+	if os.IsNotExist(err) {
+		return meta, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// SaveParagraphMeta will write the meta of the paragraphs of the document.
+func SaveParagraphMeta(documentsDir, documentID string, meta map[string]ParagraphMeta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(paragraphMetaPath(documentsDir, documentID), data, 0644)
+}
+
 // -----------------------------------------------------------------------------
 // Paragraphs
 // -----------------------------------------------------------------------------
@@ -132,6 +206,10 @@ type Paragraph struct {
 	ParagraphInfo
 
 	Content string `json:"content"`
+
+	// Sentences of the paragraph along with the time that each sentence is
+	// spoken in the paragraph audio.
+	Sentences []Sentence `json:"sentences"`
 }
 
 // LoadParagraph will load a paragraph from the paragraphs directory.
@@ -155,6 +233,21 @@ func LoadParagraph(documentsDir, documentID string, paragraphID string) (Paragra
 	paragraph.Link = "/documents/" + documentID + "/paragraphs/" + paragraph.ID
 	paragraph.AudioLink = "/documents/" + documentID + "/paragraphs/" + paragraph.ID + "/audio"
 
+	// Load the information that was found while processing the document.
+	meta, err := LoadParagraphMeta(documentsDir, documentID)
+	if err != nil {
+		return paragraph, err
+	}
+	paragraph.Language = meta[paragraph.ID].Language
+	paragraph.Stale = meta[paragraph.ID].Stale
+	paragraph.Class = meta[paragraph.ID].Class
+
+	// Load the sentences of the paragraph.
+	paragraph.Sentences, err = LoadSentences(documentsDir, documentID, paragraph.ID, paragraph.Content)
+	if err != nil {
+		return paragraph, err
+	}
+
 	// Return the paragraph.
 	return paragraph, nil
 }
@@ -167,10 +260,10 @@ func LoadParagraphBatch(documentsDir, documentID string, paragraphIDs []string)
 	for _, paragraphID := range paragraphIDs {
 		paragraph, err := LoadParagraph(documentsDir, documentID, paragraphID)
 		if err != nil {
-			// Print the error and continue. It might be that the paragraph
+			// Log the error and continue. It might be that the paragraph
 			// was missing from the directory. We don't want to stop the
 			// whole batch just because one paragraph is missing.
-			fmt.Println(err)
+			slog.Warn("Unable to load paragraph", "document_id", documentID, "paragraph_id", paragraphID, "error", err)
 			continue
 		}
 		paragraphs = append(paragraphs, paragraph)
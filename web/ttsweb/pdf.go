@@ -0,0 +1,675 @@
+package ttsweb
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"encoding/ascii85"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// -----------------------------------------------------------------------------
+// PDF Objects
+// -----------------------------------------------------------------------------
+
+// The PDF objects are read into Go values. Numbers are float64, booleans are
+// bool and null is nil. The other objects have a type of their own.
+type (
+	pdfName    string
+	pdfString  string
+	pdfKeyword string
+	pdfArray   []interface{}
+	pdfDict    map[pdfName]interface{}
+)
+
+// pdfRef is a reference to an indirect object.
+type pdfRef struct {
+	Num, Gen int
+}
+
+// pdfStream is a stream object. The data is kept encoded until it is needed.
+type pdfStream struct {
+	Dict pdfDict
+	Data []byte
+}
+
+// -----------------------------------------------------------------------------
+// PDF Lexer
+// -----------------------------------------------------------------------------
+
+// pdfLexer reads the tokens and objects of PDF files and content streams.
+type pdfLexer struct {
+	data []byte
+	pos  int
+
+	// peeked are the tokens that have been read ahead and put back, the
+	// last token is read first.
+	peeked []interface{}
+}
+
+// isPDFSpace will check if the byte is PDF white space.
+func isPDFSpace(c byte) bool {
+	return c == 0 || c == '\t' || c == '\n' || c == '\f' || c == '\r' || c == ' '
+}
+
+// isPDFDelimiter will check if the byte ends a PDF name, number or keyword.
+func isPDFDelimiter(c byte) bool {
+	return strings.IndexByte("()<>[]{}/%", c) >= 0
+}
+
+// skipSpace will move past white space and comments.
+func (l *pdfLexer) skipSpace() {
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		if isPDFSpace(c) {
+			l.pos++
+			continue
+		}
+		if c == '%' {
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' && l.data[l.pos] != '\r' {
+				l.pos++
+			}
+			continue
+		}
+		return
+	}
+}
+
+// unread will put the token back so that it is read again.
+func (l *pdfLexer) unread(token interface{}) {
+	l.peeked = append(l.peeked, token)
+}
+
+// token will read the next token. Delimiters such as "[" and "<<" are
+// returned as keywords.
+func (l *pdfLexer) token() (interface{}, error) {
+	if n := len(l.peeked); n > 0 {
+		token := l.peeked[n-1]
+		l.peeked = l.peeked[:n-1]
+		return token, nil
+	}
+
+	l.skipSpace()
+	if l.pos >= len(l.data) {
+		return nil, io.EOF
+	}
+	c := l.data[l.pos]
+	switch c {
+	case '(':
+		return l.literalString(), nil
+	case '<':
+		if l.pos+1 < len(l.data) && l.data[l.pos+1] == '<' {
+			l.pos += 2
+			return pdfKeyword("<<"), nil
+		}
+		return l.hexString(), nil
+	case '>':
+		if l.pos+1 < len(l.data) && l.data[l.pos+1] == '>' {
+			l.pos += 2
+			return pdfKeyword(">>"), nil
+		}
+		l.pos++
+		return pdfKeyword(">"), nil
+	case '[', ']', '{', '}', ')':
+		l.pos++
+		return pdfKeyword(string(c)), nil
+	case '/':
+		return l.name(), nil
+	}
+
+	// Read a number or a keyword.
+	start := l.pos
+	for l.pos < len(l.data) && !isPDFSpace(l.data[l.pos]) && !isPDFDelimiter(l.data[l.pos]) {
+		l.pos++
+	}
+	word := string(l.data[start:l.pos])
+	if c == '+' || c == '-' || c == '.' || (c >= '0' && c <= '9') {
+		if n, err := strconv.ParseFloat(word, 64); err == nil {
+			return n, nil
+		}
+	}
+	return pdfKeyword(word), nil
+}
+
+// literalString will read a string in parentheses.
+func (l *pdfLexer) literalString() pdfString {
+	l.pos++
+	b := []byte{}
+	depth := 1
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return pdfString(b)
+			}
+		case '\\':
+			if l.pos >= len(l.data) {
+				continue
+			}
+			c = l.data[l.pos]
+			l.pos++
+			switch c {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r':
+				// A backslash at the end of a line continues the string.
+				if l.pos < len(l.data) && l.data[l.pos] == '\n' {
+					l.pos++
+				}
+				continue
+			case '\n':
+				continue
+			case '0', '1', '2', '3', '4', '5', '6', '7':
+				n := int(c - '0')
+				for i := 0; i < 2 && l.pos < len(l.data) && l.data[l.pos] >= '0' && l.data[l.pos] <= '7'; i++ {
+					n = n*8 + int(l.data[l.pos]-'0')
+					l.pos++
+				}
+				c = byte(n)
+			}
+		}
+		b = append(b, c)
+	}
+	return pdfString(b)
+}
+
+// hexString will read a string of hex digits in angle brackets.
+func (l *pdfLexer) hexString() pdfString {
+	l.pos++
+	digits := []byte{}
+	for l.pos < len(l.data) && l.data[l.pos] != '>' {
+		if c := l.data[l.pos]; strings.IndexByte("0123456789abcdefABCDEF", c) >= 0 {
+			digits = append(digits, c)
+		}
+		l.pos++
+	}
+	l.pos++
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	b, _ := hex.DecodeString(string(digits))
+	return pdfString(b)
+}
+
+// name will read a name, e.g. /Type. Characters escaped as #xx are decoded.
+func (l *pdfLexer) name() pdfName {
+	l.pos++
+	b := []byte{}
+	for l.pos < len(l.data) && !isPDFSpace(l.data[l.pos]) && !isPDFDelimiter(l.data[l.pos]) {
+		c := l.data[l.pos]
+		if c == '#' && l.pos+2 < len(l.data) {
+			if n, err := strconv.ParseUint(string(l.data[l.pos+1:l.pos+3]), 16, 8); err == nil {
+				b = append(b, byte(n))
+				l.pos += 3
+				continue
+			}
+		}
+		b = append(b, c)
+		l.pos++
+	}
+	return pdfName(b)
+}
+
+// object will read the next object. Keywords that are not part of an object,
+// such as the operators of content streams, are returned as keywords.
+func (l *pdfLexer) object() (interface{}, error) {
+	token, err := l.token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t := token.(type) {
+	case float64:
+		// Check if the number starts a reference, e.g. "12 0 R".
+		if t < 0 || t != float64(int(t)) {
+			return t, nil
+		}
+		gen, err := l.token()
+		if err != nil {
+			return t, nil
+		}
+		if g, ok := gen.(float64); ok {
+			r, err := l.token()
+			if err == nil {
+				if k, ok := r.(pdfKeyword); ok && k == "R" {
+					return pdfRef{Num: int(t), Gen: int(g)}, nil
+				}
+				l.unread(r)
+			}
+		}
+		l.unread(gen)
+		return t, nil
+
+	case pdfKeyword:
+		switch t {
+		case "[":
+			array := pdfArray{}
+			for {
+				o, err := l.object()
+				if err != nil {
+					return array, err
+				}
+				if k, ok := o.(pdfKeyword); ok && k == "]" {
+					return array, nil
+				}
+				array = append(array, o)
+			}
+		case "<<":
+			dict := pdfDict{}
+			for {
+				o, err := l.object()
+				if err != nil {
+					return dict, err
+				}
+				if k, ok := o.(pdfKeyword); ok && k == ">>" {
+					return dict, nil
+				}
+				key, ok := o.(pdfName)
+				if !ok {
+					continue
+				}
+				value, err := l.object()
+				if err != nil {
+					return dict, err
+				}
+				if k, ok := value.(pdfKeyword); ok && k == ">>" {
+					return dict, nil
+				}
+				dict[key] = value
+			}
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+	}
+	return token, nil
+}
+
+// stream will read the data of the stream if the dictionary is followed by
+// one. The Length of the stream is used if it is correct, otherwise the data
+// runs up to the endstream keyword.
+func (l *pdfLexer) stream(obj interface{}) interface{} {
+	dict, ok := obj.(pdfDict)
+	if !ok || len(l.peeked) > 0 {
+		return obj
+	}
+	start := l.pos
+	token, err := l.token()
+	if k, ok := token.(pdfKeyword); err != nil || !ok || k != "stream" {
+		l.pos = start
+		return obj
+	}
+
+	// The data starts after the end of the line.
+	if l.pos < len(l.data) && l.data[l.pos] == '\r' {
+		l.pos++
+	}
+	if l.pos < len(l.data) && l.data[l.pos] == '\n' {
+		l.pos++
+	}
+	start = l.pos
+	end := -1
+	if n, ok := dict["Length"].(float64); ok {
+		if e := start + int(n); e >= start && e <= len(l.data) &&
+			bytes.HasPrefix(bytes.TrimLeft(l.data[e:], "\r\n\t "), []byte("endstream")) {
+			end = e
+		}
+	}
+	if end < 0 {
+		end = len(l.data)
+		if i := bytes.Index(l.data[start:], []byte("endstream")); i >= 0 {
+			end = start + i
+			for end > start && (l.data[end-1] == '\n' || l.data[end-1] == '\r') {
+				end--
+			}
+		}
+	}
+
+	l.pos = end
+	if i := bytes.Index(l.data[end:], []byte("endstream")); i >= 0 {
+		l.pos = end + i + len("endstream")
+	}
+	return &pdfStream{Dict: dict, Data: l.data[start:end]}
+}
+
+// -----------------------------------------------------------------------------
+// PDF Documents
+// -----------------------------------------------------------------------------
+
+// pdfDocument is the objects of a PDF file.
+type pdfDocument struct {
+	objects map[int]interface{}
+	trailer pdfDict
+
+	// inflated is the number of bytes that have been decompressed from the
+	// streams of the document so far.
+	inflated int
+
+	// err is set once the document is too large to be read any further.
+	err error
+}
+
+// maxPDFInflatedSize is the most data that is decompressed from the streams of
+// a document, so that a small file cannot be made to fill the memory of the
+// server.
+const maxPDFInflatedSize = 256 << 20
+
+// errPDFTooLarge is returned once the streams of a document decompress to
+// more than maxPDFInflatedSize.
+var errPDFTooLarge = fmt.Errorf("PDF streams decompress to more than %d bytes", maxPDFInflatedSize)
+
+var (
+	pdfObjectHeader = regexp.MustCompile(`(\d+)\s+\d+\s+obj\b`)
+	pdfTrailer      = regexp.MustCompile(`trailer\s*<<`)
+)
+
+// parsePDF will read the objects of the PDF file. Rather than trusting the
+// cross reference table, which is often broken, the file is scanned for the
+// objects. Objects that are defined again by later updates to the file
+// replace the earlier ones.
+func parsePDF(data []byte) (*pdfDocument, error) {
+	if !bytes.Contains(data[:minInt(len(data), 1024)], []byte("%PDF-")) {
+		return nil, fmt.Errorf("not a PDF file")
+	}
+	doc := &pdfDocument{objects: map[int]interface{}{}, trailer: pdfDict{}}
+
+	// Read the objects.
+	for pos := 0; pos < len(data); {
+		loc := pdfObjectHeader.FindSubmatchIndex(data[pos:])
+		if loc == nil {
+			break
+		}
+		num, _ := strconv.Atoi(string(data[pos+loc[2] : pos+loc[3]]))
+		l := &pdfLexer{data: data, pos: pos + loc[1]}
+		obj, err := l.object()
+		if err == nil {
+			doc.objects[num] = l.stream(obj)
+		}
+		if l.pos > pos+loc[1] {
+			pos = l.pos
+		} else {
+			pos += loc[1]
+		}
+	}
+
+	// Read the trailers. Later trailers replace the entries of earlier ones.
+	for _, loc := range pdfTrailer.FindAllIndex(data, -1) {
+		l := &pdfLexer{data: data, pos: loc[1] - 2}
+		if dict, ok := doc.mustObject(l).(pdfDict); ok {
+			for key, value := range dict {
+				doc.trailer[key] = value
+			}
+		}
+	}
+
+	// Files with cross reference streams keep the trailer entries in the
+	// dictionary of the stream, and most of the objects in object streams.
+	streams := []*pdfStream{}
+	for _, obj := range doc.objects {
+		if s, ok := obj.(*pdfStream); ok {
+			streams = append(streams, s)
+		}
+	}
+	for _, s := range streams {
+		switch doc.name(s.Dict["Type"]) {
+		case "XRef":
+			for _, key := range []pdfName{"Root", "Encrypt"} {
+				if _, ok := doc.trailer[key]; !ok && s.Dict[key] != nil {
+					doc.trailer[key] = s.Dict[key]
+				}
+			}
+		case "ObjStm":
+			doc.readObjectStream(s)
+		}
+	}
+
+	if doc.trailer["Encrypt"] != nil {
+		return nil, fmt.Errorf("encrypted PDF files are not supported")
+	}
+	if doc.err != nil {
+		return nil, doc.err
+	}
+	return doc, nil
+}
+
+// mustObject will read the next object, or nil if there is none.
+func (d *pdfDocument) mustObject(l *pdfLexer) interface{} {
+	obj, err := l.object()
+	if err != nil {
+		return nil
+	}
+	return obj
+}
+
+// readObjectStream will read the objects that are compressed in an object
+// stream. Objects that were found in the file itself are kept.
+func (d *pdfDocument) readObjectStream(s *pdfStream) {
+	data, err := d.decodeStream(s)
+	if err != nil {
+		return
+	}
+	n, _ := d.number(s.Dict["N"])
+	first, _ := d.number(s.Dict["First"])
+
+	// The stream starts with pairs of object numbers and offsets.
+	header := &pdfLexer{data: data}
+	for i := 0; i < int(n); i++ {
+		num, ok1 := d.mustObject(header).(float64)
+		offset, ok2 := d.mustObject(header).(float64)
+		if !ok1 || !ok2 {
+			return
+		}
+		if _, ok := d.objects[int(num)]; ok {
+			continue
+		}
+		l := &pdfLexer{data: data, pos: int(first) + int(offset)}
+		if l.pos < 0 || l.pos >= len(data) {
+			continue
+		}
+		if obj, err := l.object(); err == nil {
+			d.objects[int(num)] = obj
+		}
+	}
+}
+
+// resolve will follow the references to the object.
+func (d *pdfDocument) resolve(obj interface{}) interface{} {
+	for i := 0; i < 32; i++ {
+		ref, ok := obj.(pdfRef)
+		if !ok {
+			return obj
+		}
+		obj = d.objects[ref.Num]
+	}
+	return nil
+}
+
+// dict will resolve the object as a dictionary. The dictionary of a stream is
+// returned for streams.
+func (d *pdfDocument) dict(obj interface{}) pdfDict {
+	switch v := d.resolve(obj).(type) {
+	case pdfDict:
+		return v
+	case *pdfStream:
+		return v.Dict
+	}
+	return nil
+}
+
+// array will resolve the object as an array.
+func (d *pdfDocument) array(obj interface{}) pdfArray {
+	array, _ := d.resolve(obj).(pdfArray)
+	return array
+}
+
+// number will resolve the object as a number.
+func (d *pdfDocument) number(obj interface{}) (float64, bool) {
+	n, ok := d.resolve(obj).(float64)
+	return n, ok
+}
+
+// name will resolve the object as a name.
+func (d *pdfDocument) name(obj interface{}) pdfName {
+	name, _ := d.resolve(obj).(pdfName)
+	return name
+}
+
+// catalog will return the root object of the document.
+func (d *pdfDocument) catalog() pdfDict {
+	if root := d.dict(d.trailer["Root"]); root != nil {
+		return root
+	}
+	for _, obj := range d.objects {
+		if dict, ok := obj.(pdfDict); ok && d.name(dict["Type"]) == "Catalog" {
+			return dict
+		}
+	}
+	return nil
+}
+
+// pages will return the pages of the document in order. The resources that
+// are inherited from the page tree are copied into the pages.
+func (d *pdfDocument) pages() []pdfDict {
+	pages := []pdfDict{}
+	var walk func(node pdfDict, resources interface{}, depth int)
+	walk = func(node pdfDict, resources interface{}, depth int) {
+		if node == nil || depth > 64 {
+			return
+		}
+		if node["Resources"] != nil {
+			resources = node["Resources"]
+		}
+		if kids := d.array(node["Kids"]); kids != nil && d.name(node["Type"]) != "Page" {
+			for _, kid := range kids {
+				walk(d.dict(kid), resources, depth+1)
+			}
+			return
+		}
+		page := pdfDict{}
+		for key, value := range node {
+			page[key] = value
+		}
+		page["Resources"] = resources
+		pages = append(pages, page)
+	}
+	if catalog := d.catalog(); catalog != nil {
+		walk(d.dict(catalog["Pages"]), nil, 0)
+	}
+	return pages
+}
+
+// pageContent will return the decoded content streams of the page joined
+// together.
+func (d *pdfDocument) pageContent(page pdfDict) []byte {
+	var streams []interface{}
+	switch contents := d.resolve(page["Contents"]).(type) {
+	case pdfArray:
+		streams = contents
+	case *pdfStream:
+		streams = []interface{}{contents}
+	}
+	var b bytes.Buffer
+	for _, obj := range streams {
+		if s, ok := d.resolve(obj).(*pdfStream); ok {
+			if data, err := d.decodeStream(s); err == nil {
+				b.Write(data)
+				b.WriteByte('\n')
+			}
+		}
+	}
+	return b.Bytes()
+}
+
+// decodeStream will decode the data of the stream with its filters.
+func (d *pdfDocument) decodeStream(s *pdfStream) ([]byte, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	filters := []pdfName{}
+	switch filter := d.resolve(s.Dict["Filter"]).(type) {
+	case pdfName:
+		filters = append(filters, filter)
+	case pdfArray:
+		for _, f := range filter {
+			filters = append(filters, d.name(f))
+		}
+	}
+
+	data := s.Data
+	for _, filter := range filters {
+		switch filter {
+		case "FlateDecode", "Fl":
+			inflated, err := inflatePDF(data, maxPDFInflatedSize-d.inflated)
+			if err != nil {
+				d.err = err
+				return nil, err
+			}
+			d.inflated += len(inflated)
+			data = inflated
+		case "ASCIIHexDecode", "AHx":
+			l := &pdfLexer{data: append(append([]byte{'<'}, data...), '>')}
+			data = []byte(l.hexString())
+		case "ASCII85Decode", "A85":
+			trimmed := bytes.TrimSpace(data)
+			trimmed = bytes.TrimPrefix(trimmed, []byte("<~"))
+			if i := bytes.Index(trimmed, []byte("~>")); i >= 0 {
+				trimmed = trimmed[:i]
+			}
+			decoded, err := io.ReadAll(ascii85.NewDecoder(bytes.NewReader(trimmed)))
+			if err != nil && len(decoded) == 0 {
+				return nil, err
+			}
+			data = decoded
+		default:
+			return nil, fmt.Errorf("unsupported PDF filter: %s", filter)
+		}
+	}
+	return data, nil
+}
+
+// inflatePDF will decompress the data of a FlateDecode stream. As much data as
+// can be read is returned from streams that are damaged, since many PDF
+// writers produce streams with bad checksums. An error is returned if the
+// stream decompresses to more than limit bytes.
+func inflatePDF(data []byte, limit int) ([]byte, error) {
+	var r io.Reader
+	if zr, err := zlib.NewReader(bytes.NewReader(data)); err == nil {
+		r = zr
+	} else {
+		r = flate.NewReader(bytes.NewReader(data))
+	}
+	out, _ := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if len(out) > limit {
+		return nil, errPDFTooLarge
+	}
+	return out, nil
+}
+
+// minInt will return the smaller of the integers.
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
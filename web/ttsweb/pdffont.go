@@ -0,0 +1,424 @@
+package ttsweb
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// -----------------------------------------------------------------------------
+// PDF Fonts
+// -----------------------------------------------------------------------------
+
+// pdfFont is what is needed of a PDF font to read the text that is shown with
+// it, the text of each character code and the width of each glyph.
+type pdfFont struct {
+	// toUnicode maps the character codes to text. It is read from the
+	// ToUnicode CMap of the font, if it has one.
+	toUnicode map[int]string
+
+	// encoding maps the character codes of simple fonts to text.
+	encoding *[256]string
+
+	// twoByte is set for composite fonts, whose character codes are two
+	// bytes long.
+	twoByte bool
+
+	// widths of the glyphs in thousandths of the font size, keyed by
+	// character code. defaultWidth is used for the glyphs without one.
+	widths       map[int]float64
+	defaultWidth float64
+}
+
+// defaultPDFFont is used to read text that is shown before any font is set.
+var defaultPDFFont = &pdfFont{encoding: &pdfWinAnsiEncoding, defaultWidth: 500}
+
+// newPDFFont will read the font dictionary.
+func (d *pdfDocument) newPDFFont(dict pdfDict) *pdfFont {
+	font := &pdfFont{widths: map[int]float64{}, defaultWidth: 500}
+	if toUnicode, ok := d.resolve(dict["ToUnicode"]).(*pdfStream); ok {
+		if data, err := d.decodeStream(toUnicode); err == nil {
+			font.toUnicode = parsePDFCMap(data)
+		}
+	}
+
+	if d.name(dict["Subtype"]) == "Type0" {
+		font.twoByte = true
+		font.defaultWidth = 1000
+		descendants := d.array(dict["DescendantFonts"])
+		if len(descendants) == 0 {
+			return font
+		}
+		descendant := d.dict(descendants[0])
+		if dw, ok := d.number(descendant["DW"]); ok {
+			font.defaultWidth = dw
+		}
+		d.readCIDWidths(font, d.array(descendant["W"]))
+		return font
+	}
+
+	// Simple fonts have one byte codes, an encoding and a list of widths
+	// that starts at the first character.
+	font.encoding = d.pdfEncoding(dict)
+	scale := 1.0
+	if d.name(dict["Subtype"]) == "Type3" {
+		// The glyphs of Type 3 fonts are measured in their own units.
+		if matrix := d.array(dict["FontMatrix"]); len(matrix) > 0 {
+			if n, ok := d.number(matrix[0]); ok {
+				scale = n * 1000
+			}
+		}
+	}
+	if descriptor := d.dict(dict["FontDescriptor"]); descriptor != nil {
+		if missing, ok := d.number(descriptor["MissingWidth"]); ok && missing > 0 {
+			font.defaultWidth = missing * scale
+		}
+	}
+	first, _ := d.number(dict["FirstChar"])
+	for i, w := range d.array(dict["Widths"]) {
+		if n, ok := d.number(w); ok {
+			font.widths[int(first)+i] = n * scale
+		}
+	}
+	return font
+}
+
+// readCIDWidths will read the W array of a composite font. The array has two
+// forms of entries, "c [w1 w2 ...]" for the widths of the codes from c, and
+// "c1 c2 w" for a range of codes with the same width.
+func (d *pdfDocument) readCIDWidths(font *pdfFont, w pdfArray) {
+	for i := 0; i+1 < len(w); {
+		first, ok := d.number(w[i])
+		if !ok {
+			return
+		}
+		if widths := d.array(w[i+1]); widths != nil {
+			for j, width := range widths {
+				if n, ok := d.number(width); ok {
+					font.widths[int(first)+j] = n
+				}
+			}
+			i += 2
+			continue
+		}
+		if i+2 >= len(w) {
+			return
+		}
+		last, ok1 := d.number(w[i+1])
+		width, ok2 := d.number(w[i+2])
+		if !ok1 || !ok2 || last-first > 0xffff {
+			return
+		}
+		for c := int(first); c <= int(last); c++ {
+			font.widths[c] = width
+		}
+		i += 3
+	}
+}
+
+// pdfEncoding will read the encoding of a simple font. The encoding is either
+// the name of a standard encoding, or a dictionary with a base encoding and
+// the differences from it.
+func (d *pdfDocument) pdfEncoding(dict pdfDict) *[256]string {
+	encoding := pdfStandardEncoding
+	switch e := d.resolve(dict["Encoding"]).(type) {
+	case pdfName:
+		encoding = *pdfNamedEncoding(e, &encoding)
+	case pdfDict:
+		encoding = *pdfNamedEncoding(d.name(e["BaseEncoding"]), &encoding)
+		code := 0
+		for _, difference := range d.array(e["Differences"]) {
+			switch v := d.resolve(difference).(type) {
+			case float64:
+				code = int(v)
+			case pdfName:
+				if code >= 0 && code < 256 {
+					encoding[code] = pdfGlyphText(string(v))
+				}
+				code++
+			}
+		}
+	}
+	return &encoding
+}
+
+// pdfNamedEncoding will return the standard encoding with the name, or the
+// fallback encoding if the name is not known.
+func pdfNamedEncoding(name pdfName, fallback *[256]string) *[256]string {
+	switch name {
+	case "WinAnsiEncoding":
+		return &pdfWinAnsiEncoding
+	case "MacRomanEncoding":
+		return &pdfMacRomanEncoding
+	case "StandardEncoding":
+		return &pdfStandardEncoding
+	}
+	return fallback
+}
+
+// codes will split the string that is shown with the font into character
+// codes.
+func (f *pdfFont) codes(s pdfString) []int {
+	codes := []int{}
+	if f.twoByte {
+		for i := 0; i+1 < len(s); i += 2 {
+			codes = append(codes, int(s[i])<<8|int(s[i+1]))
+		}
+		return codes
+	}
+	for i := 0; i < len(s); i++ {
+		codes = append(codes, int(s[i]))
+	}
+	return codes
+}
+
+// text will return the text of the character code.
+func (f *pdfFont) text(code int) string {
+	if text, ok := f.toUnicode[code]; ok {
+		return text
+	}
+	if f.encoding != nil && code < 256 {
+		return f.encoding[code]
+	}
+	return ""
+}
+
+// width will return the width of the glyph of the character code in
+// thousandths of the font size.
+func (f *pdfFont) width(code int) float64 {
+	if w, ok := f.widths[code]; ok {
+		return w
+	}
+	return f.defaultWidth
+}
+
+// -----------------------------------------------------------------------------
+// CMaps
+// -----------------------------------------------------------------------------
+
+// parsePDFCMap will read the character codes and their text from a ToUnicode
+// CMap. The text is written as UTF-16 in the CMap.
+func parsePDFCMap(data []byte) map[int]string {
+	chars := map[int]string{}
+	l := &pdfLexer{data: data}
+	operands := []interface{}{}
+	for {
+		obj, err := l.object()
+		if err != nil {
+			return chars
+		}
+		op, ok := obj.(pdfKeyword)
+		if !ok {
+			operands = append(operands, obj)
+			continue
+		}
+
+		switch op {
+		case "endbfchar":
+			for i := 0; i+1 < len(operands); i += 2 {
+				src, ok1 := operands[i].(pdfString)
+				dst, ok2 := operands[i+1].(pdfString)
+				if ok1 && ok2 {
+					chars[pdfCode(src)] = pdfUTF16Text(dst)
+				}
+			}
+		case "endbfrange":
+			for i := 0; i+2 < len(operands); i += 3 {
+				lo, ok1 := operands[i].(pdfString)
+				hi, ok2 := operands[i+1].(pdfString)
+				if !ok1 || !ok2 || pdfCode(hi)-pdfCode(lo) > 0xffff {
+					continue
+				}
+				first, last := pdfCode(lo), pdfCode(hi)
+				switch dst := operands[i+2].(type) {
+				case pdfString:
+					// The last character of the text counts up through the
+					// range.
+					units := utf16.Encode([]rune(pdfUTF16Text(dst)))
+					if len(units) == 0 {
+						continue
+					}
+					for c := first; c <= last; c++ {
+						chars[c] = string(utf16.Decode(units))
+						units[len(units)-1]++
+					}
+				case pdfArray:
+					for j, text := range dst {
+						if s, ok := text.(pdfString); ok && first+j <= last {
+							chars[first+j] = pdfUTF16Text(s)
+						}
+					}
+				}
+			}
+		}
+		operands = operands[:0]
+	}
+}
+
+// pdfCode will read the bytes of the string as a big-endian character code.
+func pdfCode(s pdfString) int {
+	code := 0
+	for i := 0; i < len(s); i++ {
+		code = code<<8 | int(s[i])
+	}
+	return code
+}
+
+// pdfUTF16Text will decode the UTF-16 text of a CMap. Single bytes, which some
+// PDF writers use, are read as Latin-1.
+func pdfUTF16Text(s pdfString) string {
+	if len(s) == 1 {
+		return string(rune(s[0]))
+	}
+	units := []uint16{}
+	for i := 0; i+1 < len(s); i += 2 {
+		units = append(units, uint16(s[i])<<8|uint16(s[i+1]))
+	}
+	return string(utf16.Decode(units))
+}
+
+// -----------------------------------------------------------------------------
+// Encodings
+// -----------------------------------------------------------------------------
+
+var (
+	// pdfStandardEncoding is the Adobe standard encoding, which is mostly
+	// ASCII with typographic quotes and ligatures in the upper half.
+	pdfStandardEncoding = newPDFEncoding(map[int]string{
+		0x27: "’", 0x60: "‘", 0xa1: "¡", 0xa2: "¢", 0xa3: "£", 0xa5: "¥",
+		0xa7: "§", 0xa9: "'", 0xaa: "“", 0xab: "«", 0xae: "ﬁ", 0xaf: "ﬂ",
+		0xb1: "–", 0xb2: "†", 0xb3: "‡", 0xb7: "•", 0xb9: "„", 0xba: "”",
+		0xbb: "»", 0xbc: "…", 0xbf: "¿", 0xd0: "—", 0xe1: "Æ", 0xe8: "Ł",
+		0xe9: "Ø", 0xea: "Œ", 0xf1: "æ", 0xf5: "ı", 0xf8: "ł", 0xf9: "ø",
+		0xfa: "œ", 0xfb: "ß",
+	}, false)
+
+	// pdfWinAnsiEncoding is Windows code page 1252.
+	pdfWinAnsiEncoding = newPDFEncoding(map[int]string{
+		0x80: "€", 0x82: "‚", 0x83: "ƒ", 0x84: "„", 0x85: "…", 0x86: "†",
+		0x87: "‡", 0x88: "ˆ", 0x89: "‰", 0x8a: "Š", 0x8b: "‹", 0x8c: "Œ",
+		0x8e: "Ž", 0x91: "‘", 0x92: "’", 0x93: "“", 0x94: "”", 0x95: "•",
+		0x96: "–", 0x97: "—", 0x98: "˜", 0x99: "™", 0x9a: "š", 0x9b: "›",
+		0x9c: "œ", 0x9e: "ž", 0x9f: "Ÿ",
+	}, true)
+
+	// pdfMacRomanEncoding is the classic Mac OS character set.
+	pdfMacRomanEncoding = newPDFEncoding(macRomanUpperHalf(), false)
+)
+
+// newPDFEncoding will create an encoding that is ASCII in the lower half with
+// the changes. If latin1 is set, the upper half is Latin-1 apart from the
+// changes.
+func newPDFEncoding(changes map[int]string, latin1 bool) [256]string {
+	encoding := [256]string{}
+	for c := 32; c < 127; c++ {
+		encoding[c] = string(rune(c))
+	}
+	if latin1 {
+		for c := 0xa0; c < 256; c++ {
+			encoding[c] = string(rune(c))
+		}
+	}
+	for c, text := range changes {
+		encoding[c] = text
+	}
+	return encoding
+}
+
+// macRomanUpperHalf will return the characters of the upper half of Mac Roman.
+func macRomanUpperHalf() map[int]string {
+	changes := map[int]string{}
+	chars := []rune("ÄÅÇÉÑÖÜáàâäãåçéèêëíìîïñóòôöõúùûü†°¢£§•¶ß®©™´¨≠ÆØ∞±≤≥¥µ∂∑∏π∫ªºΩæø¿¡¬√ƒ≈∆«»…\u00a0ÀÃÕŒœ–—“”‘’÷◊ÿŸ⁄€‹›ﬁﬂ‡·‚„‰ÂÊÁËÈÍÎÏÌÓÔ\uf8ffÒÚÛÙıˆ˜¯˘˙˚¸˝˛ˇ")
+	for i, r := range chars {
+		changes[0x80+i] = string(r)
+	}
+	return changes
+}
+
+// pdfGlyphNames are the text of the glyph names that are not a single
+// letter and cannot be worked out from their name.
+var pdfGlyphNames = map[string]string{
+	"space": " ", "exclam": "!", "quotedbl": "\"", "numbersign": "#",
+	"dollar": "$", "percent": "%", "ampersand": "&", "quotesingle": "'",
+	"quoteright": "’", "quoteleft": "‘", "parenleft": "(", "parenright": ")",
+	"asterisk": "*", "plus": "+", "comma": ",", "hyphen": "-", "period": ".",
+	"slash": "/", "zero": "0", "one": "1", "two": "2", "three": "3",
+	"four": "4", "five": "5", "six": "6", "seven": "7", "eight": "8",
+	"nine": "9", "colon": ":", "semicolon": ";", "less": "<", "equal": "=",
+	"greater": ">", "question": "?", "at": "@", "bracketleft": "[",
+	"backslash": "\\", "bracketright": "]", "asciicircum": "^",
+	"underscore": "_", "grave": "`", "braceleft": "{", "bar": "|",
+	"braceright": "}", "asciitilde": "~", "bullet": "•", "endash": "–",
+	"emdash": "—", "quotedblleft": "“", "quotedblright": "”",
+	"quotesinglbase": "‚", "quotedblbase": "„", "guillemotleft": "«",
+	"guillemotright": "»", "guilsinglleft": "‹", "guilsinglright": "›",
+	"ellipsis": "…", "fi": "ﬁ", "fl": "ﬂ", "ff": "ﬀ", "ffi": "ﬃ", "ffl": "ﬄ",
+	"dagger": "†", "daggerdbl": "‡", "section": "§", "paragraph": "¶",
+	"copyright": "©", "registered": "®", "trademark": "™", "degree": "°",
+	"minus": "−", "multiply": "×", "divide": "÷", "periodcentered": "·",
+	"nbspace": "\u00a0", "nonbreakingspace": "\u00a0", "sterling": "£",
+	"Euro": "€", "yen": "¥", "cent": "¢", "dotlessi": "ı", "germandbls": "ß",
+	"AE": "Æ", "ae": "æ", "OE": "Œ", "oe": "œ", "Oslash": "Ø", "oslash": "ø",
+	"Lslash": "Ł", "lslash": "ł", "exclamdown": "¡", "questiondown": "¿",
+	"softhyphen": "\u00ad", "florin": "ƒ", "perthousand": "‰",
+}
+
+// pdfGlyphAccents are the combining marks of the accents that are added to
+// letters in glyph names, e.g. "eacute".
+var pdfGlyphAccents = map[string]string{
+	"acute": "\u0301", "grave": "\u0300", "circumflex": "\u0302",
+	"tilde": "\u0303", "dieresis": "\u0308", "ring": "\u030a",
+	"cedilla": "\u0327", "caron": "\u030c",
+}
+
+// pdfGlyphText will return the text of a glyph name from the Differences of
+// an encoding. Names that are not known have no text.
+func pdfGlyphText(name string) string {
+	if text, ok := pdfGlyphNames[name]; ok {
+		return text
+	}
+	if len(name) == 1 {
+		return name
+	}
+
+	// Names such as "uni00E9" and "u1F600" are Unicode code points.
+	if strings.HasPrefix(name, "uni") && len(name) >= 7 && (len(name)-3)%4 == 0 {
+		text := ""
+		for i := 3; i < len(name); i += 4 {
+			n, err := strconv.ParseUint(name[i:i+4], 16, 16)
+			if err != nil {
+				return ""
+			}
+			text += string(rune(n))
+		}
+		return text
+	}
+	if strings.HasPrefix(name, "u") && len(name) >= 5 && len(name) <= 7 {
+		if n, err := strconv.ParseUint(name[1:], 16, 32); err == nil {
+			return string(rune(n))
+		}
+	}
+
+	// Accented letters, e.g. "eacute".
+	if len(name) > 1 {
+		if mark, ok := pdfGlyphAccents[name[1:]]; ok {
+			return norm.NFC.String(name[:1] + mark)
+		}
+	}
+
+	// Ligatures are named after their letters, e.g. "f_i", and variants of
+	// glyphs have a suffix, e.g. "a.sc".
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		return pdfGlyphText(name[:i])
+	}
+	if strings.Contains(name, "_") {
+		text := ""
+		for _, part := range strings.Split(name, "_") {
+			text += pdfGlyphText(part)
+		}
+		return text
+	}
+	return ""
+}
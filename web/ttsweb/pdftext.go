@@ -0,0 +1,700 @@
+package ttsweb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// -----------------------------------------------------------------------------
+// PDF Splitter
+// -----------------------------------------------------------------------------
+
+// PDFSplitter is a Splitter that extracts the text of PDF documents in Go.
+// The paragraphs are rebuilt from the layout of the lines on the pages, the
+// page headers and footers that repeat on each page are left out, and words
+// that are hyphenated across lines are joined again. Lines in a larger font
+// than the body text become headings.
+type PDFSplitter struct {
+	// Fallback splits the document if no text can be extracted from it, e.g.
+	// because the PDF is encrypted. If nil, the error is returned.
+	Fallback Splitter
+}
+
+// Split will extract the paragraphs of the PDF document.
+func (s PDFSplitter) Split(ctx context.Context, inputFile, outputDir string) ([]Heading, error) {
+	data, err := ioutil.ReadFile(inputFile)
+	if err != nil {
+		return nil, err
+	}
+	blocks, err := ExtractPDFBlocks(data)
+	if err == nil && len(blocks) == 0 {
+		err = fmt.Errorf("no text found in PDF")
+	}
+	if err != nil {
+		if s.Fallback == nil {
+			return nil, err
+		}
+		slog.WarnContext(ctx, "Could not extract the text of the PDF, using the fallback splitter", "error", err)
+		return s.Fallback.Split(ctx, inputFile, outputDir)
+	}
+	return writeBlocks(outputDir, blocks)
+}
+
+// Check will check the fallback splitter. The text of most PDFs is extracted
+// without it, so the splitter is only degraded if the fallback is missing its
+// tools.
+func (s PDFSplitter) Check(ctx context.Context) (string, error) {
+	version, err := checkBackend(ctx, s.Fallback)
+	if err != nil {
+		return version, fmt.Errorf("%w, PDFs without text cannot be split: %v", ErrCapabilityDegraded, err)
+	}
+	return version, nil
+}
+
+// ExtractPDFBlocks will extract the paragraphs and headings of a PDF document.
+func ExtractPDFBlocks(data []byte) ([]Block, error) {
+	doc, err := parsePDF(data)
+	if err != nil {
+		return nil, err
+	}
+	pages := doc.pages()
+	if len(pages) == 0 {
+		return nil, fmt.Errorf("no pages found in PDF")
+	}
+
+	reader := &pdfTextReader{doc: doc, fonts: map[pdfRef]*pdfFont{}}
+	lines := [][]pdfLine{}
+	for i, page := range pages {
+		reader.spans = nil
+		reader.run(doc.pageContent(page), doc.dict(page["Resources"]), pdfIdentity, 0)
+		lines = append(lines, pdfLines(reader.spans, i))
+		if doc.err != nil {
+			return nil, doc.err
+		}
+	}
+	return pdfBlocks(removePDFHeadersFooters(lines)), nil
+}
+
+// -----------------------------------------------------------------------------
+// Content Streams
+// -----------------------------------------------------------------------------
+
+// pdfMatrix is a transformation matrix [a b c d e f].
+type pdfMatrix [6]float64
+
+var pdfIdentity = pdfMatrix{1, 0, 0, 1, 0, 0}
+
+// multiply will return the matrix m × n, which is the transformation m
+// followed by n.
+func (m pdfMatrix) multiply(n pdfMatrix) pdfMatrix {
+	return pdfMatrix{
+		m[0]*n[0] + m[1]*n[2],
+		m[0]*n[1] + m[1]*n[3],
+		m[2]*n[0] + m[3]*n[2],
+		m[2]*n[1] + m[3]*n[3],
+		m[4]*n[0] + m[5]*n[2] + n[4],
+		m[4]*n[1] + m[5]*n[3] + n[5],
+	}
+}
+
+// pdfTranslation will return the matrix that moves by x and y.
+func pdfTranslation(x, y float64) pdfMatrix {
+	return pdfMatrix{1, 0, 0, 1, x, y}
+}
+
+// pdfTextSpan is a string of text that was shown on the page. The position is
+// the start of its baseline on the page, and EndX is where the text ends.
+type pdfTextSpan struct {
+	X, Y, EndX float64
+	Size       float64
+	Text       string
+}
+
+// pdfGraphicsState is the part of the graphics state that is saved and
+// restored by the q and Q operators which is needed to place text.
+type pdfGraphicsState struct {
+	ctm       pdfMatrix
+	font      *pdfFont
+	size      float64
+	charSpace float64
+	wordSpace float64
+	scale     float64
+	leading   float64
+	rise      float64
+}
+
+// pdfTextReader reads the text that is shown by the content streams of the
+// pages of a document.
+type pdfTextReader struct {
+	doc   *pdfDocument
+	fonts map[pdfRef]*pdfFont
+	spans []pdfTextSpan
+}
+
+// font will load the font with the name from the resources.
+func (r *pdfTextReader) font(resources pdfDict, name pdfName) *pdfFont {
+	obj := r.doc.dict(resources["Font"])[name]
+	ref, isRef := obj.(pdfRef)
+	if font, ok := r.fonts[ref]; isRef && ok {
+		return font
+	}
+	dict := r.doc.dict(obj)
+	if dict == nil {
+		return defaultPDFFont
+	}
+	font := r.doc.newPDFFont(dict)
+	if isRef {
+		r.fonts[ref] = font
+	}
+	return font
+}
+
+// run will read the text of the content stream. Form XObjects that are drawn
+// by the stream are read as well.
+func (r *pdfTextReader) run(content []byte, resources pdfDict, ctm pdfMatrix, depth int) {
+	if depth > 8 {
+		return
+	}
+	state := pdfGraphicsState{ctm: ctm, font: defaultPDFFont, scale: 1}
+	stack := []pdfGraphicsState{}
+	tm, tlm := pdfIdentity, pdfIdentity
+
+	// show will add the text of the string as a span and move the text
+	// matrix past it.
+	show := func(s pdfString) {
+		font := state.font
+		start := pdfMatrix{state.size * state.scale, 0, 0, state.size, 0, state.rise}.multiply(tm).multiply(state.ctm)
+		var text strings.Builder
+		for _, code := range font.codes(s) {
+			text.WriteString(font.text(code))
+			advance := font.width(code)/1000*state.size + state.charSpace
+			if !font.twoByte && code == ' ' {
+				advance += state.wordSpace
+			}
+			tm = pdfTranslation(advance*state.scale, 0).multiply(tm)
+		}
+		end := pdfMatrix{state.size * state.scale, 0, 0, state.size, 0, state.rise}.multiply(tm).multiply(state.ctm)
+		r.spans = append(r.spans, pdfTextSpan{
+			X:    start[4],
+			Y:    start[5],
+			EndX: end[4],
+			Size: math.Hypot(start[2], start[3]),
+			Text: text.String(),
+		})
+	}
+	nextLine := func() {
+		tlm = pdfTranslation(0, -state.leading).multiply(tlm)
+		tm = tlm
+	}
+
+	l := &pdfLexer{data: content}
+	operands := []interface{}{}
+	for {
+		obj, err := l.object()
+		if err != nil {
+			return
+		}
+		op, ok := obj.(pdfKeyword)
+		if !ok {
+			operands = append(operands, obj)
+			continue
+		}
+		numbers := pdfNumbers(operands)
+
+		switch op {
+		case "q":
+			stack = append(stack, state)
+		case "Q":
+			if n := len(stack); n > 0 {
+				state = stack[n-1]
+				stack = stack[:n-1]
+			}
+		case "cm":
+			if len(numbers) == 6 {
+				state.ctm = pdfMatrix(*(*[6]float64)(numbers)).multiply(state.ctm)
+			}
+		case "BT":
+			tm, tlm = pdfIdentity, pdfIdentity
+		case "Tf":
+			if len(operands) == 2 {
+				name, _ := operands[0].(pdfName)
+				state.font = r.font(resources, name)
+				state.size, _ = operands[1].(float64)
+			}
+		case "Tc":
+			if len(numbers) == 1 {
+				state.charSpace = numbers[0]
+			}
+		case "Tw":
+			if len(numbers) == 1 {
+				state.wordSpace = numbers[0]
+			}
+		case "Tz":
+			if len(numbers) == 1 {
+				state.scale = numbers[0] / 100
+			}
+		case "TL":
+			if len(numbers) == 1 {
+				state.leading = numbers[0]
+			}
+		case "Ts":
+			if len(numbers) == 1 {
+				state.rise = numbers[0]
+			}
+		case "Td", "TD":
+			if len(numbers) == 2 {
+				if op == "TD" {
+					state.leading = -numbers[1]
+				}
+				tlm = pdfTranslation(numbers[0], numbers[1]).multiply(tlm)
+				tm = tlm
+			}
+		case "Tm":
+			if len(numbers) == 6 {
+				tlm = pdfMatrix(*(*[6]float64)(numbers))
+				tm = tlm
+			}
+		case "T*":
+			nextLine()
+		case "Tj", "'", "\"":
+			if len(operands) == 0 {
+				break
+			}
+			if spacing := pdfNumbers(operands[:len(operands)-1]); op == "\"" && len(spacing) == 2 {
+				state.wordSpace, state.charSpace = spacing[0], spacing[1]
+			}
+			if op != "Tj" {
+				nextLine()
+			}
+			if s, ok := operands[len(operands)-1].(pdfString); ok {
+				show(s)
+			}
+		case "TJ":
+			if len(operands) == 0 {
+				break
+			}
+			array, _ := operands[0].(pdfArray)
+			for _, item := range array {
+				switch v := item.(type) {
+				case pdfString:
+					show(v)
+				case float64:
+					tm = pdfTranslation(-v/1000*state.size*state.scale, 0).multiply(tm)
+				}
+			}
+		case "Do":
+			if len(operands) == 1 {
+				name, _ := operands[0].(pdfName)
+				r.form(resources, name, state.ctm, depth)
+			}
+		case "BI":
+			l.skipInlineImage()
+		}
+		operands = operands[:0]
+	}
+}
+
+// form will read the text of the Form XObject with the name.
+func (r *pdfTextReader) form(resources pdfDict, name pdfName, ctm pdfMatrix, depth int) {
+	xobject, ok := r.doc.resolve(r.doc.dict(resources["XObject"])[name]).(*pdfStream)
+	if !ok || r.doc.name(xobject.Dict["Subtype"]) != "Form" {
+		return
+	}
+	content, err := r.doc.decodeStream(xobject)
+	if err != nil {
+		return
+	}
+	if numbers := pdfNumbers(r.doc.array(xobject.Dict["Matrix"])); len(numbers) == 6 {
+		ctm = pdfMatrix(*(*[6]float64)(numbers)).multiply(ctm)
+	}
+	if formResources := r.doc.dict(xobject.Dict["Resources"]); formResources != nil {
+		resources = formResources
+	}
+	r.run(content, resources, ctm, depth+1)
+}
+
+// skipInlineImage will move past the data of an inline image, up to and
+// including the EI operator.
+func (l *pdfLexer) skipInlineImage() {
+	for {
+		token, err := l.token()
+		if err != nil {
+			return
+		}
+		if k, ok := token.(pdfKeyword); ok && k == "ID" {
+			break
+		}
+	}
+	for {
+		i := bytes.Index(l.data[l.pos:], []byte("EI"))
+		if i < 0 {
+			l.pos = len(l.data)
+			return
+		}
+		end := l.pos + i + 2
+		if i > 0 && isPDFSpace(l.data[l.pos+i-1]) && (end == len(l.data) || isPDFSpace(l.data[end])) {
+			l.pos = end
+			return
+		}
+		l.pos = end
+	}
+}
+
+// pdfNumbers will return the operands as numbers, or nil if any of them are
+// not numbers.
+func pdfNumbers(operands []interface{}) []float64 {
+	numbers := make([]float64, 0, len(operands))
+	for _, operand := range operands {
+		n, ok := operand.(float64)
+		if !ok {
+			return nil
+		}
+		numbers = append(numbers, n)
+	}
+	return numbers
+}
+
+// -----------------------------------------------------------------------------
+// Layout
+// -----------------------------------------------------------------------------
+
+// pdfLine is a line of text on a page.
+type pdfLine struct {
+	Page       int
+	X, Y, EndX float64
+	Size       float64
+	Text       string
+
+	// sizeChars is the number of characters that were shown in the size of
+	// the line, so that the size is the one of most of the text.
+	sizeChars int
+}
+
+// pdfLines will join the spans of a page into lines. The spans are taken in
+// the order that they were shown, and a span starts a new line when it is
+// not on the baseline of the line before. A space is added between spans
+// with a gap between them.
+func pdfLines(spans []pdfTextSpan, page int) []pdfLine {
+	lines := []pdfLine{}
+	for _, span := range spans {
+		if span.Text == "" {
+			continue
+		}
+		n := len(lines)
+		if n > 0 && math.Abs(lines[n-1].Y-span.Y) < 0.5*math.Max(span.Size, lines[n-1].Size) {
+			line := &lines[n-1]
+			gap := span.X - line.EndX
+			if (gap > 0.15*span.Size || gap < -span.Size) && !strings.HasSuffix(line.Text, " ") && !strings.HasPrefix(span.Text, " ") {
+				line.Text += " "
+			}
+			line.Text += span.Text
+			line.EndX = math.Max(line.EndX, span.EndX)
+			if chars := utf8.RuneCountInString(span.Text); chars > line.sizeChars {
+				line.Size, line.sizeChars = span.Size, chars
+			}
+			continue
+		}
+		if strings.TrimSpace(span.Text) == "" {
+			continue
+		}
+		lines = append(lines, pdfLine{
+			Page:      page,
+			X:         span.X,
+			Y:         span.Y,
+			EndX:      span.EndX,
+			Size:      span.Size,
+			Text:      span.Text,
+			sizeChars: utf8.RuneCountInString(span.Text),
+		})
+	}
+
+	result := []pdfLine{}
+	for _, line := range lines {
+		line.Text = collapseSpace(strings.ReplaceAll(line.Text, "\u00a0", " "))
+		if line.Text != "" {
+			result = append(result, line)
+		}
+	}
+	return result
+}
+
+// pdfEdgeLines is the number of lines at the top and at the bottom of each
+// page that can be page headers and footers.
+const pdfEdgeLines = 2
+
+var pdfDigitsPattern = regexp.MustCompile(`\d+`)
+
+// isPDFPageNumber will check if the line is only a page number, which may be
+// set between dashes, e.g. "- 12 -". The same numbers as for text documents
+// are page numbers, so that a line with only "I" or "mix" at the bottom of a
+// page is kept.
+func isPDFPageNumber(text string) bool {
+	return isPageNumberText(strings.TrimSpace(strings.Trim(text, "-–— ")))
+}
+
+// pdfLineKey will return the text of the line with the numbers replaced, so
+// that headers and footers with page numbers match on each page.
+func pdfLineKey(text string) string {
+	return pdfDigitsPattern.ReplaceAllString(strings.ToLower(text), "#")
+}
+
+// pdfEdgeLineIndexes will return the indexes of the lines at the top and at
+// the bottom of the page.
+func pdfEdgeLineIndexes(lines []pdfLine) []int {
+	indexes := make([]int, len(lines))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	sort.SliceStable(indexes, func(i, j int) bool {
+		return lines[indexes[i]].Y > lines[indexes[j]].Y
+	})
+	if len(indexes) <= 2*pdfEdgeLines {
+		return indexes
+	}
+	return append(indexes[:pdfEdgeLines], indexes[len(indexes)-pdfEdgeLines:]...)
+}
+
+// pdfHeaderDistance is how many pages away the same header or footer must be
+// found. Books often alternate between headers on odd and even pages, so the
+// page after next is checked as well as the next page.
+const pdfHeaderDistance = 2
+
+// removePDFHeadersFooters will remove the page headers and footers from the
+// pages. A line at the top or the bottom of a page is a header or a footer if
+// the same text, apart from numbers, is at the top or the bottom of a nearby
+// page. Running headers with the title of the chapter are only repeated on
+// the pages of the chapter, so the pages are not compared with the whole
+// document. Page numbers on their own are always removed.
+func removePDFHeadersFooters(pages [][]pdfLine) [][]pdfLine {
+	edgeKeys := make([]map[string]bool, len(pages))
+	for p, lines := range pages {
+		edgeKeys[p] = map[string]bool{}
+		for _, i := range pdfEdgeLineIndexes(lines) {
+			edgeKeys[p][pdfLineKey(lines[i].Text)] = true
+		}
+	}
+	repeated := func(p int, key string) bool {
+		for other := p - pdfHeaderDistance; other <= p+pdfHeaderDistance; other++ {
+			if other != p && other >= 0 && other < len(pages) && edgeKeys[other][key] {
+				return true
+			}
+		}
+		return false
+	}
+
+	result := [][]pdfLine{}
+	for p, lines := range pages {
+		remove := map[int]bool{}
+		for _, i := range pdfEdgeLineIndexes(lines) {
+			text := lines[i].Text
+			if repeated(p, pdfLineKey(text)) || isPDFPageNumber(text) {
+				remove[i] = true
+			}
+		}
+		kept := []pdfLine{}
+		for i, line := range lines {
+			if !remove[i] {
+				kept = append(kept, line)
+			}
+		}
+		result = append(result, kept)
+	}
+	return result
+}
+
+// pdfLayout is the measurements of the body text of a document.
+type pdfLayout struct {
+	// Size is the font size of most of the text.
+	Size float64
+
+	// Spacing is the usual distance between the baselines of the lines of
+	// a paragraph.
+	Spacing float64
+
+	// Width is the usual width of a full line.
+	Width float64
+
+	// HeadingSizes are the font sizes that are larger than the body text,
+	// from the largest.
+	HeadingSizes []float64
+}
+
+// roundPDFSize will round the font size to half a point, so that sizes that
+// differ by rounding errors are the same.
+func roundPDFSize(size float64) float64 {
+	return math.Round(size*2) / 2
+}
+
+// median will return the median of the numbers, or 0 if there are none.
+func median(numbers []float64) float64 {
+	if len(numbers) == 0 {
+		return 0
+	}
+	sorted := append([]float64{}, numbers...)
+	sort.Float64s(sorted)
+	return sorted[len(sorted)/2]
+}
+
+// measurePDFLayout will measure the body text of the pages.
+func measurePDFLayout(pages [][]pdfLine) pdfLayout {
+	layout := pdfLayout{}
+	chars := map[float64]int{}
+	for _, lines := range pages {
+		for _, line := range lines {
+			chars[roundPDFSize(line.Size)] += utf8.RuneCountInString(line.Text)
+		}
+	}
+	for size, n := range chars {
+		if n > chars[layout.Size] || (n == chars[layout.Size] && size < layout.Size) {
+			layout.Size = size
+		}
+	}
+
+	spacings, widths := []float64{}, []float64{}
+	for _, lines := range pages {
+		for i, line := range lines {
+			if roundPDFSize(line.Size) != layout.Size {
+				continue
+			}
+			widths = append(widths, line.EndX-line.X)
+			if i > 0 && roundPDFSize(lines[i-1].Size) == layout.Size {
+				if gap := lines[i-1].Y - line.Y; gap > 0 && gap < 3*layout.Size {
+					spacings = append(spacings, gap)
+				}
+			}
+		}
+	}
+	layout.Spacing = median(spacings)
+	if layout.Spacing == 0 {
+		layout.Spacing = 1.2 * layout.Size
+	}
+	sort.Float64s(widths)
+	if len(widths) > 0 {
+		// Most lines of a paragraph are full, so a high percentile is the
+		// width of a full line.
+		layout.Width = widths[len(widths)*3/4]
+	}
+
+	for size := range chars {
+		if size > layout.Size*1.15 {
+			layout.HeadingSizes = append(layout.HeadingSizes, size)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.Float64Slice(layout.HeadingSizes)))
+	return layout
+}
+
+// headingLevel will return the level of the heading that a line in the font
+// size is, or 0 if the line is body text. The largest size is level 1, and
+// all sizes after the third are level 3.
+func (l pdfLayout) headingLevel(size float64) int {
+	for i, headingSize := range l.HeadingSizes {
+		if roundPDFSize(size) == headingSize {
+			if i >= 2 {
+				return 3
+			}
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// endsSentence will check if the text ends with the end of a sentence.
+func endsSentence(text string) bool {
+	text = strings.TrimRight(text, `"'”’)]»`)
+	last, _ := utf8.DecodeLastRuneInString(text)
+	return strings.ContainsRune(".!?:…", last)
+}
+
+// paragraphBreak will check if a paragraph ends between the lines. Paragraphs
+// end where the gap between the lines is larger than the spacing of the
+// lines, where the next line is indented, and after a short line that ends a
+// sentence. The lines may be on different pages or in different columns, then
+// only the last of these applies.
+func (l pdfLayout) paragraphBreak(prev, line pdfLine) bool {
+	ended := endsSentence(prev.Text)
+	short := l.Width > 0 && prev.EndX-prev.X < 0.8*l.Width
+	if prev.Page == line.Page && line.Y < prev.Y && line.Y > prev.Y-4*l.Spacing {
+		if prev.Y-line.Y > 1.5*l.Spacing {
+			return true
+		}
+		if ended && line.X > prev.X+0.8*line.Size && line.X < prev.X+8*line.Size {
+			return true
+		}
+	}
+	return ended && short
+}
+
+// joinPDFLines will add the next line to the text of a paragraph. Words that
+// are hyphenated at the end of the line are joined again, so that
+// "pronun-" and "ciation" become "pronunciation".
+func joinPDFLines(text, next string) string {
+	if text == "" {
+		return next
+	}
+	if strings.HasSuffix(text, "\u00ad") {
+		return strings.TrimSuffix(text, "\u00ad") + next
+	}
+	if strings.HasSuffix(text, "-") && !strings.HasSuffix(text, "--") {
+		before, _ := utf8.DecodeLastRuneInString(strings.TrimSuffix(text, "-"))
+		after, _ := utf8.DecodeRuneInString(next)
+		if unicode.IsLetter(before) && unicode.IsLower(after) {
+			return strings.TrimSuffix(text, "-") + next
+		}
+	}
+	return text + " " + next
+}
+
+// pdfBlocks will rebuild the paragraphs and headings from the lines of the
+// pages.
+func pdfBlocks(pages [][]pdfLine) []Block {
+	layout := measurePDFLayout(pages)
+	blocks := []Block{}
+	var current *Block
+	var prev pdfLine
+	flush := func() {
+		if current != nil {
+			current.Text = strings.ReplaceAll(current.Text, "\u00ad", "")
+			blocks = append(blocks, *current)
+			current = nil
+		}
+	}
+
+	for _, lines := range pages {
+		for _, line := range lines {
+			level := layout.headingLevel(line.Size)
+			if utf8.RuneCountInString(line.Text) > 200 {
+				level = 0
+			}
+			switch {
+			case current == nil:
+			case level > 0:
+				// Headings that are longer than a line continue on the
+				// next line in the same size.
+				if current.HeadingLevel == level && prev.Page == line.Page && prev.Y-line.Y > 0 && prev.Y-line.Y < 2*line.Size {
+					current.Text = joinPDFLines(current.Text, line.Text)
+					prev = line
+					continue
+				}
+			case current.HeadingLevel == 0 && !layout.paragraphBreak(prev, line):
+				current.Text = joinPDFLines(current.Text, line.Text)
+				prev = line
+				continue
+			}
+			flush()
+			current = &Block{Text: line.Text, HeadingLevel: level}
+			prev = line
+		}
+	}
+	flush()
+	return blocks
+}
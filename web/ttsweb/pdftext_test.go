@@ -0,0 +1,150 @@
+package ttsweb
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testPDFLine is a line of text on a page of a generated PDF. The position is
+// in points from the bottom left of the page.
+type testPDFLine struct {
+	x, y, size float64
+	text       string
+}
+
+// testPDF will generate a PDF with a page for each of the pages of lines. The
+// text is shown in Helvetica, and the content streams are compressed with
+// FlateDecode.
+func testPDF(t *testing.T, pages ...[]testPDFLine) []byte {
+	t.Helper()
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"", // The pages are added once their numbers are known.
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+	}
+	kids := []string{}
+	for _, lines := range pages {
+		var content bytes.Buffer
+		for _, line := range lines {
+			text := strings.NewReplacer(`\`, `\\`, "(", `\(`, ")", `\)`).Replace(line.text)
+			fmt.Fprintf(&content, "BT /F1 %g Tf %g %g Td (%s) Tj ET\n", line.size, line.x, line.y, text)
+		}
+		stream := testDeflate(t, content.Bytes())
+		objects = append(objects, fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream", len(stream), stream))
+		objects = append(objects, fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>", len(objects)))
+		kids = append(kids, fmt.Sprintf("%d 0 R", len(objects)))
+	}
+	objects[1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(kids))
+
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+	for i, obj := range objects {
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	b.WriteString("trailer\n<< /Root 1 0 R >>\n%%EOF\n")
+	return b.Bytes()
+}
+
+// testDeflate will compress the data with zlib.
+func testDeflate(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	w := zlib.NewWriter(&b)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestExtractPDFBlocks(t *testing.T) {
+	// Each page has a running header and a page number, which are left out.
+	// Body lines are 13 points apart, and paragraphs are split by a larger
+	// gap or by a short line that ends a sentence.
+	page1 := []testPDFLine{
+		{72, 800, 9, "The Long Read"},
+		{72, 740, 18, "I"},
+		{72, 700, 11, "The first paragraph of the chapter is long enough that"},
+		{72, 687, 11, "it is set on several lines, and the pronunciation of a"},
+		{72, 674, 11, "single word is hyphenated at the end of a line, so the"},
+		{72, 661, 11, "reader must see one word and not two parts of a word. The"},
+		{72, 648, 11, "hyphens in com-"},
+		{72, 635, 11, "pound words such as well-known are kept."},
+		{72, 609, 11, "The second paragraph starts after a gap and carries on to"},
+		{72, 596, 11, "the next page, where it is joined to the lines that were"},
+		{72, 40, 9, "- 1 -"},
+	}
+	page2 := []testPDFLine{
+		{72, 800, 9, "The Long Read"},
+		{72, 740, 11, "set at the top of that page, since it does not end with a"},
+		{72, 727, 11, "full stop. When the recipe tells you to add the flour then"},
+		{72, 714, 11, "mix"},
+		{72, 40, 9, "- 2 -"},
+	}
+	blocks, err := ExtractPDFBlocks(testPDF(t, page1, page2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Block{
+		{Text: "I", HeadingLevel: 1},
+		{Text: "The first paragraph of the chapter is long enough that it is set on several lines, and the pronunciation of a single word is hyphenated at the end of a line, so the reader must see one word and not two parts of a word. The hyphens in compound words such as well-known are kept."},
+		{Text: "The second paragraph starts after a gap and carries on to the next page, where it is joined to the lines that were set at the top of that page, since it does not end with a full stop. When the recipe tells you to add the flour then mix"},
+	}
+	if !reflect.DeepEqual(blocks, want) {
+		t.Errorf("ExtractPDFBlocks() =\n%+v\nwant\n%+v", blocks, want)
+	}
+}
+
+func TestIsPDFPageNumber(t *testing.T) {
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{"12", true},
+		{"- 12 -", true},
+		{"— 7 —", true},
+		{"Page 3 of 10", true},
+		{"xiv", true},
+		{"I", false},
+		{"IV", false},
+		{"mix", false},
+		{"Mild", false},
+		{"-", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if got := isPDFPageNumber(test.text); got != test.want {
+			t.Errorf("isPDFPageNumber(%q) = %t, want %t", test.text, got, test.want)
+		}
+	}
+}
+
+func TestInflatePDFLimit(t *testing.T) {
+	data := bytes.Repeat([]byte("BT (text) Tj ET\n"), 64)
+	compressed := testDeflate(t, data)
+	if out, err := inflatePDF(compressed, len(data)); err != nil || !bytes.Equal(out, data) {
+		t.Errorf("inflatePDF() at the limit = %d bytes, %v, want %d bytes", len(out), err, len(data))
+	}
+	if _, err := inflatePDF(compressed, len(data)-1); !errors.Is(err, errPDFTooLarge) {
+		t.Errorf("inflatePDF() over the limit returned %v, want %v", err, errPDFTooLarge)
+	}
+
+	// The limit is for all of the streams of a document, so that many
+	// small streams cannot add up to more than it.
+	doc := &pdfDocument{objects: map[int]interface{}{}, trailer: pdfDict{}, inflated: maxPDFInflatedSize - len(data)/2}
+	stream := &pdfStream{Dict: pdfDict{"Filter": pdfName("FlateDecode")}, Data: compressed}
+	if _, err := doc.decodeStream(stream); !errors.Is(err, errPDFTooLarge) {
+		t.Errorf("decodeStream() over the limit of the document returned %v, want %v", err, errPDFTooLarge)
+	}
+	plain := &pdfStream{Dict: pdfDict{}, Data: data}
+	if _, err := doc.decodeStream(plain); !errors.Is(err, errPDFTooLarge) {
+		t.Errorf("decodeStream() after the limit returned %v, want %v", err, errPDFTooLarge)
+	}
+}
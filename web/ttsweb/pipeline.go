@@ -0,0 +1,534 @@
+package ttsweb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// -----------------------------------------------------------------------------
+// Pipeline
+// -----------------------------------------------------------------------------
+
+// Pipeline describes the stages that a document goes through once it has been
+// saved to the documents directory. The document is split into paragraphs,
+// the paragraphs are synthesized, the sentences are aligned with the audio
+// and the audio is then optionally transcoded into compressed formats.
+type Pipeline struct {
+	// Splitters are used to split documents into paragraphs, keyed by the
+	// file extension of the document, e.g. ".epub". Documents without a
+	// splitter are split by the split-document.sh script.
+	Splitters map[string]Splitter
+
+	// TextNormalization is applied to the text of the paragraphs once the
+	// document has been split.
+	TextNormalization TextNormalization
+
+	// Voice is used to synthesize the paragraphs. If no voice is set, the
+	// DefaultVoice is used.
+	Voice Voice
+
+	// SpeechRules are the global user dictionary of overrides for how text
+	// is spoken. The rules of the document are applied before them.
+	SpeechRules SpeechRules
+
+	// SpeechExpanders expand numbers, abbreviations and so on into the words
+	// that are spoken. They are only applied to English paragraphs.
+	SpeechExpanders []SpeechExpander
+
+	// LanguageVoices are the voices used for paragraphs in other languages,
+	// keyed by language code. Paragraphs in a language without a voice are
+	// read by the Voice.
+	LanguageVoices map[string]Voice
+
+	// Synthesizers read the voices, keyed by the engine name of the voice.
+	// If no synthesizers are set, the DefaultSynthesizers are used.
+	Synthesizers map[string]Synthesizer
+
+	// SkipClasses are the classes of paragraphs that are skipped in new
+	// documents. Each document can change the classes that it skips.
+	SkipClasses []string
+
+	// LexiconsDir is the directory that the pronunciation lexicons are
+	// stored in.
+	LexiconsDir string
+
+	// SearchIndex is updated with the paragraphs of each document once it
+	// has been split. If nil, documents are not indexed.
+	SearchIndex *SearchIndex
+
+	// Jobs tracks the progress of the documents through the pipeline. If
+	// nil, the progress is not tracked.
+	Jobs *Jobs
+
+	// Health has the results of the self-check of the splitters, the
+	// synthesizers and the transcoder. Uploads that need a backend that is
+	// unavailable are refused, and languages whose voice is unavailable are
+	// read by the Voice. If nil, the backends are not checked.
+	Health *Health
+
+	// Transcoder is used to compress the synthesized audio. If no transcoder
+	// is set, the audio is left as wav files.
+	Transcoder Transcoder
+
+	// AudioFormats are the compressed formats that the audio is transcoded
+	// into after synthesis.
+	AudioFormats []AudioFormat
+
+	// DeleteSourceAudio will remove the wav files once they have been
+	// transcoded into all of the audio formats.
+	DeleteSourceAudio bool
+}
+
+// DefaultPipeline is the pipeline used when no other pipeline has been set. It
+// splits and synthesizes the document without transcoding the audio.
+var DefaultPipeline = &Pipeline{
+	Splitters:         DefaultSplitters(),
+	TextNormalization: DefaultTextNormalization,
+	SpeechExpanders:   DefaultSpeechExpanders,
+	Voice:             DefaultVoice,
+	SkipClasses:       []string{},
+	LexiconsDir:       "lexicons/",
+	SearchIndex:       NewSearchIndex(),
+	Jobs:              NewJobs(),
+}
+
+// Process will run the document through each stage of the pipeline. If a
+// stage fails, the document is saved with the failed status and the error.
+func (p *Pipeline) Process(ctx context.Context, documentsDir string, document *DocumentInfo) error {
+	return p.run(ctx, documentsDir, document, false)
+}
+
+// Resume will finish processing a document that was interrupted or failed.
+// The document is only split again if it has no paragraphs or was
+// interrupted while it was being split, and only the paragraphs that are
+// missing their audio or sentence timings are synthesized.
+func (p *Pipeline) Resume(ctx context.Context, documentsDir string, document *DocumentInfo) error {
+	return p.run(ctx, documentsDir, document, true)
+}
+
+// run will process the document, resuming from the paragraphs that have
+// already been synthesized if resume is set, and save the document with the
+// failed status and the error if a stage fails. If the context is cancelled,
+// the document keeps its status so that it can be resumed. The log records
+// of the stages have the IDs of the document and the job.
+func (p *Pipeline) run(ctx context.Context, documentsDir string, document *DocumentInfo, resume bool) error {
+	job := p.Jobs.Start(ctx, document.ID, JobProcess)
+	ctx = job.logContext(ctx, document.ID)
+	started := time.Now()
+	err := p.process(ctx, documentsDir, document, job, resume)
+	job.finish(err)
+	if errors.Is(err, context.Canceled) {
+		slog.WarnContext(ctx, "Interrupted processing document", "status", document.Status, "duration_ms", time.Since(started).Milliseconds())
+		return err
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Unable to process document", "error", err, "duration_ms", time.Since(started).Milliseconds())
+		document.Status = StatusFailed
+		document.Error = err.Error()
+		if err := document.WriteIndex(documentsDir); err != nil {
+			slog.ErrorContext(ctx, "Unable to save the failed document", "error", err)
+		}
+		return err
+	}
+	slog.InfoContext(ctx, "Processed document", "status", document.Status, "duration_ms", time.Since(started).Milliseconds())
+	if document.Error != "" {
+		document.Error = ""
+		return document.WriteIndex(documentsDir)
+	}
+	return nil
+}
+
+// process will run the document through each stage of the pipeline and
+// report the stages to the job. If resume is set, the paragraphs that were
+// split and synthesized before are kept.
+func (p *Pipeline) process(ctx context.Context, documentsDir string, document *DocumentInfo, job *Job, resume bool) error {
+	if document.SkipClasses == nil {
+		document.SkipClasses = p.SkipClasses
+	}
+
+	// Split the document into paragraphs, unless it was split before. The
+	// paragraphs of a split that was interrupted are removed first.
+	split := !resume
+	if resume {
+		paragraphs, err := LoadParagraphInfos(documentsDir, document.ID)
+		split = err != nil || len(paragraphs) == 0 || document.Status == StatusNew || document.Status == StatusSaved
+	}
+	if split && resume {
+		paragraphsDir := filepath.Join(documentsDir, document.ID, "paragraphs")
+		if err := os.RemoveAll(paragraphsDir); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(markupDir(paragraphsDir)); err != nil {
+			return err
+		}
+	}
+	if split {
+		job.setStage(StageSplit, 0)
+		started := time.Now()
+		if err := document.SplitToParagraphs(ctx, documentsDir, p.splitterFor(document.Filename), p.TextNormalization); err != nil {
+			return err
+		}
+		if err := document.WriteIndex(documentsDir); err != nil {
+			return err
+		}
+		observeStage(StageSplit, started)
+		slog.InfoContext(ctx, "Split document into paragraphs")
+	}
+
+	// Add the paragraphs to the search index.
+	if p.SearchIndex != nil {
+		job.setStage(StageIndex, 0)
+		started := time.Now()
+		if err := p.SearchIndex.IndexDocument(documentsDir, document.ID); err != nil {
+			return err
+		}
+		observeStage(StageIndex, started)
+		slog.InfoContext(ctx, "Indexed paragraphs for search")
+	}
+
+	// Detect the language of the paragraphs.
+	job.setStage(StageLanguages, 0)
+	started := time.Now()
+	if err := document.DetectLanguages(documentsDir); err != nil {
+		return err
+	}
+	if err := document.WriteIndex(documentsDir); err != nil {
+		return err
+	}
+	observeStage(StageLanguages, started)
+	slog.InfoContext(ctx, "Detected languages", "language", document.Language)
+
+	// Synthesize all of the paragraphs of the document, or only the ones
+	// that were not finished before.
+	if split {
+		return p.synthesize(ctx, documentsDir, document, nil, job)
+	}
+	unsynthesized, unfinished, err := p.unfinishedParagraphIDs(documentsDir, document)
+	if err != nil {
+		return err
+	}
+	if len(unsynthesized) > 0 {
+		slog.InfoContext(ctx, "Resuming synthesis", "paragraphs", len(unsynthesized))
+		if err := p.synthesize(ctx, documentsDir, document, unsynthesized, job); err != nil {
+			return err
+		}
+	}
+	if len(unfinished) > 0 {
+		if err := p.finishAudio(ctx, documentsDir, document, unfinished, job); err != nil {
+			return err
+		}
+	}
+	document.Status = StatusSynthesized
+	return document.WriteIndex(documentsDir)
+}
+
+// unfinishedParagraphIDs will find the paragraphs that are not skipped but
+// were not finished before. The unsynthesized paragraphs have no audio, and
+// the unfinished paragraphs have audio but are missing their sentence timings
+// or their audio in any of the formats that the pipeline transcodes into.
+func (p *Pipeline) unfinishedParagraphIDs(documentsDir string, document *DocumentInfo) (unsynthesized, unfinished []string, err error) {
+	paragraphIDs, err := document.unskippedParagraphIDs(documentsDir, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	formats := []AudioFormat{}
+	if p.Transcoder != nil {
+		formats = p.AudioFormats
+	}
+	for _, paragraphID := range paragraphIDs {
+		hasWav := len(availableAudioFormats(documentsDir, document.ID, paragraphID, []AudioFormat{FormatWav})) > 0
+		transcoded := len(availableAudioFormats(documentsDir, document.ID, paragraphID, formats)) == len(formats)
+		_, err := os.Stat(sentenceTimingsPath(documentsDir, document.ID, paragraphID))
+		switch {
+		case !hasWav && (len(formats) == 0 || !transcoded):
+			unsynthesized = append(unsynthesized, paragraphID)
+		case err != nil || !transcoded:
+			unfinished = append(unfinished, paragraphID)
+		}
+	}
+	return unsynthesized, unfinished, nil
+}
+
+// Resynthesize will synthesize the stale paragraphs of the document again,
+// e.g. once the pronunciation of a word in the paragraphs has changed. The
+// IDs of the paragraphs that were synthesized are returned. Paragraphs whose
+// audio was not written again, e.g. because the document skips them, are
+// left stale.
+func (p *Pipeline) Resynthesize(ctx context.Context, documentsDir string, document *DocumentInfo) ([]string, error) {
+	paragraphIDs, err := document.StaleParagraphIDs(documentsDir)
+	if err != nil || len(paragraphIDs) == 0 {
+		return paragraphIDs, err
+	}
+	job := p.Jobs.Start(ctx, document.ID, JobResynthesize)
+	ctx = job.logContext(ctx, document.ID)
+	started := time.Now()
+	err = p.synthesize(ctx, documentsDir, document, paragraphIDs, job)
+	if err == nil {
+		paragraphIDs, err = document.clearStaleParagraphs(documentsDir, paragraphIDs, started)
+	}
+	job.finish(err)
+	if err != nil {
+		return nil, err
+	}
+	return paragraphIDs, nil
+}
+
+// synthesize will run the paragraphs with the specified IDs, or all of the
+// paragraphs if no IDs are specified, through the stages of the pipeline
+// after the document has been split. Paragraphs in the classes that the
+// document skips are not synthesized. The stages are reported to the job.
+func (p *Pipeline) synthesize(ctx context.Context, documentsDir string, document *DocumentInfo, paragraphIDs []string, job *Job) error {
+	// Leave out the paragraphs that the document skips.
+	paragraphIDs, err := document.unskippedParagraphIDs(documentsDir, paragraphIDs)
+	if err != nil {
+		return err
+	}
+
+	// Synthesize the paragraphs of the document.
+	job.setStage(StageSynthesize, len(paragraphIDs))
+	started := time.Now()
+	speechFor, err := p.speechPreparer(documentsDir, document)
+	if err != nil {
+		return err
+	}
+	if err := document.SynthesizeParagraphs(ctx, documentsDir, paragraphIDs, speechFor, job.paragraphDone); err != nil {
+		return err
+	}
+	if err := document.WriteIndex(documentsDir); err != nil {
+		return err
+	}
+	observeStage(StageSynthesize, started)
+	slog.InfoContext(ctx, "Synthesized paragraphs", "paragraphs", len(paragraphIDs))
+	return p.finishAudio(ctx, documentsDir, document, paragraphIDs, job)
+}
+
+// finishAudio will run the synthesized paragraphs through the stages of the
+// pipeline after synthesis. The stages are reported to the job.
+func (p *Pipeline) finishAudio(ctx context.Context, documentsDir string, document *DocumentInfo, paragraphIDs []string, job *Job) error {
+	// Align the sentences of each paragraph with the audio.
+	job.setStage(StageAlign, len(paragraphIDs))
+	started := time.Now()
+	spokenFor, err := p.spokenTextPreparer(documentsDir, document)
+	if err != nil {
+		return err
+	}
+	if err := document.AlignSentences(ctx, documentsDir, paragraphIDs, spokenFor, job.paragraphDone); err != nil {
+		return err
+	}
+	observeStage(StageAlign, started)
+	slog.InfoContext(ctx, "Aligned sentences", "paragraphs", len(paragraphIDs))
+
+	// Compress the audio of the paragraphs.
+	if p.Transcoder != nil && len(p.AudioFormats) > 0 {
+		job.setStage(StageTranscode, len(paragraphIDs))
+		started := time.Now()
+		if err := document.TranscodeParagraphs(ctx, documentsDir, paragraphIDs, p.Transcoder, p.AudioFormats, p.DeleteSourceAudio, job.paragraphDone); err != nil {
+			return err
+		}
+		observeStage(StageTranscode, started)
+		slog.InfoContext(ctx, "Transcoded paragraphs", "paragraphs", len(paragraphIDs))
+	}
+
+	// Return no error.
+	return nil
+}
+
+// splitterFor will return the splitter for the file type of the document.
+func (p *Pipeline) splitterFor(filename string) Splitter {
+	if splitter, ok := p.Splitters[strings.ToLower(filepath.Ext(filename))]; ok {
+		return splitter
+	}
+	return ScriptSplitter{}
+}
+
+// voice will return the voice that the paragraphs are synthesized with.
+func (p *Pipeline) voice() Voice {
+	if p.Voice.Model == "" {
+		return DefaultVoice
+	}
+	return p.Voice
+}
+
+// voiceFor will return the voice that paragraphs in the language are
+// synthesized with. Languages whose voice failed the self-check are read by
+// the voice of the pipeline.
+func (p *Pipeline) voiceFor(language string) Voice {
+	if voice, ok := p.LanguageVoices[language]; ok && p.Health.unavailable(CapabilitySynthesizer, voiceName(voice)) == nil {
+		return voice
+	}
+	return p.voice()
+}
+
+// synthesizerFor will return the synthesizer that reads the voice.
+func (p *Pipeline) synthesizerFor(voice Voice) (Synthesizer, error) {
+	synthesizers := p.Synthesizers
+	if synthesizers == nil {
+		synthesizers = DefaultSynthesizers()
+	}
+	engine := voice.Engine
+	if engine == "" {
+		engine = EngineCoqui
+	}
+	synthesizer, ok := synthesizers[engine]
+	if !ok {
+		return nil, fmt.Errorf("no synthesizer for engine: %s", engine)
+	}
+	return synthesizer, nil
+}
+
+// speechPreparer will return a function that prepares the text of the
+// paragraphs of the document to be spoken. The rules of the document, the
+// global rules and the expanders are applied in that order. The words of the
+// lexicons of the document are then marked up with their pronunciation for
+// synthesizers that read SSML, or respelled for the others. Synthesizers that
+// read SSML are also given the headings, emphasis and quotes of the markup of
+// the paragraph.
+func (p *Pipeline) speechPreparer(documentsDir string, document *DocumentInfo) (func(paragraph ParagraphInfo, text string) Speech, error) {
+	prepareText, words, err := p.textPreparer(documentsDir, document)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check that there is a synthesizer for each of the voices.
+	synthesizers := map[Voice]Synthesizer{}
+	for _, voice := range append([]Voice{p.voice()}, p.languageVoices()...) {
+		if synthesizers[voice], err = p.synthesizerFor(voice); err != nil {
+			return nil, err
+		}
+	}
+
+	return func(paragraph ParagraphInfo, text string) Speech {
+		language := paragraphLanguage(document, paragraph)
+		voice := p.voiceFor(language)
+		synthesizer := synthesizers[voice]
+
+		// prepare will apply the rules and expanders to the text.
+		prepare := func(text string) string {
+			return prepareText(language, text)
+		}
+
+		// Read the markup of the paragraph with SSML if there is any. The
+		// paragraph is read as plain text if the markup cannot be loaded.
+		if synthesizer.SupportsSSML() {
+			if markup, err := LoadParagraphMarkup(documentsDir, document.ID, paragraph.ID); err == nil && markup != "" {
+				text = parseMarkup(markup).SSML(func(text string) string {
+					return words.ssmlBody(prepare(text))
+				})
+			} else {
+				text = words.SSML(prepare(text))
+			}
+		} else {
+			text = voice.SpeechText(words.Respell(prepare(text)))
+		}
+		return Speech{Voice: voice, Synthesizer: synthesizer, Text: text}
+	}, nil
+}
+
+// spokenTextPreparer will return a function that prepares the text of a
+// sentence of a paragraph in the same way as speechPreparer, but always as
+// plain text with the words of the lexicons respelled. The sentence timings
+// are estimated from this text, since numbers, abbreviations and respelled
+// words take longer or shorter to say than they take to write.
+func (p *Pipeline) spokenTextPreparer(documentsDir string, document *DocumentInfo) (func(paragraph ParagraphInfo, text string) string, error) {
+	prepareText, words, err := p.textPreparer(documentsDir, document)
+	if err != nil {
+		return nil, err
+	}
+	return func(paragraph ParagraphInfo, text string) string {
+		language := paragraphLanguage(document, paragraph)
+		return p.voiceFor(language).SpeechText(words.Respell(prepareText(language, text)))
+	}, nil
+}
+
+// textPreparer will load the rules and the lexicons of the document. The
+// returned function applies the rules of the document, the global rules and
+// the expanders to text in the language, in that order.
+func (p *Pipeline) textPreparer(documentsDir string, document *DocumentInfo) (func(language, text string) string, pronunciations, error) {
+	documentRules, err := LoadSpeechRules(documentSpeechRulesPath(documentsDir, document.ID))
+	if err != nil {
+		return nil, pronunciations{}, err
+	}
+	lexicons := []Lexicon{}
+	for _, name := range document.Lexicons {
+		lexicon, err := LoadLexicon(p.LexiconsDir, name)
+		if err != nil {
+			return nil, pronunciations{}, err
+		}
+		lexicons = append(lexicons, lexicon)
+	}
+
+	return func(language, text string) string {
+		text = documentRules.Apply(text)
+		text = p.SpeechRules.Apply(text)
+		if expandsLanguage(language) {
+			for _, expander := range p.SpeechExpanders {
+				text = expander.Expand(text)
+			}
+		}
+		return text
+	}, newPronunciations(lexicons), nil
+}
+
+// paragraphLanguage will return the language of the paragraph, or of the
+// document if the language of the paragraph is not known.
+func paragraphLanguage(document *DocumentInfo, paragraph ParagraphInfo) string {
+	if paragraph.Language != "" {
+		return paragraph.Language
+	}
+	return document.Language
+}
+
+// languageVoices will return the voices of the other languages.
+func (p *Pipeline) languageVoices() []Voice {
+	voices := []Voice{}
+	for _, voice := range p.LanguageVoices {
+		voices = append(voices, voice)
+	}
+	return voices
+}
+
+// SpokenParagraph will prepare the text of the paragraph in the same way as
+// it is prepared for synthesis, so that the text can be previewed.
+func (p *Pipeline) SpokenParagraph(documentsDir string, document DocumentInfo, paragraphID string) (SpokenParagraph, error) {
+	paragraph, err := LoadParagraph(documentsDir, document.ID, paragraphID)
+	if err != nil {
+		return SpokenParagraph{}, err
+	}
+	speechFor, err := p.speechPreparer(documentsDir, &document)
+	if err != nil {
+		return SpokenParagraph{}, err
+	}
+	speech := speechFor(paragraph.ParagraphInfo, paragraph.Content)
+	return SpokenParagraph{
+		ID:       paragraph.ID,
+		Language: speech.Voice.Language,
+		Model:    speech.Voice.Model,
+		Speaker:  speech.Voice.Speaker,
+		SSML:     speech.Synthesizer.SupportsSSML(),
+		Text:     speech.Text,
+	}, nil
+}
+
+// audioFormats will return the formats that paragraph audio may be stored in,
+// ordered by preference. The formats that the pipeline transcodes into come
+// first, then the other compressed formats, which may have been written with
+// an earlier configuration, e.g. before the source wav was deleted, and the
+// raw wav audio last.
+func (p *Pipeline) audioFormats() []AudioFormat {
+	formats := []AudioFormat{}
+	if p.Transcoder != nil {
+		formats = append(formats, p.AudioFormats...)
+	}
+	for _, format := range compressedAudioFormats {
+		if _, ok := findAudioFormat(formats, format.Name); !ok {
+			formats = append(formats, format)
+		}
+	}
+	return append(formats, FormatWav)
+}
@@ -0,0 +1,450 @@
+package ttsweb
+
+import (
+	"html"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// -----------------------------------------------------------------------------
+// Search Index
+// -----------------------------------------------------------------------------
+
+// SearchIndex is an inverted index of the words in the paragraphs of all of
+// the documents. For each word it keeps the paragraphs that contain it and
+// the positions of the word in each paragraph, so that phrases can be
+// matched. The index is held in memory and built from the paragraph files,
+// the text itself is read from the files when the results are shown.
+type SearchIndex struct {
+	// postings maps each term to the paragraphs that contain it, and the
+	// positions of the term in each paragraph.
+	postings map[string]map[searchKey][]int
+
+	// documents is the number of paragraphs of each document in the index.
+	documents map[string]int
+
+	// mu guards the index while it is searched. writeMu makes sure that only
+	// one rebuild or update of the index runs at a time, so that a rebuild
+	// cannot replace a document that was indexed while it was running.
+	mu      sync.RWMutex
+	writeMu sync.Mutex
+}
+
+// searchKey identifies a paragraph in the index.
+type searchKey struct {
+	DocumentID  string
+	ParagraphID string
+}
+
+// SearchResult is a paragraph that matched a search.
+type SearchResult struct {
+	DocumentID   string  `json:"documentId"`
+	DocumentName string  `json:"documentName"`
+	ParagraphID  string  `json:"paragraphId"`
+	Score        float64 `json:"score"`
+
+	// Snippet is the text around the matches, escaped as HTML. The words
+	// that matched are wrapped in <mark> elements.
+	Snippet string `json:"snippet"`
+
+	// Link opens the document in the reader at the paragraph, and
+	// ParagraphLink is the paragraph in the API.
+	Link          string `json:"link"`
+	ParagraphLink string `json:"paragraphLink"`
+}
+
+// NewSearchIndex will create an empty search index.
+func NewSearchIndex() *SearchIndex {
+	return &SearchIndex{
+		postings:  map[string]map[searchKey][]int{},
+		documents: map[string]int{},
+	}
+}
+
+// searchToken is a word of the text and where it is in the text.
+type searchToken struct {
+	Term       string
+	Start, End int
+}
+
+// searchTokens will split the text into words. The terms of the words are in
+// lower case without accents, so that "Café" is found by "cafe".
+func searchTokens(text string) []searchToken {
+	tokens := []searchToken{}
+	start := -1
+	for i, r := range text + " " {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		switch {
+		case isWord && start < 0:
+			start = i
+		case !isWord && start >= 0:
+			tokens = append(tokens, searchToken{Term: searchTerm(text[start:i]), Start: start, End: i})
+			start = -1
+		}
+	}
+	return tokens
+}
+
+// searchTerm will fold the word into the term that it is indexed under. The
+// word is decomposed so that the accents can be dropped from their letters.
+func searchTerm(word string) string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(strings.ToLower(word)) {
+		if !unicode.Is(unicode.Mn, r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// paragraphFileIDs will return the IDs of the paragraph files of the document.
+// No IDs are returned if the document has not been split yet.
+func paragraphFileIDs(documentsDir, documentID string) ([]string, error) {
+	files, err := os.ReadDir(path.Join(documentsDir, documentID, "paragraphs"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ids := []string{}
+	for _, file := range files {
+		id := strings.TrimSuffix(file.Name(), ".txt")
+		if _, err := strconv.Atoi(id); err == nil && path.Ext(file.Name()) == ".txt" {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// addSearchDocument will add the paragraphs of the document to the postings. The
+// number of paragraphs that were added is returned.
+func addSearchDocument(postings map[string]map[searchKey][]int, documentsDir, documentID string) (int, error) {
+	paragraphIDs, err := paragraphFileIDs(documentsDir, documentID)
+	if err != nil {
+		return 0, err
+	}
+	for _, paragraphID := range paragraphIDs {
+		content, err := ioutil.ReadFile(path.Join(documentsDir, documentID, "paragraphs", paragraphID+".txt"))
+		if err != nil {
+			return 0, err
+		}
+		key := searchKey{DocumentID: documentID, ParagraphID: paragraphID}
+		for position, token := range searchTokens(string(content)) {
+			if postings[token.Term] == nil {
+				postings[token.Term] = map[searchKey][]int{}
+			}
+			postings[token.Term][key] = append(postings[token.Term][key], position)
+		}
+	}
+	return len(paragraphIDs), nil
+}
+
+// Rebuild will replace the index with one built from the paragraph files of
+// the documents. The IDs of the documents are listed once no other update of
+// the index can run, so that a document that is indexed while the rebuild
+// waits is not left out. The old index is searched until the new one is
+// ready.
+func (s *SearchIndex) Rebuild(documentsDir string, documentIDs func() []string) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	postings := map[string]map[searchKey][]int{}
+	documents := map[string]int{}
+	for _, documentID := range documentIDs() {
+		n, err := addSearchDocument(postings, documentsDir, documentID)
+		if err != nil {
+			return err
+		}
+		documents[documentID] = n
+	}
+
+	s.mu.Lock()
+	s.postings = postings
+	s.documents = documents
+	s.mu.Unlock()
+	return nil
+}
+
+// IndexDocument will replace the paragraphs of the document in the index with
+// the paragraphs in its paragraph files. It is called whenever the text of a
+// document changes.
+func (s *SearchIndex) IndexDocument(documentsDir, documentID string) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	postings := map[string]map[searchKey][]int{}
+	n, err := addSearchDocument(postings, documentsDir, documentID)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeDocument(documentID)
+	for term, keys := range postings {
+		if s.postings[term] == nil {
+			s.postings[term] = map[searchKey][]int{}
+		}
+		for key, positions := range keys {
+			s.postings[term][key] = positions
+		}
+	}
+	s.documents[documentID] = n
+	return nil
+}
+
+// RemoveDocument will remove the paragraphs of the document from the index.
+func (s *SearchIndex) RemoveDocument(documentID string) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeDocument(documentID)
+}
+
+// removeDocument will remove the paragraphs of the document from the index.
+// The lock must be held.
+func (s *SearchIndex) removeDocument(documentID string) {
+	for term, keys := range s.postings {
+		for key := range keys {
+			if key.DocumentID == documentID {
+				delete(keys, key)
+			}
+		}
+		if len(keys) == 0 {
+			delete(s.postings, term)
+		}
+	}
+	delete(s.documents, documentID)
+}
+
+// Size will return the number of documents and paragraphs in the index.
+func (s *SearchIndex) Size() (documents, paragraphs int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.documents), s.paragraphCount()
+}
+
+// paragraphCount will return the number of paragraphs in the index. The lock
+// must be held.
+func (s *SearchIndex) paragraphCount() int {
+	paragraphs := 0
+	for _, n := range s.documents {
+		paragraphs += n
+	}
+	return paragraphs
+}
+
+// -----------------------------------------------------------------------------
+// Search Queries
+// -----------------------------------------------------------------------------
+
+// SearchQuery is a parsed search. Each clause is a word or a phrase of words
+// in quotes, a paragraph must match all of the clauses.
+type SearchQuery struct {
+	Clauses [][]string
+
+	// DocumentID limits the search to a single document if set.
+	DocumentID string
+
+	// Limit is the largest number of results that are returned.
+	Limit int
+}
+
+// ParseSearchQuery will parse the query text. Words in double quotes are
+// matched as a phrase, e.g. `"white whale" ahab`.
+func ParseSearchQuery(q string) SearchQuery {
+	query := SearchQuery{}
+	for i, part := range strings.Split(q, `"`) {
+		terms := []string{}
+		for _, token := range searchTokens(part) {
+			terms = append(terms, token.Term)
+		}
+		if i%2 == 1 {
+			if len(terms) > 0 {
+				query.Clauses = append(query.Clauses, terms)
+			}
+			continue
+		}
+		for _, term := range terms {
+			query.Clauses = append(query.Clauses, []string{term})
+		}
+	}
+	return query
+}
+
+// matchClause will return the paragraphs that match the clause, along with
+// the positions where each match starts.
+func (s *SearchIndex) matchClause(clause []string, documentID string) map[searchKey][]int {
+	matches := map[searchKey][]int{}
+	for key, positions := range s.postings[clause[0]] {
+		if documentID != "" && key.DocumentID != documentID {
+			continue
+		}
+		for _, position := range positions {
+			if s.matchesAt(clause, key, position) {
+				matches[key] = append(matches[key], position)
+			}
+		}
+	}
+	return matches
+}
+
+// matchesAt will check if the words of the phrase follow each other in the
+// paragraph from the position.
+func (s *SearchIndex) matchesAt(phrase []string, key searchKey, position int) bool {
+	for i, term := range phrase[1:] {
+		found := false
+		for _, p := range s.postings[term][key] {
+			if p == position+i+1 {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Search will find the paragraphs that match all of the clauses of the query.
+// The paragraphs are scored by how often each clause is found in them and
+// how rare the clause is, and the best matches are returned first. The
+// snippets are read from the paragraph files in the documents directory.
+func (s *SearchIndex) Search(documentsDir string, query SearchQuery) []SearchResult {
+	results := []SearchResult{}
+	if len(query.Clauses) == 0 {
+		return results
+	}
+
+	s.mu.RLock()
+	paragraphs := s.paragraphCount()
+	scores := map[searchKey]float64{}
+	starts := map[searchKey][]int{}
+	for i, clause := range query.Clauses {
+		matches := s.matchClause(clause, query.DocumentID)
+		idf := math.Log(1 + float64(paragraphs)/float64(len(matches)+1))
+		next := map[searchKey]float64{}
+		for key, positions := range matches {
+			if _, ok := scores[key]; i > 0 && !ok {
+				continue
+			}
+			next[key] = scores[key] + float64(len(positions))*idf
+			for _, position := range positions {
+				for j := range clause {
+					starts[key] = append(starts[key], position+j)
+				}
+			}
+		}
+		scores = next
+	}
+	s.mu.RUnlock()
+
+	keys := []searchKey{}
+	for key := range scores {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if scores[keys[i]] != scores[keys[j]] {
+			return scores[keys[i]] > scores[keys[j]]
+		}
+		if keys[i].DocumentID != keys[j].DocumentID {
+			return keys[i].DocumentID < keys[j].DocumentID
+		}
+		a, _ := strconv.Atoi(keys[i].ParagraphID)
+		b, _ := strconv.Atoi(keys[j].ParagraphID)
+		return a < b
+	})
+	if query.Limit > 0 && len(keys) > query.Limit {
+		keys = keys[:query.Limit]
+	}
+
+	for _, key := range keys {
+		content, err := ioutil.ReadFile(path.Join(documentsDir, key.DocumentID, "paragraphs", key.ParagraphID+".txt"))
+		if err != nil {
+			continue
+		}
+		results = append(results, SearchResult{
+			DocumentID:    key.DocumentID,
+			ParagraphID:   key.ParagraphID,
+			Score:         scores[key],
+			Snippet:       searchSnippet(string(content), starts[key]),
+			Link:          "/?document=" + key.DocumentID + "&paragraph=" + key.ParagraphID,
+			ParagraphLink: "/documents/" + key.DocumentID + "/paragraphs/" + key.ParagraphID,
+		})
+	}
+	return results
+}
+
+// searchSnippetLength is about the number of bytes of text on each side of
+// the first match that are shown in a snippet.
+const searchSnippetLength = 120
+
+// searchSnippet will cut the text around the first of the matched words and
+// mark the matched words. Matched words that follow each other, such as the
+// words of a phrase, are marked together.
+func searchSnippet(text string, positions []int) string {
+	tokens := searchTokens(text)
+	marked := map[int]bool{}
+	first := len(tokens)
+	for _, position := range positions {
+		if position < len(tokens) {
+			marked[position] = true
+			if position < first {
+				first = position
+			}
+		}
+	}
+
+	// Cut the snippet at the ends of words.
+	start, end := 0, len(text)
+	if first < len(tokens) {
+		for i := first; i >= 0 && tokens[first].Start-tokens[i].Start <= searchSnippetLength; i-- {
+			start = tokens[i].Start
+		}
+		for i := first; i < len(tokens) && tokens[i].End-tokens[first].End <= searchSnippetLength; i++ {
+			end = tokens[i].End
+		}
+		if first == 0 {
+			start = 0
+		}
+		if end == tokens[len(tokens)-1].End {
+			end = len(text)
+		}
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("… ")
+	}
+	last := start
+	for i := 0; i < len(tokens); i++ {
+		if !marked[i] || tokens[i].Start < start || tokens[i].End > end {
+			continue
+		}
+		j := i
+		for j+1 < len(tokens) && marked[j+1] && tokens[j+1].End <= end {
+			j++
+		}
+		b.WriteString(html.EscapeString(text[last:tokens[i].Start]))
+		b.WriteString("<mark>" + html.EscapeString(text[tokens[i].Start:tokens[j].End]) + "</mark>")
+		last = tokens[j].End
+		i = j
+	}
+	b.WriteString(html.EscapeString(text[last:end]))
+	if end < len(text) {
+		b.WriteString(" …")
+	}
+	return strings.TrimSpace(b.String())
+}
@@ -0,0 +1,379 @@
+package ttsweb
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// -----------------------------------------------------------------------------
+// Sentences
+// -----------------------------------------------------------------------------
+
+// Sentence is a sentence of a paragraph along with the time that it is spoken
+// within the audio of the paragraph.
+type Sentence struct {
+	Text    string `json:"text"`
+	StartMs int64  `json:"startMs"`
+	EndMs   int64  `json:"endMs"`
+}
+
+// sentenceAbbreviations are words that end with a full stop but do not end a
+// sentence. They are matched without the full stop and in lower case.
+var sentenceAbbreviations = map[string]bool{
+	"mr": true, "mrs": true, "ms": true, "dr": true, "prof": true, "sr": true,
+	"jr": true, "st": true, "mt": true, "rev": true, "gen": true, "col": true,
+	"capt": true, "lt": true, "sgt": true, "gov": true, "sen": true, "rep": true,
+	"vs": true, "etc": true, "e.g": true, "i.e": true, "cf": true, "al": true,
+	"approx": true, "no": true, "vol": true, "fig": true, "p": true, "pp": true,
+	"ch": true, "ed": true, "inc": true, "ltd": true, "co": true, "corp": true,
+	"jan": true, "feb": true, "mar": true, "apr": true, "jun": true, "jul": true,
+	"aug": true, "sep": true, "sept": true, "oct": true, "nov": true, "dec": true,
+}
+
+// SplitSentences will split the text of a paragraph into sentences. A sentence
+// ends with a full stop, question mark, exclamation mark or ellipsis, along
+// with any closing quotes or brackets, that is followed by white space and
+// the start of a new sentence. Common abbreviations and initials do not end a
+// sentence.
+func SplitSentences(text string) []string {
+	sentences := []string{}
+	runes := []rune(text)
+
+	start := 0
+	for i := 0; i < len(runes); i++ {
+		if !isSentenceTerminator(runes[i]) {
+			continue
+		}
+
+		// Include any repeated terminators and closing punctuation.
+		end := i + 1
+		for end < len(runes) && (isSentenceTerminator(runes[end]) || isClosingPunctuation(runes[end])) {
+			end++
+		}
+
+		// The sentence only ends if it is followed by white space and then
+		// something that can start a sentence.
+		next := end
+		for next < len(runes) && unicode.IsSpace(runes[next]) {
+			next++
+		}
+		if next < len(runes) {
+			if next == end || !startsSentence(runes[next]) {
+				i = end - 1
+				continue
+			}
+		}
+
+		// Full stops after abbreviations and initials do not end a sentence.
+		if runes[i] == '.' && end == i+1 && isAbbreviation(runes[start:i]) {
+			i = end - 1
+			continue
+		}
+
+		sentence := strings.TrimSpace(string(runes[start:end]))
+		if sentence != "" {
+			sentences = append(sentences, sentence)
+		}
+		start = next
+		i = next - 1
+	}
+
+	// Anything left over is the last sentence.
+	if start < len(runes) {
+		if sentence := strings.TrimSpace(string(runes[start:])); sentence != "" {
+			sentences = append(sentences, sentence)
+		}
+	}
+
+	return sentences
+}
+
+// isSentenceTerminator will check if the rune can end a sentence.
+func isSentenceTerminator(r rune) bool {
+	return r == '.' || r == '?' || r == '!' || r == '…'
+}
+
+// isClosingPunctuation will check if the rune can follow the end of a sentence.
+func isClosingPunctuation(r rune) bool {
+	return r == '"' || r == '\'' || r == ')' || r == ']' || r == '”' || r == '’' || r == '»'
+}
+
+// startsSentence will check if the rune can be the first rune of a sentence.
+func startsSentence(r rune) bool {
+	return unicode.IsUpper(r) || unicode.IsDigit(r) || r == '"' || r == '\'' ||
+		r == '(' || r == '[' || r == '“' || r == '‘' || r == '«' || r == '¿' || r == '¡'
+}
+
+// isAbbreviation will check if the last word of the text before a full stop is
+// an abbreviation or an initial.
+func isAbbreviation(text []rune) bool {
+	i := len(text)
+	for i > 0 && !unicode.IsSpace(text[i-1]) && text[i-1] != '(' && text[i-1] != '"' {
+		i--
+	}
+	word := strings.ToLower(string(text[i:]))
+	if word == "" {
+		return false
+	}
+
+	// Single letters are initials, e.g. "J. R. R. Tolkien".
+	if len([]rune(word)) == 1 && unicode.IsLetter([]rune(word)[0]) {
+		return true
+	}
+	return sentenceAbbreviations[word]
+}
+
+// -----------------------------------------------------------------------------
+// Alignment
+// -----------------------------------------------------------------------------
+
+// alignSentenceTimings will work out when each of the sentences is spoken
+// within the paragraph audio. The time of each sentence is first estimated from its
+// share of the spoken characters of the paragraph, counted in the text that
+// the spoken function returns for the sentence. If the audio is 16 bit wav,
+// the boundaries between sentences are then moved to the nearest pause in
+// the audio.
+func alignSentenceTimings(sentences []string, spoken func(sentence string) string, audioPath string, format AudioFormat) ([]Sentence, error) {
+	info, err := readAudioInfo(audioPath, format)
+	if err != nil {
+		return nil, err
+	}
+	if len(sentences) == 0 {
+		return []Sentence{}, nil
+	}
+
+	// Estimate the boundaries from the length of each sentence.
+	weights := make([]float64, len(sentences))
+	total := 0.0
+	for i, sentence := range sentences {
+		weights[i] = sentenceWeight(spoken(sentence))
+		total += weights[i]
+	}
+	duration := float64(info.Duration.Milliseconds())
+	boundaries := make([]float64, len(sentences)+1)
+	for i := range sentences {
+		boundaries[i+1] = boundaries[i] + duration*weights[i]/total
+	}
+	boundaries[len(sentences)] = duration
+
+	// Move the boundaries to the pauses in the audio.
+	if format.Name == FormatWav.Name && len(sentences) > 1 {
+		pauses, err := findPauses(audioPath, info)
+		if err != nil {
+			slog.Warn("Unable to find the pauses in the audio", "path", audioPath, "error", err)
+		} else {
+			snapToPauses(boundaries, pauses, duration)
+		}
+	}
+
+	aligned := make([]Sentence, len(sentences))
+	for i, sentence := range sentences {
+		aligned[i] = Sentence{
+			Text:    sentence,
+			StartMs: int64(math.Round(boundaries[i])),
+			EndMs:   int64(math.Round(boundaries[i+1])),
+		}
+	}
+	return aligned, nil
+}
+
+// sentenceWeight estimates how long a sentence takes to say relative to other
+// sentences. Letters and digits are spoken, punctuation adds a short pause.
+func sentenceWeight(sentence string) float64 {
+	weight := 0.0
+	for _, r := range sentence {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			weight++
+		case r == ',' || r == ';' || r == ':':
+			weight += 3
+		case isSentenceTerminator(r):
+			weight += 5
+		case unicode.IsSpace(r):
+			weight += 0.5
+		}
+	}
+	if weight == 0 {
+		weight = 1
+	}
+	return weight
+}
+
+// pause is a run of quiet audio.
+type pause struct {
+	startMs float64
+	endMs   float64
+}
+
+// pauseFrame is the length of audio that the loudness is measured over when
+// looking for pauses.
+const pauseFrame = 10 * time.Millisecond
+
+// findPauses will find the runs of quiet audio in a 16 bit PCM wav file. The
+// loudness of each frame is compared against the loudness of the paragraph so
+// that the quiet parts are found regardless of the volume of the voice.
+func findPauses(audioPath string, info audioInfo) ([]pause, error) {
+	header := info.Header
+	if len(header) < 16 || binary.LittleEndian.Uint16(header[0:2]) != 1 || binary.LittleEndian.Uint16(header[14:16]) != 16 {
+		return nil, fmt.Errorf("%s: pauses can only be found in 16 bit PCM audio", audioPath)
+	}
+	channels := int(binary.LittleEndian.Uint16(header[2:4]))
+	sampleRate := int(binary.LittleEndian.Uint32(header[4:8]))
+	if channels == 0 || sampleRate == 0 {
+		return nil, fmt.Errorf("%s: invalid wav format", audioPath)
+	}
+
+	// Read the audio data.
+	file, err := os.Open(audioPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	data := make([]byte, info.DataSize)
+	if _, err := file.ReadAt(data, info.DataOffset); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	// Measure the loudness of each frame.
+	frameSamples := sampleRate * int(pauseFrame/time.Millisecond) / 1000
+	frameBytes := frameSamples * channels * 2
+	if frameBytes == 0 {
+		return nil, fmt.Errorf("%s: sample rate too low", audioPath)
+	}
+	levels := []float64{}
+	for offset := 0; offset+frameBytes <= len(data); offset += frameBytes {
+		sum := 0.0
+		for i := offset; i < offset+frameBytes; i += 2 {
+			sample := float64(int16(binary.LittleEndian.Uint16(data[i : i+2])))
+			sum += sample * sample
+		}
+		levels = append(levels, math.Sqrt(sum/float64(frameSamples*channels)))
+	}
+	if len(levels) == 0 {
+		return nil, nil
+	}
+
+	// Frames that are much quieter than the speech are part of a pause.
+	sorted := append([]float64{}, levels...)
+	sort.Float64s(sorted)
+	threshold := sorted[len(sorted)*9/10] * 0.1
+
+	pauses := []pause{}
+	frameMs := float64(pauseFrame / time.Millisecond)
+	start := -1
+	for i := 0; i <= len(levels); i++ {
+		quiet := i < len(levels) && levels[i] <= threshold
+		if quiet && start < 0 {
+			start = i
+		}
+		if !quiet && start >= 0 {
+			pauses = append(pauses, pause{
+				startMs: float64(start) * frameMs,
+				endMs:   float64(i) * frameMs,
+			})
+			start = -1
+		}
+	}
+	return pauses, nil
+}
+
+// snapToPauses will move each of the inner boundaries to the middle of the
+// longest pause close to it. The boundaries are kept in order.
+func snapToPauses(boundaries []float64, pauses []pause, duration float64) {
+	window := math.Max(duration*0.25, 1000)
+	for i := 1; i < len(boundaries)-1; i++ {
+		estimate := boundaries[i]
+		best := -1.0
+		bestScore := 0.0
+		for _, p := range pauses {
+			middle := (p.startMs + p.endMs) / 2
+			distance := math.Abs(middle - estimate)
+			if distance > window || middle <= boundaries[i-1] {
+				continue
+			}
+
+			// Prefer long pauses that are close to the estimate.
+			score := (p.endMs - p.startMs) * (1 - distance/window)
+			if score > bestScore {
+				best = middle
+				bestScore = score
+			}
+		}
+		if best >= 0 {
+			boundaries[i] = best
+		}
+	}
+}
+
+// -----------------------------------------------------------------------------
+// Sentence Timings
+// -----------------------------------------------------------------------------
+
+// sentenceTimingsPath will return the path of the file that stores the sentence
+// timings of the paragraph.
+func sentenceTimingsPath(documentsDir, documentID, paragraphID string) string {
+	return filepath.Join(documentsDir, documentID, "timings", paragraphID+".json")
+}
+
+// LoadSentences will load the sentences of the paragraph along with their
+// timings. The timings are only stored by the pipeline once the paragraph
+// audio has been written, until then the sentences are returned without
+// timings.
+func LoadSentences(documentsDir, documentID, paragraphID, content string) ([]Sentence, error) {
+	timingsPath := sentenceTimingsPath(documentsDir, documentID, paragraphID)
+
+	// Load the stored timings.
+	data, err := ioutil.ReadFile(timingsPath)
+	observeCache("sentence_timings", err == nil)
+	if err == nil {
+		sentences := []Sentence{}
+		if err := json.Unmarshal(data, &sentences); err != nil {
+			return nil, err
+		}
+		return sentences, nil
+	}
+
+	// Return the sentences without timings.
+	sentences := []Sentence{}
+	for _, text := range SplitSentences(content) {
+		sentences = append(sentences, Sentence{Text: text})
+	}
+	return sentences, nil
+}
+
+// alignParagraph will split the paragraph content into sentences and align
+// them with the first audio format that is stored for the paragraph. The
+// spoken function returns the text that is read for each sentence.
+func alignParagraph(documentsDir, documentID, paragraphID, content string, spoken func(sentence string) string) ([]Sentence, error) {
+	for _, format := range streamFormats {
+		audioPath := paragraphAudioPath(documentsDir, documentID, paragraphID, format)
+		if _, err := os.Stat(audioPath); err != nil {
+			continue
+		}
+		return alignSentenceTimings(SplitSentences(content), spoken, audioPath, format)
+	}
+	return nil, fmt.Errorf("no audio to align paragraph %s of document %s", paragraphID, documentID)
+}
+
+// writeSentenceTimings will store the sentence timings of a paragraph.
+func writeSentenceTimings(timingsPath string, sentences []Sentence) error {
+	if err := os.MkdirAll(filepath.Dir(timingsPath), 0755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(sentences)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(timingsPath, data, 0644)
+}
@@ -0,0 +1,71 @@
+package ttsweb
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// loudWav will return wav audio of a constant tone, which has no pauses for
+// the sentence boundaries to move to.
+func loudWav(duration time.Duration) []byte {
+	audio := silentWav(duration)
+	dataSize := int(duration.Seconds()*22050) * 2
+	data := audio[len(audio)-dataSize:]
+	for i := 0; i+1 < len(data); i += 2 {
+		sample := int16(10000)
+		if i%4 == 0 {
+			sample = -sample
+		}
+		binary.LittleEndian.PutUint16(data[i:], uint16(sample))
+	}
+	return audio
+}
+
+func TestAlignSentencesSpokenText(t *testing.T) {
+	documentsDir := t.TempDir() + "/"
+	document := &DocumentInfo{ID: "doc", Language: "en"}
+	audioPath := paragraphAudioPath(documentsDir, document.ID, "0", FormatWav)
+	if err := os.MkdirAll(filepath.Dir(audioPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(audioPath, loudWav(10*time.Second), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	pipeline := &Pipeline{SpeechExpanders: DefaultSpeechExpanders}
+	spokenFor, err := pipeline.spokenTextPreparer(documentsDir, document)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paragraph := ParagraphInfo{ID: "0"}
+	spoken := func(sentence string) string {
+		return spokenFor(paragraph, sentence)
+	}
+	first, second := "It cost $1,250,000 in 1999.", "That was cheap."
+	if got := spoken(first); got == first {
+		t.Fatalf("spoken text of %q was not expanded", first)
+	}
+
+	// The first sentence takes longer to say than to write, so it has a
+	// larger share of the audio than its written length gives it.
+	sentences, err := alignParagraph(documentsDir, document.ID, paragraph.ID, first+" "+second, spoken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sentences) != 2 {
+		t.Fatalf("alignParagraph() returned %d sentences, want 2", len(sentences))
+	}
+	share := func(a, b string) int64 {
+		return int64(math.Round(10000 * sentenceWeight(a) / (sentenceWeight(a) + sentenceWeight(b))))
+	}
+	if want := share(spoken(first), spoken(second)); sentences[0].EndMs != want {
+		t.Errorf("first sentence ends at %dms, want %dms from the spoken text, not %dms from the written text", sentences[0].EndMs, want, share(first, second))
+	}
+	if sentences[0].Text != first || sentences[1].Text != second {
+		t.Errorf("alignParagraph() sentences = %q and %q, want the written text", sentences[0].Text, sentences[1].Text)
+	}
+}
@@ -0,0 +1,291 @@
+package ttsweb
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// -----------------------------------------------------------------------------
+// Listening Sessions
+// -----------------------------------------------------------------------------
+
+// ListeningSession is a stretch of playback of a document, from the paragraph
+// that playback started at to the paragraph that it stopped at. The sessions
+// of a document are stored in documents/{id}/sessions.json.
+type ListeningSession struct {
+	ID               string `json:"id"`
+	DocumentID       string `json:"documentId"`
+	StartParagraphID string `json:"startParagraphId"`
+	EndParagraphID   string `json:"endParagraphId"`
+
+	Started time.Time `json:"started"`
+	Ended   time.Time `json:"ended"`
+
+	// Duration is the number of seconds of audio that were listened to. This
+	// is less than the time between Started and Ended if playback was
+	// paused, and more if the audio was played faster.
+	Duration float64 `json:"duration"`
+}
+
+// maxSessionDuration is the longest session that is recorded.
+const maxSessionDuration = 24 * time.Hour
+
+// sessionsPath will return the path to the listening sessions of the
+// document.
+func sessionsPath(documentsDir, documentID string) string {
+	return path.Join(documentsDir, documentID, "sessions.json")
+}
+
+// LoadListeningSessions will load the listening sessions of the document in
+// the order that they were recorded. No sessions are returned if the document
+// has none.
+func LoadListeningSessions(documentsDir, documentID string) ([]ListeningSession, error) {
+	sessions := []ListeningSession{}
+	data, err := ioutil.ReadFile(sessionsPath(documentsDir, documentID))
+	if os.IsNotExist(err) {
+		return sessions, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// SaveListeningSessions will write the listening sessions of the document.
+func SaveListeningSessions(documentsDir, documentID string, sessions []ListeningSession) error {
+	data, err := json.Marshal(sessions)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(sessionsPath(documentsDir, documentID), data, 0644)
+}
+
+// Validate will check the session before it is saved. The session ends now if
+// no end time is given, and starts the duration before its end if no start
+// time is given. The start and end paragraphs are swapped if playback went
+// backwards.
+func (s *ListeningSession) Validate(documentsDir string) error {
+	for _, paragraphID := range []string{s.StartParagraphID, s.EndParagraphID} {
+		if _, err := strconv.Atoi(paragraphID); err != nil {
+			return fmt.Errorf("invalid paragraph ID: %s", paragraphID)
+		}
+		if _, err := os.Stat(path.Join(documentsDir, s.DocumentID, "paragraphs", paragraphID+".txt")); err != nil {
+			return fmt.Errorf("paragraph not found: %s", paragraphID)
+		}
+	}
+	start, _ := strconv.Atoi(s.StartParagraphID)
+	end, _ := strconv.Atoi(s.EndParagraphID)
+	if start > end {
+		s.StartParagraphID, s.EndParagraphID = s.EndParagraphID, s.StartParagraphID
+	}
+
+	if s.Duration < 0 || math.IsNaN(s.Duration) || s.Duration > maxSessionDuration.Seconds() {
+		return fmt.Errorf("invalid duration: %v", s.Duration)
+	}
+	if s.Ended.IsZero() {
+		s.Ended = time.Now().UTC()
+	}
+	if s.Started.IsZero() {
+		s.Started = s.Ended.Add(-time.Duration(s.Duration * float64(time.Second)))
+	}
+	if s.Ended.Before(s.Started) || s.Ended.Sub(s.Started) > maxSessionDuration {
+		return fmt.Errorf("invalid session times: %s to %s", s.Started.Format(time.RFC3339), s.Ended.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// -----------------------------------------------------------------------------
+// Listening Statistics
+// -----------------------------------------------------------------------------
+
+// DocumentStats is the progress of listening to a document. Durations are in
+// seconds of audio. Skipped paragraphs are not counted.
+type DocumentStats struct {
+	DocumentID   string `json:"documentId"`
+	DocumentName string `json:"documentName"`
+
+	// Paragraphs is the number of paragraphs that are played, and
+	// ListenedParagraphs is how many of them were in a session.
+	Paragraphs         int `json:"paragraphs"`
+	ListenedParagraphs int `json:"listenedParagraphs"`
+
+	// Completion is the percentage of the audio of the document that was
+	// listened to.
+	Completion float64 `json:"completion"`
+
+	// Listened is the total duration of the sessions, including paragraphs
+	// that were listened to more than once.
+	Listened float64 `json:"listened"`
+
+	// Duration is the length of the audio of the document, and Remaining is
+	// the length of the paragraphs that have not been listened to. The
+	// length of paragraphs without audio is estimated from their text.
+	Duration  float64 `json:"duration"`
+	Remaining float64 `json:"remaining"`
+
+	Sessions     int        `json:"sessions"`
+	LastListened *time.Time `json:"lastListened,omitempty"`
+}
+
+// DailyListening is the number of seconds of audio that were listened to on a
+// day, in the local time of the server.
+type DailyListening struct {
+	Date     string  `json:"date"`
+	Listened float64 `json:"listened"`
+}
+
+// ListeningStats are the statistics of all of the documents.
+type ListeningStats struct {
+	Listened  float64          `json:"listened"`
+	Days      []DailyListening `json:"days"`
+	Documents []DocumentStats  `json:"documents"`
+}
+
+// defaultSecondsPerByte is the length of speech per byte of text that is used
+// to estimate the length of paragraphs without audio when no paragraph of the
+// document has audio. It is about 150 words a minute.
+const defaultSecondsPerByte = 0.065
+
+// ComputeDocumentStats will work out how much of the document was listened to
+// in the sessions.
+func ComputeDocumentStats(documentsDir string, document DocumentInfo, sessions []ListeningSession) (DocumentStats, error) {
+	stats := DocumentStats{
+		DocumentID:   document.ID,
+		DocumentName: document.Name,
+		Sessions:     len(sessions),
+	}
+	for _, session := range sessions {
+		stats.Listened += session.Duration
+		if stats.LastListened == nil || session.Ended.After(*stats.LastListened) {
+			ended := session.Ended
+			stats.LastListened = &ended
+		}
+	}
+
+	// Documents that have not been split yet have no paragraphs.
+	paragraphs, err := LoadParagraphInfos(documentsDir, document.ID)
+	if os.IsNotExist(err) {
+		return stats, nil
+	}
+	if err != nil {
+		return stats, err
+	}
+	document.MarkSkippedParagraphs(paragraphs)
+	durations := paragraphDurations(documentsDir, document.ID, paragraphs)
+
+	// Add up the paragraphs that were in any of the sessions.
+	for _, paragraph := range paragraphs {
+		if paragraph.Skip {
+			continue
+		}
+		stats.Paragraphs++
+		stats.Duration += durations[paragraph.ID]
+		if sessionsInclude(sessions, paragraph.ID) {
+			stats.ListenedParagraphs++
+		} else {
+			stats.Remaining += durations[paragraph.ID]
+		}
+	}
+	if stats.Duration > 0 {
+		stats.Completion = math.Round((stats.Duration-stats.Remaining)/stats.Duration*1000) / 10
+	}
+	return stats, nil
+}
+
+// sessionsInclude will check if the paragraph is between the start and end
+// paragraphs of any of the sessions.
+func sessionsInclude(sessions []ListeningSession, paragraphID string) bool {
+	id, _ := strconv.Atoi(paragraphID)
+	for _, session := range sessions {
+		start, _ := strconv.Atoi(session.StartParagraphID)
+		end, _ := strconv.Atoi(session.EndParagraphID)
+		if start <= id && id <= end {
+			return true
+		}
+	}
+	return false
+}
+
+// paragraphDurations will return the length of the audio of each paragraph in
+// seconds. The wav audio is used, or the mp3 audio if the wav files were
+// deleted. The length of paragraphs without audio is estimated from the size
+// of their text, at the rate of the paragraphs that have audio.
+func paragraphDurations(documentsDir, documentID string, paragraphs []ParagraphInfo) map[string]float64 {
+	durations := map[string]float64{}
+	sizes := map[string]int64{}
+	var knownSeconds float64
+	var knownBytes int64
+	for _, paragraph := range paragraphs {
+		if stat, err := os.Stat(path.Join(documentsDir, documentID, "paragraphs", paragraph.ID+".txt")); err == nil {
+			sizes[paragraph.ID] = stat.Size()
+		}
+		for _, format := range []AudioFormat{FormatWav, FormatMP3} {
+			info, err := readAudioInfo(paragraphAudioPath(documentsDir, documentID, paragraph.ID, format), format)
+			if err != nil {
+				continue
+			}
+			durations[paragraph.ID] = info.Duration.Seconds()
+			knownSeconds += info.Duration.Seconds()
+			knownBytes += sizes[paragraph.ID]
+			break
+		}
+	}
+
+	// Estimate the paragraphs without audio.
+	secondsPerByte := defaultSecondsPerByte
+	if knownBytes > 0 {
+		secondsPerByte = knownSeconds / float64(knownBytes)
+	}
+	for _, paragraph := range paragraphs {
+		if _, ok := durations[paragraph.ID]; !ok {
+			durations[paragraph.ID] = float64(sizes[paragraph.ID]) * secondsPerByte
+		}
+	}
+	return durations
+}
+
+// DailyListeningTotals will add up the duration of the sessions on each of
+// the last number of days up to and including today. Days without sessions
+// are included with no time listened. Sessions are counted on the day that
+// they started.
+func DailyListeningTotals(sessions []ListeningSession, days int, now time.Time) []DailyListening {
+	now = now.Local()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	totals := map[string]float64{}
+	for _, session := range sessions {
+		totals[session.Started.Local().Format("2006-01-02")] += session.Duration
+	}
+
+	daily := []DailyListening{}
+	for i := days - 1; i >= 0; i-- {
+		date := today.AddDate(0, 0, -i).Format("2006-01-02")
+		daily = append(daily, DailyListening{Date: date, Listened: totals[date]})
+	}
+	return daily
+}
+
+// sortDocumentStats will sort the statistics by when the documents were last
+// listened to, most recent first. Documents that have not been listened to
+// are sorted by name at the end.
+func sortDocumentStats(stats []DocumentStats) {
+	sort.SliceStable(stats, func(i, j int) bool {
+		a, b := stats[i].LastListened, stats[j].LastListened
+		switch {
+		case a != nil && b != nil:
+			return a.After(*b)
+		case a != nil || b != nil:
+			return a != nil
+		}
+		return stats[i].DocumentName < stats[j].DocumentName
+	})
+}
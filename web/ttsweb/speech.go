@@ -0,0 +1,554 @@
+package ttsweb
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// -----------------------------------------------------------------------------
+// Speech Rules
+// -----------------------------------------------------------------------------
+
+// SpeechRule is a user defined override for how text is spoken. Every match of
+// the pattern is replaced with the replacement before the text is synthesized.
+type SpeechRule struct {
+	// Pattern is a regular expression, e.g. `\bGNU\b`.
+	Pattern string `json:"pattern"`
+
+	// Replacement is the text that is spoken instead. Submatches of the
+	// pattern can be used with $1, $2 and so on.
+	Replacement string `json:"replacement"`
+
+	re *regexp.Regexp
+}
+
+// SpeechRules is a dictionary of speech rules. The rules are applied in order.
+type SpeechRules []SpeechRule
+
+// ParseSpeechRules will parse and compile the JSON list of speech rules.
+func ParseSpeechRules(data []byte) (SpeechRules, error) {
+	rules := SpeechRules{}
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, err
+	}
+	for i := range rules {
+		re, err := regexp.Compile(rules[i].Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid speech rule %q: %w", rules[i].Pattern, err)
+		}
+		rules[i].re = re
+	}
+	return rules, nil
+}
+
+// LoadSpeechRules will load the speech rules from the JSON file. No rules are
+// returned if the file does not exist.
+func LoadSpeechRules(file string) (SpeechRules, error) {
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return SpeechRules{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ParseSpeechRules(data)
+}
+
+// Apply will replace every match of each rule in the text.
+func (rules SpeechRules) Apply(text string) string {
+	for _, rule := range rules {
+		if rule.re != nil {
+			text = rule.re.ReplaceAllString(text, rule.Replacement)
+		}
+	}
+	return text
+}
+
+// documentSpeechRulesPath will return the path to the speech rules of the
+// document.
+func documentSpeechRulesPath(documentsDir, documentID string) string {
+	return path.Join(documentsDir, documentID, "speech-rules.json")
+}
+
+// -----------------------------------------------------------------------------
+// Speech Expanders
+// -----------------------------------------------------------------------------
+
+// SpeechExpander expands text that TTS models read badly into the words that
+// should be spoken, e.g. "Dr. Smith" into "Doctor Smith". The built in
+// expanders are for English text.
+type SpeechExpander struct {
+	Name   string
+	Expand func(text string) string
+}
+
+var (
+	// ExpandURLs reads web addresses and email addresses the way that they
+	// are spoken, e.g. "example dot com slash about".
+	ExpandURLs = SpeechExpander{Name: "urls", Expand: expandURLs}
+
+	// ExpandAbbreviations spells out common abbreviations, e.g. "Dr." and
+	// "e.g.".
+	ExpandAbbreviations = SpeechExpander{Name: "abbreviations", Expand: expandAbbreviations}
+
+	// ExpandCurrencies reads amounts of money, e.g. "$12.50" is "twelve
+	// dollars and fifty cents".
+	ExpandCurrencies = SpeechExpander{Name: "currencies", Expand: expandCurrencies}
+
+	// ExpandDates reads the day of dates as an ordinal, e.g. "May 5" is "May
+	// fifth".
+	ExpandDates = SpeechExpander{Name: "dates", Expand: expandDates}
+
+	// ExpandRomanNumerals reads roman numerals in chapter headings and the
+	// names of kings, e.g. "Chapter IV" and "Henry VIII".
+	ExpandRomanNumerals = SpeechExpander{Name: "roman", Expand: expandRomanNumerals}
+
+	// ExpandNumbers reads numbers, years, ordinals, percentages and times.
+	ExpandNumbers = SpeechExpander{Name: "numbers", Expand: expandNumbers}
+)
+
+// DefaultSpeechExpanders are all of the built in expanders in the order that
+// they must be applied. Numbers are expanded last so that the other expanders
+// can find the numbers that they read.
+var DefaultSpeechExpanders = []SpeechExpander{
+	ExpandURLs,
+	ExpandAbbreviations,
+	ExpandCurrencies,
+	ExpandDates,
+	ExpandRomanNumerals,
+	ExpandNumbers,
+}
+
+// ParseSpeechExpanders will parse a comma separated list of expander names,
+// e.g. "abbreviations,numbers". The expanders are always applied in the
+// default order. An empty string or "none" disables the expanders.
+func ParseSpeechExpanders(s string) ([]SpeechExpander, error) {
+	names := map[string]bool{}
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || name == "none" {
+			continue
+		}
+		found := false
+		for _, expander := range DefaultSpeechExpanders {
+			found = found || expander.Name == name
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown speech expander: %s", name)
+		}
+		names[name] = true
+	}
+
+	expanders := []SpeechExpander{}
+	for _, expander := range DefaultSpeechExpanders {
+		if names[expander.Name] {
+			expanders = append(expanders, expander)
+		}
+	}
+	return expanders, nil
+}
+
+// expandsLanguage will check if the built in expanders can be used for text in
+// the language. Text with an unknown language is expected to be English.
+func expandsLanguage(language string) bool {
+	return language == "" || language == "en" || strings.HasPrefix(language, "en-")
+}
+
+var (
+	urlPattern   = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"]+`)
+	emailPattern = regexp.MustCompile(`\b[\w.+-]+@[\w-]+(?:\.[\w-]+)+\b`)
+
+	// urlSymbols are the symbols in addresses that are read as words.
+	urlSymbols = strings.NewReplacer(
+		".", " dot ", "/", " slash ", "-", " dash ", "_", " underscore ",
+		"@", " at ", ":", " colon ", "~", " tilde ",
+	)
+)
+
+// expandURLs will replace the web and email addresses in the text with the
+// way that they are read. The scheme, "www." and the query are dropped.
+func expandURLs(text string) string {
+	text = urlPattern.ReplaceAllStringFunc(text, func(url string) string {
+		// Leave punctuation after the address in the text.
+		trimmed := strings.TrimRight(url, ".,;:!?)]'")
+		trailing := url[len(trimmed):]
+
+		address := trimmed
+		if i := strings.Index(address, "://"); i >= 0 {
+			address = address[i+3:]
+		}
+		address = strings.TrimPrefix(strings.TrimPrefix(address, "www."), "WWW.")
+		if i := strings.IndexAny(address, "?#"); i >= 0 {
+			address = address[:i]
+		}
+		address = strings.TrimSuffix(address, "/")
+		return collapseSpace(urlSymbols.Replace(address)) + trailing
+	})
+	return emailPattern.ReplaceAllStringFunc(text, func(email string) string {
+		return collapseSpace(urlSymbols.Replace(email))
+	})
+}
+
+var (
+	abbreviationPattern = regexp.MustCompile(`\b(Dr|St|Mr|Mrs|Ms|Prof|Mt|Jr|Sr|Capt|Lt|Col|Gen|Sgt|vs|etc|approx|e\.g|i\.e|No)\.`)
+
+	// abbreviations are spelled out wherever they are found.
+	abbreviations = map[string]string{
+		"Mr": "Mister", "Mrs": "Missus", "Ms": "Miz", "Prof": "Professor",
+		"Mt": "Mount", "Jr": "Junior", "Sr": "Senior", "Capt": "Captain",
+		"Lt": "Lieutenant", "Col": "Colonel", "Gen": "General",
+		"Sgt": "Sergeant", "vs": "versus", "etc": "et cetera",
+		"approx": "approximately", "e.g": "for example", "i.e": "that is",
+	}
+
+	// titleAbbreviations are the abbreviations that come before a name, the
+	// full stop after them never ends a sentence.
+	titleAbbreviations = map[string]bool{
+		"Mr": true, "Mrs": true, "Ms": true, "Prof": true, "Mt": true,
+		"Capt": true, "Lt": true, "Col": true, "Gen": true, "Sgt": true,
+	}
+)
+
+// expandAbbreviations will spell out the abbreviations in the text. "Dr." and
+// "St." are titles before a name and streets after one, "No." is only a
+// number when it is followed by one. The full stop is kept when the
+// abbreviation ends a sentence.
+func expandAbbreviations(text string) string {
+	var b strings.Builder
+	last := 0
+	for _, match := range abbreviationPattern.FindAllStringSubmatchIndex(text, -1) {
+		abbreviation := text[match[2]:match[3]]
+		after := text[match[1]:]
+		next, _ := utf8.DecodeRuneInString(strings.TrimLeft(after, " "))
+		beforeName := strings.HasPrefix(after, " ") && unicode.IsUpper(next)
+
+		expanded := abbreviations[abbreviation]
+		title := titleAbbreviations[abbreviation]
+		switch abbreviation {
+		case "Dr":
+			expanded, title = "Drive", false
+			if beforeName {
+				expanded, title = "Doctor", true
+			}
+		case "St":
+			expanded, title = "Street", false
+			if beforeName {
+				expanded, title = "Saint", true
+			}
+		case "No":
+			if !unicode.IsDigit(next) {
+				continue
+			}
+			expanded, title = "number", true
+		}
+		if strings.TrimSpace(after) == "" || (beforeName && !title) {
+			expanded += "."
+		}
+
+		b.WriteString(text[last:match[0]])
+		b.WriteString(expanded)
+		last = match[1]
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+var currencyPattern = regexp.MustCompile(`([$£€])\s?(\d{1,3}(?:,\d{3})+|\d+)(?:\.(\d{1,2}))?(?:\s(thousand|million|billion|trillion)\b)?`)
+
+// currencies are the names of the units and sub units of each currency
+// symbol, in the singular and the plural.
+var currencies = map[string][4]string{
+	"$": {"dollar", "dollars", "cent", "cents"},
+	"£": {"pound", "pounds", "penny", "pence"},
+	"€": {"euro", "euros", "cent", "cents"},
+}
+
+// expandCurrencies will read the amounts of money in the text, e.g. "$12.50"
+// is "twelve dollars and fifty cents" and "$1.5 million" is "one point five
+// million dollars".
+func expandCurrencies(text string) string {
+	return currencyPattern.ReplaceAllStringFunc(text, func(amount string) string {
+		match := currencyPattern.FindStringSubmatch(amount)
+		units := currencies[match[1]]
+		whole, fraction, scale := match[2], match[3], match[4]
+
+		// Large amounts are read as a decimal number of the scale.
+		if scale != "" {
+			words := integerWords(whole)
+			if fraction != "" {
+				words += " point " + digitWords(fraction)
+			}
+			return words + " " + scale + " " + units[1]
+		}
+
+		words := integerWords(whole)
+		if strings.ReplaceAll(whole, ",", "") == "1" {
+			words += " " + units[0]
+		} else {
+			words += " " + units[1]
+		}
+		if len(fraction) == 1 {
+			fraction += "0"
+		}
+		if cents, _ := strconv.Atoi(fraction); cents > 0 {
+			words += " and " + numberWords(int64(cents))
+			if cents == 1 {
+				words += " " + units[2]
+			} else {
+				words += " " + units[3]
+			}
+		}
+		return words
+	})
+}
+
+// monthNames maps the names and abbreviations of months to their full name.
+var monthNames = map[string]string{
+	"January": "January", "Jan": "January", "February": "February", "Feb": "February",
+	"March": "March", "Mar": "March", "April": "April", "Apr": "April", "May": "May",
+	"June": "June", "Jun": "June", "July": "July", "Jul": "July",
+	"August": "August", "Aug": "August", "September": "September", "Sep": "September",
+	"Sept": "September", "October": "October", "Oct": "October",
+	"November": "November", "Nov": "November", "December": "December", "Dec": "December",
+}
+
+// months are the month names in the order of the year.
+var months = []string{
+	"January", "February", "March", "April", "May", "June", "July", "August",
+	"September", "October", "November", "December",
+}
+
+var (
+	monthPattern       = `(January|February|March|April|May|June|July|August|September|October|November|December|Jan|Feb|Mar|Apr|Jun|Jul|Aug|Sept|Sep|Oct|Nov|Dec)\.?`
+	isoDatePattern     = regexp.MustCompile(`\b(\d{4})-(\d{2})-(\d{2})\b`)
+	monthDayPattern    = regexp.MustCompile(`\b` + monthPattern + ` (\d{1,2})(?:st|nd|rd|th)?\b`)
+	dayMonthPattern    = regexp.MustCompile(`\b(\d{1,2})(?:st|nd|rd|th)? ` + monthPattern + `(?:\s|$)`)
+	dayMonthEndPattern = regexp.MustCompile(`\s$`)
+)
+
+// expandDates will read the day of dates as an ordinal. The year is left as a
+// number, it is read as a year by the numbers expander.
+func expandDates(text string) string {
+	text = isoDatePattern.ReplaceAllStringFunc(text, func(date string) string {
+		match := isoDatePattern.FindStringSubmatch(date)
+		month, _ := strconv.Atoi(match[2])
+		day, _ := strconv.Atoi(match[3])
+		if month < 1 || month > 12 || day < 1 || day > 31 {
+			return date
+		}
+		return months[month-1] + " " + ordinalWords(int64(day)) + ", " + match[1]
+	})
+	text = monthDayPattern.ReplaceAllStringFunc(text, func(date string) string {
+		match := monthDayPattern.FindStringSubmatch(date)
+		day, _ := strconv.Atoi(match[2])
+		if day < 1 || day > 31 {
+			return date
+		}
+		return monthNames[match[1]] + " " + ordinalWords(int64(day))
+	})
+	return dayMonthPattern.ReplaceAllStringFunc(text, func(date string) string {
+		match := dayMonthPattern.FindStringSubmatch(date)
+		day, _ := strconv.Atoi(match[1])
+		if day < 1 || day > 31 {
+			return date
+		}
+		return "the " + ordinalWords(int64(day)) + " of " + monthNames[match[2]] +
+			dayMonthEndPattern.FindString(date)
+	})
+}
+
+var (
+	romanKeywordPattern   = regexp.MustCompile(`\b(Chapter|CHAPTER|Book|BOOK|Part|PART|Volume|Vol\.|Act|ACT|Scene|SCENE|Section|Canto|Appendix|Article|Psalm|Track|Episode) ([IVXLCDM]+)\b`)
+	romanNamePattern      = regexp.MustCompile(`\b([A-Z][a-z]+) ([IVXLCDM]{2,})\b`)
+	romanParagraphPattern = regexp.MustCompile(`^\s*([IVXLCDM]+)\.?\s*$`)
+
+	// maxRomanNameNumeral is the largest numeral that is read after a name,
+	// larger ones such as "DC" and "XL" are usually abbreviations.
+	maxRomanNameNumeral = 39
+
+	// romanCardinalNames are the words that are followed by a roman numeral
+	// that is read as a number rather than as the ordinal of a name.
+	romanCardinalNames = map[string]bool{
+		"War": true, "Type": true, "Class": true, "Phase": true, "Stage": true,
+		"Level": true, "Grade": true, "Mark": true, "Super": true, "Apollo": true,
+		"Gemini": true, "Vatican": true, "Rocky": true, "Final": true,
+	}
+
+	// romanOrdinalNames are the given names of kings, queens and popes that
+	// are followed by the ordinal of the name.
+	romanOrdinalNames = map[string]bool{
+		"Alexander": true, "Alfonso": true, "Amenhotep": true, "Anne": true,
+		"Benedict": true, "Boniface": true, "Carlos": true, "Catherine": true,
+		"Charles": true, "Christian": true, "Clement": true, "Constantine": true,
+		"David": true, "Edward": true, "Elizabeth": true, "Ferdinand": true,
+		"Francis": true, "Frederick": true, "George": true, "Gregory": true,
+		"Gustav": true, "Gustavus": true, "Haakon": true, "Harald": true,
+		"Henry": true, "Innocent": true, "Isabella": true, "Ivan": true,
+		"James": true, "John": true, "Joseph": true, "Juan": true, "Leo": true,
+		"Leopold": true, "Louis": true, "Ludwig": true, "Magnus": true,
+		"Malcolm": true, "Mary": true, "Napoleon": true, "Nicholas": true,
+		"Olaf": true, "Otto": true, "Paul": true, "Peter": true, "Philip": true,
+		"Pius": true, "Ptolemy": true, "Ramesses": true, "Richard": true,
+		"Robert": true, "Rudolf": true, "Sixtus": true, "Stephen": true,
+		"Thutmose": true, "Urban": true, "Victor": true, "Wilhelm": true,
+		"William": true,
+	}
+)
+
+// expandRomanNumerals will read the roman numerals in the text. Numerals after
+// words such as "Chapter" and paragraphs that are only a numeral are read as
+// numbers. Numerals up to XXXIX after a known name are read as the ordinal of
+// the name, e.g. "Henry VIII" is "Henry the eighth", other capitals after a
+// word such as "Washington DC" are left alone. The single letters "I", "V" and
+// "X" are only read as numerals after a keyword, since they are usually words.
+func expandRomanNumerals(text string) string {
+	if match := romanParagraphPattern.FindStringSubmatch(text); match != nil {
+		if n, ok := parseRoman(match[1]); ok {
+			return numberWords(int64(n))
+		}
+	}
+	text = romanKeywordPattern.ReplaceAllStringFunc(text, func(s string) string {
+		match := romanKeywordPattern.FindStringSubmatch(s)
+		n, ok := parseRoman(match[2])
+		if !ok {
+			return s
+		}
+		return match[1] + " " + numberWords(int64(n))
+	})
+	return romanNamePattern.ReplaceAllStringFunc(text, func(s string) string {
+		match := romanNamePattern.FindStringSubmatch(s)
+		n, ok := parseRoman(match[2])
+		if !ok || n > maxRomanNameNumeral {
+			return s
+		}
+		switch {
+		case romanCardinalNames[match[1]]:
+			return match[1] + " " + numberWords(int64(n))
+		case romanOrdinalNames[match[1]]:
+			return match[1] + " the " + ordinalWords(int64(n))
+		default:
+			return s
+		}
+	})
+}
+
+var (
+	timePattern   = regexp.MustCompile(`\b(\d{1,2}):(\d{2})\b`)
+	numberPattern = regexp.MustCompile(`\b(\d{1,3}(?:,\d{3})+|\d+)(\.\d+)?(st|nd|rd|th|s|\s?%)?`)
+)
+
+// expandNumbers will read the numbers in the text. Four digit numbers between
+// 1100 and 2099 that stand alone are read as years, since that is what they
+// usually are in books. Numbers with commas are always read as numbers.
+// Numbers that are joined to other numbers by "-" or ".", such as phone
+// numbers and versions, are left for the voice to read.
+func expandNumbers(text string) string {
+	text = timePattern.ReplaceAllStringFunc(text, func(s string) string {
+		match := timePattern.FindStringSubmatch(s)
+		hours, _ := strconv.Atoi(match[1])
+		minutes, _ := strconv.Atoi(match[2])
+		if hours > 23 || minutes > 59 {
+			return s
+		}
+		switch {
+		case minutes == 0:
+			return numberWords(int64(hours)) + " o'clock"
+		case minutes < 10:
+			return numberWords(int64(hours)) + " oh " + numberWords(int64(minutes))
+		default:
+			return numberWords(int64(hours)) + " " + numberWords(int64(minutes))
+		}
+	})
+
+	var b strings.Builder
+	last := 0
+	for _, match := range numberPattern.FindAllStringSubmatchIndex(text, -1) {
+		// Skip numbers that are part of a word, e.g. "mp3".
+		if next, _ := utf8.DecodeRuneInString(text[match[1]:]); unicode.IsLetter(next) || unicode.IsDigit(next) {
+			continue
+		}
+		if joinedNumber(text, match[0], match[1]) {
+			continue
+		}
+		whole := text[match[2]:match[3]]
+		fraction, suffix := "", ""
+		if match[4] >= 0 {
+			fraction = text[match[4]+1 : match[5]]
+		}
+		if match[6] >= 0 {
+			suffix = strings.TrimSpace(text[match[6]:match[7]])
+		}
+		n, _ := strconv.ParseInt(whole, 10, 64)
+		year := !strings.Contains(whole, ",") && len(whole) == 4 && n >= 1100 && n <= 2099 &&
+			fraction == "" && (suffix == "" || suffix == "s")
+
+		var words string
+		switch {
+		case fraction != "":
+			words = integerWords(whole) + " point " + digitWords(fraction)
+		case suffix == "st" || suffix == "nd" || suffix == "rd" || suffix == "th":
+			words = toOrdinal(integerWords(whole))
+		case suffix == "s" && year:
+			words = toPlural(yearWords(n))
+		case suffix == "s":
+			words = toPlural(integerWords(whole))
+		case year:
+			words = yearWords(n)
+		case len(whole) > 1 && whole[0] == '0':
+			words = digitWords(whole)
+		default:
+			words = integerWords(whole)
+		}
+		if suffix == "%" {
+			words += " percent"
+		}
+
+		b.WriteString(text[last:match[0]])
+		b.WriteString(words)
+		last = match[1]
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// joinedNumber will check if the number between start and end is joined to
+// another number by "-" or ".", e.g. "555-1234" or "3.14.15".
+func joinedNumber(text string, start, end int) bool {
+	isDigit := func(b byte) bool { return b >= '0' && b <= '9' }
+	isJoin := func(b byte) bool { return b == '-' || b == '.' }
+	if start >= 2 && isJoin(text[start-1]) && isDigit(text[start-2]) {
+		return true
+	}
+	return end+1 < len(text) && isJoin(text[end]) && isDigit(text[end+1])
+}
+
+// -----------------------------------------------------------------------------
+// Spoken Text
+// -----------------------------------------------------------------------------
+
+// Speech is the text of a paragraph prepared for the synthesizer that reads
+// it. The text is an SSML document if the synthesizer supports SSML.
+type Speech struct {
+	Voice       Voice
+	Synthesizer Synthesizer
+	Text        string
+}
+
+// SpokenParagraph is the text of a paragraph exactly as it is read by the
+// voice, once the speech rules, expanders and transliteration are applied.
+type SpokenParagraph struct {
+	ID       string `json:"id"`
+	Language string `json:"language"`
+	Model    string `json:"model"`
+	Speaker  string `json:"speaker"`
+	SSML     bool   `json:"ssml"`
+	Text     string `json:"text"`
+}
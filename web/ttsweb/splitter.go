@@ -0,0 +1,174 @@
+package ttsweb
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// -----------------------------------------------------------------------------
+// Splitters
+// -----------------------------------------------------------------------------
+
+// Splitter splits a document into paragraphs. Each paragraph is written to a
+// numbered text file in the output directory, starting from 0.txt. Splitters
+// that keep the markup of the paragraphs write it to the markup directory
+// next to the output directory.
+type Splitter interface {
+	// Split will split the input file into paragraphs in the output
+	// directory. The headings that were found in the document are returned
+	// along with the ID of the paragraph that each heading starts at.
+	Split(ctx context.Context, inputFile, outputDir string) ([]Heading, error)
+}
+
+// Heading is a chapter or section title within a document.
+type Heading struct {
+	Title string `json:"title"`
+
+	// Level of the heading. Chapters are level 1, sections within a chapter
+	// are level 2 and so on.
+	Level int `json:"level"`
+
+	// ParagraphID is the ID of the first paragraph of the chapter or section.
+	ParagraphID string `json:"paragraphId"`
+}
+
+// DefaultSplitters will return the splitters that are built into the server,
+// keyed by the file extension they handle. Any other file type is split by
+// the split-document.sh script.
+func DefaultSplitters() map[string]Splitter {
+	return map[string]Splitter{
+		".epub":     EPUBSplitter{},
+		".docx":     PandocSplitter{},
+		".pdf":      PDFSplitter{Fallback: ScriptSplitter{}},
+		".md":       MarkdownSplitter{},
+		".markdown": MarkdownSplitter{},
+		".html":     HTMLSplitter{},
+		".htm":      HTMLSplitter{},
+		".xhtml":    HTMLSplitter{},
+	}
+}
+
+// ScriptSplitter is a Splitter that runs the split-document.sh script. The
+// script converts the document to plain text with pandoc, so no headings are
+// found. The text is kept as UTF-8 so that it can be normalized by the
+// pipeline.
+type ScriptSplitter struct {
+	// Script is the path to split-document.sh. If empty, the script is
+	// expected in the parent of the working directory.
+	Script string
+}
+
+// Split will run the paragraph splitter script.
+func (s ScriptSplitter) Split(ctx context.Context, inputFile, outputDir string) ([]Heading, error) {
+	script := s.Script
+	if script == "" {
+		script = "../split-document.sh"
+	}
+
+	// Run the paragraph splitter script.
+	cmd := exec.CommandContext(ctx, "bash", script,
+		"--output", outputDir,
+		"--keep-unicode",
+		"--", inputFile)
+
+	// Log the output of the script.
+	if err := runCommand(ctx, cmd); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// Check will check that the script exists and that bash and pandoc are
+// installed, and return the version of pandoc.
+func (s ScriptSplitter) Check(ctx context.Context) (string, error) {
+	script := s.Script
+	if script == "" {
+		script = "../split-document.sh"
+	}
+	if err := checkScript(script); err != nil {
+		return "", err
+	}
+	if _, err := exec.LookPath("bash"); err != nil {
+		return "", err
+	}
+	return toolVersion(ctx, "pandoc", "--version")
+}
+
+// -----------------------------------------------------------------------------
+// Blocks
+// -----------------------------------------------------------------------------
+
+// Block is a block of text that has been extracted from a document by one of
+// the Go splitters. Each block becomes a paragraph of the document.
+type Block struct {
+	Text string
+
+	// HeadingLevel is the level of the heading if the block is a heading, or
+	// 0 if the block is body text.
+	HeadingLevel int
+
+	// Quote is set if the block is a block quote.
+	Quote bool
+
+	// Markup is the text with its emphasis kept as markup. If empty, the
+	// block has no emphasis.
+	Markup string
+}
+
+// writeBlocks will write the blocks to numbered paragraph files in the output
+// directory, and the markup of the blocks that have any to the markup
+// directory. Empty blocks are skipped. The headings are returned with the ID
+// of the paragraph that they were written to.
+func writeBlocks(outputDir string, blocks []Block) ([]Heading, error) {
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return nil, err
+	}
+	markupOutputDir := markupDir(outputDir)
+	if err := os.MkdirAll(markupOutputDir, 0755); err != nil {
+		return nil, err
+	}
+
+	headings := []Heading{}
+	id := 0
+	for _, block := range blocks {
+		text := collapseSpace(block.Text)
+		if text == "" {
+			continue
+		}
+		paragraphID := strconv.Itoa(id)
+		if err := ioutil.WriteFile(filepath.Join(outputDir, paragraphID+".txt"), []byte(text), 0644); err != nil {
+			return nil, err
+		}
+		if markup := blockMarkup(block); markup != escapeMarkup(text) {
+			if err := ioutil.WriteFile(filepath.Join(markupOutputDir, paragraphID+".txt"), []byte(markup), 0644); err != nil {
+				return nil, err
+			}
+		}
+		if block.HeadingLevel > 0 {
+			headings = append(headings, Heading{
+				Title:       text,
+				Level:       block.HeadingLevel,
+				ParagraphID: paragraphID,
+			})
+		}
+		id++
+	}
+
+	if id == 0 {
+		return nil, fmt.Errorf("no text found in document")
+	}
+	return headings, nil
+}
+
+// collapseSpace will replace runs of white space with a single space and trim
+// the white space from the ends of the text.
+func collapseSpace(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
@@ -0,0 +1,371 @@
+package ttsweb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// -----------------------------------------------------------------------------
+// Document Stream
+// -----------------------------------------------------------------------------
+
+// streamFormats are the audio formats that can be joined into a continuous
+// stream. Wav audio is joined under a single new header, mp3 frames can be
+// concatenated as they are.
+var streamFormats = []AudioFormat{FormatWav, FormatMP3}
+
+// AudioStream is the audio of a run of paragraphs joined together into a
+// single continuous audio file.
+type AudioStream struct {
+	Format AudioFormat
+
+	// Size is the total size of the stream in bytes, including any header.
+	Size int64
+
+	// Duration is the total playback time of the stream.
+	Duration time.Duration
+
+	// Timeline maps the byte and time offsets of the stream to the paragraphs
+	// of the document.
+	Timeline []StreamTimelineEntry
+
+	// ModTime is the latest modification time of the paragraph audio.
+	ModTime time.Time
+
+	segments []streamSegment
+}
+
+// StreamTimelineEntry is the position of a single paragraph within a stream.
+// The byte and time ranges include the start and exclude the end.
+type StreamTimelineEntry struct {
+	ParagraphID string `json:"paragraphId"`
+	ByteStart   int64  `json:"byteStart"`
+	ByteEnd     int64  `json:"byteEnd"`
+	StartMs     int64  `json:"startMs"`
+	EndMs       int64  `json:"endMs"`
+}
+
+// StreamTimeline is the sidecar description of a stream that is returned to
+// the client alongside the audio.
+type StreamTimeline struct {
+	DocumentID  string                `json:"documentId"`
+	Format      string                `json:"format"`
+	ContentType string                `json:"contentType"`
+	Size        int64                 `json:"size"`
+	DurationMs  int64                 `json:"durationMs"`
+	Link        string                `json:"link"`
+	Paragraphs  []StreamTimelineEntry `json:"paragraphs"`
+}
+
+// streamSegment is a run of bytes within the stream. It is either held in
+// memory, as the header is, or read from a region of an audio file.
+type streamSegment struct {
+	start int64
+	size  int64
+
+	data   []byte
+	path   string
+	offset int64
+}
+
+// BuildAudioStream will join the audio of the paragraphs into a stream in the
+// specified format. Paragraphs without audio in the format are left out of the
+// stream. For wav audio, all of the paragraphs must share the same sample
+// format, any paragraph that differs from the first one is left out.
+func BuildAudioStream(documentsDir, documentID string, paragraphIDs []string, format AudioFormat) (*AudioStream, error) {
+	stream := &AudioStream{Format: format}
+
+	// Read the layout of each of the audio files.
+	var header []byte
+	var duration time.Duration
+	for _, paragraphID := range paragraphIDs {
+		audioPath := paragraphAudioPath(documentsDir, documentID, paragraphID, format)
+		info, err := readAudioInfo(audioPath, format)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				slog.Warn("Unable to read the audio", "path", audioPath, "error", err)
+			}
+			continue
+		}
+		if format.Name == FormatWav.Name {
+			if header == nil {
+				header = info.Header
+			} else if !bytes.Equal(header, info.Header) {
+				slog.Warn("Skipping paragraph with a different audio format", "path", audioPath)
+				continue
+			}
+		}
+		if info.modTime.After(stream.ModTime) {
+			stream.ModTime = info.modTime
+		}
+
+		stream.segments = append(stream.segments, streamSegment{
+			start:  stream.Size,
+			size:   info.DataSize,
+			path:   audioPath,
+			offset: info.DataOffset,
+		})
+		stream.Timeline = append(stream.Timeline, StreamTimelineEntry{
+			ParagraphID: paragraphID,
+			ByteStart:   stream.Size,
+			ByteEnd:     stream.Size + info.DataSize,
+			StartMs:     duration.Milliseconds(),
+			EndMs:       (duration + info.Duration).Milliseconds(),
+		})
+		stream.Size += info.DataSize
+		duration += info.Duration
+	}
+	if len(stream.segments) == 0 {
+		return nil, fmt.Errorf("no %s audio found for document %s", format.Name, documentID)
+	}
+	stream.Duration = duration
+
+	// Wav streams need a header in front of the audio.
+	if format.Name == FormatWav.Name {
+		stream.prepend(wavHeader(header, stream.Size))
+	}
+
+	// Return the stream.
+	return stream, nil
+}
+
+// prepend will put the data in front of the audio of the stream, shifting
+// everything along to make room for it.
+func (s *AudioStream) prepend(data []byte) {
+	offset := int64(len(data))
+	for i := range s.segments {
+		s.segments[i].start += offset
+	}
+	for i := range s.Timeline {
+		s.Timeline[i].ByteStart += offset
+		s.Timeline[i].ByteEnd += offset
+	}
+	s.segments = append([]streamSegment{{start: 0, size: offset, data: data}}, s.segments...)
+	s.Size += offset
+}
+
+// SetTimestamp will start an mp3 stream with an ID3 tag holding the time that
+// the stream starts at within the whole document. HLS players need the tag
+// at the start of each packed audio segment to place it on the timeline.
+func (s *AudioStream) SetTimestamp(start time.Duration) error {
+	if s.Format.Name != FormatMP3.Name {
+		return fmt.Errorf("timestamps are not supported for %s audio", s.Format.Name)
+	}
+	s.prepend(id3TimestampTag(start))
+	return nil
+}
+
+// id3TimestampTag will create an ID3v2.4 tag with the PRIV frame that HLS
+// packed audio uses for the timestamp of a segment. The timestamp is the 33
+// bit MPEG-2 presentation time, which counts at 90kHz.
+func id3TimestampTag(start time.Duration) []byte {
+	const owner = "com.apple.streaming.transportStreamTimestamp\x00"
+	pts := uint64(start.Microseconds()*9/100) & (1<<33 - 1)
+
+	frame := make([]byte, 0, 10+len(owner)+8)
+	frame = append(frame, "PRIV"...)
+	frame = append(frame, syncsafe(len(owner)+8)...)
+	frame = append(frame, 0, 0)
+	frame = append(frame, owner...)
+	frame = binary.BigEndian.AppendUint64(frame, pts)
+
+	tag := make([]byte, 0, 10+len(frame))
+	tag = append(tag, 'I', 'D', '3', 4, 0, 0)
+	tag = append(tag, syncsafe(len(frame))...)
+	return append(tag, frame...)
+}
+
+// syncsafe will encode the size of an ID3 tag or frame in four bytes of seven
+// bits each.
+func syncsafe(size int) []byte {
+	return []byte{byte(size >> 21 & 0x7f), byte(size >> 14 & 0x7f), byte(size >> 7 & 0x7f), byte(size & 0x7f)}
+}
+
+// Reader will return a reader over the bytes of the stream. The reader can seek
+// so that byte ranges of the stream can be served. The reader must be closed
+// once it is no longer needed.
+func (s *AudioStream) Reader() io.ReadSeekCloser {
+	return &streamReader{stream: s, segment: -1}
+}
+
+// ParagraphIDs will return the IDs of the paragraphs that are in the stream.
+func (s *AudioStream) ParagraphIDs() []string {
+	ids := []string{}
+	for _, entry := range s.Timeline {
+		ids = append(ids, entry.ParagraphID)
+	}
+	return ids
+}
+
+// ParagraphAt will return the timeline entry of the paragraph that contains
+// the byte offset of the stream. The header of a wav stream belongs to the
+// first paragraph.
+func (s *AudioStream) ParagraphAt(offset int64) (StreamTimelineEntry, bool) {
+	if offset < 0 || offset >= s.Size || len(s.Timeline) == 0 {
+		return StreamTimelineEntry{}, false
+	}
+	i := sort.Search(len(s.Timeline), func(i int) bool {
+		return s.Timeline[i].ByteEnd > offset
+	})
+	if i == len(s.Timeline) {
+		return StreamTimelineEntry{}, false
+	}
+	return s.Timeline[i], true
+}
+
+// rangeStart will return the first byte offset requested by the Range header
+// of a request for a resource of the size. Suffix ranges count back from the
+// end of the resource.
+func rangeStart(header string, size int64) (int64, bool) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return 0, false
+	}
+	first, _, _ := strings.Cut(spec, ",")
+	start, end, ok := strings.Cut(strings.TrimSpace(first), "-")
+	if !ok {
+		return 0, false
+	}
+	if start == "" {
+		suffix, err := strconv.ParseInt(end, 10, 64)
+		if err != nil || suffix <= 0 {
+			return 0, false
+		}
+		if suffix > size {
+			suffix = size
+		}
+		return size - suffix, true
+	}
+	offset, err := strconv.ParseInt(start, 10, 64)
+	if err != nil || offset < 0 {
+		return 0, false
+	}
+	return offset, true
+}
+
+// streamReader reads the segments of a stream in order, opening each of the
+// paragraph audio files as they are reached.
+type streamReader struct {
+	stream *AudioStream
+	pos    int64
+
+	// The currently open audio file and the index of its segment.
+	file    *os.File
+	segment int
+}
+
+// Read implements io.Reader.
+func (r *streamReader) Read(p []byte) (int, error) {
+	if r.pos >= r.stream.Size {
+		return 0, io.EOF
+	}
+
+	// Find the segment that contains the current position.
+	segments := r.stream.segments
+	i := sort.Search(len(segments), func(i int) bool {
+		return segments[i].start+segments[i].size > r.pos
+	})
+	segment := segments[i]
+	within := r.pos - segment.start
+	remaining := segment.size - within
+	if int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+
+	// Read from memory or from the audio file.
+	var n int
+	var err error
+	if segment.data != nil {
+		n = copy(p, segment.data[within:])
+	} else {
+		if r.segment != i {
+			if r.file != nil {
+				r.file.Close()
+				r.file = nil
+			}
+			if r.file, err = os.Open(segment.path); err != nil {
+				return 0, err
+			}
+			r.segment = i
+		}
+		n, err = r.file.ReadAt(p, segment.offset+within)
+		if err == io.EOF && n == len(p) {
+			err = nil
+		}
+	}
+	r.pos += int64(n)
+
+	return n, err
+}
+
+// Seek implements io.Seeker.
+func (r *streamReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.stream.Size
+	default:
+		return 0, fmt.Errorf("invalid whence: %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative position: %d", offset)
+	}
+	r.pos = offset
+	return r.pos, nil
+}
+
+// Close implements io.Closer.
+func (r *streamReader) Close() error {
+	if r.file != nil {
+		return r.file.Close()
+	}
+	return nil
+}
+
+// paragraphRange will return the IDs of the paragraphs from the paragraph with
+// the ID from up to and including the paragraph with the ID to. If from is
+// empty, the range starts at the first paragraph. If to is empty, the range
+// runs to the last paragraph.
+func paragraphRange(paragraphs []ParagraphInfo, from, to string) ([]string, error) {
+	start, end := 0, len(paragraphs)-1
+	if from != "" {
+		start = -1
+		for i, paragraph := range paragraphs {
+			if paragraph.ID == from {
+				start = i
+				break
+			}
+		}
+		if start < 0 {
+			return nil, fmt.Errorf("paragraph not found: %s", from)
+		}
+	}
+	if to != "" {
+		end = -1
+		for i, paragraph := range paragraphs {
+			if paragraph.ID == to {
+				end = i
+				break
+			}
+		}
+		if end < 0 {
+			return nil, fmt.Errorf("paragraph not found: %s", to)
+		}
+	}
+
+	ids := []string{}
+	for i := start; i <= end; i++ {
+		ids = append(ids, paragraphs[i].ID)
+	}
+	return ids, nil
+}
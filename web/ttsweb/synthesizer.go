@@ -0,0 +1,148 @@
+package ttsweb
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// -----------------------------------------------------------------------------
+// Synthesizers
+// -----------------------------------------------------------------------------
+
+// Synthesizer is a TTS engine that turns the text of paragraphs into audio.
+type Synthesizer interface {
+	// Synthesize will read the text file of each paragraph, {id}.txt, in the
+	// text directory and write its audio to {id}.wav in the audio directory.
+	Synthesize(ctx context.Context, voice Voice, textDir, audioDir string, paragraphIDs []string) error
+
+	// SupportsSSML reports whether the text files may be SSML documents
+	// instead of plain text.
+	SupportsSSML() bool
+}
+
+// DefaultSynthesizers will return the synthesizers that are built into the
+// server, keyed by the engine name of the voices that they synthesize.
+func DefaultSynthesizers() map[string]Synthesizer {
+	return map[string]Synthesizer{
+		EngineCoqui:  CoquiSynthesizer{},
+		EngineEspeak: EspeakSynthesizer{},
+	}
+}
+
+const (
+	// EngineCoqui is the engine name of the Coqui TTS models. It is the
+	// engine of voices that do not name one.
+	EngineCoqui = "coqui"
+
+	// EngineEspeak is the engine name of the espeak-ng voices.
+	EngineEspeak = "espeak"
+)
+
+// CoquiSynthesizer is a Synthesizer that runs the split-txt-to-tts.py script
+// with a Coqui TTS model. The models only read plain text.
+type CoquiSynthesizer struct {
+	// Script is the path to split-txt-to-tts.py. If empty, the script is
+	// expected in the parent of the working directory.
+	Script string
+}
+
+// Synthesize will run the paragraph synthesizer script.
+func (s CoquiSynthesizer) Synthesize(ctx context.Context, voice Voice, textDir, audioDir string, paragraphIDs []string) error {
+	script := s.Script
+	if script == "" {
+		script = "../split-txt-to-tts.py"
+	}
+
+	// Run the paragraph synthesizer script.
+	cmd := exec.CommandContext(ctx, "python3", script,
+		"--model", voice.Model,
+		"--speaker", voice.Speaker,
+		"--ids", strings.Join(paragraphIDs, ","),
+		"--text-dir", textDir,
+		"--out-dir", audioDir)
+
+	// Log the output of the script.
+	return runCommand(ctx, cmd)
+}
+
+// Check will check that the script exists and that the Coqui TTS package can
+// be imported by python3, and return the version of the package.
+func (s CoquiSynthesizer) Check(ctx context.Context) (string, error) {
+	script := s.Script
+	if script == "" {
+		script = "../split-txt-to-tts.py"
+	}
+	if err := checkScript(script); err != nil {
+		return "", err
+	}
+	version, err := toolVersion(ctx, "python3", "-c", "import TTS; print(TTS.__version__)")
+	if err != nil {
+		return "", err
+	}
+	return "TTS " + version, nil
+}
+
+// SupportsSSML reports that the Coqui models read plain text.
+func (s CoquiSynthesizer) SupportsSSML() bool {
+	return false
+}
+
+// EspeakSynthesizer is a Synthesizer that runs espeak-ng. The model of the
+// voice is the espeak-ng voice name, e.g. "en-gb", and the speaker is the
+// optional variant, e.g. "f3". espeak-ng reads SSML.
+type EspeakSynthesizer struct {
+	// Path is the path to the espeak-ng binary. If empty, espeak-ng is
+	// expected on the PATH.
+	Path string
+}
+
+// Synthesize will run espeak-ng for each of the paragraphs.
+func (s EspeakSynthesizer) Synthesize(ctx context.Context, voice Voice, textDir, audioDir string, paragraphIDs []string) error {
+	binary := s.Path
+	if binary == "" {
+		binary = "espeak-ng"
+	}
+	name := voice.Model
+	if voice.Speaker != "" {
+		name += "+" + voice.Speaker
+	}
+
+	// Synthesize each paragraph. Keep going if one of the paragraphs fails
+	// so the others are still synthesized.
+	failed := []string{}
+	for _, paragraphID := range paragraphIDs {
+		cmd := exec.CommandContext(ctx, binary, "-m",
+			"-v", name,
+			"-w", path.Join(audioDir, paragraphID+".wav"),
+			"-f", path.Join(textDir, paragraphID+".txt"))
+		if err := runCommand(ctx, cmd); err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			slog.WarnContext(ctx, "Unable to synthesize paragraph", "paragraph_id", paragraphID, "error", err)
+			failed = append(failed, paragraphID)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to synthesize paragraphs with espeak-ng: %s", strings.Join(failed, ","))
+	}
+	return nil
+}
+
+// Check will check that espeak-ng is installed and return its version.
+func (s EspeakSynthesizer) Check(ctx context.Context) (string, error) {
+	binary := s.Path
+	if binary == "" {
+		binary = "espeak-ng"
+	}
+	return toolVersion(ctx, binary, "--version")
+}
+
+// SupportsSSML reports that espeak-ng reads SSML.
+func (s EspeakSynthesizer) SupportsSSML() bool {
+	return true
+}
@@ -0,0 +1,158 @@
+package ttsweb
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// -----------------------------------------------------------------------------
+// Table of Contents
+// -----------------------------------------------------------------------------
+
+// TableOfContents is the structure of a document. It lists the chapters and
+// sections of the document along with the range of paragraphs in each. The
+// table of contents is stored in the toc.json file in the document directory.
+type TableOfContents struct {
+	DocumentID string     `json:"documentId"`
+	Entries    []TOCEntry `json:"entries"`
+	Link       string     `json:"link"`
+}
+
+// TOCEntry is a single chapter or section of a document. The entries are
+// listed in document order. An entry contains all of the entries with a
+// higher level that follow it, up until the next entry with the same or a
+// lower level.
+type TOCEntry struct {
+	// ID of the entry. This is the index of the entry in the table of
+	// contents.
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	Level int    `json:"level"`
+
+	// StartParagraphID and EndParagraphID are the IDs of the first and last
+	// paragraphs of the entry, inclusive.
+	StartParagraphID string `json:"startParagraphId"`
+	EndParagraphID   string `json:"endParagraphId"`
+
+	ParagraphsLink string `json:"paragraphsLink"`
+}
+
+// BuildTableOfContents will create the table of contents of a document from
+// the headings found by the splitter. The paragraph IDs must be all of the
+// paragraphs of the document in order.
+func BuildTableOfContents(documentID string, headings []Heading, paragraphIDs []string) TableOfContents {
+	toc := TableOfContents{
+		DocumentID: documentID,
+		Entries:    []TOCEntry{},
+		Link:       "/documents/" + documentID + "/toc",
+	}
+	if len(paragraphIDs) == 0 {
+		return toc
+	}
+
+	// Find the position of each paragraph so that the end of each entry can
+	// be found.
+	positions := map[string]int{}
+	for i, id := range paragraphIDs {
+		positions[id] = i
+	}
+
+	for i, heading := range headings {
+		start, ok := positions[heading.ParagraphID]
+		if !ok {
+			continue
+		}
+
+		// The entry ends before the next heading at the same or a lower
+		// level, or at the end of the document.
+		end := len(paragraphIDs) - 1
+		for _, next := range headings[i+1:] {
+			if next.Level <= heading.Level {
+				if position, ok := positions[next.ParagraphID]; ok && position > start {
+					end = position - 1
+					break
+				}
+			}
+		}
+
+		id := strconv.Itoa(len(toc.Entries))
+		toc.Entries = append(toc.Entries, TOCEntry{
+			ID:               id,
+			Title:            heading.Title,
+			Level:            heading.Level,
+			StartParagraphID: paragraphIDs[start],
+			EndParagraphID:   paragraphIDs[end],
+			ParagraphsLink:   "/documents/" + documentID + "/toc/" + id + "/paragraphs",
+		})
+	}
+
+	return toc
+}
+
+// Entry will return the entry of the table of contents with the specified ID.
+func (t TableOfContents) Entry(id string) (TOCEntry, bool) {
+	for _, entry := range t.Entries {
+		if entry.ID == id {
+			return entry, true
+		}
+	}
+	return TOCEntry{}, false
+}
+
+// ChapterStarts will return the IDs of the paragraphs that start a chapter. A
+// chapter is an entry with the lowest level in the table of contents.
+func (t TableOfContents) ChapterStarts() map[string]bool {
+	starts := map[string]bool{}
+	level := 0
+	for _, entry := range t.Entries {
+		if level == 0 || entry.Level < level {
+			level = entry.Level
+		}
+	}
+	for _, entry := range t.Entries {
+		if entry.Level == level {
+			starts[entry.StartParagraphID] = true
+		}
+	}
+	return starts
+}
+
+// tocPath will return the path to the table of contents of the document.
+func tocPath(documentsDir, documentID string) string {
+	return filepath.Join(documentsDir, documentID, "toc.json")
+}
+
+// LoadTableOfContents will load the table of contents of the document. If the
+// document does not have a table of contents, an empty one is returned.
+func LoadTableOfContents(documentsDir, documentID string) (TableOfContents, error) {
+	toc := TableOfContents{
+		DocumentID: documentID,
+		Entries:    []TOCEntry{},
+		Link:       "/documents/" + documentID + "/toc",
+	}
+
+	data, err := ioutil.ReadFile(tocPath(documentsDir, documentID))
+	if os.IsNotExist(err) {
+		return toc, nil
+	}
+	if err != nil {
+		return toc, err
+	}
+	if err := json.Unmarshal(data, &toc); err != nil {
+		return toc, fmt.Errorf("invalid table of contents for document %s: %v", documentID, err)
+	}
+	return toc, nil
+}
+
+// Save will store the table of contents in the document directory.
+func (t TableOfContents) Save(documentsDir string) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(tocPath(documentsDir, t.DocumentID), data, 0644)
+}
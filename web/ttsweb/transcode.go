@@ -0,0 +1,295 @@
+package ttsweb
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// -----------------------------------------------------------------------------
+// Audio Formats
+// -----------------------------------------------------------------------------
+
+// AudioFormat describes an audio rendition of a paragraph that can be stored in
+// the audio directory of a document and served to the user.
+type AudioFormat struct {
+	// Name of the format. This is used when configuring the formats from the
+	// command line, e.g. "opus" or "mp3".
+	Name string `json:"name"`
+
+	// Extension of the audio file, including the leading dot.
+	Extension string `json:"extension"`
+
+	// ContentType is the primary MIME type of the format. This is used for
+	// the Content-Type header when serving the audio.
+	ContentType string `json:"contentType"`
+
+	// Aliases are other MIME types that clients might use in their Accept
+	// header to ask for this format.
+	Aliases []string `json:"-"`
+}
+
+var (
+	// FormatWav is the raw audio that is produced by the synthesizer.
+	FormatWav = AudioFormat{
+		Name:        "wav",
+		Extension:   ".wav",
+		ContentType: "audio/wav",
+		Aliases:     []string{"audio/wave", "audio/x-wav", "audio/vnd.wave"},
+	}
+
+	// FormatOpus is Opus audio in an Ogg container.
+	FormatOpus = AudioFormat{
+		Name:        "opus",
+		Extension:   ".opus",
+		ContentType: "audio/ogg",
+		Aliases:     []string{"audio/opus", "application/ogg"},
+	}
+
+	// FormatMP3 is MPEG-1 Audio Layer III.
+	FormatMP3 = AudioFormat{
+		Name:        "mp3",
+		Extension:   ".mp3",
+		ContentType: "audio/mpeg",
+		Aliases:     []string{"audio/mp3"},
+	}
+
+	// compressedAudioFormats are the formats that the synthesized audio can
+	// be transcoded into.
+	compressedAudioFormats = []AudioFormat{FormatOpus, FormatMP3}
+)
+
+// ParseAudioFormats will parse a comma separated list of audio format names
+// into the list of audio formats, e.g. "opus,mp3".
+func ParseAudioFormats(s string) ([]AudioFormat, error) {
+	formats := []AudioFormat{}
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		format, ok := findAudioFormat(compressedAudioFormats, strings.ToLower(name))
+		if !ok {
+			return nil, fmt.Errorf("unsupported audio format: %s", name)
+		}
+		formats = append(formats, format)
+	}
+	return formats, nil
+}
+
+// findAudioFormat will find the format with the name in the formats.
+func findAudioFormat(formats []AudioFormat, name string) (AudioFormat, bool) {
+	for _, format := range formats {
+		if format.Name == name {
+			return format, true
+		}
+	}
+	return AudioFormat{}, false
+}
+
+// matches will check if the MIME type matches the format. The MIME type can be
+// a wildcard such as "audio/*" or "*/*".
+func (f AudioFormat) matches(mediaType string) bool {
+	if mediaType == "*/*" || mediaType == "audio/*" {
+		return true
+	}
+	if mediaType == f.ContentType {
+		return true
+	}
+	for _, alias := range f.Aliases {
+		if mediaType == alias {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateAudioFormat will pick the best format from the available formats
+// using the Accept header sent by the client. The available formats should be
+// ordered by preference, the first format will be used when the client has no
+// preference. An error is returned if none of the formats are acceptable.
+func negotiateAudioFormat(accept string, available []AudioFormat) (AudioFormat, error) {
+	if len(available) == 0 {
+		return AudioFormat{}, fmt.Errorf("no audio available")
+	}
+	if strings.TrimSpace(accept) == "" {
+		return available[0], nil
+	}
+
+	// Parse the media ranges from the header along with their quality.
+	type mediaRange struct {
+		mediaType string
+		quality   float64
+	}
+	ranges := []mediaRange{}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+		ranges = append(ranges, mediaRange{mediaType: mediaType, quality: quality})
+	}
+
+	// Exact media types take precedence over wildcards with the same quality.
+	sort.SliceStable(ranges, func(i, j int) bool {
+		if ranges[i].quality != ranges[j].quality {
+			return ranges[i].quality > ranges[j].quality
+		}
+		return !strings.HasSuffix(ranges[i].mediaType, "*") &&
+			strings.HasSuffix(ranges[j].mediaType, "*")
+	})
+
+	// Formats that are refused by name with a quality of 0 are not picked by
+	// a wildcard either.
+	refused := map[string]bool{}
+	for _, r := range ranges {
+		if r.quality <= 0 && !strings.HasSuffix(r.mediaType, "*") {
+			for _, format := range available {
+				if format.matches(r.mediaType) {
+					refused[format.Name] = true
+				}
+			}
+		}
+	}
+
+	// Use the first format that matches the highest quality range.
+	for _, r := range ranges {
+		if r.quality <= 0 {
+			continue
+		}
+		for _, format := range available {
+			if !refused[format.Name] && format.matches(r.mediaType) {
+				return format, nil
+			}
+		}
+	}
+
+	return AudioFormat{}, fmt.Errorf("no acceptable audio format for: %s", accept)
+}
+
+// selectAudioFormat will narrow the formats down to the format with the
+// specified name. This lets clients that cannot set an Accept header, such as
+// playlist players, ask for a format in the URL. If the name is empty, the
+// formats are returned unchanged.
+func selectAudioFormat(formats []AudioFormat, name string) []AudioFormat {
+	if name == "" {
+		return formats
+	}
+	selected := []AudioFormat{}
+	for _, format := range formats {
+		if format.Name == name {
+			selected = append(selected, format)
+		}
+	}
+	return selected
+}
+
+// -----------------------------------------------------------------------------
+// Transcoders
+// -----------------------------------------------------------------------------
+
+// Transcoder converts the audio produced by the synthesizer into a compressed
+// audio format.
+type Transcoder interface {
+	// Transcode will convert the source audio file into the destination file
+	// using the specified format.
+	Transcode(ctx context.Context, source, destination string, format AudioFormat) error
+}
+
+// FFmpegTranscoder is a Transcoder that runs the ffmpeg command.
+type FFmpegTranscoder struct {
+	// Path to the ffmpeg binary. If empty, ffmpeg will be looked up in the
+	// PATH.
+	Path string
+
+	// Bitrate of the compressed audio, e.g. "48k". If empty, a bitrate
+	// suitable for speech will be used.
+	Bitrate string
+}
+
+// Transcode will run ffmpeg to convert the source audio file.
+func (t FFmpegTranscoder) Transcode(ctx context.Context, source, destination string, format AudioFormat) error {
+	bin := t.Path
+	if bin == "" {
+		bin = "ffmpeg"
+	}
+
+	args := []string{"-nostdin", "-loglevel", "error", "-y", "-i", source}
+	switch format.Name {
+	case FormatOpus.Name:
+		args = append(args, "-c:a", "libopus", "-b:a", t.bitrate("32k"), "-f", "ogg")
+	case FormatMP3.Name:
+		// Leave out the ID3 tag and Xing frame so that the files of each
+		// paragraph can be joined into a continuous stream.
+		args = append(args, "-c:a", "libmp3lame", "-b:a", t.bitrate("64k"),
+			"-id3v2_version", "0", "-write_xing", "0", "-f", "mp3")
+	default:
+		return fmt.Errorf("ffmpeg: unsupported audio format: %s", format.Name)
+	}
+	args = append(args, destination)
+
+	return runCommand(ctx, exec.CommandContext(ctx, bin, args...))
+}
+
+// Check will check that ffmpeg is installed and return its version.
+func (t FFmpegTranscoder) Check(ctx context.Context) (string, error) {
+	bin := t.Path
+	if bin == "" {
+		bin = "ffmpeg"
+	}
+	return toolVersion(ctx, bin, "-version")
+}
+
+// bitrate will return the configured bitrate or the default if none is set.
+func (t FFmpegTranscoder) bitrate(def string) string {
+	if t.Bitrate != "" {
+		return t.Bitrate
+	}
+	return def
+}
+
+// -----------------------------------------------------------------------------
+// Paragraph Audio
+// -----------------------------------------------------------------------------
+
+// paragraphAudioPath will return the path to the audio file of the paragraph in
+// the specified format.
+func paragraphAudioPath(documentsDir, documentID, paragraphID string, format AudioFormat) string {
+	return filepath.Join(documentsDir, documentID, "audio", paragraphID+format.Extension)
+}
+
+// availableAudioFormats will return the formats that are stored on disk for the
+// paragraph. The formats are checked in the order they are given.
+func availableAudioFormats(documentsDir, documentID, paragraphID string, formats []AudioFormat) []AudioFormat {
+	available := []AudioFormat{}
+	for _, format := range formats {
+		if _, err := os.Stat(paragraphAudioPath(documentsDir, documentID, paragraphID, format)); err == nil {
+			available = append(available, format)
+		}
+	}
+	return available
+}
+
+// transcodeFile will transcode a single audio file. The output is written to a
+// temporary file first and renamed into place so that a partial file is never
+// served.
+func transcodeFile(ctx context.Context, t Transcoder, source, destination string, format AudioFormat) error {
+	tmp := destination + ".tmp"
+	if err := t.Transcode(ctx, source, tmp, format); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, destination)
+}
@@ -0,0 +1,227 @@
+package ttsweb
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeTranscoder is a Transcoder that copies the source file to the
+// destination without converting it. Every call is recorded so that the
+// transcoding stage can be exercised without ffmpeg being installed.
+type fakeTranscoder struct {
+	// err will be returned from Transcode if it is set.
+	err error
+
+	mu    sync.Mutex
+	calls []fakeTranscodeCall
+}
+
+// fakeTranscodeCall records a single call to fakeTranscoder.Transcode.
+type fakeTranscodeCall struct {
+	source      string
+	destination string
+	format      AudioFormat
+}
+
+// Transcode will copy the source file to the destination.
+func (t *fakeTranscoder) Transcode(ctx context.Context, source, destination string, format AudioFormat) error {
+	t.mu.Lock()
+	t.calls = append(t.calls, fakeTranscodeCall{source: source, destination: destination, format: format})
+	t.mu.Unlock()
+
+	if t.err != nil {
+		return t.err
+	}
+	data, err := os.ReadFile(source)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(destination, data, 0644)
+}
+
+// writeTestAudio will write a second of silence as the wav audio of each of
+// the paragraphs of the document.
+func writeTestAudio(t *testing.T, documentsDir, documentID string, paragraphIDs ...string) {
+	t.Helper()
+	audioDir := filepath.Join(documentsDir, documentID, "audio")
+	if err := os.MkdirAll(audioDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, paragraphID := range paragraphIDs {
+		path := paragraphAudioPath(documentsDir, documentID, paragraphID, FormatWav)
+		if err := os.WriteFile(path, silentWav(time.Second), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestParseAudioFormats(t *testing.T) {
+	formats, err := ParseAudioFormats(" MP3, opus,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(formats) != 2 || formats[0].Name != "mp3" || formats[1].Name != "opus" {
+		t.Errorf("ParseAudioFormats() = %v, want mp3 and opus", formats)
+	}
+
+	if _, err := ParseAudioFormats("opus,flac"); err == nil {
+		t.Error("ParseAudioFormats() accepted flac")
+	}
+}
+
+func TestNegotiateAudioFormat(t *testing.T) {
+	available := []AudioFormat{FormatOpus, FormatMP3, FormatWav}
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", "opus"},
+		{"*/*", "opus"},
+		{"audio/*", "opus"},
+		{"audio/mpeg", "mp3"},
+		{"audio/mp3", "mp3"},
+		{"audio/x-wav", "wav"},
+		{"audio/wav;q=0.5, audio/mpeg;q=0.9", "mp3"},
+		{"audio/*;q=0.5, audio/wav", "wav"},
+		{"audio/ogg;q=0, audio/*", "mp3"},
+		{"text/html, audio/mpeg", "mp3"},
+	}
+	for _, test := range tests {
+		format, err := negotiateAudioFormat(test.accept, available)
+		if err != nil {
+			t.Errorf("negotiateAudioFormat(%q) returned error: %v", test.accept, err)
+			continue
+		}
+		if format.Name != test.want {
+			t.Errorf("negotiateAudioFormat(%q) = %s, want %s", test.accept, format.Name, test.want)
+		}
+	}
+
+	if _, err := negotiateAudioFormat("audio/flac", available); err == nil {
+		t.Error("negotiateAudioFormat(audio/flac) did not return an error")
+	}
+	if _, err := negotiateAudioFormat("", nil); err == nil {
+		t.Error("negotiateAudioFormat() with no formats did not return an error")
+	}
+}
+
+func TestTranscodeParagraphs(t *testing.T) {
+	documentsDir := t.TempDir()
+	document := DocumentInfo{ID: "doc"}
+	writeTestAudio(t, documentsDir, document.ID, "0", "1", "2")
+
+	transcoder := &fakeTranscoder{}
+	formats := []AudioFormat{FormatOpus, FormatMP3}
+	transcoded := 0
+	err := document.TranscodeParagraphs(context.Background(), documentsDir, []string{"0", "2"}, transcoder, formats, true, func() { transcoded++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transcoded != 2 {
+		t.Errorf("%d paragraphs were counted as transcoded, want 2", transcoded)
+	}
+	if len(transcoder.calls) != 4 {
+		t.Errorf("Transcode was called %d times, want 4", len(transcoder.calls))
+	}
+	for _, call := range transcoder.calls {
+		if filepath.Ext(call.destination) != ".tmp" {
+			t.Errorf("Transcode wrote %s instead of a temporary file", call.destination)
+		}
+	}
+
+	// The wav is only removed from the transcoded paragraphs.
+	for paragraphID, want := range map[string][]AudioFormat{
+		"0": formats,
+		"1": {FormatWav},
+		"2": formats,
+	} {
+		got := availableAudioFormats(documentsDir, document.ID, paragraphID, []AudioFormat{FormatOpus, FormatMP3, FormatWav})
+		if len(got) != len(want) {
+			t.Errorf("paragraph %s has formats %v, want %v", paragraphID, got, want)
+			continue
+		}
+		for i := range got {
+			if got[i].Name != want[i].Name {
+				t.Errorf("paragraph %s has formats %v, want %v", paragraphID, got, want)
+			}
+		}
+	}
+}
+
+func TestTranscodeParagraphsFailure(t *testing.T) {
+	documentsDir := t.TempDir()
+	document := DocumentInfo{ID: "doc"}
+	writeTestAudio(t, documentsDir, document.ID, "0")
+
+	transcoder := &fakeTranscoder{err: errors.New("no encoder")}
+	err := document.TranscodeParagraphs(context.Background(), documentsDir, nil, transcoder, []AudioFormat{FormatMP3}, true, func() {
+		t.Error("a paragraph that failed was counted as transcoded")
+	})
+	if err == nil {
+		t.Fatal("TranscodeParagraphs() did not return an error")
+	}
+
+	// The source is kept and no partial output is left behind.
+	entries, err := os.ReadDir(filepath.Join(documentsDir, document.ID, "audio"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "0.wav" {
+		t.Errorf("audio directory has %v, want only 0.wav", entries)
+	}
+}
+
+func TestParagraphAudioNegotiation(t *testing.T) {
+	documentsDir := t.TempDir()
+	document := DocumentInfo{ID: "doc"}
+	writeTestAudio(t, documentsDir, document.ID, "0")
+	transcoder := &fakeTranscoder{}
+	if err := document.TranscodeParagraphs(context.Background(), documentsDir, nil, transcoder, []AudioFormat{FormatOpus, FormatMP3}, true, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// The server is restarted without any audio formats configured, so only
+	// the files on disk tell which formats can be served.
+	documents := &DocumentsInfo{Documents: []DocumentInfo{document}}
+	documents.SetDocumentsDir(documentsDir)
+	documents.SetPipeline(&Pipeline{})
+
+	tests := []struct {
+		accept string
+		format string
+		status int
+		want   string
+	}{
+		{"", "", http.StatusOK, "audio/ogg"},
+		{"audio/mpeg", "", http.StatusOK, "audio/mpeg"},
+		{"", "mp3", http.StatusOK, "audio/mpeg"},
+		{"audio/wav", "", http.StatusNotAcceptable, ""},
+		{"", "wav", http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		url := "/documents/doc/paragraphs/0/audio"
+		if test.format != "" {
+			url += "?format=" + test.format
+		}
+		r := httptest.NewRequest(http.MethodGet, url, nil)
+		if test.accept != "" {
+			r.Header.Set("Accept", test.accept)
+		}
+		w := httptest.NewRecorder()
+		documents.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("GET %s with Accept %q returned %d, want %d", url, test.accept, w.Code, test.status)
+			continue
+		}
+		if test.want != "" && w.Header().Get("Content-Type") != test.want {
+			t.Errorf("GET %s with Accept %q returned %s, want %s", url, test.accept, w.Header().Get("Content-Type"), test.want)
+		}
+	}
+}
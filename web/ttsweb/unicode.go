@@ -0,0 +1,160 @@
+package ttsweb
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// -----------------------------------------------------------------------------
+// Unicode Normalization
+// -----------------------------------------------------------------------------
+
+// TextNormalization describes how the text of a document is normalized once
+// it has been split into paragraphs. The text is kept as UTF-8, only the
+// characters that are written differently but read the same are changed.
+type TextNormalization struct {
+	// NFC will compose letters and combining marks into precomposed letters,
+	// so that text copied from different sources is written the same way.
+	NFC bool
+
+	// FoldQuotes will replace curly quotes and guillemets with the plain
+	// ASCII quotes.
+	FoldQuotes bool
+
+	// ExpandLigatures will replace typographic ligatures, e.g. "ﬁ", with the
+	// letters that make them up. Ligatures are common in text extracted from
+	// PDF files.
+	ExpandLigatures bool
+}
+
+// DefaultTextNormalization applies all of the normalizations.
+var DefaultTextNormalization = TextNormalization{
+	NFC:             true,
+	FoldQuotes:      true,
+	ExpandLigatures: true,
+}
+
+// ParseTextNormalization will parse a comma separated list of normalizations,
+// e.g. "nfc,quotes,ligatures". An empty string or "none" disables the
+// normalization.
+func ParseTextNormalization(s string) (TextNormalization, error) {
+	n := TextNormalization{}
+	for _, name := range strings.Split(s, ",") {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "", "none":
+		case "nfc":
+			n.NFC = true
+		case "quotes":
+			n.FoldQuotes = true
+		case "ligatures":
+			n.ExpandLigatures = true
+		default:
+			return TextNormalization{}, fmt.Errorf("unknown text normalization: %s", name)
+		}
+	}
+	return n, nil
+}
+
+// Normalize will apply the normalizations to the text.
+func (n TextNormalization) Normalize(text string) string {
+	if n.NFC {
+		text = norm.NFC.String(text)
+	}
+	if n.FoldQuotes {
+		text = quoteReplacer.Replace(text)
+	}
+	if n.ExpandLigatures {
+		text = ligatureReplacer.Replace(text)
+	}
+	return text
+}
+
+var (
+	// quoteReplacer folds the typographic quotes into ASCII quotes.
+	quoteReplacer = strings.NewReplacer(
+		"‘", "'", "’", "'", "‚", "'", "‛", "'",
+		"‹", "'", "›", "'",
+		"“", "\"", "”", "\"", "„", "\"", "‟", "\"",
+		"«", "\"", "»", "\"",
+	)
+
+	// ligatureReplacer expands the ligatures into separate letters.
+	ligatureReplacer = strings.NewReplacer(
+		"ﬀ", "ff", "ﬁ", "fi", "ﬂ", "fl", "ﬃ", "ffi",
+		"ﬄ", "ffl", "ﬅ", "st", "ﬆ", "st",
+		"Ĳ", "IJ", "ĳ", "ij",
+	)
+)
+
+// stripMarks will remove the accents and other combining marks from the
+// letters of the text, e.g. "é" becomes "e".
+func stripMarks(text string) string {
+	var b strings.Builder
+	b.Grow(len(text))
+	for _, r := range norm.NFD.String(text) {
+		if !unicode.Is(unicode.Mn, r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// -----------------------------------------------------------------------------
+// Transliteration
+// -----------------------------------------------------------------------------
+
+// asciiReplacements are the characters that have an ASCII spelling other than
+// their base letter.
+var asciiReplacements = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'Æ': "AE", 'œ': "oe", 'Œ': "OE", 'ø': "o", 'Ø': "O",
+	'đ': "d", 'Đ': "D", 'ð': "d", 'Ð': "D", 'þ': "th", 'Þ': "TH", 'ł': "l",
+	'Ł': "L", 'ı': "i", 'ħ': "h", 'Ħ': "H",
+	'–': "-", '—': "-", '―': "-", '−': "-", '…': "...",
+	'•': "*", '×': "x", '©': "(C)", '®': "(R)",
+	'™': "(TM)", '€': "EUR",
+}
+
+// asciiLanguageReplacements are the ASCII spellings of letters that are
+// written differently in each language, rather than without their accent,
+// e.g. German "ü" is written "ue".
+var asciiLanguageReplacements = map[string]map[rune]string{
+	"de": {'ä': "ae", 'ö': "oe", 'ü': "ue", 'Ä': "Ae", 'Ö': "Oe", 'Ü': "Ue"},
+	"da": {'å': "aa", 'Å': "Aa", 'ø': "oe", 'Ø': "Oe"},
+	"no": {'å': "aa", 'Å': "Aa", 'ø': "oe", 'Ø': "Oe"},
+}
+
+// TransliterateASCII will convert the text to ASCII for voices that can only
+// read ASCII text. Letters are spelled the way the language writes them
+// without accents where it has its own spelling, the accents are removed from
+// the other letters, and the typographic characters are replaced with their
+// closest ASCII spelling. Any character without an ASCII spelling is replaced
+// with "?", in the same way as iconv.
+func TransliterateASCII(text, language string) string {
+	text = DefaultTextNormalization.Normalize(text)
+	replacements := asciiLanguageReplacements[language]
+
+	var b strings.Builder
+	b.Grow(len(text))
+	for _, r := range text {
+		if replacement, ok := replacements[r]; ok {
+			b.WriteString(replacement)
+			continue
+		}
+		for _, r := range stripMarks(string(r)) {
+			switch {
+			case r <= unicode.MaxASCII:
+				b.WriteRune(r)
+			case unicode.IsSpace(r):
+				b.WriteByte(' ')
+			case asciiReplacements[r] != "":
+				b.WriteString(asciiReplacements[r])
+			default:
+				b.WriteByte('?')
+			}
+		}
+	}
+	return b.String()
+}
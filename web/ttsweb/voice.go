@@ -0,0 +1,80 @@
+package ttsweb
+
+import (
+	"fmt"
+	"strings"
+)
+
+// -----------------------------------------------------------------------------
+// Voices
+// -----------------------------------------------------------------------------
+
+// Voice is the TTS model and speaker that paragraphs are synthesized with.
+type Voice struct {
+	// Engine is the name of the synthesizer that reads the voice. If empty,
+	// the voice is a Coqui TTS model.
+	Engine string
+
+	// Model is the name of the model of the engine, e.g. the Coqui TTS model
+	// "tts_models/en/vctk/vits" or the espeak-ng voice "en-gb".
+	Model string
+
+	// Speaker is the name of the speaker for multi speaker models.
+	Speaker string
+
+	// Language is the language code of the voice, e.g. "en".
+	Language string
+
+	// Transliterate is set for voices whose language is only known to the
+	// model in ASCII, the text is transliterated to ASCII before it is
+	// synthesized. Voices that read their language with accents or in
+	// another script must leave this unset.
+	Transliterate bool
+}
+
+// DefaultVoice is the English VCTK voice that documents have always been
+// synthesized with.
+var DefaultVoice = Voice{
+	Model:    "tts_models/en/vctk/vits",
+	Speaker:  "p241",
+	Language: "en",
+}
+
+// SpeechText will prepare the text of a paragraph to be read by the voice.
+func (v Voice) SpeechText(text string) string {
+	if v.Transliterate {
+		return TransliterateASCII(text, v.Language)
+	}
+	return text
+}
+
+// ParseLanguageVoices will parse a comma separated list of voices for each
+// language, e.g. "de=tts_models/de/thorsten/vits,fr=tts_models/fr/css10/vits".
+// The speaker of multi speaker models follows the model after a colon, e.g.
+// "en=tts_models/en/vctk/vits:p241". espeak-ng voices start with "espeak/",
+// e.g. "de=espeak/de".
+func ParseLanguageVoices(s string) (map[string]Voice, error) {
+	voices := map[string]Voice{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		language, model, ok := strings.Cut(entry, "=")
+		if !ok || language == "" || model == "" {
+			return nil, fmt.Errorf("invalid language voice: %s", entry)
+		}
+		model, speaker, _ := strings.Cut(model, ":")
+		engine := ""
+		if strings.HasPrefix(model, EngineEspeak+"/") {
+			engine, model = EngineEspeak, strings.TrimPrefix(model, EngineEspeak+"/")
+		}
+		voices[language] = Voice{
+			Engine:   engine,
+			Model:    model,
+			Speaker:  speaker,
+			Language: language,
+		}
+	}
+	return voices, nil
+}
@@ -0,0 +1,237 @@
+package ttsweb
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DocumentExtensions are the file types that documents are split from. Other
+// files are left alone when a folder of documents is read.
+var DocumentExtensions = []string{".md", ".docx", ".pdf", ".txt", ".epub", ".html", ".htm"}
+
+// watchResultSuffix is added to the name of a file in the watch folder to name
+// the file that its result is written to.
+const watchResultSuffix = ".result.json"
+
+// -----------------------------------------------------------------------------
+// Folder Watcher
+// -----------------------------------------------------------------------------
+
+// FolderWatcher polls a folder for new documents. A file is only picked up
+// once its size and modification time have stopped changing between polls, so
+// that files that are still being copied into the folder are not read early.
+// Each document is created from the file, the file is moved into the archive
+// folder and a result file is written next to where the file was.
+type FolderWatcher struct {
+	// Dir is the folder that is watched.
+	Dir string
+
+	// ArchiveDir is the folder that the files are moved to once they have
+	// been read.
+	ArchiveDir string
+
+	// Interval is the time between polls of the folder.
+	Interval time.Duration
+
+	// Documents is the list of documents that the files are added to.
+	Documents *DocumentsInfo
+
+	// seen are the files in the folder at the last poll, and failed are the
+	// files that could not be read. A file that failed is only read again
+	// once it has changed.
+	seen   map[string]watchedFile
+	failed map[string]watchedFile
+
+	// pending are the results of the documents that are still being
+	// processed, keyed by document ID.
+	pending map[string]WatchResult
+}
+
+// watchedFile is the state of a file in the watch folder at a poll.
+type watchedFile struct {
+	size    int64
+	modTime time.Time
+}
+
+// WatchResult is the outcome of reading a file from the watch folder. It is
+// written to {file}.result.json when the document is created, and again once
+// it has been processed.
+type WatchResult struct {
+	File       string    `json:"file"`
+	DocumentID string    `json:"documentId,omitempty"`
+	Status     string    `json:"status,omitempty"`
+	Archived   string    `json:"archived,omitempty"`
+	RequestID  string    `json:"requestId,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Updated    time.Time `json:"updated"`
+}
+
+// NewFolderWatcher will create a watcher that adds the documents in the folder
+// to the list of documents. The files are archived in the archive subfolder.
+func NewFolderWatcher(dir string, documents *DocumentsInfo) *FolderWatcher {
+	return &FolderWatcher{
+		Dir:        dir,
+		ArchiveDir: filepath.Join(dir, "archive"),
+		Interval:   5 * time.Second,
+		Documents:  documents,
+		seen:       map[string]watchedFile{},
+		failed:     map[string]watchedFile{},
+		pending:    map[string]WatchResult{},
+	}
+}
+
+// Watch will poll the folder until the stop channel is closed.
+func (w *FolderWatcher) Watch(stop <-chan struct{}) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for {
+		if err := w.Poll(); err != nil {
+			slog.Error("Unable to read the watch folder", "dir", w.Dir, "error", err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll will read the files that have not changed since the last poll, and
+// update the results of the documents that have finished processing.
+func (w *FolderWatcher) Poll() error {
+	if err := os.MkdirAll(w.ArchiveDir, 0755); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(w.Dir)
+	if err != nil {
+		return err
+	}
+
+	seen := map[string]watchedFile{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !isWatchedFile(name) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		file := watchedFile{size: info.Size(), modTime: info.ModTime()}
+		seen[name] = file
+
+		// Wait for the file to stop changing, and leave files that failed
+		// until they are replaced.
+		if last, ok := w.seen[name]; !ok || last != file {
+			continue
+		}
+		if failed, ok := w.failed[name]; ok && failed == file {
+			continue
+		}
+		delete(w.failed, name)
+
+		// Leave the files in the folder to be uploaded at the next start
+		// once the server is shutting down.
+		if w.Documents.isClosed() {
+			break
+		}
+		result := w.ingest(name)
+		if result.Error != "" {
+			w.failed[name] = file
+		}
+		if err := w.writeResult(name, result); err != nil {
+			slog.Error("Unable to write the watch result", "file", name, "error", err)
+		}
+	}
+	w.seen = seen
+
+	w.updatePending()
+	return nil
+}
+
+// isWatchedFile will check if the file in the watch folder is a document.
+// Hidden files, result files and unsupported file types are ignored.
+func isWatchedFile(name string) bool {
+	if strings.HasPrefix(name, ".") || strings.HasSuffix(name, watchResultSuffix) {
+		return false
+	}
+	return containsString(DocumentExtensions, strings.ToLower(filepath.Ext(name)))
+}
+
+// ingest will create a document from the file and move the file into the
+// archive folder. Each file is given a request ID, as if it had been uploaded,
+// so that the logs of its processing can be found.
+func (w *FolderWatcher) ingest(name string) WatchResult {
+	result := WatchResult{File: name, RequestID: generateID(), Updated: time.Now().UTC()}
+	ctx := WithLogAttrs(WithRequestID(context.Background(), result.RequestID), slog.String("file", name))
+	source := filepath.Join(w.Dir, name)
+	data, err := ioutil.ReadFile(source)
+	if err != nil {
+		slog.ErrorContext(ctx, "Unable to read the file from the watch folder", "error", err)
+		result.Error = err.Error()
+		return result
+	}
+
+	documentName := strings.TrimSuffix(name, filepath.Ext(name))
+	document, err := w.Documents.CreateDocument(ctx, documentName, name, "", data)
+	if err != nil {
+		slog.ErrorContext(ctx, "Unable to create the document from the watch folder", "error", err)
+		result.Error = err.Error()
+		return result
+	}
+	result.DocumentID = document.ID
+	result.Status = document.Status
+	slog.InfoContext(ctx, "Created document from the watch folder", "document_id", document.ID)
+
+	// Keep the names of the archived files unique, in case a file with the
+	// same name is dropped into the folder again.
+	archived := filepath.Join(w.ArchiveDir, name)
+	if _, err := os.Stat(archived); err == nil {
+		archived = filepath.Join(w.ArchiveDir, documentName+"-"+document.ID+filepath.Ext(name))
+	}
+	if err := os.Rename(source, archived); err != nil {
+		slog.ErrorContext(ctx, "Unable to archive the file from the watch folder", "error", err)
+		result.Error = err.Error()
+		return result
+	}
+	result.Archived = archived
+
+	w.pending[document.ID] = result
+	return result
+}
+
+// updatePending will write the results of the documents that have been
+// synthesized or have failed since the last poll.
+func (w *FolderWatcher) updatePending() {
+	for id, result := range w.pending {
+		document, ok := w.Documents.Document(id)
+		if ok && document.Status != StatusSynthesized && document.Status != StatusFailed {
+			continue
+		}
+		delete(w.pending, id)
+		result.Status = document.Status
+		result.Error = document.Error
+		if !ok {
+			result.Error = ErrDocumentNotFound.Error()
+		}
+		result.Updated = time.Now().UTC()
+		if err := w.writeResult(result.File, result); err != nil {
+			slog.Error("Unable to write the watch result", "file", result.File, "error", err)
+		}
+	}
+}
+
+// writeResult will write the result next to where the file was dropped.
+func (w *FolderWatcher) writeResult(name string, result WatchResult) error {
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(w.Dir, name+watchResultSuffix), data, 0644)
+}
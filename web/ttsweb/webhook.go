@@ -0,0 +1,353 @@
+package ttsweb
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Events of the lifecycle of a document that webhooks are sent for.
+const (
+	EventDocumentCreated     = "document.created"
+	EventDocumentSynthesized = "document.synthesized"
+	EventDocumentFailed      = "document.failed"
+	EventDocumentDeleted     = "document.deleted"
+)
+
+// WebhookEvents are all of the events that webhooks can be sent for.
+var WebhookEvents = []string{EventDocumentCreated, EventDocumentSynthesized, EventDocumentFailed, EventDocumentDeleted}
+
+const (
+	// webhookAttempts is the number of times that an event is sent to a
+	// webhook before it is given up on.
+	webhookAttempts = 5
+
+	// webhookBackoff is the time before the first retry. It doubles after
+	// each attempt.
+	webhookBackoff = 2 * time.Second
+
+	// maxWebhookDeliveries is the number of deliveries that are kept in the
+	// delivery log.
+	maxWebhookDeliveries = 500
+)
+
+// -----------------------------------------------------------------------------
+// Webhooks
+// -----------------------------------------------------------------------------
+
+// Webhook is a URL that the events of documents are posted to. The body is
+// signed with the secret, and only the events in the filter are sent, or all
+// of the events if the filter is empty.
+type Webhook struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret,omitempty"`
+	Events []string `json:"events,omitempty"`
+}
+
+// WebhookEvent is the JSON body that is posted to the webhooks.
+type WebhookEvent struct {
+	ID       string       `json:"id"`
+	Event    string       `json:"event"`
+	Created  time.Time    `json:"created"`
+	Document DocumentInfo `json:"document"`
+}
+
+// WebhookDelivery is an attempt to send an event to a webhook. The status code
+// is 0 if no response was received.
+type WebhookDelivery struct {
+	ID         string    `json:"id"`
+	EventID    string    `json:"eventId"`
+	Event      string    `json:"event"`
+	DocumentID string    `json:"documentId"`
+	URL        string    `json:"url"`
+	Attempt    int       `json:"attempt"`
+	StatusCode int       `json:"statusCode"`
+	Error      string    `json:"error,omitempty"`
+	Success    bool      `json:"success"`
+	Sent       time.Time `json:"sent"`
+	Duration   int64     `json:"durationMs"`
+}
+
+// Webhooks sends the events of documents to the webhooks and keeps a log of
+// the most recent deliveries in memory.
+type Webhooks struct {
+	hooks  []Webhook
+	client *http.Client
+
+	// backoff is the time before the first retry.
+	backoff time.Duration
+
+	// ctx is cancelled once the server gives up on the deliveries that are
+	// still being sent, which stops their requests and retries. It is not
+	// the context of the request that caused the event, since the event is
+	// delivered after that request has finished.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	deliveries []WebhookDelivery
+	mu         sync.RWMutex
+	wg         sync.WaitGroup
+}
+
+// LoadWebhooks will load the webhooks from a JSON file, e.g.
+//
+//	[{"url": "https://example.com/hook", "secret": "...", "events": ["document.synthesized"]}]
+func LoadWebhooks(file string) ([]Webhook, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	hooks := []Webhook{}
+	if err := json.Unmarshal(data, &hooks); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	for _, hook := range hooks {
+		if err := hook.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+	}
+	return hooks, nil
+}
+
+// Validate will check that the webhook has an HTTP URL and only filters known
+// events.
+func (h Webhook) Validate() error {
+	u, err := url.Parse(h.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid webhook URL: %s", h.URL)
+	}
+	for _, event := range h.Events {
+		if !containsString(WebhookEvents, event) {
+			return fmt.Errorf("unknown webhook event: %s", event)
+		}
+	}
+	return nil
+}
+
+// wants will check if the event passes the filter of the webhook.
+func (h Webhook) wants(event string) bool {
+	return len(h.Events) == 0 || containsString(h.Events, event)
+}
+
+// NewWebhooks will create the sender for the webhooks.
+func NewWebhooks(hooks []Webhook) *Webhooks {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Webhooks{
+		hooks:   hooks,
+		client:  &http.Client{Timeout: 30 * time.Second},
+		backoff: webhookBackoff,
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// Hooks will return the webhooks without their secrets. No webhooks are
+// returned if the webhooks are nil.
+func (w *Webhooks) Hooks() []Webhook {
+	hooks := []Webhook{}
+	if w == nil {
+		return hooks
+	}
+	for _, hook := range w.hooks {
+		if hook.Secret != "" {
+			hook.Secret = "********"
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks
+}
+
+// Notify will send the event of the document to each of the webhooks that
+// want it, in the background. Nothing is sent if there are no webhooks. The
+// deliveries are logged with the attributes of the context.
+func (w *Webhooks) Notify(ctx context.Context, event string, document DocumentInfo) {
+	if w == nil {
+		return
+	}
+	payload := WebhookEvent{
+		ID:       generateID(),
+		Event:    event,
+		Created:  time.Now().UTC(),
+		Document: document,
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		slog.ErrorContext(ctx, "Unable to encode the webhook event", "event", event, "error", err)
+		return
+	}
+	for _, hook := range w.hooks {
+		if !hook.wants(event) {
+			continue
+		}
+		w.wg.Add(1)
+		go func(hook Webhook) {
+			defer w.wg.Done()
+			w.deliver(ctx, hook, payload, body)
+		}(hook)
+	}
+}
+
+// Wait will block until the events that have been sent are delivered or have
+// been given up on, or until the context is done. When the context is done,
+// the deliveries that are still being sent or waiting to be retried are
+// stopped, and the error of the context is returned.
+func (w *Webhooks) Wait(ctx context.Context) error {
+	if w == nil {
+		return nil
+	}
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		w.cancel()
+		return ctx.Err()
+	}
+}
+
+// deliver will post the event to the webhook, retrying with a growing delay
+// until it is accepted, it is refused with a status that will not change, the
+// attempts run out or the deliveries are stopped. The context is only used
+// for the attributes of the log.
+func (w *Webhooks) deliver(ctx context.Context, hook Webhook, payload WebhookEvent, body []byte) {
+	backoff := w.backoff
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		delivery := w.send(hook, payload, body)
+		delivery.Attempt = attempt
+		w.record(delivery)
+		if delivery.Success {
+			slog.DebugContext(ctx, "Delivered webhook", "event", payload.Event, "url", hook.URL, "attempt", attempt)
+			return
+		}
+		slog.WarnContext(ctx, "Webhook delivery failed", "event", payload.Event, "url", hook.URL, "attempt", attempt, "error", delivery.Error)
+		if attempt == webhookAttempts || !retryWebhook(delivery.StatusCode) {
+			return
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-w.ctx.Done():
+			timer.Stop()
+			slog.WarnContext(ctx, "Stopped retrying webhook", "event", payload.Event, "url", hook.URL, "attempt", attempt)
+			return
+		}
+		backoff *= 2
+	}
+}
+
+// retryWebhook will check if a delivery that failed with the status code is
+// worth sending again. Deliveries without a response, server errors, timeouts
+// and rate limits are retried, other client errors are not since the same
+// request would be refused again.
+func retryWebhook(statusCode int) bool {
+	switch {
+	case statusCode == 0 || statusCode >= 500:
+		return true
+	case statusCode == http.StatusRequestTimeout || statusCode == http.StatusTooManyRequests:
+		return true
+	}
+	return false
+}
+
+// send will post the event to the webhook once. The time that it is sent is
+// put in the X-TTSWeb-Timestamp header as Unix seconds, and the timestamp and
+// the body are signed with the secret of the webhook in the
+// X-TTSWeb-Signature header.
+func (w *Webhooks) send(hook Webhook, payload WebhookEvent, body []byte) (delivery WebhookDelivery) {
+	delivery = WebhookDelivery{
+		ID:         generateID(),
+		EventID:    payload.ID,
+		Event:      payload.Event,
+		DocumentID: payload.Document.ID,
+		URL:        hook.URL,
+		Sent:       time.Now().UTC(),
+	}
+	defer func() {
+		delivery.Duration = time.Since(delivery.Sent).Milliseconds()
+	}()
+
+	request, err := http.NewRequestWithContext(w.ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "ttsweb-webhook")
+	request.Header.Set("X-TTSWeb-Event", payload.Event)
+	request.Header.Set("X-TTSWeb-Delivery", payload.ID)
+	timestamp := strconv.FormatInt(delivery.Sent.Unix(), 10)
+	request.Header.Set("X-TTSWeb-Timestamp", timestamp)
+	if hook.Secret != "" {
+		request.Header.Set("X-TTSWeb-Signature", "sha256="+SignWebhook(hook.Secret, timestamp, body))
+	}
+
+	response, err := w.client.Do(request)
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10))
+	delivery.StatusCode = response.StatusCode
+	delivery.Success = response.StatusCode >= 200 && response.StatusCode <= 299
+	if !delivery.Success {
+		delivery.Error = response.Status
+	}
+	return delivery
+}
+
+// SignWebhook will return the hex encoded HMAC-SHA256 with the secret of the
+// timestamp, a "." and the body. Receivers compare it with the
+// X-TTSWeb-Signature header, after the "sha256=" prefix, to check that the
+// event came from the server, and reject timestamps that are too old so that
+// a captured delivery cannot be replayed.
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// record will add the delivery to the log, dropping the oldest deliveries.
+func (w *Webhooks) record(delivery WebhookDelivery) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.deliveries = append(w.deliveries, delivery)
+	if len(w.deliveries) > maxWebhookDeliveries {
+		w.deliveries = w.deliveries[len(w.deliveries)-maxWebhookDeliveries:]
+	}
+}
+
+// Deliveries will return the logged deliveries of the document, or of all of
+// the documents if the document ID is empty, most recent first.
+func (w *Webhooks) Deliveries(documentID string) []WebhookDelivery {
+	deliveries := []WebhookDelivery{}
+	if w == nil {
+		return deliveries
+	}
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	for i := len(w.deliveries) - 1; i >= 0; i-- {
+		if documentID == "" || w.deliveries[i].DocumentID == documentID {
+			deliveries = append(deliveries, w.deliveries[i])
+		}
+	}
+	return deliveries
+}
@@ -0,0 +1,224 @@
+package ttsweb
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookReceiver is a webhook endpoint that answers with the status codes in
+// order, repeating the last one, and keeps the requests that it received.
+type webhookReceiver struct {
+	statusCodes []int
+
+	mu       sync.Mutex
+	requests []webhookRequest
+}
+
+// webhookRequest is a request that the webhookReceiver received.
+type webhookRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// ServeHTTP will record the request and answer with the next status code.
+func (h *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	h.mu.Lock()
+	h.requests = append(h.requests, webhookRequest{header: r.Header.Clone(), body: body})
+	statusCode := h.statusCodes[len(h.statusCodes)-1]
+	if len(h.requests) <= len(h.statusCodes) {
+		statusCode = h.statusCodes[len(h.requests)-1]
+	}
+	h.mu.Unlock()
+	w.WriteHeader(statusCode)
+}
+
+// received will return the requests that the receiver received.
+func (h *webhookReceiver) received() []webhookRequest {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]webhookRequest{}, h.requests...)
+}
+
+// newTestWebhooks will start a webhook receiver that answers with the status
+// codes, and create the webhooks that send to it with a short backoff.
+func newTestWebhooks(t *testing.T, hook Webhook, statusCodes ...int) (*Webhooks, *webhookReceiver) {
+	t.Helper()
+	receiver := &webhookReceiver{statusCodes: statusCodes}
+	server := httptest.NewServer(receiver)
+	t.Cleanup(server.Close)
+	hook.URL = server.URL
+	webhooks := NewWebhooks([]Webhook{hook})
+	webhooks.backoff = time.Millisecond
+	return webhooks, receiver
+}
+
+// waitWebhooks will wait for the deliveries of the webhooks to finish.
+func waitWebhooks(t *testing.T, webhooks *Webhooks) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := webhooks.Wait(ctx); err != nil {
+		t.Fatalf("Wait() returned error: %v", err)
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	webhooks, receiver := newTestWebhooks(t, Webhook{Secret: "secret"}, http.StatusOK)
+	before := time.Now().Unix()
+	webhooks.Notify(context.Background(), EventDocumentSynthesized, DocumentInfo{ID: "doc"})
+	waitWebhooks(t, webhooks)
+
+	requests := receiver.received()
+	if len(requests) != 1 {
+		t.Fatalf("webhook received %d requests, want 1", len(requests))
+	}
+	request := requests[0]
+	if request.header.Get("X-TTSWeb-Event") != EventDocumentSynthesized {
+		t.Errorf("X-TTSWeb-Event = %q, want %q", request.header.Get("X-TTSWeb-Event"), EventDocumentSynthesized)
+	}
+	var event WebhookEvent
+	if err := json.Unmarshal(request.body, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Event != EventDocumentSynthesized || event.Document.ID != "doc" {
+		t.Errorf("webhook received %s of %s, want %s of doc", event.Event, event.Document.ID, EventDocumentSynthesized)
+	}
+
+	// The signature covers the timestamp as well as the body.
+	timestamp := request.header.Get("X-TTSWeb-Timestamp")
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || sent < before || sent > time.Now().Unix() {
+		t.Errorf("X-TTSWeb-Timestamp = %q, want the time that it was sent", timestamp)
+	}
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(timestamp + "." + string(request.body)))
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if request.header.Get("X-TTSWeb-Signature") != want {
+		t.Errorf("X-TTSWeb-Signature = %q, want %q", request.header.Get("X-TTSWeb-Signature"), want)
+	}
+	if SignWebhook("secret", timestamp, request.body) == SignWebhook("secret", strconv.FormatInt(sent-1, 10), request.body) {
+		t.Error("SignWebhook() does not depend on the timestamp")
+	}
+}
+
+func TestWebhookEventFilter(t *testing.T) {
+	webhooks, receiver := newTestWebhooks(t, Webhook{Events: []string{EventDocumentFailed}}, http.StatusOK)
+	webhooks.Notify(context.Background(), EventDocumentCreated, DocumentInfo{ID: "doc"})
+	webhooks.Notify(context.Background(), EventDocumentFailed, DocumentInfo{ID: "doc"})
+	waitWebhooks(t, webhooks)
+
+	requests := receiver.received()
+	if len(requests) != 1 || requests[0].header.Get("X-TTSWeb-Event") != EventDocumentFailed {
+		t.Fatalf("webhook received %d requests, want only %s", len(requests), EventDocumentFailed)
+	}
+	if requests[0].header.Get("X-TTSWeb-Signature") != "" {
+		t.Error("webhook without a secret was signed")
+	}
+}
+
+func TestWebhookRetry(t *testing.T) {
+	tests := []struct {
+		name        string
+		statusCodes []int
+		attempts    int
+		success     bool
+	}{
+		{"accepted", []int{http.StatusOK}, 1, true},
+		{"server error", []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusNoContent}, 3, true},
+		{"timeout", []int{http.StatusRequestTimeout, http.StatusOK}, 2, true},
+		{"rate limited", []int{http.StatusTooManyRequests}, webhookAttempts, false},
+		{"bad request", []int{http.StatusBadRequest}, 1, false},
+		{"gone", []int{http.StatusGone}, 1, false},
+	}
+	for _, test := range tests {
+		webhooks, receiver := newTestWebhooks(t, Webhook{}, test.statusCodes...)
+		webhooks.Notify(context.Background(), EventDocumentCreated, DocumentInfo{ID: "doc"})
+		waitWebhooks(t, webhooks)
+
+		if n := len(receiver.received()); n != test.attempts {
+			t.Errorf("%s: webhook received %d requests, want %d", test.name, n, test.attempts)
+		}
+
+		// The delivery log has every attempt, most recent first.
+		deliveries := webhooks.Deliveries("doc")
+		if len(deliveries) != test.attempts {
+			t.Errorf("%s: %d deliveries were logged, want %d", test.name, len(deliveries), test.attempts)
+			continue
+		}
+		for i, delivery := range deliveries {
+			if delivery.Attempt != test.attempts-i {
+				t.Errorf("%s: delivery %d is attempt %d, want %d", test.name, i, delivery.Attempt, test.attempts-i)
+			}
+			wantSuccess := i == 0 && test.success
+			if delivery.Success != wantSuccess || (delivery.Error == "") != wantSuccess {
+				t.Errorf("%s: delivery %d has success %t and error %q, want success %t", test.name, i, delivery.Success, delivery.Error, wantSuccess)
+			}
+		}
+		if got := deliveries[len(deliveries)-1].StatusCode; got != test.statusCodes[0] {
+			t.Errorf("%s: first delivery has status %d, want %d", test.name, got, test.statusCodes[0])
+		}
+	}
+}
+
+func TestWebhookDeliveries(t *testing.T) {
+	webhooks, _ := newTestWebhooks(t, Webhook{}, http.StatusOK)
+	webhooks.Notify(context.Background(), EventDocumentCreated, DocumentInfo{ID: "a"})
+	waitWebhooks(t, webhooks)
+	webhooks.Notify(context.Background(), EventDocumentDeleted, DocumentInfo{ID: "b"})
+	waitWebhooks(t, webhooks)
+
+	all := webhooks.Deliveries("")
+	if len(all) != 2 || all[0].DocumentID != "b" || all[1].DocumentID != "a" {
+		t.Errorf("Deliveries() = %+v, want b then a", all)
+	}
+	if a := webhooks.Deliveries("a"); len(a) != 1 || a[0].Event != EventDocumentCreated {
+		t.Errorf("Deliveries(a) = %+v, want the %s event", a, EventDocumentCreated)
+	}
+	if missing := webhooks.Deliveries("missing"); len(missing) != 0 {
+		t.Errorf("Deliveries(missing) = %+v, want none", missing)
+	}
+
+	// The log only keeps the most recent deliveries.
+	for i := 0; i < maxWebhookDeliveries+10; i++ {
+		webhooks.record(WebhookDelivery{DocumentID: "c"})
+	}
+	if n := len(webhooks.Deliveries("")); n != maxWebhookDeliveries {
+		t.Errorf("the log has %d deliveries, want %d", n, maxWebhookDeliveries)
+	}
+}
+
+func TestWebhooksWaitStopsRetries(t *testing.T) {
+	webhooks, receiver := newTestWebhooks(t, Webhook{}, http.StatusServiceUnavailable)
+	webhooks.backoff = time.Hour
+	webhooks.Notify(context.Background(), EventDocumentCreated, DocumentInfo{ID: "doc"})
+	for deadline := time.Now().Add(5 * time.Second); len(webhooks.Deliveries("doc")) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("the webhook was not sent")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The delivery is waiting an hour to be retried, so the shutdown gives up
+	// on it and stops the retry.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := webhooks.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait() returned %v, want %v", err, context.DeadlineExceeded)
+	}
+	waitWebhooks(t, webhooks)
+	if n := len(receiver.received()); n != 1 {
+		t.Errorf("webhook received %d requests, want 1", n)
+	}
+}